@@ -42,17 +42,47 @@ func NewAttrsFromAttrs(attrs ...*Attrs) *Attrs {
 
 // MergeAttrs accepts another existing Attrs and merges the attributes into its own.
 func (a *Attrs) MergeAttrs(attrs *Attrs) {
-	if attrs == nil {
+	if attrs == nil || attrs == a {
 		return
 	}
+
+	// Copy the other attrs before locking our own so two Attrs merging
+	// into each other at the same time can't deadlock.
+	attrs.attrsLock.RLock()
+	merge := make(map[uint32]interface{}, len(attrs.attrs))
+	for hash, val := range attrs.attrs {
+		merge[hash] = val
+	}
+	attrs.attrsLock.RUnlock()
+
 	a.attrsLock.Lock()
 	defer a.attrsLock.Unlock()
-	for hash, val := range attrs.attrs {
+	for hash, val := range merge {
 		a.attrs[hash] = val
 	}
 }
 
+// replaceAttrs replaces all of the attributes with the ones in attrs.  If attrs
+// is nil all of the attributes are removed.
+func (a *Attrs) replaceAttrs(attrs *Attrs) {
+	newAttrs := make(map[uint32]interface{})
+	if attrs != nil {
+		attrs.attrsLock.RLock()
+		for hash, val := range attrs.attrs {
+			newAttrs[hash] = val
+		}
+		attrs.attrsLock.RUnlock()
+	}
+
+	a.attrsLock.Lock()
+	defer a.attrsLock.Unlock()
+	a.attrs = newAttrs
+}
+
 func (a *Attrs) clone() *Attrs {
+	a.attrsLock.RLock()
+	defer a.attrsLock.RUnlock()
+
 	attrs := NewAttrs()
 	for hash, val := range a.attrs {
 		attrs.attrs[hash] = val
@@ -1,7 +1,8 @@
 package gomol
 
 import (
-	"os"
+	"context"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,6 +21,9 @@ func withClock(clock glock.Clock) baseConfigFunc {
 Base holds an instance of all information needed for logging.  It is possible
 to create multiple instances of Base if multiple sets of loggers or attributes
 are desired.
+
+All of the methods on Base are safe to call while messages are being logged in
+other goroutines.
 */
 type Base struct {
 	// This must be at the beginning of the struct due to
@@ -29,16 +33,40 @@ type Base struct {
 
 	clock glock.Clock
 
-	isInitialized bool
-	config        *Config
-	errorChan     chan<- error
-	queue         *queue
-	logLevel      LogLevel
-	BaseAttrs     *Attrs
+	// mutLock is held by anything that changes the loggers or
+	// initialization state of the Base so those changes happen
+	// one at a time.  Logging never takes this lock.
+	mutLock sync.Mutex
+
+	initialized int32
+	samplerVal  atomic.Value
+	dedupVal    atomic.Value
+	routerVal   atomic.Value
+	exiterVal   atomic.Value
+
+	// state holds the Base's *baseState.  stateLock is held while it's
+	// being replaced so changes to different parts of it aren't lost.
+	state     atomic.Value
+	stateLock sync.Mutex
+
+	exitHooks     []func()
+	exitHooksLock sync.Mutex
+
+	errorChan    chan<- error
+	errorDone    chan struct{}
+	errorSenders sync.WaitGroup
+	errorLock    sync.RWMutex
+
+	queue     *queue
+	spool     *spool
+	queueLock sync.RWMutex
 
-	loggers        []Logger
-	fallbackLogger Logger
-	hookPreQueue   []HookPreQueue
+	// closingSpool is the spool from before the last shutdown, which
+	// stays open while a queue worker that outlived the shutdown is
+	// still acking messages in it.
+	closingSpool *spool
+
+	BaseAttrs *Attrs
 }
 
 // NewBase creates a new instance of Base with default values set.
@@ -46,14 +74,14 @@ func NewBase(configs ...baseConfigFunc) *Base {
 	b := &Base{
 		clock: glock.NewRealClock(),
 
-		config:    NewConfig(),
-		logLevel:  LevelDebug,
 		sequence:  0,
 		BaseAttrs: NewAttrs(),
-
-		loggers:      make([]Logger, 0),
-		hookPreQueue: make([]HookPreQueue, 0),
 	}
+	b.state.Store(&baseState{
+		config:  NewConfig(),
+		level:   LevelDebug,
+		loggers: newLoggerSet(nil, nil),
+	})
 
 	for _, f := range configs {
 		f(b)
@@ -62,24 +90,38 @@ func NewBase(configs ...baseConfigFunc) *Base {
 	return b
 }
 
-type appExiter interface {
-	Exit(code int)
+// baseState is the Config, level and loggers a Base logs messages with.  It's
+// never changed once it's stored, only replaced as a whole, so a message is
+// never logged with a mix of old and new values.
+type baseState struct {
+	config  *Config
+	level   LogLevel
+	loggers *loggerSet
 }
-type osExiter struct{}
 
-func (exiter *osExiter) Exit(code int) {
-	os.Exit(code)
+func (b *Base) getState() *baseState {
+	return b.state.Load().(*baseState)
 }
 
-var curExiter appExiter = &osExiter{}
+// updateState replaces the Base's state with a copy changed by update
+func (b *Base) updateState(update func(state *baseState)) {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
 
-func setExiter(exiter appExiter) {
-	curExiter = exiter
+	state := *b.getState()
+	update(&state)
+	b.state.Store(&state)
 }
 
 // SetConfig will set the configuration for the Base to the given Config
 func (b *Base) SetConfig(config *Config) {
-	b.config = config
+	b.updateState(func(state *baseState) {
+		state.config = config
+	})
+}
+
+func (b *Base) config() *Config {
+	return b.getState().config
 }
 
 // SetErrorChan will register a channel as the consumer of internal error
@@ -87,12 +129,51 @@ func (b *Base) SetConfig(config *Config) {
 // The consumer of this channel is expected to be efficient as writing to
 // this channel will block.
 func (b *Base) SetErrorChan(ch chan<- error) {
+	b.errorLock.Lock()
+	defer b.errorLock.Unlock()
+
 	b.errorChan = ch
+	if b.errorDone == nil {
+		b.errorDone = make(chan struct{})
+	}
 }
 
 func (b *Base) report(err error) {
-	if b.errorChan != nil {
-		b.errorChan <- err
+	b.errorLock.RLock()
+	ch, done := b.errorChan, b.errorDone
+	if ch != nil {
+		b.errorSenders.Add(1)
+	}
+	b.errorLock.RUnlock()
+
+	if ch == nil {
+		return
+	}
+	defer b.errorSenders.Done()
+
+	// The send happens without the lock held so a slow consumer doesn't
+	// block SetErrorChan or shutting down.  An error still waiting to be
+	// sent when the Base is shut down is dropped.
+	select {
+	case ch <- err:
+	case <-done:
+	}
+}
+
+// closeErrorChan closes the error channel once any errors being sent to it
+// have been sent or dropped
+func (b *Base) closeErrorChan() {
+	b.errorLock.Lock()
+	ch, done := b.errorChan, b.errorDone
+	b.errorChan, b.errorDone = nil, nil
+	b.errorLock.Unlock()
+
+	if done != nil {
+		close(done)
+	}
+	b.errorSenders.Wait()
+	if ch != nil {
+		close(ch)
 	}
 }
 
@@ -101,26 +182,103 @@ SetLogLevel sets the level messages will be logged at.  It will log any message
 that is at the level or more severe than the level.
 */
 func (b *Base) SetLogLevel(level LogLevel) {
-	b.logLevel = level
+	b.updateState(func(state *baseState) {
+		state.level = level
+	})
+}
+
+/*
+SetSampler sets the Sampler used to limit how many of the same message are
+logged.  Passing nil stops sampling.
+*/
+func (b *Base) SetSampler(sampler *Sampler) {
+	b.samplerVal.Store(sampler)
+}
+
+func (b *Base) sampler() *Sampler {
+	sampler, _ := b.samplerVal.Load().(*Sampler)
+	return sampler
+}
+
+/*
+SetDeduplicator sets the Deduplicator used to collapse identical messages.
+Passing nil stops collapsing messages, and any summaries that haven't been
+logged yet are lost.
+*/
+func (b *Base) SetDeduplicator(dedup *Deduplicator) {
+	b.dedupVal.Store(dedup)
+}
+
+func (b *Base) deduplicator() *Deduplicator {
+	dedup, _ := b.dedupVal.Load().(*Deduplicator)
+	return dedup
+}
+
+/*
+SetRouter sets the Router used to choose which loggers each message is sent
+to.  Passing nil sends every message to every logger.
+*/
+func (b *Base) SetRouter(router *Router) {
+	b.routerVal.Store(router)
+}
+
+func (b *Base) router() *Router {
+	router, _ := b.routerVal.Load().(*Router)
+	return router
+}
+
+func (b *Base) logLevel() LogLevel {
+	return b.getState().level
 }
 
 func (b *Base) shouldLog(level LogLevel) bool {
-	if level <= b.logLevel {
+	if level <= b.logLevel() {
 		return true
 	}
 	return false
 }
 
+func (b *Base) getLoggerSet() *loggerSet {
+	return b.getState().loggers
+}
+
+// loggerSetGeneration is increased whenever the loggers of any Base change so
+// a ForwardLogger knows when it needs to check for loops again
+var loggerSetGeneration uint64
+
+// storeLoggerSet replaces the Base's loggers with set
+func (b *Base) storeLoggerSet(set *loggerSet) {
+	b.updateState(func(state *baseState) {
+		state.loggers = set
+	})
+	atomic.AddUint64(&loggerSetGeneration, 1)
+}
+
+func (b *Base) loggers() []Logger {
+	return b.getLoggerSet().loggers
+}
+
+func (b *Base) fallbackLogger() Logger {
+	return b.getLoggerSet().fallbackLogger
+}
+
 // SetFallbackLogger sets a Logger to be used if there aren't any loggers added or any of
 // the added loggers are in a degraded or unhealthy state.  A Logger passed to SetFallbackLogger
 // will be initialized if it hasn't been already.  In addition, if the Logger fails to initialize
 // completely the fallback logger will fail to be set.
 func (b *Base) SetFallbackLogger(logger Logger) error {
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
+
+	set := b.getLoggerSet()
+	oldLogger := set.fallbackLogger
+
 	if logger == nil {
-		if b.fallbackLogger != nil && b.fallbackLogger.IsInitialized() {
-			b.fallbackLogger.ShutdownLogger()
+		b.storeLoggerSet(set.withFallbackLogger(nil))
+		if oldLogger != nil && oldLogger.IsInitialized() {
+			b.Flush()
+			oldLogger.ShutdownLogger()
 		}
-		b.fallbackLogger = nil
 		return nil
 	}
 
@@ -131,18 +289,23 @@ func (b *Base) SetFallbackLogger(logger Logger) error {
 		}
 	}
 
-	// Shut down any old logger we might already have a reference to
-	if b.fallbackLogger != nil && b.fallbackLogger.IsInitialized() {
-		b.fallbackLogger.ShutdownLogger()
-	}
+	b.storeLoggerSet(set.withFallbackLogger(logger))
 
-	b.fallbackLogger = logger
+	// Shut down any old logger we might already have a reference to once
+	// the queue worker is done with it
+	if oldLogger != nil && oldLogger != logger && oldLogger.IsInitialized() {
+		b.Flush()
+		oldLogger.ShutdownLogger()
+	}
 
 	return nil
 }
 
 // AddLogger adds a new logger instance to the Base
 func (b *Base) AddLogger(logger Logger) error {
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
+
 	if b.IsInitialized() && !logger.IsInitialized() {
 		err := logger.InitLogger()
 		if err != nil {
@@ -154,69 +317,75 @@ func (b *Base) AddLogger(logger Logger) error {
 			return err
 		}
 	}
-	b.loggers = append(b.loggers, logger)
-
-	if hook, ok := logger.(HookPreQueue); ok {
-		b.hookPreQueue = append(b.hookPreQueue, hook)
-	}
 
+	// The base needs to be set before the logger is visible to the
+	// queue worker since it may start logging to it right away.
 	logger.SetBase(b)
+	b.storeLoggerSet(b.getLoggerSet().withLogger(logger))
+
 	return nil
 }
 
 /*
-RemoveLogger will run ShutdownLogger on the given logger and then remove the given
-Logger from the list in Base
+RemoveLogger will remove the given Logger from the list in Base and then run
+ShutdownLogger on it once it has been given every message logged before it was
+removed.  The logger is removed even if ShutdownLogger returns an error.
 */
 func (b *Base) RemoveLogger(logger Logger) error {
-	for idx, rLogger := range b.loggers {
-		if rLogger == logger {
-			err := rLogger.ShutdownLogger()
-			if err != nil {
-				return err
-			}
-			b.loggers[idx] = b.loggers[len(b.loggers)-1]
-			b.loggers[len(b.loggers)-1] = nil
-			b.loggers = b.loggers[:len(b.loggers)-1]
-			return nil
-		}
-	}
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
 
-	// Remove any hook instances the logger has
-	for idx, hookLogger := range b.hookPreQueue {
-		if hookLogger == logger {
-			b.hookPreQueue[idx] = b.hookPreQueue[len(b.hookPreQueue)-1]
-			b.hookPreQueue[len(b.hookPreQueue)-1] = nil
-			b.hookPreQueue = b.hookPreQueue[:len(b.hookPreQueue)-1]
-		}
+	set := b.getLoggerSet()
+	if !set.contains(logger) {
+		return nil
 	}
 
-	return nil
+	b.storeLoggerSet(set.withoutLogger(logger))
+
+	// Shut down the logger once the queue worker is done with it
+	b.Flush()
+
+	return logger.ShutdownLogger()
 }
 
 /*
-ClearLoggers will shut down and remove any loggers added to the Base. If an
-error occurs while shutting down one of the loggers, the list will not be
-cleared but any loggers that have already been shut down before the error
-occurred will remain shut down.
+ClearLoggers will remove any loggers added to the Base and then shut them down
+once they've been given every message logged before they were removed.  If an
+error occurs while shutting down one of the loggers the rest of them are still
+shut down and the first error is returned.
 */
 func (b *Base) ClearLoggers() error {
-	for _, logger := range b.loggers {
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
+
+	set := b.getLoggerSet()
+	b.storeLoggerSet(set.withLoggers(nil))
+
+	// Shut down the loggers once the queue worker is done with them
+	b.Flush()
+
+	var firstErr error
+	for _, logger := range set.loggers {
 		err := logger.ShutdownLogger()
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
 
-	b.loggers = make([]Logger, 0)
-	b.hookPreQueue = make([]HookPreQueue, 0)
-
-	return nil
+	return firstErr
 }
 
 // IsInitialized returns true if InitLoggers has been successfully run on the Base
 func (b *Base) IsInitialized() bool {
-	return b.isInitialized
+	return atomic.LoadInt32(&b.initialized) == 1
+}
+
+func (b *Base) setInitialized(initialized bool) {
+	if initialized {
+		atomic.StoreInt32(&b.initialized, 1)
+	} else {
+		atomic.StoreInt32(&b.initialized, 0)
+	}
 }
 
 /*
@@ -225,19 +394,78 @@ If an error occurs in initializing a logger, the loggers that have already been
 initialized will continue to be initialized.
 */
 func (b *Base) InitLoggers() error {
-	if b.queue == nil {
-		b.queue = newQueue(b, b.config.MaxQueueSize)
-	}
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
 
-	for _, logger := range b.loggers {
+	for _, logger := range b.loggers() {
 		err := logger.InitLogger()
 		if err != nil {
 			return err
 		}
 	}
 
-	b.queue.startWorker()
-	b.isInitialized = true
+	b.queueLock.Lock()
+	var replay []*spoolRecord
+	if cfg := b.config(); len(cfg.SpoolFile) > 0 && b.spool == nil {
+		// If the old worker is still writing the messages in the last
+		// spool it's used again instead of opened a second time.
+		// Those messages are still on their way so none of them are
+		// replayed.
+		if s := b.closingSpool; s != nil && s.path == cfg.SpoolFile && s.retain() {
+			b.spool = s
+		} else {
+			s, records, err := openSpool(cfg.SpoolFile, cfg.SpoolSync)
+			if err != nil {
+				b.queueLock.Unlock()
+				return err
+			}
+			b.spool = s
+			replay = records
+		}
+		b.closingSpool = nil
+	}
+	running := b.queue != nil
+	b.queueLock.Unlock()
+
+	if running {
+		b.setInitialized(true)
+		return nil
+	}
+
+	// The queue isn't made visible to anything logging until its
+	// worker is running, and anything left in the spool from last
+	// time is queued before it's visible so it's logged before any
+	// new messages.  These go straight on the queue channel so none
+	// of them are dropped if there are more of them than the queue
+	// holds.
+	q := newQueue(b, b.config().MaxQueueSize)
+	q.startWorker()
+	for _, record := range replay {
+		entry, err := record.entry()
+		if err != nil {
+			// It can't ever be replayed so don't keep it around to
+			// fail again the next time the spool is opened.
+			b.report(err)
+			b.spool.ack(record.id)
+			continue
+		}
+		q.enqueue(&Message{
+			base:      b,
+			Level:     entry.Level,
+			Timestamp: entry.Timestamp,
+			Attrs:     NewAttrsFromMap(entry.Attrs),
+			Msg:       entry.Msg,
+			spool:     b.spool,
+			spoolID:   record.id,
+			loggers:   b.getLoggerSet(),
+		})
+	}
+
+	b.queueLock.Lock()
+	b.queue = q
+	b.queueLock.Unlock()
+
+	b.setInitialized(true)
 
 	return nil
 }
@@ -245,9 +473,66 @@ func (b *Base) InitLoggers() error {
 // Flush will wait until all messages currently queued are distributed to
 // all initialized loggers
 func (b *Base) Flush() {
-	if b.queue != nil {
-		b.queue.flush()
+	b.FlushContext(context.Background())
+}
+
+// FlushContext will wait until all messages currently queued are distributed
+// to all initialized loggers or until ctx is done.  If ctx is done first an
+// *IncompleteError is returned with the number of messages that were left
+// and the logger that was still writing a message, if any.
+func (b *Base) FlushContext(ctx context.Context) error {
+	b.queueLock.RLock()
+	q := b.queue
+	b.queueLock.RUnlock()
+
+	if q == nil {
+		return nil
+	}
+
+	if dedup := b.deduplicator(); dedup != nil {
+		b.queueSummaries(dedup.flush())
+	}
+
+	err := q.flushContext(ctx)
+	if err != nil {
+		return newIncompleteError(q, nil, err)
+	}
+
+	return nil
+}
+
+// newIncompleteError creates an *IncompleteError for the messages left in q
+// and the loggers that didn't finish shutting down.  If nothing was actually
+// left unfinished nil is returned.
+func newIncompleteError(q *queue, unfinished []Logger, err error) error {
+	incErr := &IncompleteError{
+		Loggers: make([]Logger, 0),
+		Err:     err,
+	}
+
+	var writing Logger
+	if q != nil {
+		incErr.Remaining = q.pressure() + q.batched()
+		var inBatch bool
+		writing, inBatch = q.writingTo()
+		if writing != nil {
+			// Messages in a batch are already counted as batched
+			if !inBatch {
+				incErr.Remaining++
+			}
+			incErr.Loggers = append(incErr.Loggers, writing)
+		}
+	}
+	for _, logger := range unfinished {
+		if logger != writing {
+			incErr.Loggers = append(incErr.Loggers, logger)
+		}
+	}
+
+	if incErr.Remaining == 0 && len(incErr.Loggers) == 0 {
+		return nil
 	}
+	return incErr
 }
 
 /*
@@ -256,28 +541,146 @@ while shutting down a Logger, the error will be returned and all the loggers tha
 were already shut down will remain shut down.
 */
 func (b *Base) ShutdownLoggers() error {
-	// Before shutting down we should flush all the messsages
-	b.Flush()
+	return b.ShutdownLoggersContext(context.Background())
+}
 
-	for _, logger := range b.loggers {
-		err := logger.ShutdownLogger()
-		if err != nil {
-			return err
+/*
+ShutdownLoggersContext is the same as ShutdownLoggers but will stop waiting for
+queued messages to be written and for loggers to shut down once ctx is done.
+Loggers that implement ShutdownLoggerContext are given ctx when they're shut down.
+If ctx is done before everything has finished the Base is still shut down, and an
+*IncompleteError is returned with the number of messages that were left and the
+loggers that didn't finish.  Loggers are only shut down once the queued messages
+have been written to them, so if ctx is done before that none of them are shut
+down and they're all returned in the *IncompleteError.  A spool file is kept open
+until the queue worker has finished with those messages.
+*/
+func (b *Base) ShutdownLoggersContext(ctx context.Context) error {
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
+
+	// Before shutting down we should flush all the messsages.  If this
+	// doesn't finish in time the queue worker may still be writing to
+	// the loggers, so they're left running rather than shut down from
+	// underneath it.
+	var unfinished []Logger
+	loggers := b.loggers()
+	flushErr := b.FlushContext(ctx)
+	if flushErr != nil {
+		unfinished = loggers
+	} else {
+		for idx, logger := range loggers {
+			err := shutdownLoggerContext(ctx, logger)
+			if err != nil && ctx.Err() != nil {
+				// We're out of time so don't wait on any of the
+				// remaining loggers either.
+				unfinished = loggers[idx:]
+				break
+			} else if err != nil {
+				return err
+			}
 		}
 	}
 
+	var stopErr error
+	b.queueLock.Lock()
 	if b.queue != nil {
-		b.queue.stopWorker()
+		err := b.queue.stopWorkerContext(ctx)
+		if err != nil || len(unfinished) > 0 {
+			stopErr = newIncompleteError(b.queue, unfinished, ctx.Err())
+		}
 		b.queue = nil
+	} else if len(unfinished) > 0 {
+		stopErr = newIncompleteError(nil, unfinished, ctx.Err())
+	}
+	if b.spool != nil {
+		// Anything that wasn't delivered stays in the spool until
+		// InitLoggers is called again.  If the worker didn't stop in
+		// time the spool is only closed once it does, so the messages
+		// it still writes are acked and not replayed later.
+		b.spool.release()
+		b.closingSpool = b.spool
+		b.spool = nil
 	}
+	b.queueLock.Unlock()
+
+	b.closeErrorChan()
+
+	b.setInitialized(false)
+	return stopErr
+}
 
-	if b.errorChan != nil {
-		close(b.errorChan)
-		b.errorChan = nil
+// shutdownLoggerContext shuts down logger and waits for it to finish or for
+// ctx to be done.  If ctx is done first, ctx.Err() is returned.  If ctx is
+// already done the logger isn't shut down at all.
+func shutdownLoggerContext(ctx context.Context, logger Logger) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	b.isInitialized = false
-	return nil
+	errChan := make(chan error, 1)
+	go func() {
+		if ctxLogger, ok := logger.(ShutdownLoggerContext); ok {
+			errChan <- ctxLogger.ShutdownLoggerContext(ctx)
+		} else {
+			errChan <- logger.ShutdownLogger()
+		}
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		// Prefer the logger's result if it finished at the same time
+		select {
+		case err := <-errChan:
+			return err
+		default:
+		}
+		return ctx.Err()
+	}
+}
+
+/*
+NewChild creates a new Base whose messages are also logged to this Base's
+loggers along with this Base's attributes.  Loggers added to the child only
+receive the child's messages, and the child's level can be set without
+changing this Base's level.  The child starts out with a copy of this Base's
+attributes, the same Config without a spool file, and the same level and
+Exiter as this Base.  Attributes set on the child afterwards are only added to
+the child's messages.  If this Base is initialized the child is initialized as
+well, otherwise InitLoggers needs to be called on the child.
+*/
+func (b *Base) NewChild() (*Base, error) {
+	child := NewBase(withClock(b.clock))
+
+	config := *b.config()
+	config.SpoolFile = ""
+	child.SetConfig(&config)
+	child.SetLogLevel(b.logLevel())
+	child.SetExiter(b.exiter())
+	child.BaseAttrs = b.BaseAttrs.clone()
+
+	fl, err := NewForwardLogger(b, &ForwardLoggerConfig{
+		Attrs:       NewAttrs(),
+		IgnoreLevel: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = child.AddLogger(fl)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.IsInitialized() {
+		err = child.InitLoggers()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return child, nil
 }
 
 /*
@@ -289,7 +692,7 @@ func (b *Base) NewLogAdapter(attrs *Attrs) *LogAdapter {
 
 // ClearAttrs will remove all the attributes added to Base
 func (b *Base) ClearAttrs() {
-	b.BaseAttrs = NewAttrs()
+	b.BaseAttrs.replaceAttrs(nil)
 }
 
 /*
@@ -320,41 +723,134 @@ func (b *Base) LogWithTime(level LogLevel, ts time.Time, m *Attrs, msg string, a
 		return nil
 	}
 
-	if !b.isInitialized {
+	return b.log(0, level, ts, m, msg, a...)
+}
+
+func (b *Base) now() time.Time {
+	return b.clock.Now()
+}
+
+// logFlags change how Base.log handles a message
+type logFlags int
+
+const (
+	// logUnfiltered skips sampling and deduplication
+	logUnfiltered logFlags = 1 << iota
+	// logKeepCaller keeps the location the message was logged at from its
+	// attributes instead of adding the caller's location
+	logKeepCaller
+)
+
+// logBuffered logs a message from a LogAdapter's flight recorder regardless of
+// the Base's level.  It isn't sampled or deduplicated either, since the kept
+// messages are the context the flight recorder exists to log.
+func (b *Base) logBuffered(level LogLevel, ts time.Time, m *Attrs, msg string) error {
+	return b.log(logUnfiltered, level, ts, m, msg)
+}
+
+// logForwarded logs a message from a ForwardLogger regardless of the Base's
+// level.  The message already has the location it was logged at in the Base
+// it was forwarded from, which is more useful than the ForwardLogger's.
+func (b *Base) logForwarded(level LogLevel, ts time.Time, m *Attrs, msg string) error {
+	return b.log(logKeepCaller, level, ts, m, msg)
+}
+
+func (b *Base) log(flags logFlags, level LogLevel, ts time.Time, m *Attrs, msg string, a ...interface{}) error {
+	if !b.IsInitialized() {
 		return ErrNotInitialized
 	}
 
-	if len(b.config.FilenameAttr) > 0 || len(b.config.LineNumberAttr) > 0 {
+	if sampler := b.sampler(); flags&logUnfiltered == 0 && sampler != nil {
+		var ok bool
+		m, ok = sampleAttrs(sampler, level, m, msg)
+		if !ok {
+			return nil
+		}
+	}
+
+	// Everything the message is logged with comes from the same state so
+	// a Reload running at the same time doesn't mix old and new values
+	state := b.getState()
+	config := state.config
+	if flags&logKeepCaller == 0 && (len(config.FilenameAttr) > 0 || len(config.LineNumberAttr) > 0) {
 		file, line := getCallerInfo()
 		if m == nil {
 			m = NewAttrs()
 		}
-		if len(b.config.FilenameAttr) > 0 {
-			m.SetAttr(b.config.FilenameAttr, file)
+		if len(config.FilenameAttr) > 0 {
+			m.SetAttr(config.FilenameAttr, file)
 		}
-		if len(b.config.LineNumberAttr) > 0 {
-			m.SetAttr(b.config.LineNumberAttr, line)
+		if len(config.LineNumberAttr) > 0 {
+			m.SetAttr(config.LineNumberAttr, line)
 		}
 	}
 
-	if len(b.config.SequenceAttr) > 0 {
+	if len(config.SequenceAttr) > 0 {
 		if m == nil {
 			m = NewAttrs()
 		}
 		seq := atomic.AddUint64(&b.sequence, 1)
-		m.SetAttr(b.config.SequenceAttr, seq)
+		m.SetAttr(config.SequenceAttr, seq)
 	}
 
 	nm := newMessage(ts, b, level, m, msg, a...)
+	nm.loggers = state.loggers
+
+	if dedup := b.deduplicator(); flags&logUnfiltered == 0 && dedup != nil {
+		summaries, ok := dedup.check(nm)
+		if len(summaries) > 0 {
+			b.queueSummaries(summaries)
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	return b.queueMessage(nm)
+}
 
-	for _, hook := range b.hookPreQueue {
+// queueMessage runs the PreQueue hooks on nm and adds it to the queue.  If nm
+// doesn't have a set of loggers yet it's given the Base's current loggers.
+func (b *Base) queueMessage(nm *Message) error {
+	if nm.loggers == nil {
+		nm.loggers = b.getLoggerSet()
+	}
+	for _, hook := range nm.loggers.hookPreQueue {
 		err := hook.PreQueue(nm)
 		if err != nil {
 			return err
 		}
 	}
 
-	return b.queue.queueMessage(nm)
+	b.queueLock.RLock()
+	defer b.queueLock.RUnlock()
+	if b.queue == nil {
+		return ErrNotInitialized
+	}
+
+	// The message is still queued if it can't be spooled so it isn't
+	// lost, but the caller is told it wasn't persisted.
+	var spoolErr error
+	if b.spool != nil {
+		spoolErr = b.spool.append(nm)
+	}
+
+	err := b.queue.queueMessage(nm)
+	if err != nil {
+		return err
+	}
+	return spoolErr
+}
+
+// queueSummaries queues the summaries of collapsed messages.  Errors are
+// reported since they have no caller to return them to.
+func (b *Base) queueSummaries(summaries []*Message) {
+	for _, summary := range summaries {
+		err := b.queueMessage(summary)
+		if err != nil {
+			b.report(err)
+		}
+	}
 }
 
 // Log will log a message at the provided level to all added loggers with the timestamp set to the time
@@ -532,23 +1028,23 @@ func (b *Base) Fatalm(m *Attrs, msg string, a ...interface{}) error {
 	return b.Log(LevelFatal, m, msg, a...)
 }
 
-// Die will log a message using Fatal, call ShutdownLoggers and then exit the application with the provided exit code.
+// Die will log a message using Fatal, run the OnExit hooks, call ShutdownLoggers and then exit the application with the
+// provided exit code.
 func (b *Base) Die(exitCode int, msg string) {
 	b.Log(LevelFatal, nil, msg)
-	b.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	b.exit(exitCode)
 }
 
-// Dief will log a message using Fatalf, call ShutdownLoggers and then exit the application with the provided exit code.
+// Dief will log a message using Fatalf, run the OnExit hooks, call ShutdownLoggers and then exit the application with the
+// provided exit code.
 func (b *Base) Dief(exitCode int, msg string, a ...interface{}) {
 	b.Log(LevelFatal, nil, msg, a...)
-	b.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	b.exit(exitCode)
 }
 
-// Diem will log a message using Fatalm, call ShutdownLoggers and then exit the application with the provided exit code.
+// Diem will log a message using Fatalm, run the OnExit hooks, call ShutdownLoggers and then exit the application with the
+// provided exit code.
 func (b *Base) Diem(exitCode int, m *Attrs, msg string, a ...interface{}) {
 	b.Log(LevelFatal, m, msg, a...)
-	b.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	b.exit(exitCode)
 }
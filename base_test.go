@@ -1,7 +1,10 @@
 package gomol
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/aphistic/sweet"
 	"github.com/efritz/glock"
@@ -29,19 +32,20 @@ func (s *BaseSuite) SetUpTest(t sweet.T) {
 	gomolFiles = map[string]fileRecord{}
 
 	curTestExiter = &testExiter{}
-	setExiter(curTestExiter)
 
 	clock := glock.NewMockClock()
 
 	testBase = NewBase(
 		withClock(clock),
 	)
+	testBase.SetExiter(curTestExiter)
 	testBase.AddLogger(newDefaultMemLogger())
 	testBase.InitLoggers()
 
 	curDefault = NewBase(
 		withClock(clock),
 	)
+	curDefault.SetExiter(curTestExiter)
 	curDefault.AddLogger(newDefaultMemLogger())
 	curDefault.InitLoggers()
 }
@@ -78,30 +82,30 @@ func (s *BaseSuite) TestShouldLog(t sweet.T) {
 
 func (s *BaseSuite) TestNewBase(t sweet.T) {
 	b := NewBase()
-	Expect(b.isInitialized).To(Equal(false))
-	Expect(b.config).ToNot(BeNil())
-	Expect(b.config.FilenameAttr).To(Equal(""))
-	Expect(b.config.LineNumberAttr).To(Equal(""))
-	Expect(b.logLevel).To(Equal(LevelDebug))
-	Expect(b.loggers).To(HaveLen(0))
+	Expect(b.IsInitialized()).To(Equal(false))
+	Expect(b.config()).ToNot(BeNil())
+	Expect(b.config().FilenameAttr).To(Equal(""))
+	Expect(b.config().LineNumberAttr).To(Equal(""))
+	Expect(b.logLevel()).To(Equal(LevelDebug))
+	Expect(b.loggers()).To(HaveLen(0))
 	Expect(b.BaseAttrs.Attrs()).To(HaveLen(0))
 }
 
 func (s *BaseSuite) TestSetConfig(t sweet.T) {
 	b := NewBase()
 
-	Expect(b.config).ToNot(BeNil())
-	Expect(b.config.FilenameAttr).To(Equal(""))
-	Expect(b.config.LineNumberAttr).To(Equal(""))
+	Expect(b.config()).ToNot(BeNil())
+	Expect(b.config().FilenameAttr).To(Equal(""))
+	Expect(b.config().LineNumberAttr).To(Equal(""))
 
 	cfg := NewConfig()
 	cfg.FilenameAttr = "filename"
 	cfg.LineNumberAttr = "line_number"
 
 	b.SetConfig(cfg)
-	Expect(b.config).ToNot(BeNil())
-	Expect(b.config.FilenameAttr).To(Equal("filename"))
-	Expect(b.config.LineNumberAttr).To(Equal("line_number"))
+	Expect(b.config()).ToNot(BeNil())
+	Expect(b.config().FilenameAttr).To(Equal("filename"))
+	Expect(b.config().LineNumberAttr).To(Equal("line_number"))
 }
 
 func (s *BaseSuite) TestErrorChannel(t sweet.T) {
@@ -151,7 +155,7 @@ func (s *BaseSuite) TestSetLogLevel(t sweet.T) {
 func (s *BaseSuite) TestAddLogger(t sweet.T) {
 	b := NewBase()
 	b.InitLoggers()
-	Expect(b.loggers).To(HaveLen(0))
+	Expect(b.loggers()).To(HaveLen(0))
 
 	ml := newDefaultMemLogger()
 	Expect(ml.IsInitialized()).To(Equal(false))
@@ -159,8 +163,8 @@ func (s *BaseSuite) TestAddLogger(t sweet.T) {
 
 	b.AddLogger(ml)
 	Expect(b.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(1))
-	Expect(b.loggers[0].IsInitialized()).To(Equal(true))
+	Expect(b.loggers()).To(HaveLen(1))
+	Expect(b.loggers()[0].IsInitialized()).To(Equal(true))
 	Expect(ml.base).To(Equal(b))
 }
 
@@ -203,7 +207,7 @@ func (s *BaseSuite) TestAddLoggerAfterInitFail(t sweet.T) {
 	Expect(ret).ToNot(BeNil())
 	Expect(ret.Error()).To(Equal("Init failed"))
 	Expect(ml.IsInitialized()).To(Equal(false))
-	Expect(b.loggers).To(HaveLen(0))
+	Expect(b.loggers()).To(HaveLen(0))
 }
 
 func (s *BaseSuite) TestAddLoggerAfterShutdownFail(t sweet.T) {
@@ -221,7 +225,7 @@ func (s *BaseSuite) TestAddLoggerAfterShutdownFail(t sweet.T) {
 	Expect(ret).ToNot(BeNil())
 	Expect(ret.Error()).To(Equal("Shutdown failed"))
 	Expect(ml.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(0))
+	Expect(b.loggers()).To(HaveLen(0))
 }
 
 func (s *BaseSuite) TestBaseRemoveLogger(t sweet.T) {
@@ -239,14 +243,14 @@ func (s *BaseSuite) TestBaseRemoveLogger(t sweet.T) {
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(true))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(3))
+	Expect(b.loggers()).To(HaveLen(3))
 
 	err := b.RemoveLogger(ml2)
 	Expect(err).To(BeNil())
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(false))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(2))
+	Expect(b.loggers()).To(HaveLen(2))
 }
 
 func (s *BaseSuite) TestBaseRemoveLoggerNonExistent(t sweet.T) {
@@ -259,7 +263,7 @@ func (s *BaseSuite) TestBaseRemoveLoggerNonExistent(t sweet.T) {
 	b.InitLoggers()
 
 	Expect(ml1.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(1))
+	Expect(b.loggers()).To(HaveLen(1))
 
 	err := b.RemoveLogger(ml2)
 	Expect(err).To(BeNil())
@@ -280,14 +284,14 @@ func (s *BaseSuite) TestBaseClearLoggers(t sweet.T) {
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(true))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(b.loggers).To(HaveLen(3))
+	Expect(b.loggers()).To(HaveLen(3))
 
 	err := b.ClearLoggers()
 	Expect(err).To(BeNil())
 	Expect(ml1.IsInitialized()).To(Equal(false))
 	Expect(ml2.IsInitialized()).To(Equal(false))
 	Expect(ml3.IsInitialized()).To(Equal(false))
-	Expect(b.loggers).To(HaveLen(0))
+	Expect(b.loggers()).To(HaveLen(0))
 }
 
 func (s *BaseSuite) TestInitLoggers(t sweet.T) {
@@ -470,7 +474,7 @@ func (s *BaseSuite) TestSequenceDisabled(t sweet.T) {
 
 func (s *BaseSuite) TestSequence(t sweet.T) {
 	b := NewBase()
-	b.config.SequenceAttr = "seq"
+	b.config().SequenceAttr = "seq"
 
 	l := newDefaultMemLogger()
 	b.AddLogger(l)
@@ -969,6 +973,7 @@ func (s *BaseSuite) TestBaseFatalm(t sweet.T) {
 
 func (s *BaseSuite) TestBaseDie(t sweet.T) {
 	b := NewBase()
+	b.SetExiter(curTestExiter)
 
 	l1 := newDefaultMemLogger()
 	l2 := newDefaultMemLogger()
@@ -991,13 +996,14 @@ func (s *BaseSuite) TestBaseDie(t sweet.T) {
 	Expect(msg.Attrs).To(HaveLen(0))
 	Expect(msg.Level).To(Equal(LevelFatal))
 
-	Expect(b.isInitialized).To(Equal(false))
+	Expect(b.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
 
 func (s *BaseSuite) TestBaseDief(t sweet.T) {
 	b := NewBase()
+	b.SetExiter(curTestExiter)
 
 	l1 := newDefaultMemLogger()
 	l2 := newDefaultMemLogger()
@@ -1020,13 +1026,14 @@ func (s *BaseSuite) TestBaseDief(t sweet.T) {
 	Expect(msg.Attrs).To(HaveLen(0))
 	Expect(msg.Level).To(Equal(LevelFatal))
 
-	Expect(b.isInitialized).To(Equal(false))
+	Expect(b.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
 
 func (s *BaseSuite) TestBaseDiem(t sweet.T) {
 	b := NewBase()
+	b.SetExiter(curTestExiter)
 	b.SetAttr("attr1", 1234)
 
 	l1 := newDefaultMemLogger()
@@ -1063,7 +1070,7 @@ func (s *BaseSuite) TestBaseDiem(t sweet.T) {
 	Expect(msg.Attrs["attr3"]).To(Equal("val3"))
 	Expect(msg.Level).To(Equal(LevelFatal))
 
-	Expect(b.isInitialized).To(Equal(false))
+	Expect(b.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
@@ -1125,3 +1132,721 @@ func (s *BaseSuite) TestBaseOrdering(t sweet.T) {
 	Expect(msg.Attrs["attr5"]).To(Equal("val3"))
 	Expect(msg.Level).To(Equal(LevelFatal))
 }
+
+func (s *BaseSuite) TestConcurrentAddRemoveLoggers(t sweet.T) {
+	const (
+		logRoutines = 8
+		logCount    = 1000
+	)
+
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	stop := make(chan struct{})
+	mutatorErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				mutatorErr <- nil
+				return
+			default:
+			}
+
+			added := newDefaultMemLogger()
+			if err := b.AddLogger(added); err != nil {
+				mutatorErr <- err
+				return
+			}
+			b.SetAttr("mutated", true)
+			if err := b.RemoveLogger(added); err != nil {
+				mutatorErr <- err
+				return
+			}
+			b.RemoveAttr("mutated")
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < logRoutines; i++ {
+		wg.Add(1)
+		go func(routine int) {
+			defer wg.Done()
+			for j := 0; j < logCount; j++ {
+				b.Infof("routine %d message %d", routine, j)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(stop)
+	Expect(<-mutatorErr).To(BeNil())
+
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Expect(ml.Messages()).To(HaveLen(logRoutines * logCount))
+}
+
+func (s *BaseSuite) TestConcurrentMutators(t sweet.T) {
+	const (
+		logRoutines = 4
+		logCount    = 1000
+	)
+
+	ml := newDefaultMemLogger()
+	ml.SetHealthy(true)
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	errChan := make(chan error)
+	go func() {
+		for range errChan {
+		}
+	}()
+	b.SetErrorChan(errChan)
+
+	stop := make(chan struct{})
+	mutatorErr := make(chan error, 1)
+	go func() {
+		levels := []LogLevel{LevelDebug, LevelInfo, LevelWarning, LevelError}
+		for idx := 0; ; idx++ {
+			select {
+			case <-stop:
+				mutatorErr <- nil
+				return
+			default:
+			}
+
+			b.SetLogLevel(levels[idx%len(levels)])
+
+			cfg := NewConfig()
+			cfg.SequenceAttr = "seq"
+			b.SetConfig(cfg)
+
+			fallback := newDefaultMemLogger()
+			fallback.SetHealthy(true)
+
+			added := newDefaultMemLogger()
+			mutations := []func() error{
+				func() error { return b.SetFallbackLogger(fallback) },
+				func() error { return b.AddLogger(added) },
+				b.ClearLoggers,
+				func() error { return b.AddLogger(ml) },
+			}
+			for _, mutate := range mutations {
+				if err := mutate(); err != nil {
+					mutatorErr <- err
+					return
+				}
+			}
+
+			b.ClearAttrs()
+			b.SetAttr("mutated", idx)
+
+			if err := b.SetFallbackLogger(nil); err != nil {
+				mutatorErr <- err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < logRoutines; i++ {
+		wg.Add(1)
+		go func(routine int) {
+			defer wg.Done()
+			la := b.NewLogAdapter(NewAttrs().SetAttr("routine", routine))
+			for j := 0; j < logCount; j++ {
+				la.Errorf("message %d", j)
+				la.Dbgf("message %d", j)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(stop)
+	Expect(<-mutatorErr).To(BeNil())
+
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Expect(b.IsInitialized()).To(BeFalse())
+}
+
+func (s *BaseSuite) TestConcurrentInitShutdown(t sweet.T) {
+	const (
+		logRoutines = 4
+		logCount    = 1000
+	)
+
+	b := NewBase()
+	b.AddLogger(newDefaultMemLogger())
+
+	var wg sync.WaitGroup
+	logErrs := make(chan error, logRoutines)
+	for i := 0; i < logRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < logCount; j++ {
+				err := b.Info("message")
+				if err != nil && err != ErrNotInitialized {
+					logErrs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		Expect(b.InitLoggers()).To(BeNil())
+		Expect(b.ShutdownLoggers()).To(BeNil())
+	}
+
+	wg.Wait()
+	close(logErrs)
+	Expect(<-logErrs).To(BeNil())
+}
+
+func (s *BaseSuite) TestFlushWhileLogging(t sweet.T) {
+	b := NewBase()
+	b.AddLogger(newDefaultMemLogger())
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	stop := make(chan struct{})
+	logDone := make(chan struct{})
+	go func() {
+		defer close(logDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			b.Info("message")
+		}
+	}()
+
+	// The queue never goes idle while the goroutine above is logging,
+	// but the flush only waits for what was queued before it started.
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for i := 0; i < 10; i++ {
+			b.Flush()
+		}
+	}()
+	Eventually(flushed, "5s").Should(BeClosed())
+
+	close(stop)
+	<-logDone
+}
+
+func (s *BaseSuite) TestRemoveLoggerDeliversQueued(t sweet.T) {
+	blocker := make(chan struct{})
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(&BlockingLogger{ch: blocker})
+	b.AddLogger(ml)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	for i := 0; i < 10; i++ {
+		b.Infof("message %d", i)
+	}
+
+	removed := make(chan error, 1)
+	go func() {
+		removed <- b.RemoveLogger(ml)
+	}()
+
+	// The logger isn't shut down until the messages logged before it
+	// was removed have been written to it.
+	Consistently(removed).ShouldNot(Receive())
+	Expect(ml.IsInitialized()).To(BeTrue())
+
+	close(blocker)
+	Eventually(removed).Should(Receive(BeNil()))
+	Expect(ml.Messages()).To(HaveLen(10))
+	Expect(ml.IsInitialized()).To(BeFalse())
+}
+
+func (s *BaseSuite) TestReportDoesNotBlockShutdown(t sweet.T) {
+	b := NewBase()
+	b.SetErrorChan(make(chan error))
+
+	// Nothing reads from the channel so the report blocks until the
+	// Base is shut down.
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		b.report(errors.New("unread"))
+	}()
+
+	setDone := make(chan struct{})
+	go func() {
+		defer close(setDone)
+		b.SetErrorChan(make(chan error))
+	}()
+	Eventually(setDone).Should(BeClosed())
+
+	Expect(b.InitLoggers()).To(BeNil())
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Eventually(reported).Should(BeClosed())
+}
+
+func (s *BaseSuite) TestRemoveLoggerRemovesHook(t sweet.T) {
+	hl := &hookLogger{memLogger: newDefaultMemLogger()}
+
+	b := NewBase()
+	b.AddLogger(hl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	Expect(b.getLoggerSet().hookPreQueue).To(HaveLen(1))
+
+	b.Info("hooked")
+	Expect(hl.hooked).To(Equal(1))
+
+	Expect(b.RemoveLogger(hl)).To(BeNil())
+	Expect(b.getLoggerSet().hookPreQueue).To(HaveLen(0))
+
+	b.Info("not hooked")
+	Expect(hl.hooked).To(Equal(1))
+}
+
+type hookLogger struct {
+	*memLogger
+	hooked int
+}
+
+func (l *hookLogger) PreQueue(msg *Message) error {
+	l.hooked++
+	return nil
+}
+
+func (s *BaseSuite) TestFlushContext(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	for i := 0; i < 100; i++ {
+		b.Infof("message %d", i)
+	}
+
+	err := b.FlushContext(context.Background())
+	Expect(err).To(BeNil())
+	Expect(ml.Messages()).To(HaveLen(100))
+}
+
+func (s *BaseSuite) TestFlushContextNotInitialized(t sweet.T) {
+	b := NewBase()
+	Expect(b.FlushContext(context.Background())).To(BeNil())
+}
+
+func (s *BaseSuite) TestFlushContextTimeout(t sweet.T) {
+	blocker := make(chan struct{})
+	bl := &BlockingLogger{ch: blocker}
+
+	b := NewBase()
+	b.AddLogger(bl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+	defer close(blocker)
+
+	for i := 0; i < 10; i++ {
+		b.Infof("message %d", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := b.FlushContext(ctx)
+	Expect(err).ToNot(BeNil())
+
+	incErr, ok := err.(*IncompleteError)
+	Expect(ok).To(BeTrue())
+	Expect(incErr.Err).To(Equal(context.DeadlineExceeded))
+	Expect(incErr.Remaining).To(Equal(10))
+	Expect(incErr.Loggers).To(Equal([]Logger{bl}))
+	Expect(incErr.Error()).To(Equal(
+		"incomplete: 10 message(s) remaining, 1 logger(s) did not finish: context deadline exceeded",
+	))
+}
+
+func (s *BaseSuite) TestShutdownLoggersContext(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	b.Info("message")
+
+	err := b.ShutdownLoggersContext(context.Background())
+	Expect(err).To(BeNil())
+	Expect(ml.Messages()).To(HaveLen(1))
+	Expect(ml.isShutdown).To(BeTrue())
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(b.queue).To(BeNil())
+}
+
+func (s *BaseSuite) TestShutdownLoggersContextWedgedLogger(t sweet.T) {
+	blocker := make(chan struct{})
+	defer close(blocker)
+
+	ml1 := newDefaultMemLogger()
+	wl := &wedgedShutdownLogger{memLogger: newDefaultMemLogger(), ch: blocker}
+	ml2 := newDefaultMemLogger()
+
+	ch := make(chan error)
+	b := NewBase()
+	b.SetErrorChan(ch)
+	b.AddLogger(ml1)
+	b.AddLogger(wl)
+	b.AddLogger(ml2)
+	b.InitLoggers()
+
+	b.Info("message")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := b.ShutdownLoggersContext(ctx)
+	Expect(err).ToNot(BeNil())
+
+	incErr, ok := err.(*IncompleteError)
+	Expect(ok).To(BeTrue())
+	Expect(incErr.Err).To(Equal(context.DeadlineExceeded))
+	Expect(incErr.Remaining).To(Equal(0))
+	Expect(incErr.Loggers).To(Equal([]Logger{wl, ml2}))
+
+	Expect(ml1.isShutdown).To(BeTrue())
+	Expect(ml2.isShutdown).To(BeFalse())
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(b.queue).To(BeNil())
+	Eventually(ch).Should(BeClosed())
+}
+
+func (s *BaseSuite) TestShutdownLoggersContextWedgedQueue(t sweet.T) {
+	blocker := make(chan struct{})
+	defer close(blocker)
+
+	bl := &BlockingLogger{ch: blocker}
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(bl)
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	for i := 0; i < 5; i++ {
+		b.Infof("message %d", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := b.ShutdownLoggersContext(ctx)
+	Expect(err).ToNot(BeNil())
+
+	incErr, ok := err.(*IncompleteError)
+	Expect(ok).To(BeTrue())
+	Expect(incErr.Remaining).To(Equal(5))
+	Expect(incErr.Loggers).To(Equal([]Logger{bl, ml}))
+	Expect(b.IsInitialized()).To(BeFalse())
+
+	// The worker is still going to write to ml so it's left running
+	Expect(ml.isShutdown).To(BeFalse())
+}
+
+func (s *BaseSuite) TestShutdownLoggersContextAlreadyDone(t sweet.T) {
+	ml1 := newDefaultMemLogger()
+	ml2 := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(ml1)
+	b.AddLogger(ml2)
+	b.InitLoggers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := b.ShutdownLoggersContext(ctx)
+	Expect(err).ToNot(BeNil())
+
+	incErr, ok := err.(*IncompleteError)
+	Expect(ok).To(BeTrue())
+	Expect(incErr.Err).To(Equal(context.Canceled))
+	Expect(incErr.Remaining).To(Equal(0))
+	Expect(incErr.Loggers).To(Equal([]Logger{ml1, ml2}))
+	Expect(ml1.isShutdown).To(BeFalse())
+	Expect(ml2.isShutdown).To(BeFalse())
+	Expect(b.IsInitialized()).To(BeFalse())
+}
+
+func (s *BaseSuite) TestShutdownLoggersContextInterface(t sweet.T) {
+	cl := &contextShutdownLogger{memLogger: newDefaultMemLogger()}
+
+	b := NewBase()
+	b.AddLogger(cl)
+	b.InitLoggers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := b.ShutdownLoggersContext(ctx)
+	Expect(err).To(BeNil())
+	Expect(cl.ctx).To(Equal(ctx))
+	Expect(cl.isShutdown).To(BeTrue())
+}
+
+func (s *BaseSuite) TestShutdownLoggersContextError(t sweet.T) {
+	ml := newDefaultMemLogger()
+	ml.config.FailShutdown = true
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	err := b.ShutdownLoggersContext(context.Background())
+	Expect(err).To(MatchError("Shutdown failed"))
+	Expect(b.IsInitialized()).To(BeTrue())
+}
+
+type wedgedShutdownLogger struct {
+	*memLogger
+	ch chan struct{}
+}
+
+func (l *wedgedShutdownLogger) ShutdownLogger() error {
+	<-l.ch
+	return l.memLogger.ShutdownLogger()
+}
+
+type contextShutdownLogger struct {
+	*memLogger
+	ctx context.Context
+}
+
+func (l *contextShutdownLogger) ShutdownLoggerContext(ctx context.Context) error {
+	l.ctx = ctx
+	return l.memLogger.ShutdownLogger()
+}
+
+func (s *BaseSuite) TestNewChild(t sweet.T) {
+	b := NewBase()
+	b.SetLogLevel(LevelInfo)
+	b.SetAttr("app", "service")
+	parentLogger := newDefaultMemLogger()
+	b.AddLogger(parentLogger)
+	b.InitLoggers()
+
+	child, err := b.NewChild()
+	Expect(err).To(BeNil())
+	Expect(child.IsInitialized()).To(BeTrue())
+	Expect(child.logLevel()).To(Equal(LevelInfo))
+	childLogger := newDefaultMemLogger()
+	child.AddLogger(childLogger)
+	child.SetAttr("component", "db")
+	child.SetLogLevel(LevelDebug)
+
+	b.Info("parent")
+	child.Debug("child")
+	child.ShutdownLoggers()
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(parentLogger)).To(Equal([]string{"parent", "child"}))
+	Expect(parentLogger.Messages()[1].Attrs).To(Equal(map[string]interface{}{
+		"app":       "service",
+		"component": "db",
+	}))
+	Expect(memMessageTexts(childLogger)).To(Equal([]string{"child"}))
+	Expect(childLogger.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"app":       "service",
+		"component": "db",
+	}))
+	Expect(b.GetAttr("component")).To(BeNil())
+}
+
+func (s *BaseSuite) TestNewChildCallerInfo(t sweet.T) {
+	cfg := NewConfig()
+	cfg.FilenameAttr = "filename"
+	cfg.LineNumberAttr = "line"
+
+	b := NewBase()
+	b.SetConfig(cfg)
+	parentLogger := newDefaultMemLogger()
+	b.AddLogger(parentLogger)
+
+	child, err := b.NewChild()
+	Expect(err).To(BeNil())
+	Expect(child.IsInitialized()).To(BeFalse())
+	childLogger := newDefaultMemLogger()
+	child.AddLogger(childLogger)
+	b.InitLoggers()
+	child.InitLoggers()
+
+	b.Infom(NewAttrs().SetAttr("filename", "other.go"), "parent")
+	child.Info("child")
+	child.ShutdownLoggers()
+	b.ShutdownLoggers()
+
+	// The location the message was logged at in the child is kept
+	Expect(parentLogger.Messages()).To(HaveLen(2))
+	Expect(parentLogger.Messages()[0].Attrs["filename"]).NotTo(Equal("other.go"))
+	Expect(childLogger.Messages()).To(HaveLen(1))
+	Expect(parentLogger.Messages()[1].Attrs["filename"]).To(Equal(childLogger.Messages()[0].Attrs["filename"]))
+	Expect(parentLogger.Messages()[1].Attrs["line"]).To(Equal(childLogger.Messages()[0].Attrs["line"]))
+}
+
+func (s *BaseSuite) TestBaseOnExit(t sweet.T) {
+	b := NewBase()
+	b.SetExiter(curTestExiter)
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	var order []int
+	b.OnExit(func() {
+		order = append(order, 1)
+	})
+	b.OnExit(func() {
+		order = append(order, 2)
+		b.Info("cleaning up")
+	})
+
+	b.Die(1234, "test")
+	Expect(order).To(Equal([]int{2, 1}))
+	Expect(memMessageTexts(ml)).To(Equal([]string{"test", "cleaning up"}))
+	Expect(curTestExiter.code).To(Equal(1234))
+
+	// Hooks are only run once
+	b.InitLoggers()
+	b.Die(1, "again")
+	Expect(order).To(Equal([]int{2, 1}))
+}
+
+func (s *BaseSuite) TestBaseOnExitLogAdapter(t sweet.T) {
+	b := NewBase()
+	b.SetExiter(curTestExiter)
+	b.InitLoggers()
+
+	ran := false
+	b.OnExit(func() {
+		ran = true
+	})
+
+	b.NewLogAdapter(nil).Die(1234, "test")
+	Expect(ran).To(BeTrue())
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(curTestExiter.code).To(Equal(1234))
+}
+
+func (s *BaseSuite) TestBaseDieExitTimeout(t sweet.T) {
+	blocker := make(chan struct{})
+	defer close(blocker)
+
+	cfg := NewConfig()
+	cfg.ExitTimeout = 10 * time.Millisecond
+
+	b := NewBase()
+	b.SetConfig(cfg)
+	b.SetExiter(curTestExiter)
+	b.AddLogger(&wedgedShutdownLogger{memLogger: newDefaultMemLogger(), ch: blocker})
+	b.InitLoggers()
+
+	b.Die(1234, "test")
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(curTestExiter.exited).To(BeTrue())
+	Expect(curTestExiter.code).To(Equal(1234))
+}
+
+func (s *BaseSuite) TestBaseDieExitTimeoutHook(t sweet.T) {
+	blocker := make(chan struct{})
+	defer close(blocker)
+
+	cfg := NewConfig()
+	cfg.ExitTimeout = 10 * time.Millisecond
+
+	b := NewBase()
+	b.SetConfig(cfg)
+	b.SetExiter(curTestExiter)
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	b.OnExit(func() {
+		<-blocker
+	})
+
+	b.Die(1234, "test")
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(memMessageTexts(ml)).To(Equal([]string{"test"}))
+	Expect(curTestExiter.exited).To(BeTrue())
+	Expect(curTestExiter.code).To(Equal(1234))
+}
+
+func (s *BaseSuite) TestBaseDieExitTimeoutHookPanic(t sweet.T) {
+	cfg := NewConfig()
+	cfg.ExitTimeout = time.Minute
+
+	b := NewBase()
+	b.SetConfig(cfg)
+	b.SetExiter(curTestExiter)
+	b.InitLoggers()
+
+	b.OnExit(func() {
+		panic("hook failed")
+	})
+
+	var recovered interface{}
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		b.Die(1234, "test")
+	}()
+	Expect(recovered).To(Equal("hook failed"))
+}
+
+func (s *BaseSuite) TestBasePanicExiter(t sweet.T) {
+	b := NewBase()
+	b.SetExiter(&PanicExiter{})
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	var recovered interface{}
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		b.Die(1234, "test")
+	}()
+	Expect(recovered).To(Equal(&ExitPanic{Code: 1234}))
+	Expect(memMessageTexts(ml)).To(Equal([]string{"test"}))
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect((&ExitPanic{Code: 1234}).Error()).To(Equal("exit with code 1234"))
+}
+
+func (s *BaseSuite) TestBaseSetExiterNil(t sweet.T) {
+	b := NewBase()
+	Expect(b.exiter()).To(Equal(defaultExiter))
+
+	b.SetExiter(curTestExiter)
+	Expect(b.exiter()).To(Equal(curTestExiter))
+	child, err := b.NewChild()
+	Expect(err).To(BeNil())
+	Expect(child.exiter()).To(Equal(curTestExiter))
+
+	b.SetExiter(nil)
+	Expect(b.exiter()).To(Equal(defaultExiter))
+}
@@ -1,5 +1,7 @@
 package gomol
 
+import "time"
+
 // Config is the runtime configuration for Gomol
 type Config struct {
 	// FilenameAttr is the name of the attribute to put the log location's
@@ -17,8 +19,42 @@ type Config struct {
 
 	// MaxQueueSize is the number of log messages which will be queued before old
 	// messages are discarded.  This value takes effect once InitLoggers is called.
-	// Further changes to this value will not increase or decrease the queue size.
+	// Further changes to this value will not increase or decrease the queue size
+	// unless the new Config is applied with Base.Reload.
 	MaxQueueSize uint
+
+	// MaxBatchSize is the largest number of messages that will be passed to a
+	// BatchLogger in a single call to LogBatch.
+	MaxBatchSize uint
+
+	// MaxBatchWait is the longest the queue will hold on to a message waiting
+	// for more messages to fill a batch before passing the batch to any
+	// BatchLoggers.  A Flush will deliver a partial batch immediately.
+	MaxBatchWait time.Duration
+
+	// MaxRetrySize is the most messages kept for each logger to pass to it
+	// again after it returned an error for them.  They're passed to it again
+	// before the next message written to it.  Once a logger has this many
+	// the oldest is dropped.  If it's 0 failed messages aren't retried.
+	MaxRetrySize uint
+
+	// SpoolFile is the path of a file messages are written to before they're
+	// queued.  Messages stay in the file until they've been passed to every
+	// logger they're written to, and any left in it when InitLoggers is
+	// called, such as after a crash, are logged again.  If it's empty
+	// messages are only queued in memory.  This value takes effect once
+	// InitLoggers is called.
+	SpoolFile string
+
+	// SpoolSync will sync SpoolFile to disk after each message is written to
+	// it.  This makes sure messages survive the machine crashing, not just the
+	// process, at the cost of much slower logging.
+	SpoolSync bool
+
+	// ExitTimeout is the longest Die will wait for OnExit functions to run,
+	// queued messages to be written and loggers to shut down before exiting.
+	// If it is 0 Die waits until they've finished.
+	ExitTimeout time.Duration
 }
 
 // NewConfig creates a new configuration with default settings
@@ -28,5 +64,11 @@ func NewConfig() *Config {
 		LineNumberAttr: "",
 		SequenceAttr:   "",
 		MaxQueueSize:   10000,
+		MaxBatchSize:   100,
+		MaxBatchWait:   100 * time.Millisecond,
+		MaxRetrySize:   1000,
+		SpoolFile:      "",
+		SpoolSync:      false,
+		ExitTimeout:    0,
 	}
 }
@@ -1,6 +1,8 @@
 package gomol
 
 import (
+	"time"
+
 	"github.com/aphistic/sweet"
 	. "github.com/onsi/gomega"
 )
@@ -9,4 +11,6 @@ func (s *GomolSuite) TestNewConfig(t sweet.T) {
 	cfg := NewConfig()
 	Expect(cfg.FilenameAttr).To(Equal(""))
 	Expect(cfg.LineNumberAttr).To(Equal(""))
+	Expect(cfg.MaxRetrySize).To(Equal(uint(1000)))
+	Expect(cfg.ExitTimeout).To(Equal(time.Duration(0)))
 }
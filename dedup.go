@@ -0,0 +1,260 @@
+package gomol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// DedupConfig is the configuration for a Deduplicator
+type DedupConfig struct {
+	// Window is how long identical messages are collapsed for after the
+	// first one is logged
+	Window time.Duration
+
+	// Attrs are the names of attributes that must also be equal for two
+	// messages to be identical.  Other attributes are ignored.
+	Attrs []string
+
+	// SummaryFormat is the format string of the summary logged once the
+	// window ends, given the number of messages that were collapsed
+	SummaryFormat string
+
+	// CountAttr is the name of the attribute the number of collapsed
+	// messages is added to the summary as.  If it is empty the attribute
+	// isn't added.
+	CountAttr string
+}
+
+// NewDedupConfig creates a new DedupConfig that collapses identical messages
+// for 10 seconds
+func NewDedupConfig() *DedupConfig {
+	return &DedupConfig{
+		Window:        10 * time.Second,
+		Attrs:         nil,
+		SummaryFormat: "last message repeated %d times",
+		CountAttr:     "repeated",
+	}
+}
+
+/*
+Deduplicator collapses identical messages, those with the same level, message
+and configured attributes, logged within a window of each other.  The first
+message is logged right away and any identical messages after it in the window
+are counted instead of logged.  Once the window has ended a summary such as
+"last message repeated 532 times" is logged at the same level and with the same
+attributes as the first message.
+
+Summaries are logged as soon as their windows end, even if nothing else is
+logged, and any summaries still waiting are logged by Base.Flush and
+Base.ShutdownLoggers.
+
+A Deduplicator is set on a Base with SetDeduplicator and shouldn't be shared
+between more than one Base.
+*/
+type Deduplicator struct {
+	config *DedupConfig
+	clock  glock.Clock
+
+	lock         sync.Mutex
+	entries      map[string]*dedupEntry
+	nextExpiry   time.Time
+	timerRunning bool
+}
+
+type dedupEntry struct {
+	base    *Base
+	level   LogLevel
+	attrs   *Attrs
+	last    time.Time
+	expires time.Time
+	count   uint64
+}
+
+// NewDeduplicator creates a new Deduplicator.  If config is nil the values
+// from NewDedupConfig are used.
+func NewDeduplicator(config *DedupConfig) *Deduplicator {
+	if config == nil {
+		config = NewDedupConfig()
+	}
+
+	return &Deduplicator{
+		config:  config,
+		clock:   glock.NewRealClock(),
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+func (d *Deduplicator) key(msg *Message) string {
+	var key strings.Builder
+	key.WriteString(msg.Level.String())
+	key.WriteByte(0)
+	key.WriteString(msg.Msg)
+	for _, name := range d.config.Attrs {
+		key.WriteByte(0)
+		key.WriteString(attrString(msg.Attrs.GetAttr(name), time.RFC3339Nano))
+	}
+	return key.String()
+}
+
+// check returns whether msg should be logged, along with the summaries of any
+// windows that have ended and should be logged before it
+func (d *Deduplicator) check(msg *Message) ([]*Message, bool) {
+	now := d.clock.Now()
+	key := d.key(msg)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	summaries := d.expire(now)
+
+	if entry, ok := d.entries[key]; ok {
+		entry.count++
+		entry.last = msg.Timestamp
+		d.startTimer()
+		return summaries, false
+	}
+
+	expires := now.Add(d.config.Window)
+	d.entries[key] = &dedupEntry{
+		base:    msg.base,
+		level:   msg.Level,
+		attrs:   msg.Attrs.clone(),
+		last:    msg.Timestamp,
+		expires: expires,
+	}
+	if d.nextExpiry.IsZero() || expires.Before(d.nextExpiry) {
+		d.nextExpiry = expires
+	}
+
+	return summaries, true
+}
+
+// startTimer starts logging summaries as their windows end if it isn't
+// already.  It must be called with the lock held.
+func (d *Deduplicator) startTimer() {
+	if d.timerRunning {
+		return
+	}
+	d.timerRunning = true
+
+	go d.summarizeExpired()
+}
+
+// summarizeExpired logs the summary of each window that collapsed messages
+// once it ends, so a burst of messages followed by silence is still
+// summarized.  It stops once no windows are waiting for a summary.
+func (d *Deduplicator) summarizeExpired() {
+	for {
+		d.lock.Lock()
+		next := d.nextSummary()
+		if next.IsZero() {
+			d.timerRunning = false
+			d.lock.Unlock()
+			return
+		}
+		d.lock.Unlock()
+
+		<-d.clock.After(next.Sub(d.clock.Now()))
+
+		// The summaries are queued once the lock is released since
+		// queueing them can block and runs PreQueue hooks, which may log
+		// messages of their own.  A message logged at the same moment
+		// may be queued before them.
+		d.lock.Lock()
+		summaries := d.expire(d.clock.Now())
+		d.lock.Unlock()
+
+		for _, summary := range summaries {
+			summary.base.queueSummaries([]*Message{summary})
+		}
+	}
+}
+
+// nextSummary returns when the first window that collapsed messages ends, or
+// the zero time if none have.  It must be called with the lock held.
+func (d *Deduplicator) nextSummary() time.Time {
+	var next time.Time
+	for _, entry := range d.entries {
+		if entry.count > 0 && (next.IsZero() || entry.expires.Before(next)) {
+			next = entry.expires
+		}
+	}
+	return next
+}
+
+// expire removes the entries whose windows have ended and returns summaries
+// for the ones that collapsed any messages.  It must be called with the lock
+// held.
+func (d *Deduplicator) expire(now time.Time) []*Message {
+	if d.nextExpiry.IsZero() || now.Before(d.nextExpiry) {
+		return nil
+	}
+
+	var summaries []*Message
+	d.nextExpiry = time.Time{}
+	for key, entry := range d.entries {
+		if now.Before(entry.expires) {
+			if d.nextExpiry.IsZero() || entry.expires.Before(d.nextExpiry) {
+				d.nextExpiry = entry.expires
+			}
+			continue
+		}
+
+		if entry.count > 0 {
+			summaries = append(summaries, d.summary(entry))
+		}
+		delete(d.entries, key)
+	}
+
+	sortDedupSummaries(summaries)
+	return summaries
+}
+
+// flush returns summaries for all the entries that have collapsed messages,
+// whether their windows have ended or not.  Messages identical to theirs are
+// still collapsed until the windows end.
+func (d *Deduplicator) flush() []*Message {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var summaries []*Message
+	for _, entry := range d.entries {
+		if entry.count > 0 {
+			summaries = append(summaries, d.summary(entry))
+			entry.count = 0
+		}
+	}
+
+	sortDedupSummaries(summaries)
+	return summaries
+}
+
+// summary creates the summary message for entry.  It must be called with the
+// lock held.
+func (d *Deduplicator) summary(entry *dedupEntry) *Message {
+	attrs := entry.attrs.clone()
+	if len(d.config.CountAttr) > 0 {
+		attrs.SetAttr(d.config.CountAttr, entry.count)
+	}
+
+	return &Message{
+		base:      entry.base,
+		Level:     entry.level,
+		Timestamp: entry.last,
+		Attrs:     attrs,
+		Msg:       fmt.Sprintf(d.config.SummaryFormat, entry.count),
+	}
+}
+
+// sortDedupSummaries sorts summaries by the time of the last message each one
+// collapsed so they're logged in a consistent order
+func sortDedupSummaries(summaries []*Message) {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Timestamp.Before(summaries[j].Timestamp)
+	})
+}
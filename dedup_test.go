@@ -0,0 +1,206 @@
+package gomol
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type DedupSuite struct{}
+
+func newTestDeduplicator(config *DedupConfig, clock glock.Clock) *Deduplicator {
+	dedup := NewDeduplicator(config)
+	dedup.clock = clock
+	return dedup
+}
+
+func (s *DedupSuite) TestCollapse(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	for idx := 0; idx < 5; idx++ {
+		b.Errorm(NewAttrs().SetAttr("attempt", idx), "connection refused")
+	}
+	b.Error("other")
+	b.Info("connection refused")
+	b.Flush()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"connection refused",
+		"other",
+		"connection refused",
+		"last message repeated 4 times",
+	}))
+
+	summary := ml.Messages()[3]
+	Expect(summary.Level).To(Equal(LevelError))
+	Expect(summary.Attrs).To(Equal(map[string]interface{}{
+		"attempt":  0,
+		"repeated": uint64(4),
+	}))
+}
+
+func (s *DedupSuite) TestWindowEnds(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	b.Warn("flapping")
+	b.Warn("flapping")
+	b.Warn("flapping")
+	clock.Advance(10 * time.Second)
+	b.Warn("flapping")
+	b.Warn("flapping")
+	b.Flush()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"flapping",
+		"last message repeated 2 times",
+		"flapping",
+		"last message repeated 1 times",
+	}))
+}
+
+func (s *DedupSuite) TestWindowEndsOnOtherMessage(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	b.Warn("flapping")
+	b.Warn("flapping")
+	clock.Advance(10 * time.Second)
+	b.Info("something else")
+	b.Flush()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"flapping",
+		"last message repeated 1 times",
+		"something else",
+	}))
+}
+
+func (s *DedupSuite) TestWindowEndsWithoutMessages(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	b.Warn("flapping")
+	b.Warn("flapping")
+	b.Warn("flapping")
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(10 * time.Second)
+
+	Eventually(func() []string {
+		return memMessageTexts(ml)
+	}).Should(Equal([]string{
+		"flapping",
+		"last message repeated 2 times",
+	}))
+}
+
+func (s *DedupSuite) TestSummaryHookLogs(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.AddLogger(&summaryHookLogger{memLogger: newDefaultMemLogger(), base: b})
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	b.Warn("flapping")
+	b.Warn("flapping")
+
+	// The hook logs while the summary is being queued, which can't wait
+	// on the deduplicator
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(10 * time.Second)
+
+	Eventually(func() []string {
+		return memMessageTexts(ml)
+	}).Should(Equal([]string{
+		"flapping",
+		"summary queued",
+		"last message repeated 1 times",
+	}))
+}
+
+// summaryHookLogger logs a message of its own when a summary is queued
+type summaryHookLogger struct {
+	*memLogger
+	base *Base
+}
+
+func (l *summaryHookLogger) PreQueue(msg *Message) error {
+	if strings.HasPrefix(msg.Msg, "last message") {
+		return l.base.Info("summary queued")
+	}
+	return nil
+}
+
+func (s *DedupSuite) TestFlushKeepsWindow(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+	defer b.ShutdownLoggers()
+
+	b.Warn("flapping")
+	b.Warn("flapping")
+	b.Flush()
+	b.Warn("flapping")
+	b.Flush()
+	b.Flush()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"flapping",
+		"last message repeated 1 times",
+		"last message repeated 1 times",
+	}))
+}
+
+func (s *DedupSuite) TestAttrs(t sweet.T) {
+	cfg := NewDedupConfig()
+	cfg.Attrs = []string{"host"}
+	cfg.CountAttr = ""
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(cfg, clock))
+
+	b.Errorm(NewAttrs().SetAttr("host", "a").SetAttr("attempt", 1), "down")
+	b.Errorm(NewAttrs().SetAttr("host", "b").SetAttr("attempt", 2), "down")
+	b.Errorm(NewAttrs().SetAttr("host", "a").SetAttr("attempt", 3), "down")
+	b.Errorm(NewAttrs().SetAttr("host", "a").SetAttr("attempt", 4), "down")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"down",
+		"down",
+		"last message repeated 2 times",
+	}))
+	Expect(ml.Messages()[2].Attrs).To(Equal(map[string]interface{}{
+		"host":    "a",
+		"attempt": 1,
+	}))
+}
+
+func (s *DedupSuite) TestShutdown(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetDeduplicator(newTestDeduplicator(nil, clock))
+
+	b.Info("repeated")
+	b.Info("repeated")
+	b.Info("repeated")
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"repeated",
+		"last message repeated 2 times",
+	}))
+}
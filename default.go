@@ -1,5 +1,7 @@
 package gomol
 
+import "context"
+
 var curDefault *Base
 
 func init() {
@@ -16,6 +18,11 @@ func SetConfig(config *Config) {
 	curDefault.SetConfig(config)
 }
 
+// Reload executes the same function on the default Base instance
+func Reload(cfg *BaseConfig) error {
+	return curDefault.Reload(cfg)
+}
+
 // SetErrorChan executes the same function on the default Base instance
 func SetErrorChan(ch chan<- error) {
 	curDefault.SetErrorChan(ch)
@@ -26,6 +33,31 @@ func SetLogLevel(level LogLevel) {
 	curDefault.SetLogLevel(level)
 }
 
+// SetSampler executes the same function on the default Base instance
+func SetSampler(sampler *Sampler) {
+	curDefault.SetSampler(sampler)
+}
+
+// SetDeduplicator executes the same function on the default Base instance
+func SetDeduplicator(dedup *Deduplicator) {
+	curDefault.SetDeduplicator(dedup)
+}
+
+// SetRouter executes the same function on the default Base instance
+func SetRouter(router *Router) {
+	curDefault.SetRouter(router)
+}
+
+// SetExiter executes the same function on the default Base instance
+func SetExiter(exiter Exiter) {
+	curDefault.SetExiter(exiter)
+}
+
+// OnExit executes the same function on the default Base instance
+func OnExit(f func()) {
+	curDefault.OnExit(f)
+}
+
 // SetFallbackLogger executes the same function on the default Base instance
 func SetFallbackLogger(logger Logger) error {
 	return curDefault.SetFallbackLogger(logger)
@@ -62,11 +94,21 @@ func Flush() {
 	curDefault.Flush()
 }
 
+// FlushContext executes the same function on the default Base instance
+func FlushContext(ctx context.Context) error {
+	return curDefault.FlushContext(ctx)
+}
+
 // ShutdownLoggers executes the same function on the default Base instance
 func ShutdownLoggers() error {
 	return curDefault.ShutdownLoggers()
 }
 
+// ShutdownLoggersContext executes the same function on the default Base instance
+func ShutdownLoggersContext(ctx context.Context) error {
+	return curDefault.ShutdownLoggersContext(ctx)
+}
+
 // ClearAttrs executes the same function on the default Base instance
 func ClearAttrs() {
 	curDefault.ClearAttrs()
@@ -87,6 +129,11 @@ func RemoveAttr(key string) {
 	curDefault.RemoveAttr(key)
 }
 
+// NewChild executes the same function on the default Base instance
+func NewChild() (*Base, error) {
+	return curDefault.NewChild()
+}
+
 // NewLogAdapter executes the same function on the default Base instance
 func NewLogAdapter(attrs *Attrs) *LogAdapter {
 	return curDefault.NewLogAdapter(attrs)
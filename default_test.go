@@ -1,6 +1,9 @@
 package gomol
 
 import (
+	"context"
+	"time"
+
 	"github.com/aphistic/sweet"
 	"github.com/efritz/glock"
 	. "github.com/onsi/gomega"
@@ -16,6 +19,8 @@ func (s *DefaultSuite) SetUpTest(t sweet.T) {
 	curDefault = NewBase(
 		withClock(s.currentClock),
 	)
+	curTestExiter = &testExiter{}
+	curDefault.SetExiter(curTestExiter)
 	cfg := newMemLoggerConfig()
 	memL, _ := newMemLogger(cfg)
 	curDefault.AddLogger(memL)
@@ -29,15 +34,29 @@ func (s *DefaultSuite) TearDownTest(t sweet.T) {
 func (s *DefaultSuite) TestDefaultSetConfig(t sweet.T) {
 	cfg := NewConfig()
 
-	Expect(curDefault.config).To(Equal(cfg))
+	Expect(curDefault.config()).To(Equal(cfg))
 
 	cfg.FilenameAttr = "file"
 	cfg.LineNumberAttr = "line"
 	cfg.SequenceAttr = "seq"
 	SetConfig(cfg)
 
-	Expect(curDefault.config).To(Equal(cfg))
-	Expect(curDefault.config).To(Equal(cfg))
+	Expect(curDefault.config()).To(Equal(cfg))
+	Expect(curDefault.config()).To(Equal(cfg))
+}
+
+func (s *DefaultSuite) TestDefaultReload(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	cfg := NewBaseConfig()
+	cfg.LogLevel = LevelWarning
+	cfg.Loggers = []Logger{ml}
+
+	err := Reload(cfg)
+	Expect(err).To(BeNil())
+	Expect(curDefault.logLevel()).To(Equal(LevelWarning))
+	Expect(curDefault.loggers()).To(Equal([]Logger{ml}))
+	Expect(ml.IsInitialized()).To(BeTrue())
 }
 
 func (s *DefaultSuite) TestDefaultSetErrorChan(t sweet.T) {
@@ -51,7 +70,7 @@ func (s *DefaultSuite) TestDefaultInitLogger(t sweet.T) {
 	curDefault = NewBase()
 	Expect(IsInitialized()).To(Equal(false))
 	AddLogger(newDefaultMemLogger())
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.IsInitialized()).To(Equal(false))
 	InitLoggers()
 	Expect(IsInitialized()).To(Equal(true))
@@ -65,27 +84,42 @@ func (s *DefaultSuite) TestDefaultShutdownLogger(t sweet.T) {
 	AddLogger(newDefaultMemLogger())
 	InitLoggers()
 	Expect(IsInitialized()).To(Equal(true))
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.isShutdown).To(Equal(false))
 	ShutdownLoggers()
 	Expect(defLogger.isShutdown).To(Equal(true))
 	Expect(IsInitialized()).To(Equal(false))
 }
 
+func (s *DefaultSuite) TestDefaultShutdownLoggersContext(t sweet.T) {
+	curDefault = NewBase()
+	AddLogger(newDefaultMemLogger())
+	InitLoggers()
+	defLogger := curDefault.loggers()[0].(*memLogger)
+
+	Info("message")
+	Expect(FlushContext(context.Background())).To(BeNil())
+	Expect(defLogger.Messages()).To(HaveLen(1))
+
+	Expect(ShutdownLoggersContext(context.Background())).To(BeNil())
+	Expect(defLogger.isShutdown).To(Equal(true))
+	Expect(IsInitialized()).To(Equal(false))
+}
+
 func (s *DefaultSuite) TestSetFallbackLogger(t sweet.T) {
 	curDefault = NewBase()
-	Expect(curDefault.fallbackLogger).To(BeNil())
+	Expect(curDefault.fallbackLogger()).To(BeNil())
 
 	ml := newDefaultMemLogger()
 	SetFallbackLogger(ml)
-	Expect(curDefault.fallbackLogger).To(Equal(ml))
+	Expect(curDefault.fallbackLogger()).To(Equal(ml))
 }
 
 func (s *DefaultSuite) TestDefaultAddLogger(t sweet.T) {
 	curDefault = NewBase()
-	Expect(curDefault.loggers).To(HaveLen(0))
+	Expect(curDefault.loggers()).To(HaveLen(0))
 	AddLogger(newDefaultMemLogger())
-	Expect(curDefault.loggers).To(HaveLen(1))
+	Expect(curDefault.loggers()).To(HaveLen(1))
 }
 
 func (s *DefaultSuite) TestDefaultRemoveLogger(t sweet.T) {
@@ -103,14 +137,14 @@ func (s *DefaultSuite) TestDefaultRemoveLogger(t sweet.T) {
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(true))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(curDefault.loggers).To(HaveLen(3))
+	Expect(curDefault.loggers()).To(HaveLen(3))
 
 	err := RemoveLogger(ml2)
 	Expect(err).To(BeNil())
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(false))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(curDefault.loggers).To(HaveLen(2))
+	Expect(curDefault.loggers()).To(HaveLen(2))
 }
 
 func (s *DefaultSuite) TestDefaultClearLoggers(t sweet.T) {
@@ -128,14 +162,14 @@ func (s *DefaultSuite) TestDefaultClearLoggers(t sweet.T) {
 	Expect(ml1.IsInitialized()).To(Equal(true))
 	Expect(ml2.IsInitialized()).To(Equal(true))
 	Expect(ml3.IsInitialized()).To(Equal(true))
-	Expect(curDefault.loggers).To(HaveLen(3))
+	Expect(curDefault.loggers()).To(HaveLen(3))
 
 	err := ClearLoggers()
 	Expect(err).To(BeNil())
 	Expect(ml1.IsInitialized()).To(Equal(false))
 	Expect(ml2.IsInitialized()).To(Equal(false))
 	Expect(ml3.IsInitialized()).To(Equal(false))
-	Expect(curDefault.loggers).To(HaveLen(0))
+	Expect(curDefault.loggers()).To(HaveLen(0))
 }
 
 func (s *DefaultSuite) TestDefaultSetLogLevel(t sweet.T) {
@@ -154,6 +188,52 @@ func (s *DefaultSuite) TestDefaultSetLogLevel(t sweet.T) {
 	Expect(ml.Messages()).To(HaveLen(3))
 }
 
+func (s *DefaultSuite) TestDefaultSetSampler(t sweet.T) {
+	curDefault = NewBase()
+	InitLoggers()
+	ml := newDefaultMemLogger()
+	AddLogger(ml)
+
+	SetSampler(NewSampler(&SamplerConfig{Interval: time.Hour, First: 1}))
+	Info("test")
+	Info("test")
+	ShutdownLoggers()
+	Expect(ml.Messages()).To(HaveLen(1))
+}
+
+func (s *DefaultSuite) TestDefaultSetDeduplicator(t sweet.T) {
+	curDefault = NewBase()
+	InitLoggers()
+	ml := newDefaultMemLogger()
+	AddLogger(ml)
+
+	SetDeduplicator(NewDeduplicator(nil))
+	Info("test")
+	Info("test")
+	ShutdownLoggers()
+	Expect(ml.Messages()).To(HaveLen(2))
+	Expect(ml.Messages()[1].Message).To(Equal("last message repeated 1 times"))
+}
+
+func (s *DefaultSuite) TestDefaultSetRouter(t sweet.T) {
+	curDefault = NewBase()
+	InitLoggers()
+	ml1 := newDefaultMemLogger()
+	ml2 := newDefaultMemLogger()
+	AddLogger(ml1)
+	AddLogger(ml2)
+
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{NewRoute(ml1)}
+	router, err := NewRouter(cfg)
+	Expect(err).To(BeNil())
+	SetRouter(router)
+	Info("test")
+	ShutdownLoggers()
+	Expect(ml1.Messages()).To(HaveLen(1))
+	Expect(ml2.Messages()).To(HaveLen(0))
+}
+
 func (s *DefaultSuite) TestDefaultSetAttr(t sweet.T) {
 	curDefault = NewBase()
 	Expect(curDefault.BaseAttrs.Attrs()).To(HaveLen(0))
@@ -194,9 +274,23 @@ func (s *DefaultSuite) TestDefaultClearAttrs(t sweet.T) {
 	Expect(curDefault.BaseAttrs.Attrs()).To(HaveLen(0))
 }
 
+func (s *DefaultSuite) TestDefaultNewChild(t sweet.T) {
+	curDefault = NewBase()
+	ml := newDefaultMemLogger()
+	AddLogger(ml)
+	InitLoggers()
+
+	child, err := NewChild()
+	Expect(err).To(BeNil())
+	child.Info("test")
+	child.ShutdownLoggers()
+	ShutdownLoggers()
+	Expect(ml.Messages()).To(HaveLen(1))
+}
+
 func (s *DefaultSuite) TestDefaultNewLogAdapter(t sweet.T) {
 	la := NewLogAdapter(NewAttrs().SetAttr("foo", "bar"))
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 
 	la.Dbgm(NewAttrs().SetAttr("attr", "val"), "test")
 
@@ -221,7 +315,7 @@ func (s *DefaultSuite) TestDefaultNewLogAdapter(t sweet.T) {
 func (s *DefaultSuite) TestDefaultDbg(t sweet.T) {
 	Dbg("test")
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -235,7 +329,7 @@ func (s *DefaultSuite) TestDefaultDbg(t sweet.T) {
 func (s *DefaultSuite) TestDefaultDbgf(t sweet.T) {
 	Dbgf("test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -251,7 +345,7 @@ func (s *DefaultSuite) TestDefaultDbgm(t sweet.T) {
 		NewAttrs().SetAttr("attr1", 4321),
 		"test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -269,7 +363,7 @@ func (s *DefaultSuite) TestDefaultDbgm(t sweet.T) {
 func (s *DefaultSuite) TestDefaultInfo(t sweet.T) {
 	Info("test")
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -283,7 +377,7 @@ func (s *DefaultSuite) TestDefaultInfo(t sweet.T) {
 func (s *DefaultSuite) TestDefaultInfof(t sweet.T) {
 	Infof("test %v", 1234)
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -301,7 +395,7 @@ func (s *DefaultSuite) TestDefaultInfom(t sweet.T) {
 		1234,
 	)
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -319,7 +413,7 @@ func (s *DefaultSuite) TestDefaultInfom(t sweet.T) {
 func (s *DefaultSuite) TestDefaultWarn(t sweet.T) {
 	Warn("test")
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -333,7 +427,7 @@ func (s *DefaultSuite) TestDefaultWarn(t sweet.T) {
 func (s *DefaultSuite) TestDefaultWarnf(t sweet.T) {
 	Warnf("test %v", 1234)
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -351,7 +445,7 @@ func (s *DefaultSuite) TestDefaultWarnm(t sweet.T) {
 		1234,
 	)
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -369,7 +463,7 @@ func (s *DefaultSuite) TestDefaultWarnm(t sweet.T) {
 func (s *DefaultSuite) TestDefaultErr(t sweet.T) {
 	Err("test")
 	curDefault.Flush()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -383,7 +477,7 @@ func (s *DefaultSuite) TestDefaultErr(t sweet.T) {
 func (s *DefaultSuite) TestDefaultErrf(t sweet.T) {
 	Errf("test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -399,7 +493,7 @@ func (s *DefaultSuite) TestDefaultErrm(t sweet.T) {
 		NewAttrs().SetAttr("attr1", 4321),
 		"test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -417,7 +511,7 @@ func (s *DefaultSuite) TestDefaultErrm(t sweet.T) {
 func (s *DefaultSuite) TestDefaultFatal(t sweet.T) {
 	Fatal("test")
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -431,7 +525,7 @@ func (s *DefaultSuite) TestDefaultFatal(t sweet.T) {
 func (s *DefaultSuite) TestDefaultFatalf(t sweet.T) {
 	Fatalf("test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -447,7 +541,7 @@ func (s *DefaultSuite) TestDefaultFatalm(t sweet.T) {
 		NewAttrs().SetAttr("attr1", 4321),
 		"test %v", 1234)
 	curDefault.queue.stopWorker()
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -462,9 +556,22 @@ func (s *DefaultSuite) TestDefaultFatalm(t sweet.T) {
 	}))
 }
 
+func (s *DefaultSuite) TestDefaultOnExit(t sweet.T) {
+	exiter := &testExiter{}
+	SetExiter(exiter)
+
+	ran := false
+	OnExit(func() {
+		ran = true
+	})
+	Die(1234, "test")
+	Expect(ran).To(BeTrue())
+	Expect(exiter.code).To(Equal(1234))
+}
+
 func (s *DefaultSuite) TestDefaultDie(t sweet.T) {
 	Die(1234, "test")
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -474,14 +581,14 @@ func (s *DefaultSuite) TestDefaultDie(t sweet.T) {
 		StringAttrs: map[string]string{},
 	}))
 
-	Expect(curDefault.isInitialized).To(Equal(false))
+	Expect(curDefault.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
 
 func (s *DefaultSuite) TestDefaultDief(t sweet.T) {
 	Dief(1234, "test %v", 1234)
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp:   s.currentClock.Now(),
@@ -494,7 +601,7 @@ func (s *DefaultSuite) TestDefaultDief(t sweet.T) {
 	Expect(defLogger.Messages()[0].Message).To(Equal("test 1234"))
 	Expect(defLogger.Messages()[0].Attrs).To(HaveLen(0))
 
-	Expect(curDefault.isInitialized).To(Equal(false))
+	Expect(curDefault.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
@@ -504,7 +611,7 @@ func (s *DefaultSuite) TestDefaultDiem(t sweet.T) {
 		1234,
 		NewAttrs().SetAttr("attr1", 4321),
 		"test %v", 1234)
-	defLogger := curDefault.loggers[0].(*memLogger)
+	defLogger := curDefault.loggers()[0].(*memLogger)
 	Expect(defLogger.Messages()).To(HaveLen(1))
 	Expect(defLogger.Messages()[0]).To(Equal(&memMessage{
 		Timestamp: s.currentClock.Now(),
@@ -518,7 +625,7 @@ func (s *DefaultSuite) TestDefaultDiem(t sweet.T) {
 		},
 	}))
 
-	Expect(curDefault.isInitialized).To(Equal(false))
+	Expect(curDefault.IsInitialized()).To(Equal(false))
 	Expect(curTestExiter.exited).To(Equal(true))
 	Expect(curTestExiter.code).To(Equal(1234))
 }
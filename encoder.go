@@ -0,0 +1,82 @@
+package gomol
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Encoder converts a log message into the bytes a WriterLogger writes for it.
+// The attrs passed to Encode are the Base's attributes merged with the
+// message's attributes.  Encoders are expected to end each message with a
+// newline.
+type Encoder interface {
+	Encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error
+}
+
+// ContentTypeEncoder is an Encoder that knows the media type of what it
+// writes, which is used as the Content-Type of requests sent with its output
+type ContentTypeEncoder interface {
+	Encoder
+
+	ContentType() string
+}
+
+// TemplateEncoder is an Encoder that formats messages with a Template
+type TemplateEncoder struct {
+	tpl      *Template
+	colorize bool
+}
+
+var _ Encoder = &TemplateEncoder{}
+
+// NewTemplateEncoder creates a TemplateEncoder that formats messages with tpl.
+// If colorize is true the template's color functions will insert ANSI color
+// codes.
+func NewTemplateEncoder(tpl *Template, colorize bool) *TemplateEncoder {
+	return &TemplateEncoder{
+		tpl:      tpl,
+		colorize: colorize,
+	}
+}
+
+// Encode executes the template for the message and writes the result to buf
+func (e *TemplateEncoder) Encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	out, err := e.tpl.Execute(NewTemplateMsg(timestamp, level, attrs, msg), e.colorize)
+	if err != nil {
+		return err
+	}
+
+	buf.WriteString(out)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// sortedAttrKeys returns the keys of attrs in sorted order
+func sortedAttrKeys(attrs map[string]interface{}) []string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// attrString converts an attribute value to the string encoders write for it
+func attrString(val interface{}, timeFormat string) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(timeFormat)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,142 @@
+package gomol
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CEFEncoderConfig is the configuration for a CEFEncoder
+type CEFEncoderConfig struct {
+	// DeviceVendor is the vendor of the product sending the events
+	DeviceVendor string
+
+	// DeviceProduct is the name of the product sending the events
+	DeviceProduct string
+
+	// DeviceVersion is the version of the product sending the events
+	DeviceVersion string
+
+	// SignatureIDAttr is the name of the attribute holding a message's
+	// Signature ID.  If a message doesn't have the attribute its level name
+	// is used instead.
+	SignatureIDAttr string
+}
+
+// NewCEFEncoderConfig creates a new CEFEncoderConfig with default settings
+func NewCEFEncoderConfig() *CEFEncoderConfig {
+	return &CEFEncoderConfig{
+		DeviceVendor:    "gomol",
+		DeviceProduct:   "gomol",
+		DeviceVersion:   "1.0",
+		SignatureIDAttr: "signature_id",
+	}
+}
+
+/*
+CEFEncoder is an Encoder that writes each message as an ArcSight Common Event
+Format (CEF) event.  The message is used as the event's Name and its level is
+mapped to a CEF severity between 1 (debug) and 10 (fatal).  The message's
+attributes are written as extension fields sorted by name, along with its
+timestamp in the "rt" field.
+*/
+type CEFEncoder struct {
+	config *CEFEncoderConfig
+}
+
+var _ Encoder = &CEFEncoder{}
+
+// NewCEFEncoder creates a new CEFEncoder.  If config is nil the values from
+// NewCEFEncoderConfig are used.
+func NewCEFEncoder(config *CEFEncoderConfig) *CEFEncoder {
+	if config == nil {
+		config = NewCEFEncoderConfig()
+	}
+
+	return &CEFEncoder{
+		config: config,
+	}
+}
+
+// Encode writes the message to buf as a CEF event
+func (e *CEFEncoder) Encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	signatureID := level.String()
+	if val, ok := attrs[e.config.SignatureIDAttr]; ok && len(e.config.SignatureIDAttr) > 0 {
+		signatureID = attrString(val, time.RFC3339Nano)
+	}
+
+	buf.WriteString("CEF:0|")
+	for _, field := range []string{
+		e.config.DeviceVendor,
+		e.config.DeviceProduct,
+		e.config.DeviceVersion,
+		signatureID,
+		msg,
+		strconv.Itoa(cefSeverity(level)),
+	} {
+		buf.WriteString(cefHeaderReplacer.Replace(field))
+		buf.WriteByte('|')
+	}
+
+	buf.WriteString("rt=")
+	buf.WriteString(strconv.FormatInt(timestamp.UnixNano()/int64(time.Millisecond), 10))
+	for _, key := range sortedAttrKeys(attrs) {
+		name := cefExtensionKey(key)
+		if len(name) == 0 || name == "rt" || key == e.config.SignatureIDAttr {
+			continue
+		}
+
+		buf.WriteByte(' ')
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(cefExtensionReplacer.Replace(attrString(attrs[key], time.RFC3339Nano)))
+	}
+
+	buf.WriteByte('\n')
+	return nil
+}
+
+var cefHeaderReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	"\r", " ",
+	"\n", " ",
+)
+
+var cefExtensionReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`=`, `\=`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+func cefSeverity(level LogLevel) int {
+	switch level {
+	case LevelDebug:
+		return 1
+	case LevelInfo:
+		return 3
+	case LevelWarning:
+		return 5
+	case LevelError:
+		return 8
+	case LevelFatal:
+		return 10
+	default:
+		return 0
+	}
+}
+
+// cefExtensionKey removes any characters from key that aren't allowed in an
+// extension key
+func cefExtensionKey(key string) string {
+	name := make([]byte, 0, len(key))
+	for idx := 0; idx < len(key); idx++ {
+		c := key[idx]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '.' {
+			name = append(name, c)
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,297 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONTimeEpochMillis can be used as the TimeFormat of a JSONEncoderConfig to
+// write timestamps as the number of milliseconds since the Unix epoch
+const JSONTimeEpochMillis = "epoch_millis"
+
+// JSONEncoderConfig is the configuration for a JSONEncoder
+type JSONEncoderConfig struct {
+	// TimeKey is the key the message's timestamp is written with.  If it is
+	// empty the timestamp isn't written.
+	TimeKey string
+
+	// LevelKey is the key the message's level is written with.  If it is
+	// empty the level isn't written.
+	LevelKey string
+
+	// MessageKey is the key the message is written with
+	MessageKey string
+
+	// TimeFormat is the layout the message's timestamp and any time.Time
+	// attributes are written with, or JSONTimeEpochMillis to write them as
+	// milliseconds since the Unix epoch.
+	TimeFormat string
+
+	// UppercaseLevel writes level names in upper case, such as "INFO"
+	UppercaseLevel bool
+}
+
+// NewJSONEncoderConfig creates a new JSONEncoderConfig with default settings
+func NewJSONEncoderConfig() *JSONEncoderConfig {
+	return &JSONEncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		MessageKey:     "message",
+		TimeFormat:     time.RFC3339Nano,
+		UppercaseLevel: false,
+	}
+}
+
+/*
+JSONEncoder is an Encoder that writes each message as a JSON object on its own
+line.  The object has the message's timestamp, level and message followed by
+its attributes sorted by name.  Attributes with the same name as one of the
+first three keys are ignored.
+
+JSONEncoder writes the JSON itself instead of using encoding/json so strings,
+numbers, bools, times, errors and fmt.Stringers can be written without
+reflection or extra allocations.  Attribute values of any other type are
+written with json.Marshal.
+*/
+type JSONEncoder struct {
+	config *JSONEncoderConfig
+}
+
+var _ ContentTypeEncoder = &JSONEncoder{}
+
+// NewJSONEncoder creates a new JSONEncoder.  If config is nil the values from
+// NewJSONEncoderConfig are used.
+func NewJSONEncoder(config *JSONEncoderConfig) *JSONEncoder {
+	if config == nil {
+		config = NewJSONEncoderConfig()
+	}
+
+	return &JSONEncoder{
+		config: config,
+	}
+}
+
+// ContentType returns the newline delimited JSON content type
+func (e *JSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Encode writes the message to buf as a line of JSON
+func (e *JSONEncoder) Encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	buf.WriteByte('{')
+
+	first := true
+	writeKey := func(key string) {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		writeJSONString(buf, key)
+		buf.WriteByte(':')
+	}
+
+	if len(e.config.TimeKey) > 0 {
+		writeKey(e.config.TimeKey)
+		e.writeTime(buf, timestamp)
+	}
+	if len(e.config.LevelKey) > 0 {
+		writeKey(e.config.LevelKey)
+		e.writeLevel(buf, level)
+	}
+	writeKey(e.config.MessageKey)
+	writeJSONString(buf, msg)
+
+	for _, key := range sortedAttrKeys(attrs) {
+		if key == e.config.TimeKey || key == e.config.LevelKey || key == e.config.MessageKey {
+			continue
+		}
+		writeKey(key)
+		e.writeValue(buf, attrs[key])
+	}
+
+	buf.WriteString("}\n")
+	return nil
+}
+
+func (e *JSONEncoder) writeTime(buf *bytes.Buffer, t time.Time) {
+	var scratch [64]byte
+	if e.config.TimeFormat == JSONTimeEpochMillis {
+		buf.Write(strconv.AppendInt(scratch[:0], t.UnixNano()/int64(time.Millisecond), 10))
+		return
+	}
+
+	buf.WriteByte('"')
+	buf.Write(t.AppendFormat(scratch[:0], e.config.TimeFormat))
+	buf.WriteByte('"')
+}
+
+func (e *JSONEncoder) writeLevel(buf *bytes.Buffer, level LogLevel) {
+	name := getLevelName(level)
+	if !e.config.UppercaseLevel {
+		writeJSONString(buf, name)
+		return
+	}
+
+	// Level names are always lower case ASCII
+	buf.WriteByte('"')
+	for idx := 0; idx < len(name); idx++ {
+		buf.WriteByte(name[idx] - ('a' - 'A'))
+	}
+	buf.WriteByte('"')
+}
+
+func (e *JSONEncoder) writeValue(buf *bytes.Buffer, val interface{}) {
+	var scratch [64]byte
+
+	switch v := val.(type) {
+	case nil:
+		buf.WriteString("null")
+	case string:
+		writeJSONString(buf, v)
+	case bool:
+		buf.Write(strconv.AppendBool(scratch[:0], v))
+	case int:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int8:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int16:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int32:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int64:
+		buf.Write(strconv.AppendInt(scratch[:0], v, 10))
+	case uint:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint8:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint16:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint32:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint64:
+		buf.Write(strconv.AppendUint(scratch[:0], v, 10))
+	case float32:
+		writeJSONFloat(buf, float64(v), 32)
+	case float64:
+		writeJSONFloat(buf, v, 64)
+	case time.Time:
+		e.writeTime(buf, v)
+	case time.Duration:
+		writeJSONString(buf, v.String())
+	case LogLevel:
+		e.writeLevel(buf, v)
+	case json.Marshaler:
+		writeJSONMarshaled(buf, v)
+	case error:
+		writeJSONString(buf, v.Error())
+	case fmt.Stringer:
+		writeJSONString(buf, v.String())
+	default:
+		writeJSONMarshaled(buf, v)
+	}
+}
+
+// writeJSONMarshaled writes val using encoding/json, falling back to its
+// fmt representation as a string if it can't be marshaled
+func writeJSONMarshaled(buf *bytes.Buffer, val interface{}) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		writeJSONString(buf, fmt.Sprint(val))
+		return
+	}
+	buf.Write(data)
+}
+
+// writeJSONFloat writes f the same way encoding/json does.  NaN and infinite
+// values can't be represented in JSON so they're written as strings.
+func writeJSONFloat(buf *bytes.Buffer, f float64, bits int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		writeJSONString(buf, strconv.FormatFloat(f, 'g', -1, bits))
+		return
+	}
+
+	var scratch [64]byte
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	data := strconv.AppendFloat(scratch[:0], f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9 to match encoding/json
+		n := len(data)
+		if n >= 4 && data[n-4] == 'e' && data[n-3] == '-' && data[n-2] == '0' {
+			data[n-2] = data[n-1]
+			data = data[:n-1]
+		}
+	}
+	buf.Write(data)
+}
+
+const jsonHex = "0123456789abcdef"
+
+// writeJSONString writes s as a quoted JSON string.  Invalid UTF-8 is replaced
+// with the Unicode replacement character like encoding/json does.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+
+	start := 0
+	for idx := 0; idx < len(s); {
+		if c := s[idx]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				idx++
+				continue
+			}
+
+			buf.WriteString(s[start:idx])
+			switch c {
+			case '"', '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(jsonHex[c>>4])
+				buf.WriteByte(jsonHex[c&0xf])
+			}
+			idx++
+			start = idx
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[idx:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(s[start:idx])
+			buf.WriteString(`\ufffd`)
+			idx += size
+			start = idx
+			continue
+		}
+
+		// U+2028 and U+2029 are valid JSON but break JavaScript parsers
+		if r == '\u2028' || r == '\u2029' {
+			buf.WriteString(s[start:idx])
+			buf.WriteString(`\u202`)
+			buf.WriteByte(jsonHex[r&0xf])
+			idx += size
+			start = idx
+			continue
+		}
+
+		idx += size
+	}
+	buf.WriteString(s[start:])
+
+	buf.WriteByte('"')
+}
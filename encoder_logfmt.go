@@ -0,0 +1,279 @@
+package gomol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LogfmtEncoderConfig is the configuration for a LogfmtEncoder
+type LogfmtEncoderConfig struct {
+	// TimeKey is the key the message's timestamp is written with.  If it is
+	// empty the timestamp isn't written.
+	TimeKey string
+
+	// LevelKey is the key the message's level is written with.  If it is
+	// empty the level isn't written.
+	LevelKey string
+
+	// MessageKey is the key the message is written with
+	MessageKey string
+
+	// TimeFormat is the format the message's timestamp and any time.Time
+	// attributes are written in
+	TimeFormat string
+
+	// KeyOrder is the order attributes are written in after the timestamp,
+	// level and message.  Attributes that aren't in KeyOrder are written after
+	// the ones that are, sorted by name.
+	KeyOrder []string
+}
+
+// NewLogfmtEncoderConfig creates a new LogfmtEncoderConfig with default settings
+func NewLogfmtEncoderConfig() *LogfmtEncoderConfig {
+	return &LogfmtEncoderConfig{
+		TimeKey:    "time",
+		LevelKey:   "level",
+		MessageKey: "msg",
+		TimeFormat: time.RFC3339Nano,
+		KeyOrder:   []string{},
+	}
+}
+
+/*
+LogfmtEncoder is an Encoder that writes each message as a line of logfmt
+key=value pairs.  Each line starts with the message's time, level and message
+followed by its attributes in the configured order.  Attributes with the same
+name as one of the first three keys are ignored.
+
+Values are quoted when they're empty or contain spaces, equal signs, quotes,
+backslashes or characters that aren't printable, and quoted values are escaped
+the same way as Go string literals.  Any character in a key that isn't allowed
+is replaced with an underscore.
+*/
+type LogfmtEncoder struct {
+	config *LogfmtEncoderConfig
+}
+
+var _ Encoder = &LogfmtEncoder{}
+
+// NewLogfmtEncoder creates a new LogfmtEncoder.  If config is nil the values
+// from NewLogfmtEncoderConfig are used.
+func NewLogfmtEncoder(config *LogfmtEncoderConfig) *LogfmtEncoder {
+	if config == nil {
+		config = NewLogfmtEncoderConfig()
+	}
+
+	return &LogfmtEncoder{
+		config: config,
+	}
+}
+
+// Encode writes the message to buf as a line of logfmt
+func (e *LogfmtEncoder) Encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	e.encode(buf, timestamp, level, attrs, msg)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func (e *LogfmtEncoder) encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) {
+	if len(e.config.TimeKey) > 0 {
+		writeLogfmtPair(buf, e.config.TimeKey, timestamp.Format(e.config.TimeFormat))
+		buf.WriteByte(' ')
+	}
+	if len(e.config.LevelKey) > 0 {
+		writeLogfmtPair(buf, e.config.LevelKey, level.String())
+		buf.WriteByte(' ')
+	}
+	writeLogfmtPair(buf, e.config.MessageKey, msg)
+
+	written := make(map[string]bool, len(attrs))
+	writeAttr := func(key string) {
+		val, ok := attrs[key]
+		if !ok || written[key] || key == e.config.TimeKey || key == e.config.LevelKey || key == e.config.MessageKey {
+			return
+		}
+		written[key] = true
+
+		buf.WriteByte(' ')
+		writeLogfmtPair(buf, key, attrString(val, e.config.TimeFormat))
+	}
+
+	for _, key := range e.config.KeyOrder {
+		writeAttr(key)
+	}
+	for _, key := range sortedAttrKeys(attrs) {
+		writeAttr(key)
+	}
+}
+
+// tplLogfmt is the logfmt template function.  A TemplateMsg is formatted the
+// same way as a LogfmtEncoder with the default settings, a map is formatted as
+// key=value pairs sorted by key and anything else is formatted as a single
+// logfmt value.
+func tplLogfmt(data interface{}) string {
+	buf := &bytes.Buffer{}
+
+	switch v := data.(type) {
+	case *TemplateMsg:
+		NewLogfmtEncoder(nil).encode(buf, v.Timestamp, v.Level, v.Attrs, v.Message)
+	case map[string]interface{}:
+		for idx, key := range sortedAttrKeys(v) {
+			if idx > 0 {
+				buf.WriteByte(' ')
+			}
+			writeLogfmtPair(buf, key, attrString(v[key], time.RFC3339Nano))
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for idx, key := range keys {
+			if idx > 0 {
+				buf.WriteByte(' ')
+			}
+			writeLogfmtPair(buf, key, v[key])
+		}
+	default:
+		writeLogfmtValue(buf, attrString(v, time.RFC3339Nano))
+	}
+
+	return buf.String()
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key string, value string) {
+	writeLogfmtKey(buf, key)
+	buf.WriteByte('=')
+	writeLogfmtValue(buf, value)
+}
+
+func writeLogfmtKey(buf *bytes.Buffer, key string) {
+	if len(key) == 0 {
+		buf.WriteByte('_')
+		return
+	}
+
+	for _, c := range key {
+		if !isLogfmtKeyRune(c) {
+			buf.WriteByte('_')
+		} else {
+			buf.WriteRune(c)
+		}
+	}
+}
+
+func isLogfmtKeyRune(c rune) bool {
+	return c > ' ' && c != '=' && c != '"' && c != utf8.RuneError && unicode.IsPrint(c)
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, value string) {
+	if !logfmtNeedsQuotes(value) {
+		buf.WriteString(value)
+		return
+	}
+
+	buf.WriteString(strconv.Quote(value))
+}
+
+func logfmtNeedsQuotes(value string) bool {
+	if len(value) == 0 {
+		return true
+	}
+
+	for _, c := range value {
+		if !isLogfmtKeyRune(c) || c == '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+// LogfmtPair is a single key=value pair from a line of logfmt
+type LogfmtPair struct {
+	Key   string
+	Value string
+}
+
+// ErrLogfmtUnterminatedQuote is returned by ParseLogfmt when a quoted value
+// doesn't have a closing quote
+var ErrLogfmtUnterminatedQuote = errors.New("logfmt: unterminated quoted value")
+
+// ParseLogfmt parses a line of logfmt into its key=value pairs in the order
+// they appear.  A key without a value is returned with an empty value, and
+// quoted values are unescaped the same way as Go string literals.
+func ParseLogfmt(line string) ([]LogfmtPair, error) {
+	pairs := make([]LogfmtPair, 0)
+
+	idx := 0
+	for {
+		for idx < len(line) && isLogfmtSpace(line[idx]) {
+			idx++
+		}
+		if idx >= len(line) {
+			return pairs, nil
+		}
+
+		keyStart := idx
+		for idx < len(line) && line[idx] != '=' && !isLogfmtSpace(line[idx]) {
+			idx++
+		}
+		pair := LogfmtPair{Key: line[keyStart:idx]}
+		if len(pair.Key) == 0 {
+			return nil, fmt.Errorf("logfmt: missing key at offset %d", keyStart)
+		}
+
+		if idx < len(line) && line[idx] == '=' {
+			idx++
+			if idx < len(line) && line[idx] == '"' {
+				end, err := findLogfmtQuoteEnd(line, idx)
+				if err != nil {
+					return nil, err
+				}
+				value, err := strconv.Unquote(line[idx : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("logfmt: invalid quoted value for %s: %s", pair.Key, err)
+				}
+				pair.Value = value
+				idx = end + 1
+			} else {
+				valueStart := idx
+				for idx < len(line) && !isLogfmtSpace(line[idx]) {
+					idx++
+				}
+				pair.Value = line[valueStart:idx]
+				if strings.ContainsRune(pair.Value, '"') {
+					return nil, fmt.Errorf("logfmt: unexpected quote in value for %s", pair.Key)
+				}
+			}
+		}
+
+		pairs = append(pairs, pair)
+	}
+}
+
+// isLogfmtSpace returns whether c separates pairs
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// findLogfmtQuoteEnd returns the index of the quote that closes the quoted
+// value starting at start
+func findLogfmtQuoteEnd(line string, start int) (int, error) {
+	for idx := start + 1; idx < len(line); idx++ {
+		switch line[idx] {
+		case '\\':
+			idx++
+		case '"':
+			return idx, nil
+		}
+	}
+	return 0, ErrLogfmtUnterminatedQuote
+}
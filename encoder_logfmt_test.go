@@ -0,0 +1,131 @@
+package gomol
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *EncoderSuite) TestLogfmtEncoderConfig(t sweet.T) {
+	cfg := NewLogfmtEncoderConfig()
+	cfg.TimeKey = "ts"
+	cfg.LevelKey = ""
+	cfg.MessageKey = "message"
+	cfg.TimeFormat = time.Kitchen
+	cfg.KeyOrder = []string{"zebra", "missing", "apple"}
+
+	out := encodeString(NewLogfmtEncoder(cfg), LevelInfo, map[string]interface{}{
+		"apple":  1,
+		"mango":  2,
+		"zebra":  3,
+		"banana": 4,
+		"ts":     "ignored",
+	}, "message")
+	Expect(out).To(Equal("ts=5:06AM message=message zebra=3 apple=1 banana=4 mango=2\n"))
+}
+
+func (s *EncoderSuite) TestLogfmtKeys(t sweet.T) {
+	out := encodeString(NewLogfmtEncoder(nil), LevelInfo, map[string]interface{}{
+		"":          "empty",
+		"a=b":       1,
+		`"quoted"`:  2,
+		"tab\there": 3,
+		"ünïcode":   4,
+	}, "m")
+	Expect(out).To(HaveSuffix(`msg=m _=empty _quoted_=2 a_b=1 tab_here=3 ünïcode=4` + "\n"))
+}
+
+func (s *EncoderSuite) TestLogfmtValueQuoting(t sweet.T) {
+	for value, expected := range map[string]string{
+		"plain":      "plain",
+		"ünïcode":    "ünïcode",
+		"":           `""`,
+		"two words":  `"two words"`,
+		"a=b":        `"a=b"`,
+		`say "hi"`:   `"say \"hi\""`,
+		`back\slash`: `"back\\slash"`,
+		"new\nline":  `"new\nline"`,
+		"tab\there":  `"tab\there"`,
+		"bell\a":     `"bell\a"`,
+		"bad\xffutf": `"bad\xffutf"`,
+	} {
+		buf := &bytes.Buffer{}
+		writeLogfmtValue(buf, value)
+		Expect(buf.String()).To(Equal(expected), value)
+	}
+}
+
+func (s *EncoderSuite) TestParseLogfmt(t sweet.T) {
+	pairs, err := ParseLogfmt(`a=1 b="two words"  c= d e="esc \"q\" \\ \n" f=ünï` + "\n")
+	Expect(err).To(BeNil())
+	Expect(pairs).To(Equal([]LogfmtPair{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "two words"},
+		{Key: "c", Value: ""},
+		{Key: "d", Value: ""},
+		{Key: "e", Value: "esc \"q\" \\ \n"},
+		{Key: "f", Value: "ünï"},
+	}))
+
+	pairs, err = ParseLogfmt("a=1\tb\tc=3\r\n")
+	Expect(err).To(BeNil())
+	Expect(pairs).To(Equal([]LogfmtPair{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: ""},
+		{Key: "c", Value: "3"},
+	}))
+}
+
+func (s *EncoderSuite) TestParseLogfmtErrors(t sweet.T) {
+	_, err := ParseLogfmt(`a="unterminated`)
+	Expect(err).To(Equal(ErrLogfmtUnterminatedQuote))
+
+	_, err = ParseLogfmt(`a="ends in escape\"`)
+	Expect(err).To(Equal(ErrLogfmtUnterminatedQuote))
+
+	_, err = ParseLogfmt(`a=1 =2`)
+	Expect(err).To(MatchError("logfmt: missing key at offset 4"))
+
+	_, err = ParseLogfmt(`a="bad \q escape"`)
+	Expect(err).ToNot(BeNil())
+
+	_, err = ParseLogfmt(`a=b"c`)
+	Expect(err).To(MatchError("logfmt: unexpected quote in value for a"))
+}
+
+func (s *EncoderSuite) TestLogfmtRoundTrip(t sweet.T) {
+	attrs := map[string]interface{}{
+		"empty":     "",
+		"spaces":    "  leading and trailing  ",
+		"equals":    "a=b=c",
+		"quotes":    `"quoted" and 'single'`,
+		"backslash": `C:\path\to\file`,
+		"newlines":  "line 1\nline 2\r\n",
+		"controls":  "\x00\x01\x1b[31m",
+		"unicode":   "日本語 ünïcode 🙂",
+		"invalid":   "bad\xff\xfeutf8",
+		"number":    "1234",
+	}
+
+	out := encodeString(NewLogfmtEncoder(nil), LevelWarning, attrs, `the "message"`)
+	pairs, err := ParseLogfmt(out)
+	Expect(err).To(BeNil())
+
+	parsed := make(map[string]string)
+	keys := make([]string, 0)
+	for _, pair := range pairs {
+		parsed[pair.Key] = pair.Value
+		keys = append(keys, pair.Key)
+	}
+
+	Expect(keys[:3]).To(Equal([]string{"time", "level", "msg"}))
+	Expect(parsed["time"]).To(Equal(testTime.Format(time.RFC3339Nano)))
+	Expect(parsed["level"]).To(Equal("warn"))
+	Expect(parsed["msg"]).To(Equal(`the "message"`))
+	for key, val := range attrs {
+		Expect(parsed[key]).To(Equal(val), key)
+	}
+	Expect(parsed).To(HaveLen(len(attrs) + 3))
+}
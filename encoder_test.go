@@ -0,0 +1,159 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type EncoderSuite struct{}
+
+func encodeString(enc Encoder, level LogLevel, attrs map[string]interface{}, msg string) string {
+	buf := &bytes.Buffer{}
+	Expect(enc.Encode(buf, testTime, level, attrs, msg)).To(BeNil())
+	return buf.String()
+}
+
+func (s *EncoderSuite) TestTemplateEncoder(t sweet.T) {
+	tpl, err := NewTemplate("[{{ucase .LevelName}}] {{.Message}} {{.Attrs.attr}}")
+	Expect(err).To(BeNil())
+
+	enc := NewTemplateEncoder(tpl, false)
+	Expect(encodeString(enc, LevelError, map[string]interface{}{"attr": "val"}, "message")).
+		To(Equal("[ERROR] message val\n"))
+}
+
+func (s *EncoderSuite) TestTemplateEncoderColor(t sweet.T) {
+	tpl, err := NewTemplate("{{color}}{{.Message}}{{reset}}")
+	Expect(err).To(BeNil())
+
+	enc := NewTemplateEncoder(tpl, true)
+	Expect(encodeString(enc, LevelError, nil, "message")).
+		To(Equal("\x1b[31mmessage\x1b[0m\n"))
+}
+
+func (s *EncoderSuite) TestJSONEncoder(t sweet.T) {
+	out := encodeString(NewJSONEncoder(nil), LevelInfo, map[string]interface{}{
+		"attr":  1234,
+		"level": "ignored",
+	}, "message")
+	Expect(out).To(HaveSuffix("\n"))
+
+	obj := map[string]interface{}{}
+	Expect(json.Unmarshal([]byte(out), &obj)).To(BeNil())
+	Expect(obj).To(Equal(map[string]interface{}{
+		"timestamp": "2017-03-04T05:06:07.89Z",
+		"level":     "info",
+		"message":   "message",
+		"attr":      float64(1234),
+	}))
+}
+
+func (s *EncoderSuite) TestJSONEncoderOutput(t sweet.T) {
+	out := encodeString(NewJSONEncoder(nil), LevelWarning, map[string]interface{}{
+		"b":     true,
+		"a":     "str",
+		"err":   errors.New("an error"),
+		"f":     1.5,
+		"small": float32(0.0000001),
+		"i8":    int8(-8),
+		"u":     uint64(18446744073709551615),
+		"nil":   nil,
+		"dur":   time.Second,
+		"t":     testTime,
+		"map":   map[string]int{"x": 1},
+		"nan":   math.NaN(),
+	}, "the message")
+	Expect(out).To(Equal(
+		`{"timestamp":"2017-03-04T05:06:07.89Z","level":"warn","message":"the message",` +
+			`"a":"str","b":true,"dur":"1s","err":"an error","f":1.5,"i8":-8,"map":{"x":1},` +
+			`"nan":"NaN","nil":null,"small":1e-7,"t":"2017-03-04T05:06:07.89Z","u":18446744073709551615}` + "\n",
+	))
+}
+
+func (s *EncoderSuite) TestJSONEncoderConfig(t sweet.T) {
+	cfg := NewJSONEncoderConfig()
+	cfg.TimeKey = "time"
+	cfg.LevelKey = "severity"
+	cfg.MessageKey = "message"
+	cfg.TimeFormat = JSONTimeEpochMillis
+	cfg.UppercaseLevel = true
+
+	out := encodeString(NewJSONEncoder(cfg), LevelError, map[string]interface{}{
+		"t":    testTime,
+		"time": "ignored",
+	}, "message")
+	Expect(out).To(Equal(`{"time":1488603967890,"severity":"ERROR","message":"message","t":1488603967890}` + "\n"))
+
+	cfg.TimeKey = ""
+	cfg.LevelKey = ""
+	out = encodeString(NewJSONEncoder(cfg), LevelError, nil, "message")
+	Expect(out).To(Equal(`{"message":"message"}` + "\n"))
+}
+
+func (s *EncoderSuite) TestJSONEncoderEscaping(t sweet.T) {
+	msg := "quote\" back\\slash\n\r\t\x01 \xff \u2028 \u00e9"
+	out := encodeString(NewJSONEncoder(nil), LevelInfo, map[string]interface{}{"key\"": msg}, msg)
+
+	escaped := `"quote\" back\\slash\n\r\t\u0001 \ufffd \u2028 ` + "\u00e9" + `"`
+	Expect(out).To(ContainSubstring(`"message":` + escaped))
+	Expect(out).To(ContainSubstring(`"key\"":` + escaped))
+
+	obj := map[string]interface{}{}
+	Expect(json.Unmarshal([]byte(out), &obj)).To(BeNil())
+	Expect(obj["message"]).To(Equal("quote\" back\\slash\n\r\t\x01 \ufffd \u2028 \u00e9"))
+}
+
+func (s *EncoderSuite) TestLogfmtEncoder(t sweet.T) {
+	out := encodeString(NewLogfmtEncoder(nil), LevelDebug, map[string]interface{}{
+		"b":       `has "quotes"`,
+		"a":       "has spaces",
+		"c":       "",
+		"d":       "a=b",
+		"e":       "line\nbreak",
+		"bad key": errors.New("an error"),
+		"msg":     "ignored",
+		"t":       testTime,
+		"nil":     nil,
+	}, "the message")
+	Expect(out).To(Equal(
+		`time=2017-03-04T05:06:07.89Z level=debug msg="the message" ` +
+			`a="has spaces" b="has \"quotes\"" bad_key="an error" c="" d="a=b" e="line\nbreak" ` +
+			`nil="" t=2017-03-04T05:06:07.89Z` + "\n",
+	))
+}
+
+func (s *EncoderSuite) TestCEFEncoder(t sweet.T) {
+	cfg := NewCEFEncoderConfig()
+	cfg.DeviceVendor = "Ven|dor"
+	cfg.DeviceProduct = "Product"
+	cfg.DeviceVersion = "2.0"
+
+	out := encodeString(NewCEFEncoder(cfg), LevelError, map[string]interface{}{
+		"src":          "10.0.0.1",
+		"msg":          "a=b\\c\nd",
+		"bad key!":     "val",
+		"signature_id": 100,
+	}, "Something | broke")
+	Expect(out).To(Equal(
+		`CEF:0|Ven\|dor|Product|2.0|100|Something \| broke|8|` +
+			`rt=1488603967890 badkey=val msg=a\=b\\c\nd src=10.0.0.1` + "\n",
+	))
+}
+
+func (s *EncoderSuite) TestCEFEncoderDefaults(t sweet.T) {
+	out := encodeString(NewCEFEncoder(nil), LevelDebug, nil, "message")
+	Expect(out).To(Equal("CEF:0|gomol|gomol|1.0|debug|message|1|rt=1488603967890\n"))
+}
+
+func (s *EncoderSuite) TestAttrString(t sweet.T) {
+	Expect(attrString(nil, time.RFC3339)).To(Equal(""))
+	Expect(attrString(1.5, time.RFC3339)).To(Equal("1.5"))
+	Expect(attrString(LevelInfo, time.RFC3339)).To(Equal("info"))
+	Expect(attrString(testTime, time.RFC3339)).To(Equal("2017-03-04T05:06:07Z"))
+}
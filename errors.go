@@ -1,6 +1,10 @@
 package gomol
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	// ErrUnknownLevel is returned when the provided log level is not known
@@ -14,3 +18,50 @@ var (
 	// initialized
 	ErrNotInitialized = errors.New("not initialized")
 )
+
+// IncompleteError is returned by FlushContext, ShutdownLoggersContext and
+// ReloadContext when their context is done before they were able to finish.
+type IncompleteError struct {
+	// Remaining is the number of messages that had not been written to the
+	// loggers yet, including any message a logger was in the middle of
+	// writing.
+	Remaining int
+
+	// Loggers holds the loggers that were still writing a message or had not
+	// finished shutting down.
+	Loggers []Logger
+
+	// Err is the error from the context, either context.Canceled or
+	// context.DeadlineExceeded.
+	Err error
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf(
+		"incomplete: %d message(s) remaining, %d logger(s) did not finish: %s",
+		e.Remaining,
+		len(e.Loggers),
+		e.Err,
+	)
+}
+
+// Unwrap returns the error from the context
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// ShutdownError is returned by Reload when some of the loggers it removed
+// failed to shut down.  Every removed logger is still shut down, and Loggers
+// and Errors hold the ones that failed and the errors they returned.
+type ShutdownError struct {
+	Loggers []Logger
+	Errors  []error
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d logger(s) failed to shut down: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,151 @@
+package gomol
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Exiter is used by a Base to exit the application once Die has logged its
+// message and shut down the loggers
+type Exiter interface {
+	Exit(code int)
+}
+
+type osExiter struct{}
+
+func (exiter *osExiter) Exit(code int) {
+	os.Exit(code)
+}
+
+// defaultExiter is used by a Base that hasn't had an Exiter set, and when the
+// logger given to a LogAdapter or RecoverAndLog doesn't wrap a Base
+var defaultExiter Exiter = &osExiter{}
+
+// ExitPanic is the value PanicExiter panics with
+type ExitPanic struct {
+	// Code is the exit code Die was called with
+	Code int
+}
+
+func (e *ExitPanic) Error() string {
+	return fmt.Sprintf("exit with code %d", e.Code)
+}
+
+/*
+PanicExiter is an Exiter that panics with an *ExitPanic instead of exiting the
+application.  This lets tests check that Die was called, and lets library code
+recover from Die instead of having the application exit from underneath it.
+*/
+type PanicExiter struct{}
+
+// Exit panics with an *ExitPanic holding code
+func (e *PanicExiter) Exit(code int) {
+	panic(&ExitPanic{Code: code})
+}
+
+/*
+SetExiter sets the Exiter used to exit the application once Die has shut down
+the loggers.  Passing nil uses os.Exit.
+*/
+func (b *Base) SetExiter(exiter Exiter) {
+	if exiter == nil {
+		exiter = defaultExiter
+	}
+	b.exiterVal.Store(exiterHolder{exiter: exiter})
+}
+
+// exiterHolder lets different Exiter implementations be stored in the same
+// atomic.Value
+type exiterHolder struct {
+	exiter Exiter
+}
+
+func (b *Base) exiter() Exiter {
+	holder, ok := b.exiterVal.Load().(exiterHolder)
+	if !ok {
+		return defaultExiter
+	}
+	return holder.exiter
+}
+
+/*
+OnExit adds a function that's run when Die is called, before the loggers are
+shut down so the function can still log messages.  Functions are run in the
+reverse order they were added, similar to defer.  Each function is only run
+once, even if Die is called again.  Functions that are still running once
+Config.ExitTimeout has passed are left running while the application exits.
+*/
+func (b *Base) OnExit(f func()) {
+	b.exitHooksLock.Lock()
+	defer b.exitHooksLock.Unlock()
+
+	b.exitHooks = append(b.exitHooks, f)
+}
+
+// runExitHooks runs the exit hooks, waiting no longer than until ctx is done.
+// Hooks still running then are left running in the background.
+func (b *Base) runExitHooks(ctx context.Context) {
+	b.exitHooksLock.Lock()
+	hooks := b.exitHooks
+	b.exitHooks = nil
+	b.exitHooksLock.Unlock()
+
+	run := func() {
+		for idx := len(hooks) - 1; idx >= 0; idx-- {
+			hooks[idx]()
+		}
+	}
+
+	if ctx.Done() == nil {
+		run()
+		return
+	}
+
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			done <- recover()
+		}()
+		run()
+	}()
+
+	select {
+	case r := <-done:
+		// Let a hook's panic reach the caller of Die the same as it would
+		// without an ExitTimeout
+		if r != nil {
+			panic(r)
+		}
+	case <-ctx.Done():
+	}
+}
+
+// exit runs the exit hooks and shuts down the loggers, waiting no longer than
+// Config.ExitTimeout for both, and then exits the application using the Base's
+// Exiter
+func (b *Base) exit(code int) {
+	ctx := context.Background()
+	if timeout := b.config().ExitTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	b.runExitHooks(ctx)
+	b.ShutdownLoggersContext(ctx)
+
+	b.exiter().Exit(code)
+}
+
+// exitFrom exits the application using the Base logger logs to, or by shutting
+// down logger and calling os.Exit if it doesn't log to a Base
+func exitFrom(logger WrappableLogger, code int) {
+	if b := wrappedBase(logger); b != nil {
+		b.exit(code)
+		return
+	}
+
+	logger.ShutdownLoggers()
+	defaultExiter.Exit(code)
+}
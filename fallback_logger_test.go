@@ -33,7 +33,7 @@ func (s *FallbackLoggerSuite) TestSetUninitialized(t sweet.T) {
 
 	Expect(ml.IsInitialized()).To(BeTrue())
 
-	Expect(b.fallbackLogger).To(Equal(ml))
+	Expect(b.fallbackLogger()).To(Equal(ml))
 }
 
 func (s *FallbackLoggerSuite) TestSetUninitializedFailToInitialize(t sweet.T) {
@@ -47,7 +47,7 @@ func (s *FallbackLoggerSuite) TestSetUninitializedFailToInitialize(t sweet.T) {
 	err := b.SetFallbackLogger(ml)
 	Expect(err).ToNot(BeNil())
 
-	Expect(b.fallbackLogger).To(BeNil())
+	Expect(b.fallbackLogger()).To(BeNil())
 }
 
 func (s *FallbackLoggerSuite) TestShutdownLoggerWhenReplacingWithNil(t sweet.T) {
@@ -95,7 +95,7 @@ func (s *FallbackLoggerSuite) TestNoReplaceOldLoggerIfNewFailsToInitialize(t swe
 	Expect(err).ToNot(BeNil())
 	Expect(ml2.IsInitialized()).ToNot(BeTrue())
 	Expect(ml.IsInitialized()).To(BeTrue())
-	Expect(b.fallbackLogger).To(Equal(ml))
+	Expect(b.fallbackLogger()).To(Equal(ml))
 }
 
 func (s *FallbackLoggerSuite) TestLogToFallbackWithZeroLoggers(t sweet.T) {
@@ -0,0 +1,52 @@
+package gomol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// testTime is the time messages are logged at in tests that check the output
+// of loggers and encoders
+var testTime = time.Date(2017, 3, 4, 5, 6, 7, 890000000, time.UTC)
+
+// newTestTLSConfigs creates a self-signed certificate for 127.0.0.1 and
+// returns TLS configs for a server using it and a client that trusts it
+func newTestTLSConfigs() (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gomol test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverCfg := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	clientCfg := &tls.Config{RootCAs: pool}
+
+	return serverCfg, clientCfg
+}
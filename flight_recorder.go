@@ -0,0 +1,144 @@
+package gomol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FlightRecorderConfig is the configuration for a LogAdapter's flight
+// recorder
+type FlightRecorderConfig struct {
+	// Size is the most messages kept.  Once it's full the oldest message is
+	// dropped to make room for each new one.
+	Size int
+
+	// TriggerLevel is the least severe level that logs the kept messages
+	// before the message itself is logged
+	TriggerLevel LogLevel
+
+	// BufferedAttr is the name of an attribute set to true on messages that
+	// were kept and logged later.  If it is empty the attribute isn't added.
+	BufferedAttr string
+}
+
+// NewFlightRecorderConfig creates a new FlightRecorderConfig that keeps the
+// last 100 messages and logs them when an error is logged
+func NewFlightRecorderConfig() *FlightRecorderConfig {
+	return &FlightRecorderConfig{
+		Size:         100,
+		TriggerLevel: LevelError,
+		BufferedAttr: "",
+	}
+}
+
+// unleveledLogger is implemented by Base and LogAdapter so messages can be
+// logged to them even though they're less severe than the level being logged,
+// such as the messages kept by a LogAdapter's flight recorder or the messages
+// forwarded by a ForwardLogger with IgnoreLevel set.
+type unleveledLogger interface {
+	shouldLog(level LogLevel) bool
+	now() time.Time
+	logBuffered(level LogLevel, ts time.Time, m *Attrs, msg string) error
+	logForwarded(level LogLevel, ts time.Time, m *Attrs, msg string) error
+}
+
+var _ unleveledLogger = &Base{}
+var _ unleveledLogger = &LogAdapter{}
+
+type flightRecord struct {
+	level LogLevel
+	ts    time.Time
+	attrs *Attrs
+	msg   string
+}
+
+// flightRecorder is a ring buffer of the messages a LogAdapter didn't log
+type flightRecorder struct {
+	config *FlightRecorderConfig
+
+	lock    sync.Mutex
+	records []*flightRecord
+	next    int
+	count   int
+}
+
+func newFlightRecorder(config *FlightRecorderConfig) *flightRecorder {
+	size := config.Size
+	if size < 1 {
+		size = 1
+	}
+
+	return &flightRecorder{
+		config:  config,
+		records: make([]*flightRecord, size),
+	}
+}
+
+// add keeps a message, formatting it now since its arguments may have changed
+// by the time it's logged
+func (r *flightRecorder) add(level LogLevel, ts time.Time, attrs *Attrs, msg string, a []interface{}) {
+	if len(a) > 0 {
+		msg = fmt.Sprintf(msg, a...)
+	}
+	if len(r.config.BufferedAttr) > 0 {
+		attrs.SetAttr(r.config.BufferedAttr, true)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.records[r.next] = &flightRecord{
+		level: level,
+		ts:    ts,
+		attrs: attrs,
+		msg:   msg,
+	}
+	r.next = (r.next + 1) % len(r.records)
+	if r.count < len(r.records) {
+		r.count++
+	}
+}
+
+// take removes and returns the kept messages, oldest first
+func (r *flightRecorder) take() []*flightRecord {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	records := make([]*flightRecord, 0, r.count)
+	start := (r.next - r.count + len(r.records)) % len(r.records)
+	for idx := 0; idx < r.count; idx++ {
+		pos := (start + idx) % len(r.records)
+		records = append(records, r.records[pos])
+		r.records[pos] = nil
+	}
+	r.count = 0
+	return records
+}
+
+type flightRecorderContextKey struct{}
+
+/*
+ContextWithFlightRecorder returns a copy of ctx that carries a new LogAdapter
+wrapping logger with flight recorder mode turned on, so everything handling a
+request can log through the same flight recorder by getting the LogAdapter with
+FlightRecorderFromContext.  If config is nil the values from
+NewFlightRecorderConfig are used.
+*/
+func ContextWithFlightRecorder(ctx context.Context, logger WrappableLogger, config *FlightRecorderConfig) context.Context {
+	if config == nil {
+		config = NewFlightRecorderConfig()
+	}
+
+	la := NewLogAdapterFor(logger, nil)
+	la.SetFlightRecorder(config)
+	return context.WithValue(ctx, flightRecorderContextKey{}, la)
+}
+
+// FlightRecorderFromContext returns the LogAdapter added to ctx by
+// ContextWithFlightRecorder.  If ctx doesn't carry one it returns nil.
+func FlightRecorderFromContext(ctx context.Context) *LogAdapter {
+	la, _ := ctx.Value(flightRecorderContextKey{}).(*LogAdapter)
+	return la
+}
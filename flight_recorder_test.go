@@ -0,0 +1,209 @@
+package gomol
+
+import (
+	"context"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type FlightRecorderSuite struct{}
+
+func (s *FlightRecorderSuite) TestBufferUntilError(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	la := b.NewLogAdapter(NewAttrs().SetAttr("request", "abc"))
+	la.SetFlightRecorder(nil)
+	la.SetFlightRecorder(NewFlightRecorderConfig())
+
+	la.Debugf("step %d", 1)
+	la.Info("info")
+	la.Debugm(NewAttrs().SetAttr("step", 2), "step 2")
+	b.Flush()
+	Expect(memMessageTexts(ml)).To(Equal([]string{"info"}))
+
+	la.Error("failed")
+	la.Error("failed again")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"info",
+		"step 1",
+		"step 2",
+		"failed",
+		"failed again",
+	}))
+
+	msgs := ml.Messages()
+	Expect(msgs[1].Level).To(Equal(LevelDebug))
+	Expect(msgs[2].Attrs).To(Equal(map[string]interface{}{
+		"request": "abc",
+		"step":    2,
+	}))
+}
+
+func (s *FlightRecorderSuite) TestRingOverflow(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	cfg := NewFlightRecorderConfig()
+	cfg.Size = 3
+	la := b.NewLogAdapter(nil)
+	la.SetFlightRecorder(cfg)
+
+	for idx := 0; idx < 5; idx++ {
+		la.Debugf("step %d", idx)
+	}
+	la.Fatal("failed")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"step 2",
+		"step 3",
+		"step 4",
+		"failed",
+	}))
+}
+
+func (s *FlightRecorderSuite) TestTriggerLevel(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelWarning)
+
+	cfg := NewFlightRecorderConfig()
+	cfg.TriggerLevel = LevelWarning
+	cfg.BufferedAttr = "buffered"
+	la := b.NewLogAdapter(nil)
+	la.SetFlightRecorder(cfg)
+
+	la.Info("info")
+	la.Debug("debug")
+	la.Warn("warning")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"info", "debug", "warning"}))
+	msgs := ml.Messages()
+	Expect(msgs[0].Attrs).To(Equal(map[string]interface{}{"buffered": true}))
+	Expect(msgs[1].Attrs).To(Equal(map[string]interface{}{"buffered": true}))
+	Expect(msgs[2].Attrs).To(BeEmpty())
+}
+
+func (s *FlightRecorderSuite) TestAdapterLevel(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelDebug)
+
+	la := b.NewLogAdapter(nil)
+	la.SetLogLevel(LevelWarning)
+	la.SetFlightRecorder(NewFlightRecorderConfig())
+
+	la.Info("info")
+	la.Warn("warning")
+	la.Error("failed")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"warning", "info", "failed"}))
+}
+
+func (s *FlightRecorderSuite) TestTimestamps(t sweet.T) {
+	clock := glock.NewMockClock()
+	b, ml := newTestBase(withClock(clock))
+	b.SetLogLevel(LevelInfo)
+
+	la := b.NewLogAdapter(nil)
+	la.SetFlightRecorder(NewFlightRecorderConfig())
+
+	first := clock.Now()
+	la.Debug("first")
+	clock.Advance(time.Second)
+	la.LogWithTime(LevelDebug, testTime, nil, "second")
+	clock.Advance(time.Second)
+	la.Error("failed")
+	b.ShutdownLoggers()
+
+	msgs := ml.Messages()
+	Expect(msgs).To(HaveLen(3))
+	Expect(msgs[0].Timestamp).To(Equal(first))
+	Expect(msgs[1].Timestamp).To(Equal(testTime))
+	Expect(msgs[2].Timestamp).To(Equal(first.Add(2 * time.Second)))
+}
+
+func (s *FlightRecorderSuite) TestFlushFlightRecorder(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	la := b.NewLogAdapter(nil)
+	Expect(la.FlushFlightRecorder()).To(BeNil())
+
+	la.SetFlightRecorder(NewFlightRecorderConfig())
+	la.Debug("debug")
+	Expect(la.FlushFlightRecorder()).To(BeNil())
+	Expect(la.FlushFlightRecorder()).To(BeNil())
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"debug"}))
+}
+
+func (s *FlightRecorderSuite) TestNestedAdapters(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	parent := b.NewLogAdapter(NewAttrs().SetAttr("parent", 1))
+	parent.SetLogLevel(LevelWarning)
+	child := NewLogAdapterFor(parent, NewAttrs().SetAttr("child", 2))
+	child.SetFlightRecorder(NewFlightRecorderConfig())
+
+	child.Info("info")
+	child.Error("failed")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"info", "failed"}))
+	Expect(ml.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"parent": 1,
+		"child":  2,
+	}))
+}
+
+func (s *FlightRecorderSuite) TestNotSampledOrDeduplicated(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	sampleCfg := NewSamplerConfig()
+	sampleCfg.First = 1
+	sampleCfg.Thereafter = 0
+	b.SetSampler(NewSampler(sampleCfg))
+	b.SetDeduplicator(NewDeduplicator(nil))
+
+	la := b.NewLogAdapter(nil)
+	la.SetFlightRecorder(NewFlightRecorderConfig())
+
+	la.Debug("step")
+	la.Debug("step")
+	la.Debug("step")
+	la.Error("failed")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"step", "step", "step", "failed"}))
+}
+
+func (s *FlightRecorderSuite) TestContext(t sweet.T) {
+	b, ml := newTestBase()
+	b.SetLogLevel(LevelInfo)
+
+	Expect(FlightRecorderFromContext(context.Background())).To(BeNil())
+
+	ctx := ContextWithFlightRecorder(context.Background(), b, nil)
+	FlightRecorderFromContext(ctx).Debug("first")
+	FlightRecorderFromContext(ctx).Debug("second")
+	b.Flush()
+	Expect(memMessageTexts(ml)).To(BeEmpty())
+
+	other := ContextWithFlightRecorder(context.Background(), b, nil)
+	FlightRecorderFromContext(other).Debug("other")
+
+	FlightRecorderFromContext(ctx).Error("failed")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first", "second", "failed"}))
+}
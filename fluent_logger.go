@@ -0,0 +1,499 @@
+package gomol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// FluentMode is how a FluentLogger packs messages into Forward protocol events
+type FluentMode int
+
+const (
+	// FluentModeForward sends each batch of messages with the same tag as an
+	// array of entries
+	FluentModeForward FluentMode = iota
+	// FluentModePackedForward sends each batch of messages with the same tag
+	// as a single binary blob of MessagePack encoded entries
+	FluentModePackedForward
+)
+
+// fluentMaxAckSize is the largest ack read from the server.  An ack is a map
+// holding a chunk ID, which is much smaller than this.
+const fluentMaxAckSize = 1024
+
+// ErrFluentAckMismatch is reported to the Base's error channel when a Fluentd server acknowledges a chunk
+// other than the one that was sent
+var ErrFluentAckMismatch = errors.New("the server acknowledged the wrong chunk")
+
+// FluentLoggerConfig is the configuration for a FluentLogger
+type FluentLoggerConfig struct {
+	// Network is the type of connection to the server and can be one of
+	// "tcp", "unix" or "tcp+tls"
+	Network string
+
+	// Address is the address of the server, or the path to its socket for a
+	// "unix" connection
+	Address string
+
+	// TLSConfig is the TLS configuration used for "tcp+tls" connections
+	TLSConfig *tls.Config
+
+	// Tag is the tag messages are sent with
+	Tag string
+
+	// TagAttr is the name of an attribute that overrides Tag for the messages
+	// it's set on.  The attribute isn't included in the record.
+	TagAttr string
+
+	// Mode is how messages are packed into events
+	Mode FluentMode
+
+	// RequireAck asks the server to acknowledge each event.  Events that
+	// aren't acknowledged within AckTimeout are sent again.
+	RequireAck bool
+
+	// AckTimeout is how long to wait for the server to acknowledge an event
+	AckTimeout time.Duration
+
+	// MessageKey is the key of the record field holding the message
+	MessageKey string
+
+	// LevelKey is the key of the record field holding the message's level.
+	// If it is empty the level isn't included.
+	LevelKey string
+
+	// DialTimeout is how long to wait when connecting to the server
+	DialTimeout time.Duration
+
+	// WriteTimeout is how long to wait for an event to be written before the
+	// connection is considered broken
+	WriteTimeout time.Duration
+
+	// MaxRetries is how many times an event is sent again after it couldn't
+	// be sent or wasn't acknowledged before it's dropped
+	MaxRetries int
+
+	RetryDelay
+
+	// BufferSize is the most events kept waiting to be sent.  Once it's
+	// reached the oldest waiting event is dropped.
+	BufferSize int
+}
+
+// NewFluentLoggerConfig creates a new FluentLoggerConfig that sends messages
+// to a Fluentd or Fluent Bit server running on the local host
+func NewFluentLoggerConfig() *FluentLoggerConfig {
+	return &FluentLoggerConfig{
+		Network:      "tcp",
+		Address:      "127.0.0.1:24224",
+		Tag:          "gomol",
+		TagAttr:      "tag",
+		Mode:         FluentModeForward,
+		RequireAck:   false,
+		AckTimeout:   5 * time.Second,
+		MessageKey:   "message",
+		LevelKey:     "level",
+		DialTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		MaxRetries:   3,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 100 * time.Millisecond,
+			MaxRetryDelay: 5 * time.Second,
+		},
+		BufferSize: 100,
+	}
+}
+
+/*
+FluentLogger is a Logger that sends messages to Fluentd or Fluent Bit using the
+Forward protocol.  Each message is sent as a record with its message, its
+level and the Base's attributes merged with its own, timestamped with the
+time it was logged at.
+
+FluentLogger is a BatchLogger so each batch of messages from the queue is sent
+as one event per tag.  Events are sent in the background so a slow or
+unreachable server doesn't hold up the queue, and up to BufferSize events wait
+to be sent.  If an event can't be sent, or isn't acknowledged when RequireAck is
+set, the logger reconnects and sends it again.  Once an event has been dropped
+the error is reported to the Base's error channel and the logger reports itself
+as unhealthy until an event is sent successfully.
+*/
+type FluentLogger struct {
+	config *FluentLoggerConfig
+	clock  glock.Clock
+
+	lock          sync.Mutex
+	base          *Base
+	isInitialized bool
+	healthy       bool
+	sender        *sender
+
+	// sendLock is held while an event is being sent and protects the
+	// connection
+	sendLock sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+var _ Logger = &FluentLogger{}
+var _ HealthCheckLogger = &FluentLogger{}
+var _ BatchLogger = &FluentLogger{}
+var _ ShutdownLoggerContext = &FluentLogger{}
+
+// NewFluentLogger creates a new FluentLogger.  If config is nil the values
+// from NewFluentLoggerConfig are used.
+func NewFluentLogger(config *FluentLoggerConfig) (*FluentLogger, error) {
+	if config == nil {
+		config = NewFluentLoggerConfig()
+	}
+
+	switch config.Network {
+	case "tcp", "tcp4", "tcp6", "tcp+tls", "unix":
+	default:
+		return nil, fmt.Errorf("unknown network: %s", config.Network)
+	}
+	if config.Mode != FluentModeForward && config.Mode != FluentModePackedForward {
+		return nil, fmt.Errorf("unknown fluent mode: %d", config.Mode)
+	}
+	if len(config.Tag) == 0 {
+		return nil, fmt.Errorf("a tag is required")
+	}
+	if config.BufferSize <= 0 {
+		return nil, fmt.Errorf("the buffer size must be positive")
+	}
+	if err := config.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+
+	return &FluentLogger{
+		config: config,
+		clock:  glock.NewRealClock(),
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *FluentLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger connects to the server
+func (l *FluentLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	l.sendLock.Lock()
+	err := l.connect()
+	l.sendLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	l.sender = newSender(l.config.BufferSize)
+	l.healthy = true
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *FluentLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger waits for the events waiting to be sent and closes the
+// connection to the server
+func (l *FluentLogger) ShutdownLogger() error {
+	return l.ShutdownLoggerContext(context.Background())
+}
+
+// ShutdownLoggerContext waits for the events waiting to be sent and closes the
+// connection to the server.  If ctx is done before they're sent they're no
+// longer retried and are dropped.
+func (l *FluentLogger) ShutdownLoggerContext(ctx context.Context) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+	sender := l.sender
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	sender.shutdown(ctx)
+
+	l.sendLock.Lock()
+	defer l.sendLock.Unlock()
+
+	var err error
+	if l.conn != nil {
+		err = l.conn.Close()
+		l.conn = nil
+	}
+	return err
+}
+
+// Healthy returns whether the last event was sent
+func (l *FluentLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.healthy
+}
+
+// Logm adds a single message to the events waiting to be sent to the server
+func (l *FluentLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	baseAttrs := l.baseAttrs()
+	l.lock.Unlock()
+
+	tag, entry := l.newEntry(baseAttrs, timestamp, level, attrs, msg)
+	return l.queue([]string{tag}, map[string][]*fluentEntry{tag: {entry}})
+}
+
+// LogBatch adds a batch of messages to the events waiting to be sent to the
+// server as one event per tag
+func (l *FluentLogger) LogBatch(msgs []*Message) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	baseAttrs := l.baseAttrs()
+	l.lock.Unlock()
+
+	tags := make([]string, 0, 1)
+	entries := make(map[string][]*fluentEntry)
+	for _, msg := range msgs {
+		tag, entry := l.newEntry(baseAttrs, msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+		if _, ok := entries[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		entries[tag] = append(entries[tag], entry)
+	}
+
+	return l.queue(tags, entries)
+}
+
+// queue encodes an event for each of tags and adds them to the events waiting
+// to be sent
+func (l *FluentLogger) queue(tags []string, entries map[string][]*fluentEntry) error {
+	events := make([][]byte, 0, len(tags))
+	chunks := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		var chunk string
+		if l.config.RequireAck {
+			chunk = newFluentChunkID()
+		}
+		events = append(events, l.encodeEvent(tag, entries[tag], chunk))
+		chunks = append(chunks, chunk)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// The logger may have been shut down while the events were being encoded
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	for idx := range events {
+		data, chunk := events[idx], chunks[idx]
+		if l.sender.add(func(stop <-chan struct{}) { l.send(data, chunk, stop) }) {
+			l.report(ErrSendBufferFull)
+		}
+	}
+	return nil
+}
+
+// report reports err to the Base's error channel, if the logger has a Base.
+// It must be called with the lock held.
+func (l *FluentLogger) report(err error) {
+	if l.base != nil {
+		l.base.report(err)
+	}
+}
+
+// baseAttrs returns the Base's attributes.  It must be called with the lock
+// held.
+func (l *FluentLogger) baseAttrs() map[string]interface{} {
+	if l.base == nil {
+		return nil
+	}
+	return l.base.BaseAttrs.Attrs()
+}
+
+type fluentEntry struct {
+	timestamp time.Time
+	record    map[string]interface{}
+}
+
+func (l *FluentLogger) newEntry(baseAttrs map[string]interface{}, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) (string, *fluentEntry) {
+	record := make(map[string]interface{}, len(baseAttrs)+len(attrs)+2)
+	for key, val := range baseAttrs {
+		record[key] = val
+	}
+	for key, val := range attrs {
+		record[key] = val
+	}
+
+	tag := l.config.Tag
+	if val, ok := record[l.config.TagAttr]; ok && len(l.config.TagAttr) > 0 {
+		if attrTag := attrString(val, time.RFC3339Nano); len(attrTag) > 0 {
+			tag = attrTag
+		}
+		delete(record, l.config.TagAttr)
+	}
+
+	if len(l.config.LevelKey) > 0 {
+		record[l.config.LevelKey] = level.String()
+	}
+	record[l.config.MessageKey] = msg
+
+	return tag, &fluentEntry{
+		timestamp: timestamp,
+		record:    record,
+	}
+}
+
+// encodeEvent encodes entries as a Forward or PackedForward mode event
+func (l *FluentLogger) encodeEvent(tag string, entries []*fluentEntry, chunk string) []byte {
+	buf := &bytes.Buffer{}
+	writeMsgpackArrayHeader(buf, 3)
+	writeMsgpackString(buf, tag)
+
+	if l.config.Mode == FluentModePackedForward {
+		packed := &bytes.Buffer{}
+		for _, entry := range entries {
+			writeFluentEntry(packed, entry)
+		}
+		writeMsgpackBin(buf, packed.Bytes())
+	} else {
+		writeMsgpackArrayHeader(buf, len(entries))
+		for _, entry := range entries {
+			writeFluentEntry(buf, entry)
+		}
+	}
+
+	if len(chunk) > 0 {
+		writeMsgpackMapHeader(buf, 2)
+		writeMsgpackString(buf, "chunk")
+		writeMsgpackString(buf, chunk)
+	} else {
+		writeMsgpackMapHeader(buf, 1)
+	}
+	writeMsgpackString(buf, "size")
+	writeMsgpackUint(buf, uint64(len(entries)))
+
+	return buf.Bytes()
+}
+
+func writeFluentEntry(buf *bytes.Buffer, entry *fluentEntry) {
+	writeMsgpackArrayHeader(buf, 2)
+	writeMsgpackEventTime(buf, entry.timestamp)
+	writeMsgpackValue(buf, entry.record)
+}
+
+// send sends an encoded event, retrying until it succeeds, has been retried
+// MaxRetries times or stop is closed.  If the event can't be sent the error is
+// reported.
+func (l *FluentLogger) send(data []byte, chunk string, stop <-chan struct{}) {
+	l.sendLock.Lock()
+	err := retry(l.clock, l.config.backoff(), l.config.MaxRetries, stop, func() (time.Duration, error) {
+		return 0, l.sendEvent(data, chunk)
+	})
+	l.sendLock.Unlock()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.healthy = err == nil
+	if err != nil {
+		l.report(err)
+	}
+}
+
+// sendEvent writes an encoded event to the server and waits for it to be
+// acknowledged if chunk isn't empty.  If anything fails the connection is
+// closed so the next attempt reconnects.  It must be called with sendLock held.
+func (l *FluentLogger) sendEvent(data []byte, chunk string) error {
+	if l.conn == nil {
+		err := l.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := l.writeEvent(data, chunk)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	return err
+}
+
+func (l *FluentLogger) writeEvent(data []byte, chunk string) error {
+	if l.config.WriteTimeout > 0 {
+		l.conn.SetWriteDeadline(time.Now().Add(l.config.WriteTimeout))
+	}
+	_, err := l.conn.Write(data)
+	if err != nil {
+		return err
+	}
+
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	if l.config.AckTimeout > 0 {
+		l.conn.SetReadDeadline(time.Now().Add(l.config.AckTimeout))
+	}
+	resp, err := readMsgpack(l.reader, fluentMaxAckSize)
+	if err != nil {
+		return err
+	}
+	if respMap, ok := resp.(map[string]interface{}); !ok || respMap["ack"] != chunk {
+		return ErrFluentAckMismatch
+	}
+	return nil
+}
+
+// connect connects to the server.  It must be called with sendLock held.
+func (l *FluentLogger) connect() error {
+	conn, _, err := dialNetwork(l.config.Network, l.config.Address, l.config.TLSConfig, l.config.DialTimeout)
+	if err != nil {
+		return err
+	}
+
+	l.conn = conn
+	l.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// newFluentChunkID creates a random ID for the server to acknowledge an event
+// with
+func newFluentChunkID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return base64.StdEncoding.EncodeToString(id)
+}
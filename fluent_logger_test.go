@@ -0,0 +1,378 @@
+package gomol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type FluentLoggerSuite struct{}
+
+// fluentTestEvent is an event received by a fluentTestServer
+type fluentTestEvent struct {
+	Tag     string
+	Entries []fluentTestEntry
+	Option  map[string]interface{}
+}
+
+type fluentTestEntry struct {
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// fluentTestServer is a stand-in for a server with a Forward protocol input.
+// It acknowledges events that ask for it unless ack is false.
+type fluentTestServer struct {
+	ln net.Listener
+
+	lock   sync.Mutex
+	events []*fluentTestEvent
+	ack    bool
+	conns  []net.Conn
+}
+
+func newFluentTestServer() *fluentTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+
+	s := &fluentTestServer{ln: ln, ack: true}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fluentTestServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		val, err := readMsgpack(r, math.MaxInt32)
+		if err != nil {
+			return
+		}
+		event := parseFluentTestEvent(val)
+
+		s.lock.Lock()
+		s.events = append(s.events, event)
+		ack := s.ack
+		s.lock.Unlock()
+
+		if chunk, ok := event.Option["chunk"]; ok && ack {
+			buf := &bytes.Buffer{}
+			writeMsgpackValue(buf, map[string]interface{}{"ack": chunk})
+			conn.Write(buf.Bytes())
+		}
+	}
+}
+
+func parseFluentTestEvent(val interface{}) *fluentTestEvent {
+	arr := val.([]interface{})
+	event := &fluentTestEvent{
+		Tag:    arr[0].(string),
+		Option: arr[2].(map[string]interface{}),
+	}
+
+	var rawEntries []interface{}
+	switch entries := arr[1].(type) {
+	case []interface{}:
+		rawEntries = entries
+	case []byte:
+		r := bufio.NewReader(bytes.NewReader(entries))
+		for {
+			entry, err := readMsgpack(r, math.MaxInt32)
+			if err != nil {
+				break
+			}
+			rawEntries = append(rawEntries, entry)
+		}
+	}
+
+	for _, raw := range rawEntries {
+		entry := raw.([]interface{})
+		ext := entry[0].(msgpackExt)
+		Expect(ext.Type).To(Equal(int8(0)))
+		event.Entries = append(event.Entries, fluentTestEntry{
+			Time: time.Unix(
+				int64(binary.BigEndian.Uint32(ext.Data[:4])),
+				int64(binary.BigEndian.Uint32(ext.Data[4:])),
+			).UTC(),
+			Record: entry[1].(map[string]interface{}),
+		})
+	}
+	return event
+}
+
+func (s *fluentTestServer) Events() []*fluentTestEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*fluentTestEvent{}, s.events...)
+}
+
+func (s *fluentTestServer) SetAck(ack bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ack = ack
+}
+
+func (s *fluentTestServer) Close() {
+	s.ln.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func newTestFluentLogger(address string) (*FluentLogger, *glock.MockClock) {
+	cfg := NewFluentLoggerConfig()
+	cfg.Address = address
+	cfg.MaxRetries = 1
+
+	l, err := NewFluentLogger(cfg)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	l.clock = clock
+	return l, clock
+}
+
+func (s *FluentLoggerSuite) TestMsgpackRoundTrip(t sweet.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(-1), int64(-32), int64(-33), int64(-200),
+		int64(-40000), int64(-3000000000),
+		int64(200), int64(60000), int64(4000000000), uint64(math.MaxUint64),
+		1.5, "", "short", string(make([]byte, 40)), string(make([]byte, 300)), string(make([]byte, 70000)),
+		[]byte{1, 2, 3},
+		[]interface{}{"a", int64(1)},
+		map[string]interface{}{"key": "val", "nested": map[string]interface{}{"x": int64(-5)}},
+	}
+
+	for _, val := range values {
+		buf := &bytes.Buffer{}
+		writeMsgpackValue(buf, val)
+		decoded, err := readMsgpack(bufio.NewReader(buf), math.MaxInt32)
+		Expect(err).To(BeNil())
+		if val == nil {
+			Expect(decoded).To(BeNil())
+		} else {
+			Expect(decoded).To(Equal(val))
+		}
+	}
+}
+
+func (s *FluentLoggerSuite) TestMsgpackTooLarge(t sweet.T) {
+	for _, data := range [][]byte{
+		{0xc6, 0xff, 0xff, 0xff, 0xff},
+		{0xdb, 0x00, 0x00, 0x04, 0x01},
+		{0xc9, 0x7f, 0xff, 0xff, 0xff, 0x00},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0xdf, 0xff, 0xff, 0xff, 0xff},
+		{0x81, 0xa3, 'a', 'c', 'k', 0xdd, 0x00, 0x00, 0x10, 0x00},
+	} {
+		_, err := readMsgpack(bufio.NewReader(bytes.NewReader(data)), 1024)
+		Expect(err).To(Equal(errMsgpackTooLarge))
+	}
+}
+
+func (s *FluentLoggerSuite) TestMalformedAck(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, _ := newTestFluentLogger(ln.Addr().String())
+	l.config.RequireAck = true
+	l.config.MaxRetries = 0
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	_, err = readMsgpack(bufio.NewReader(conn), math.MaxInt32)
+	Expect(err).To(BeNil())
+
+	// A 4 GiB binary value the logger mustn't try to allocate
+	conn.Write([]byte{0xc6, 0xff, 0xff, 0xff, 0xff, 0x00})
+	Eventually(errs).Should(Receive(Equal(errMsgpackTooLarge)))
+	Expect(l.Healthy()).To(BeFalse())
+}
+
+func (s *FluentLoggerSuite) TestNewFluentLoggerBadConfig(t sweet.T) {
+	cfg := NewFluentLoggerConfig()
+	cfg.Network = "udp"
+	_, err := NewFluentLogger(cfg)
+	Expect(err).ToNot(BeNil())
+
+	cfg = NewFluentLoggerConfig()
+	cfg.Mode = FluentMode(100)
+	_, err = NewFluentLogger(cfg)
+	Expect(err).ToNot(BeNil())
+
+	cfg = NewFluentLoggerConfig()
+	cfg.Tag = ""
+	_, err = NewFluentLogger(cfg)
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *FluentLoggerSuite) TestForward(t sweet.T) {
+	server := newFluentTestServer()
+	defer server.Close()
+
+	l, _ := newTestFluentLogger(server.ln.Addr().String())
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelWarning, map[string]interface{}{"attr": 1234}, "message")).To(BeNil())
+
+	Eventually(server.Events).Should(HaveLen(1))
+	event := server.Events()[0]
+	Expect(event.Tag).To(Equal("gomol"))
+	Expect(event.Option).To(Equal(map[string]interface{}{"size": int64(1)}))
+	Expect(event.Entries).To(Equal([]fluentTestEntry{{
+		Time: testTime,
+		Record: map[string]interface{}{
+			"attr":    int64(1234),
+			"level":   "warn",
+			"message": "message",
+		},
+	}}))
+}
+
+func (s *FluentLoggerSuite) TestPackedForwardWithAck(t sweet.T) {
+	server := newFluentTestServer()
+	defer server.Close()
+
+	l, _ := newTestFluentLogger(server.ln.Addr().String())
+	l.config.Mode = FluentModePackedForward
+	l.config.RequireAck = true
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.LogBatch([]*Message{
+		newMessage(testTime, nil, LevelInfo, NewAttrs(), "first"),
+		newMessage(testTime.Add(time.Second), nil, LevelError, NewAttrs(), "second"),
+	})).To(BeNil())
+	Eventually(server.Events).Should(HaveLen(1))
+	Expect(l.Healthy()).To(BeTrue())
+
+	events := server.Events()
+	Expect(events[0].Option["size"]).To(Equal(int64(2)))
+	Expect(events[0].Option["chunk"]).ToNot(BeEmpty())
+	Expect(events[0].Entries).To(HaveLen(2))
+	Expect(events[0].Entries[0].Record["message"]).To(Equal("first"))
+	Expect(events[0].Entries[1].Record["message"]).To(Equal("second"))
+	Expect(events[0].Entries[1].Time).To(Equal(testTime.Add(time.Second)))
+}
+
+func (s *FluentLoggerSuite) TestTagAttr(t sweet.T) {
+	server := newFluentTestServer()
+	defer server.Close()
+
+	l, _ := newTestFluentLogger(server.ln.Addr().String())
+
+	b := NewBase()
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+
+	b.Infom(NewAttrs().SetAttr("tag", "app.access"), "first")
+	b.Info("second")
+	b.Infom(NewAttrs().SetAttr("tag", "app.access"), "third")
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Eventually(func() map[string][]string {
+		records := map[string][]string{}
+		for _, event := range server.Events() {
+			for _, entry := range event.Entries {
+				Expect(entry.Record).ToNot(HaveKey("tag"))
+				Expect(entry.Record["base_attr"]).To(Equal("base"))
+				records[event.Tag] = append(records[event.Tag], entry.Record["message"].(string))
+			}
+		}
+		return records
+	}).Should(Equal(map[string][]string{
+		"app.access": {"first", "third"},
+		"gomol":      {"second"},
+	}))
+}
+
+func (s *FluentLoggerSuite) TestAckTimeout(t sweet.T) {
+	server := newFluentTestServer()
+	defer server.Close()
+	server.SetAck(false)
+
+	l, clock := newTestFluentLogger(server.ln.Addr().String())
+	l.config.RequireAck = true
+	l.config.AckTimeout = 50 * time.Millisecond
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// The event is retried in the background so Logm doesn't wait for it
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	// The logger stays healthy until the event has been dropped
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	Expect(l.Healthy()).To(BeTrue())
+	clock.Advance(100 * time.Millisecond)
+
+	Eventually(errs).Should(Receive(HaveOccurred()))
+	Expect(server.Events()).To(HaveLen(2))
+	Expect(l.Healthy()).To(BeFalse())
+
+	server.SetAck(true)
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(l.Healthy).Should(BeTrue())
+}
+
+func (s *FluentLoggerSuite) TestReconnect(t sweet.T) {
+	server := newFluentTestServer()
+	defer server.Close()
+
+	l, clock := newTestFluentLogger(server.ln.Addr().String())
+	l.config.RequireAck = true
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Eventually(func() int {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		return len(server.conns)
+	}).Should(Equal(1))
+	server.lock.Lock()
+	server.conns[0].Close()
+	server.lock.Unlock()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(100 * time.Millisecond)
+
+	Eventually(server.Events).Should(HaveLen(1))
+	Expect(l.Healthy()).To(BeTrue())
+}
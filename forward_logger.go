@@ -0,0 +1,190 @@
+package gomol
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrForwardLoop is returned by ForwardLogger.InitLogger and reported to the
+// Base's error channel when messages it forwards would end up being logged to
+// its own Base again
+var ErrForwardLoop = errors.New("forwarding would loop back to the same base")
+
+// ForwardLoggerConfig is the configuration for a ForwardLogger
+type ForwardLoggerConfig struct {
+	// Attrs are added to every message forwarded.  A message's own
+	// attributes take precedence over them.
+	Attrs *Attrs
+
+	// IgnoreLevel logs forwarded messages even if they're less severe than
+	// the level the target logs at.  This only works if the target is a Base
+	// or a LogAdapter.
+	IgnoreLevel bool
+}
+
+// NewForwardLoggerConfig creates a new ForwardLoggerConfig without any extra
+// attributes
+func NewForwardLoggerConfig() *ForwardLoggerConfig {
+	return &ForwardLoggerConfig{
+		Attrs:       NewAttrs(),
+		IgnoreLevel: false,
+	}
+}
+
+/*
+ForwardLogger is a Logger that logs messages to another WrappableLogger, such as
+another Base or a LogAdapter, so messages logged to one Base also show up in
+another one.  This is useful when a library has its own Base and its messages
+should also be logged by the application's Base.  Forwarded messages include
+the attributes of the Base the ForwardLogger was added to as well as Attrs from
+its config.
+
+If the messages a ForwardLogger forwards would end up being forwarded back to
+its own Base they're dropped and ErrForwardLoop is reported to the Base's error
+channel instead.
+*/
+type ForwardLogger struct {
+	config *ForwardLoggerConfig
+	target WrappableLogger
+
+	lock          sync.RWMutex
+	base          *Base
+	isInitialized bool
+
+	// loopChecked is the loggerSetGeneration when forwarding was last
+	// checked for loops, and loops is whether it looped
+	loopChecked uint64
+	loops       bool
+}
+
+var _ Logger = &ForwardLogger{}
+
+// NewForwardLogger creates a new ForwardLogger that logs messages to target.
+// If config is nil the values from NewForwardLoggerConfig are used.
+func NewForwardLogger(target WrappableLogger, config *ForwardLoggerConfig) (*ForwardLogger, error) {
+	if target == nil {
+		return nil, errors.New("target cannot be nil")
+	}
+	if config == nil {
+		config = NewForwardLoggerConfig()
+	}
+
+	return &ForwardLogger{
+		config: config,
+		target: target,
+	}, nil
+}
+
+// SetBase will set the Base the ForwardLogger is attached to
+func (l *ForwardLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.base = base
+	l.loopChecked = 0
+}
+
+// InitLogger initializes the ForwardLogger.  It returns ErrForwardLoop if it
+// already has a Base and forwarding to the target would loop back to it.
+func (l *ForwardLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.base != nil && l.checkLoop() {
+		return ErrForwardLoop
+	}
+
+	l.isInitialized = true
+	return nil
+}
+
+// IsInitialized returns whether the ForwardLogger has been initialized or not
+func (l *ForwardLogger) IsInitialized() bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.isInitialized
+}
+
+// ShutdownLogger shuts down the ForwardLogger.  The target is not shut down.
+func (l *ForwardLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.isInitialized = false
+	return nil
+}
+
+// Logm forwards a message to the target
+func (l *ForwardLogger) Logm(timestamp time.Time, level LogLevel, m map[string]interface{}, msg string) error {
+	l.lock.RLock()
+	base := l.base
+	loops := l.loops
+	checked := l.loopChecked == atomic.LoadUint64(&loggerSetGeneration)
+	l.lock.RUnlock()
+
+	// Loggers can be added to any of the Bases along the way at any time,
+	// so this has to be checked again whenever any of them change.
+	if base != nil && !checked {
+		l.lock.Lock()
+		loops = l.checkLoop()
+		l.lock.Unlock()
+	}
+	if base != nil && loops {
+		// The message is dropped on purpose, so it isn't an error for
+		// the queue to retry
+		base.report(ErrForwardLoop)
+		return nil
+	}
+
+	attrs := NewAttrs()
+	if base != nil {
+		attrs.MergeAttrs(base.BaseAttrs)
+	}
+	attrs.MergeAttrs(l.config.Attrs)
+	attrs.MergeAttrs(NewAttrsFromMap(m))
+
+	if l.config.IgnoreLevel {
+		if target, ok := l.target.(unleveledLogger); ok {
+			return target.logForwarded(level, timestamp, attrs, msg)
+		}
+	}
+	return l.target.LogWithTime(level, timestamp, attrs, msg)
+}
+
+// checkLoop checks whether forwarding to the target would loop back to the
+// Base and remembers the result until any Base's loggers change.  It must be
+// called with the lock held.
+func (l *ForwardLogger) checkLoop() bool {
+	generation := atomic.LoadUint64(&loggerSetGeneration)
+	l.loops = forwardsTo(l.target, l.base, make(map[*Base]bool))
+	l.loopChecked = generation
+	return l.loops
+}
+
+// forwardsTo returns true if messages logged to target end up being logged to
+// base, either because target is base or one of the LogAdapters wrapping it, or
+// because the target's Base forwards messages on to base
+func forwardsTo(target WrappableLogger, base *Base, visited map[*Base]bool) bool {
+	targetBase := wrappedBase(target)
+	if targetBase == nil {
+		return false
+	}
+	if targetBase == base {
+		return true
+	}
+	if visited[targetBase] {
+		return false
+	}
+	visited[targetBase] = true
+
+	for _, logger := range targetBase.loggers() {
+		fl, ok := logger.(*ForwardLogger)
+		if ok && forwardsTo(fl.target, base, visited) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package gomol
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type ForwardLoggerSuite struct{}
+
+func (s *ForwardLoggerSuite) TestNewForwardLoggerNilTarget(t sweet.T) {
+	_, err := NewForwardLogger(nil, nil)
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *ForwardLoggerSuite) TestForward(t sweet.T) {
+	app, appLogger := newTestBase()
+	app.SetLogLevel(LevelDebug)
+	app.SetAttr("app", "service")
+
+	cfg := NewForwardLoggerConfig()
+	cfg.Attrs.SetAttr("library", "db").SetAttr("shared", "config")
+	fl, err := NewForwardLogger(app, cfg)
+	Expect(err).To(BeNil())
+
+	lib, libLogger := newTestBase()
+	lib.SetLogLevel(LevelDebug)
+	lib.SetAttr("shared", "lib")
+	lib.SetAttr("pool", 1)
+	Expect(lib.AddLogger(fl)).To(BeNil())
+	Expect(fl.IsInitialized()).To(BeTrue())
+
+	lib.Infom(NewAttrs().SetAttr("query", "select 1"), "query took %d%%", 100)
+	lib.ShutdownLoggers()
+	Expect(fl.IsInitialized()).To(BeFalse())
+	app.ShutdownLoggers()
+
+	Expect(memMessageTexts(libLogger)).To(Equal([]string{"query took 100%"}))
+	Expect(memMessageTexts(appLogger)).To(Equal([]string{"query took 100%"}))
+	Expect(appLogger.Messages()[0].Level).To(Equal(LevelInfo))
+	Expect(appLogger.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"app":     "service",
+		"library": "db",
+		"shared":  "config",
+		"pool":    1,
+		"query":   "select 1",
+	}))
+}
+
+func (s *ForwardLoggerSuite) TestTargetLevel(t sweet.T) {
+	app, appLogger := newTestBase()
+	app.SetLogLevel(LevelInfo)
+
+	fl, _ := NewForwardLogger(app, nil)
+	lib, _ := newTestBase()
+	lib.SetLogLevel(LevelDebug)
+	lib.AddLogger(fl)
+
+	lib.Debug("debug")
+	lib.Info("info")
+	lib.ShutdownLoggers()
+	app.ShutdownLoggers()
+
+	Expect(memMessageTexts(appLogger)).To(Equal([]string{"info"}))
+}
+
+func (s *ForwardLoggerSuite) TestIgnoreLevel(t sweet.T) {
+	app, appLogger := newTestBase()
+	app.SetLogLevel(LevelInfo)
+
+	cfg := NewForwardLoggerConfig()
+	cfg.IgnoreLevel = true
+	la := app.NewLogAdapter(NewAttrs().SetAttr("adapter", true))
+	la.SetLogLevel(LevelError)
+	fl, _ := NewForwardLogger(la, cfg)
+	lib, _ := newTestBase()
+	lib.SetLogLevel(LevelDebug)
+	lib.AddLogger(fl)
+
+	lib.Debug("debug")
+	lib.ShutdownLoggers()
+	app.ShutdownLoggers()
+
+	Expect(memMessageTexts(appLogger)).To(Equal([]string{"debug"}))
+	Expect(appLogger.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"adapter": true,
+	}))
+}
+
+func (s *ForwardLoggerSuite) TestLoopOnInit(t sweet.T) {
+	b1 := NewBase()
+	b2 := NewBase()
+
+	fl1, _ := NewForwardLogger(b2, nil)
+	b1.AddLogger(fl1)
+	fl2, _ := NewForwardLogger(b1.NewLogAdapter(nil), nil)
+	b2.AddLogger(fl2)
+
+	Expect(b1.InitLoggers()).To(Equal(ErrForwardLoop))
+
+	fl3, _ := NewForwardLogger(b1, nil)
+	b3 := NewBase()
+	b3.AddLogger(fl3)
+	Expect(b3.InitLoggers()).To(BeNil())
+	b3.ShutdownLoggers()
+}
+
+func (s *ForwardLoggerSuite) TestLoopWhileLogging(t sweet.T) {
+	b1, ml1 := newTestBase()
+	b1.SetLogLevel(LevelDebug)
+	b2, ml2 := newTestBase()
+	b2.SetLogLevel(LevelDebug)
+
+	errs := make(chan error, 10)
+	b1.SetErrorChan(errs)
+
+	fl1, _ := NewForwardLogger(b2, nil)
+	Expect(b1.AddLogger(fl1)).To(BeNil())
+	fl2, _ := NewForwardLogger(b1, nil)
+	Expect(b2.AddLogger(fl2)).To(BeNil())
+
+	b1.Info("from b1")
+	b1.Flush()
+	b2.Flush()
+	b1.ShutdownLoggers()
+	b2.ShutdownLoggers()
+
+	// Neither Base forwards anything once they forward to each other
+	Expect(memMessageTexts(ml1)).To(Equal([]string{"from b1"}))
+	Expect(memMessageTexts(ml2)).To(BeEmpty())
+	Expect(errs).To(Receive(Equal(ErrForwardLoop)))
+}
+
+func (s *ForwardLoggerSuite) TestLoopRemoved(t sweet.T) {
+	b1, ml1 := newTestBase()
+	b1.SetLogLevel(LevelDebug)
+	b2, ml2 := newTestBase()
+	b2.SetLogLevel(LevelDebug)
+
+	fl1, _ := NewForwardLogger(b2, nil)
+	Expect(b1.AddLogger(fl1)).To(BeNil())
+	fl2, _ := NewForwardLogger(b1, nil)
+	Expect(b2.AddLogger(fl2)).To(BeNil())
+
+	b1.Info("looped")
+	b1.Flush()
+	Expect(b2.RemoveLogger(fl2)).To(BeNil())
+
+	b1.Info("forwarded")
+	b1.ShutdownLoggers()
+	b2.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml1)).To(Equal([]string{"looped", "forwarded"}))
+	Expect(memMessageTexts(ml2)).To(Equal([]string{"forwarded"}))
+}
@@ -0,0 +1,628 @@
+package gomol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// GELFTransport is how a GELFLogger sends messages to the server
+type GELFTransport int
+
+const (
+	// GELFTransportUDP sends each message as a datagram, split into chunks if
+	// it's larger than ChunkSize
+	GELFTransportUDP GELFTransport = iota
+	// GELFTransportTCP sends messages over a TCP connection, each one
+	// followed by a null byte
+	GELFTransportTCP
+	// GELFTransportHTTP sends each message as the body of a POST request
+	GELFTransportHTTP
+)
+
+// GELFCompression is how a GELFLogger compresses messages before sending them
+type GELFCompression int
+
+const (
+	// GELFCompressionNone sends messages uncompressed
+	GELFCompressionNone GELFCompression = iota
+	// GELFCompressionGzip compresses messages with gzip
+	GELFCompressionGzip
+	// GELFCompressionZlib compresses messages with zlib.  It can only be used
+	// with GELFTransportUDP.
+	GELFCompressionZlib
+)
+
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// ErrGELFMessageTooLarge is reported to the Base's error channel when a message
+// sent over UDP would need more chunks than the 128 allowed by GELF
+var ErrGELFMessageTooLarge = errors.New("the message is too large to send as GELF chunks")
+
+// GELFFieldNameError is reported to the Base's error channel when an attribute
+// isn't sent because its GELF field name is the same as another attribute's.
+// Characters GELF doesn't allow in field names are replaced with underscores,
+// so attributes such as "a b" and "a_b" would otherwise overwrite each other.
+// An attribute that already had a valid name is the one that's sent.
+type GELFFieldNameError struct {
+	Attr  string
+	Field string
+}
+
+func (e *GELFFieldNameError) Error() string {
+	return fmt.Sprintf("the attribute %q wasn't sent because another attribute uses the GELF field name %q", e.Attr, e.Field)
+}
+
+// The errors returned by NewGELFLogger for an invalid config
+var (
+	ErrGELFNoConfig           = errors.New("a config is required")
+	ErrGELFNoAddress          = errors.New("an address is required")
+	ErrGELFChunkSize          = errors.New("the chunk size must be larger than the 12 byte chunk header")
+	ErrGELFCompression        = errors.New("the compression can't be used with the transport")
+	ErrGELFUnknownTransport   = errors.New("unknown GELF transport")
+	ErrGELFUnknownCompression = errors.New("unknown GELF compression")
+	ErrGELFBufferSize         = errors.New("the buffer size must be positive")
+)
+
+// GELFLoggerConfig is the configuration for a GELFLogger
+type GELFLoggerConfig struct {
+	// Transport is how messages are sent to the server
+	Transport GELFTransport
+
+	// Address is the host and port of the server for GELFTransportUDP and
+	// GELFTransportTCP, or the URL messages are posted to for
+	// GELFTransportHTTP
+	Address string
+
+	// TLSConfig is the TLS configuration used for GELFTransportTCP.  If it is
+	// nil the connection isn't encrypted.
+	TLSConfig *tls.Config
+
+	// Compression is how messages are compressed.  Messages sent with
+	// GELFTransportTCP can't be compressed.
+	Compression GELFCompression
+
+	// Hostname is the name of the host included with each message
+	Hostname string
+
+	// ChunkSize is the largest datagram sent with GELFTransportUDP,
+	// including the chunk header.  Messages larger than this are split into
+	// chunks.
+	ChunkSize int
+
+	// HTTPClient is the client requests are sent with for GELFTransportHTTP.
+	// If it is nil a client with a timeout of Timeout is used.
+	HTTPClient *http.Client
+
+	// Timeout is how long to wait for each request to the server
+	Timeout time.Duration
+
+	// DialTimeout is how long to wait when connecting to the server
+	DialTimeout time.Duration
+
+	// WriteTimeout is how long to wait for a message to be written before
+	// the connection is considered broken
+	WriteTimeout time.Duration
+
+	// MaxRetries is how many times a message is sent again after it couldn't
+	// be sent before it's dropped
+	MaxRetries int
+
+	RetryDelay
+
+	// BufferSize is the most messages kept waiting to be sent.  Once it's
+	// reached the oldest waiting message is dropped.
+	BufferSize int
+}
+
+// NewGELFLoggerConfig creates a new GELFLoggerConfig that sends compressed
+// messages over UDP to address
+func NewGELFLoggerConfig(address string) *GELFLoggerConfig {
+	hostname, _ := os.Hostname()
+
+	return &GELFLoggerConfig{
+		Transport:    GELFTransportUDP,
+		Address:      address,
+		Compression:  GELFCompressionGzip,
+		Hostname:     hostname,
+		ChunkSize:    1420,
+		Timeout:      10 * time.Second,
+		DialTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		MaxRetries:   3,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 100 * time.Millisecond,
+			MaxRetryDelay: 5 * time.Second,
+		},
+		BufferSize: 1000,
+	}
+}
+
+/*
+GELFLogger is a Logger that sends messages to Graylog, or anything else that
+accepts the Graylog Extended Log Format.  A message's level is sent as the GELF
+level and the Base's attributes merged with the message's are sent as
+additional fields, prefixed with an underscore.  Characters GELF doesn't allow
+in field names are replaced with underscores and an attribute named "id", which
+GELF reserves, is sent as "_id_".
+
+Messages are sent in the background so a slow or unreachable server doesn't
+hold up the queue, and up to BufferSize messages wait to be sent.  If a message
+can't be sent the logger sends it again, reconnecting first for
+GELFTransportTCP.  Once a message has been dropped the error is reported to the
+Base's error channel and the logger reports itself as unhealthy until a message
+is sent successfully.
+*/
+type GELFLogger struct {
+	config  *GELFLoggerConfig
+	clock   glock.Clock
+	client  *http.Client
+	encoder *JSONEncoder
+
+	lock          sync.Mutex
+	base          *Base
+	isInitialized bool
+	healthy       bool
+	sender        *sender
+
+	// sendLock is held while a message is being sent and protects the
+	// connection
+	sendLock sync.Mutex
+	conn     net.Conn
+}
+
+var _ Logger = &GELFLogger{}
+var _ HealthCheckLogger = &GELFLogger{}
+var _ ShutdownLoggerContext = &GELFLogger{}
+
+// NewGELFLogger creates a new GELFLogger
+func NewGELFLogger(config *GELFLoggerConfig) (*GELFLogger, error) {
+	if config == nil {
+		return nil, ErrGELFNoConfig
+	}
+	if len(config.Address) == 0 {
+		return nil, ErrGELFNoAddress
+	}
+
+	switch config.Transport {
+	case GELFTransportUDP:
+		if config.ChunkSize <= gelfChunkHeaderSize {
+			return nil, ErrGELFChunkSize
+		}
+	case GELFTransportTCP:
+		// Messages over TCP are separated by null bytes, which
+		// compressed messages could contain
+		if config.Compression != GELFCompressionNone {
+			return nil, ErrGELFCompression
+		}
+	case GELFTransportHTTP:
+		if config.Compression == GELFCompressionZlib {
+			return nil, ErrGELFCompression
+		}
+	default:
+		return nil, ErrGELFUnknownTransport
+	}
+	if config.Compression < GELFCompressionNone || config.Compression > GELFCompressionZlib {
+		return nil, ErrGELFUnknownCompression
+	}
+	if config.BufferSize <= 0 {
+		return nil, ErrGELFBufferSize
+	}
+	if err := config.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &GELFLogger{
+		config:  config,
+		clock:   glock.NewRealClock(),
+		client:  client,
+		encoder: NewJSONEncoder(nil),
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *GELFLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger connects to the server for GELFTransportUDP and
+// GELFTransportTCP
+func (l *GELFLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	if l.config.Transport != GELFTransportHTTP {
+		l.sendLock.Lock()
+		err := l.connect()
+		l.sendLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	l.sender = newSender(l.config.BufferSize)
+	l.healthy = true
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *GELFLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger waits for the messages waiting to be sent and closes the
+// connection to the server
+func (l *GELFLogger) ShutdownLogger() error {
+	return l.ShutdownLoggerContext(context.Background())
+}
+
+// ShutdownLoggerContext waits for the messages waiting to be sent and closes
+// the connection to the server.  If ctx is done before they're sent they're
+// no longer retried and are dropped.
+func (l *GELFLogger) ShutdownLoggerContext(ctx context.Context) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+	sender := l.sender
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	sender.shutdown(ctx)
+
+	l.sendLock.Lock()
+	defer l.sendLock.Unlock()
+
+	var err error
+	if l.conn != nil {
+		err = l.conn.Close()
+		l.conn = nil
+	}
+	return err
+}
+
+// Healthy returns whether the last message was sent
+func (l *GELFLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.healthy
+}
+
+// Logm adds a message to the messages waiting to be sent to the server
+func (l *GELFLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	mergedAttrs := attrs
+	if l.base != nil {
+		mergedAttrs = l.base.BaseAttrs.Attrs()
+		for key, val := range attrs {
+			mergedAttrs[key] = val
+		}
+	}
+	l.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	collisions := l.encode(buf, timestamp, level, mergedAttrs, msg)
+	if len(collisions) > 0 {
+		l.lock.Lock()
+		for _, err := range collisions {
+			l.report(err)
+		}
+		l.lock.Unlock()
+	}
+
+	var send func(stop <-chan struct{}) error
+	switch l.config.Transport {
+	case GELFTransportHTTP:
+		data := gelfCompress(buf.Bytes(), l.config.Compression)
+		send = func(stop <-chan struct{}) error { return l.post(data, stop) }
+	case GELFTransportUDP:
+		packets, err := l.chunk(gelfCompress(buf.Bytes(), l.config.Compression))
+		if err != nil {
+			l.lock.Lock()
+			l.healthy = false
+			l.report(err)
+			l.lock.Unlock()
+			return nil
+		}
+		send = func(stop <-chan struct{}) error { return l.send(packets, stop) }
+	default:
+		packets := [][]byte{append(buf.Bytes(), 0)}
+		send = func(stop <-chan struct{}) error { return l.send(packets, stop) }
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// The logger may have been shut down while the message was being encoded
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	dropped := l.sender.add(func(stop <-chan struct{}) {
+		err := send(stop)
+
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.healthy = err == nil
+		if err != nil {
+			l.report(err)
+		}
+	})
+	if dropped {
+		l.report(ErrSendBufferFull)
+	}
+	return nil
+}
+
+// report reports err to the Base's error channel, if the logger has a Base.
+// It must be called with the lock held.
+func (l *GELFLogger) report(err error) {
+	if l.base != nil {
+		l.base.report(err)
+	}
+}
+
+// encode writes a message as a GELF payload.  If msg has more than one line
+// the first line is sent as the short message and all of msg as the full
+// message.  Attributes that weren't written because their field name is used
+// by another attribute are returned as errors.
+func (l *GELFLogger) encode(buf *bytes.Buffer, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) []error {
+	var scratch [64]byte
+
+	buf.WriteString(`{"version":"1.1","host":`)
+	writeJSONString(buf, l.config.Hostname)
+
+	buf.WriteString(`,"short_message":`)
+	if idx := strings.IndexByte(msg, '\n'); idx >= 0 {
+		writeJSONString(buf, strings.TrimRight(msg[:idx], "\r"))
+		buf.WriteString(`,"full_message":`)
+	}
+	writeJSONString(buf, msg)
+
+	buf.WriteString(`,"timestamp":`)
+	buf.Write(strconv.AppendInt(scratch[:0], timestamp.Unix(), 10))
+	millis := timestamp.Nanosecond() / int(time.Millisecond)
+	buf.WriteByte('.')
+	buf.WriteByte(byte('0' + millis/100))
+	buf.WriteByte(byte('0' + millis/10%10))
+	buf.WriteByte(byte('0' + millis%10))
+
+	buf.WriteString(`,"level":`)
+	buf.Write(strconv.AppendInt(scratch[:0], int64(gelfLevel(level)), 10))
+
+	keys := sortedAttrKeys(attrs)
+	names := make([]string, len(keys))
+	owners := make(map[string]string, len(keys))
+	var collisions []error
+	for idx, key := range keys {
+		name := gelfFieldName(key)
+		names[idx] = name
+		owner, ok := owners[name]
+		if !ok {
+			owners[name] = key
+			continue
+		}
+
+		// Only one attribute can have exactly the field's name and it
+		// wins over any that had to be changed to get it
+		if key == name {
+			owners[name] = key
+			key = owner
+		}
+		collisions = append(collisions, &GELFFieldNameError{Attr: key, Field: "_" + name})
+	}
+
+	for idx, key := range keys {
+		name := names[idx]
+		if len(name) == 0 || owners[name] != key {
+			continue
+		}
+
+		buf.WriteString(`,"_`)
+		buf.WriteString(name)
+		buf.WriteString(`":`)
+
+		// GELF only allows numbers and strings as field values
+		switch val := attrs[key].(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			l.encoder.writeValue(buf, val)
+		default:
+			writeJSONString(buf, attrString(val, time.RFC3339Nano))
+		}
+	}
+
+	buf.WriteByte('}')
+	return collisions
+}
+
+// gelfLevel returns the GELF level for level.  gomol's levels already use the
+// syslog severities GELF expects.
+func gelfLevel(level LogLevel) int {
+	if level < 0 || level > 7 {
+		return int(LevelDebug)
+	}
+	return int(level)
+}
+
+// gelfFieldName returns name with any characters that aren't allowed in a GELF
+// field name replaced with underscores
+func gelfFieldName(name string) string {
+	if name == "id" {
+		return "id_"
+	}
+
+	var fixed []byte
+	for idx := 0; idx < len(name); idx++ {
+		c := name[idx]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' {
+			continue
+		}
+		if fixed == nil {
+			fixed = []byte(name)
+		}
+		fixed[idx] = '_'
+	}
+
+	if fixed == nil {
+		return name
+	}
+	return string(fixed)
+}
+
+// gelfCompress compresses data with compression
+func gelfCompress(data []byte, compression GELFCompression) []byte {
+	buf := &bytes.Buffer{}
+	switch compression {
+	case GELFCompressionGzip:
+		w := gzip.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+	case GELFCompressionZlib:
+		w := zlib.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+	default:
+		return data
+	}
+	return buf.Bytes()
+}
+
+// post sends a message to the server with GELFTransportHTTP
+func (l *GELFLogger) post(data []byte, stop <-chan struct{}) error {
+	return postWithRetry(l.client, l.clock, l.config.MaxRetries, l.config.RetryDelay, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", l.config.Address, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if l.config.Compression == GELFCompressionGzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		return req, nil
+	}, httpRetryable, stop)
+}
+
+// send sends the packets of a message to the server with GELFTransportUDP or
+// GELFTransportTCP, retrying until it succeeds, has been retried MaxRetries
+// times or stop is closed
+func (l *GELFLogger) send(packets [][]byte, stop <-chan struct{}) error {
+	l.sendLock.Lock()
+	defer l.sendLock.Unlock()
+
+	return retry(l.clock, l.config.backoff(), l.config.MaxRetries, stop, func() (time.Duration, error) {
+		return 0, l.write(packets)
+	})
+}
+
+// chunk splits data into GELF chunks if it's larger than ChunkSize
+func (l *GELFLogger) chunk(data []byte) ([][]byte, error) {
+	if len(data) <= l.config.ChunkSize {
+		return [][]byte{data}, nil
+	}
+
+	size := l.config.ChunkSize - gelfChunkHeaderSize
+	count := (len(data) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, ErrGELFMessageTooLarge
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	chunks := make([][]byte, 0, count)
+	for idx := 0; idx < count; idx++ {
+		start := idx * size
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-start)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(idx), byte(count))
+		chunks = append(chunks, append(chunk, data[start:end]...))
+	}
+	return chunks, nil
+}
+
+// write writes packets to the connection, connecting first if needed.  If
+// anything fails the connection is closed so the next attempt reconnects.  It
+// must be called with sendLock held.
+func (l *GELFLogger) write(packets [][]byte) error {
+	if l.conn == nil {
+		err := l.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, packet := range packets {
+		if l.config.WriteTimeout > 0 {
+			l.conn.SetWriteDeadline(time.Now().Add(l.config.WriteTimeout))
+		}
+		_, err := l.conn.Write(packet)
+		if err != nil {
+			l.conn.Close()
+			l.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// connect connects to the server.  It must be called with sendLock held.
+func (l *GELFLogger) connect() error {
+	network := "tcp"
+	switch {
+	case l.config.Transport == GELFTransportUDP:
+		network = "udp"
+	case l.config.TLSConfig != nil:
+		network = "tcp+tls"
+	}
+
+	conn, _, err := dialNetwork(network, l.config.Address, l.config.TLSConfig, l.config.DialTimeout)
+	if err != nil {
+		return err
+	}
+
+	l.conn = conn
+	return nil
+}
@@ -0,0 +1,353 @@
+package gomol
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type GELFLoggerSuite struct{}
+
+func newTestGELFLogger(transport GELFTransport, address string) (*GELFLogger, *glock.MockClock) {
+	cfg := NewGELFLoggerConfig(address)
+	cfg.Transport = transport
+	cfg.Compression = GELFCompressionNone
+	cfg.Hostname = "testhost"
+	cfg.MaxRetries = 1
+
+	l, err := NewGELFLogger(cfg)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	l.clock = clock
+	return l, clock
+}
+
+// readGELFTestUDP reads datagrams from pc until a whole message has been
+// received, reassembling chunks and decompressing it if needed
+func readGELFTestUDP(pc net.PacketConn) map[string]interface{} {
+	chunks := map[string][][]byte{}
+	buf := make([]byte, 65536)
+
+	for {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		packet := append([]byte{}, buf[:n]...)
+
+		var data []byte
+		if len(packet) > 1 && packet[0] == 0x1e && packet[1] == 0x0f {
+			Expect(len(packet)).To(BeNumerically(">", gelfChunkHeaderSize))
+			id := string(packet[2:10])
+			seq, count := int(packet[10]), int(packet[11])
+			if chunks[id] == nil {
+				chunks[id] = make([][]byte, count)
+			}
+			chunks[id][seq] = packet[gelfChunkHeaderSize:]
+
+			complete := true
+			for _, chunk := range chunks[id] {
+				complete = complete && chunk != nil
+			}
+			if !complete {
+				continue
+			}
+			data = bytes.Join(chunks[id], nil)
+		} else {
+			data = packet
+		}
+
+		return decodeGELFTestPayload(data)
+	}
+}
+
+func decodeGELFTestPayload(data []byte) map[string]interface{} {
+	switch {
+	case len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		Expect(err).To(BeNil())
+		data, err = ioutil.ReadAll(r)
+		Expect(err).To(BeNil())
+	case len(data) > 0 && data[0] == 0x78:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		Expect(err).To(BeNil())
+		data, err = ioutil.ReadAll(r)
+		Expect(err).To(BeNil())
+	}
+
+	payload := map[string]interface{}{}
+	Expect(json.Unmarshal(data, &payload)).To(BeNil())
+	return payload
+}
+
+func (s *GELFLoggerSuite) TestNewGELFLoggerBadConfig(t sweet.T) {
+	_, err := NewGELFLogger(nil)
+	Expect(err).To(Equal(ErrGELFNoConfig))
+
+	_, err = NewGELFLogger(NewGELFLoggerConfig(""))
+	Expect(err).To(Equal(ErrGELFNoAddress))
+
+	cfg := NewGELFLoggerConfig("127.0.0.1:12201")
+	cfg.Transport = GELFTransportTCP
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFCompression))
+
+	cfg = NewGELFLoggerConfig("http://127.0.0.1:12201/gelf")
+	cfg.Transport = GELFTransportHTTP
+	cfg.Compression = GELFCompressionZlib
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFCompression))
+
+	cfg = NewGELFLoggerConfig("127.0.0.1:12201")
+	cfg.ChunkSize = gelfChunkHeaderSize
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFChunkSize))
+
+	cfg = NewGELFLoggerConfig("127.0.0.1:12201")
+	cfg.Transport = GELFTransport(100)
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFUnknownTransport))
+
+	cfg = NewGELFLoggerConfig("127.0.0.1:12201")
+	cfg.Compression = GELFCompression(100)
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFUnknownCompression))
+
+	cfg = NewGELFLoggerConfig("127.0.0.1:12201")
+	cfg.BufferSize = 0
+	_, err = NewGELFLogger(cfg)
+	Expect(err).To(Equal(ErrGELFBufferSize))
+}
+
+func (s *GELFLoggerSuite) TestEncode(t sweet.T) {
+	l, _ := newTestGELFLogger(GELFTransportUDP, "127.0.0.1:12201")
+
+	buf := &bytes.Buffer{}
+	l.encode(buf, testTime, LevelWarning, map[string]interface{}{
+		"int":      1234,
+		"float":    1.5,
+		"bool":     true,
+		"time":     testTime,
+		"bad key!": "val",
+		"id":       "reserved",
+		"":         "empty",
+	}, "message")
+	Expect(buf.String()).To(Equal(`{"version":"1.1","host":"testhost","short_message":"message",` +
+		`"timestamp":1488603967.890,"level":4,"_bad_key_":"val","_bool":"true","_float":1.5,` +
+		`"_id_":"reserved","_int":1234,"_time":"2017-03-04T05:06:07.89Z"}`))
+
+	buf.Reset()
+	l.encode(buf, testTime, LevelNone, nil, "first line\r\nsecond line")
+	Expect(buf.String()).To(Equal(`{"version":"1.1","host":"testhost","short_message":"first line",` +
+		`"full_message":"first line\r\nsecond line","timestamp":1488603967.890,"level":7}`))
+}
+
+func (s *GELFLoggerSuite) TestEncodeFieldNameCollision(t sweet.T) {
+	l, _ := newTestGELFLogger(GELFTransportUDP, "127.0.0.1:12201")
+
+	buf := &bytes.Buffer{}
+	collisions := l.encode(buf, testTime, LevelInfo, map[string]interface{}{
+		"a b": "changed",
+		"a_b": "valid",
+		"c d": "first",
+		"c!d": "second",
+	}, "message")
+	Expect(buf.String()).To(Equal(`{"version":"1.1","host":"testhost","short_message":"message",` +
+		`"timestamp":1488603967.890,"level":6,"_a_b":"valid","_c_d":"first"}`))
+	Expect(collisions).To(Equal([]error{
+		&GELFFieldNameError{Attr: "a b", Field: "_a_b"},
+		&GELFFieldNameError{Attr: "c!d", Field: "_c_d"},
+	}))
+}
+
+func (s *GELFLoggerSuite) TestFieldNameCollisionReported(t sweet.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer pc.Close()
+
+	l, _ := newTestGELFLogger(GELFTransportUDP, pc.LocalAddr().String())
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// The message is still sent without the attribute
+	Expect(l.Logm(testTime, LevelInfo, map[string]interface{}{"a b": 1, "a_b": 2}, "message")).To(BeNil())
+	Eventually(errs).Should(Receive(Equal(&GELFFieldNameError{Attr: "a b", Field: "_a_b"})))
+
+	buf := make([]byte, 8192)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(n).To(BeNumerically(">", 0))
+}
+
+func (s *GELFLoggerSuite) TestLevels(t sweet.T) {
+	Expect(gelfLevel(LevelFatal)).To(Equal(2))
+	Expect(gelfLevel(LevelError)).To(Equal(3))
+	Expect(gelfLevel(LevelWarning)).To(Equal(4))
+	Expect(gelfLevel(LevelInfo)).To(Equal(6))
+	Expect(gelfLevel(LevelDebug)).To(Equal(7))
+}
+
+func (s *GELFLoggerSuite) TestUDP(t sweet.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer pc.Close()
+
+	l, _ := newTestGELFLogger(GELFTransportUDP, pc.LocalAddr().String())
+
+	b := NewBase()
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+	defer b.ShutdownLoggers()
+
+	b.Infom(NewAttrs().SetAttr("msg_attr", 1), "udp message")
+
+	payload := readGELFTestUDP(pc)
+	Expect(payload["short_message"]).To(Equal("udp message"))
+	Expect(payload["level"]).To(Equal(float64(6)))
+	Expect(payload["_base_attr"]).To(Equal("base"))
+	Expect(payload["_msg_attr"]).To(Equal(float64(1)))
+}
+
+func (s *GELFLoggerSuite) TestUDPChunked(t sweet.T) {
+	for _, compression := range []GELFCompression{GELFCompressionNone, GELFCompressionGzip, GELFCompressionZlib} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+
+		l, _ := newTestGELFLogger(GELFTransportUDP, pc.LocalAddr().String())
+		l.config.Compression = compression
+		l.config.ChunkSize = 100
+		Expect(l.InitLogger()).To(BeNil())
+
+		// Random-ish content so compression doesn't make it fit in one chunk
+		msg := &bytes.Buffer{}
+		for idx := 0; idx < 200; idx++ {
+			msg.WriteString(time.Duration(idx * 7919 * 104729).String())
+		}
+
+		Expect(l.Logm(testTime, LevelError, nil, msg.String())).To(BeNil())
+		payload := readGELFTestUDP(pc)
+		Expect(payload["short_message"]).To(Equal(msg.String()))
+		Expect(payload["level"]).To(Equal(float64(3)))
+
+		l.ShutdownLogger()
+		pc.Close()
+	}
+}
+
+func (s *GELFLoggerSuite) TestUDPTooManyChunks(t sweet.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer pc.Close()
+
+	l, _ := newTestGELFLogger(GELFTransportUDP, pc.LocalAddr().String())
+	l.config.ChunkSize = gelfChunkHeaderSize + 1
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// Sending the message again wouldn't help so it's reported instead of
+	// returned
+	Expect(l.Logm(testTime, LevelInfo, nil, strings.Repeat("x", 200))).To(BeNil())
+	Expect(errs).To(Receive(Equal(ErrGELFMessageTooLarge)))
+	Expect(l.Healthy()).To(BeFalse())
+}
+
+func (s *GELFLoggerSuite) TestTCP(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, _ := newTestGELFLogger(GELFTransportTCP, ln.Addr().String())
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "first")).To(BeNil())
+	Expect(l.Logm(testTime, LevelDebug, nil, "second")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, msg := range []string{"first", "second"} {
+		frame, err := r.ReadBytes(0)
+		Expect(err).To(BeNil())
+		payload := decodeGELFTestPayload(frame[:len(frame)-1])
+		Expect(payload["short_message"]).To(Equal(msg))
+	}
+}
+
+func (s *GELFLoggerSuite) TestTCPReconnect(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, clock := newTestGELFLogger(GELFTransportTCP, ln.Addr().String())
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	conn.Close()
+
+	// Writes to a socket closed by the other side don't fail right away so
+	// pretend the logger noticed
+	l.sendLock.Lock()
+	l.conn.Close()
+	l.sendLock.Unlock()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "reconnected")).To(BeNil())
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(100 * time.Millisecond)
+
+	conn, err = ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := bufio.NewReader(conn).ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(decodeGELFTestPayload(frame[:len(frame)-1])["short_message"]).To(Equal("reconnected"))
+}
+
+func (s *GELFLoggerSuite) TestHTTP(t sweet.T) {
+	server := newHTTPTestServer(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	l, clock := newTestGELFLogger(GELFTransportHTTP, server.URL+"/gelf")
+	l.config.Compression = GELFCompressionGzip
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// The message is retried in the background so Logm doesn't wait for it
+	Expect(l.Logm(testTime, LevelInfo, nil, "http message")).To(BeNil())
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(100 * time.Millisecond)
+	Eventually(server.Bodies).Should(HaveLen(2))
+
+	bodies := server.Bodies()
+	Expect(server.Header(1).Get("Content-Encoding")).To(Equal("gzip"))
+	Expect(decodeGELFTestPayload([]byte(bodies[1]))["short_message"]).To(Equal("http message"))
+}
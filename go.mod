@@ -16,5 +16,5 @@ require (
 	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b
 	github.com/onsi/gomega v1.4.3
 	github.com/spaolacci/murmur3 v0.0.0-20180118202830-f09979ecbc72
-	golang.org/x/sys v0.0.0-20190312061237-fead79001313 // indirect
+	golang.org/x/sys v0.0.0-20190312061237-fead79001313
 )
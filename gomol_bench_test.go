@@ -1,5 +1,15 @@
 package gomol
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
 /*
 import . "gopkg.in/check.v1"
 
@@ -129,3 +139,115 @@ func (s *GomolSuite) BenchmarkGetCallerInfo(c *C) {
 	}
 }
 */
+
+// writerLogger writes each message to w with its own call to Write
+type writerLogger struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (l *writerLogger) SetBase(*Base)         {}
+func (l *writerLogger) InitLogger() error     { return nil }
+func (l *writerLogger) ShutdownLogger() error { return nil }
+func (l *writerLogger) IsInitialized() bool   { return true }
+
+func (l *writerLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.buf.Reset()
+	fmt.Fprintf(&l.buf, "%s [%s] %s %v\n", timestamp.Format(time.RFC3339Nano), level, msg, attrs)
+	_, err := l.w.Write(l.buf.Bytes())
+	return err
+}
+
+// batchWriterLogger writes a whole batch of messages to w with a single call to Write
+type batchWriterLogger struct {
+	writerLogger
+}
+
+func (l *batchWriterLogger) LogBatch(msgs []*Message) error {
+	l.buf.Reset()
+	for _, msg := range msgs {
+		fmt.Fprintf(&l.buf, "%s [%s] %s %v\n", msg.Timestamp.Format(time.RFC3339Nano), msg.Level, msg.Msg, msg.Attrs.Attrs())
+	}
+	_, err := l.w.Write(l.buf.Bytes())
+	return err
+}
+
+func benchmarkQueueWrite(b *testing.B, logger Logger) {
+	base := NewBase()
+	base.AddLogger(logger)
+	base.InitLoggers()
+	defer base.ShutdownLoggers()
+
+	attrs := NewAttrs().SetAttr("attr1", 1234)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		base.Log(LevelInfo, attrs, "test %d", i)
+
+		// Keep the queue from filling up and dropping messages
+		if i%1000 == 999 {
+			base.Flush()
+		}
+	}
+	base.Flush()
+}
+
+func BenchmarkQueueLogm(b *testing.B) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	benchmarkQueueWrite(b, &writerLogger{w: f})
+}
+
+func BenchmarkQueueLogBatch(b *testing.B) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	benchmarkQueueWrite(b, &batchWriterLogger{writerLogger{w: f}})
+}
+
+var benchEncoderAttrs = map[string]interface{}{
+	"attr1": 1234,
+	"attr2": "val2",
+	"attr3": 12.34,
+	"attr4": true,
+	"attr5": errors.New("an error"),
+	"attr6": time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC),
+}
+
+func BenchmarkTemplateEncoderJSON(b *testing.B) {
+	tpl, err := NewTemplate("{{json .}}")
+	if err != nil {
+		b.Fatal(err)
+	}
+	enc := NewTemplateEncoder(tpl, false)
+	ts := time.Now()
+	buf := &bytes.Buffer{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		buf.Reset()
+		enc.Encode(buf, ts, LevelInfo, benchEncoderAttrs, "test message 1234")
+	}
+}
+
+func BenchmarkJSONEncoder(b *testing.B) {
+	enc := NewJSONEncoder(nil)
+	ts := time.Now()
+	buf := &bytes.Buffer{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		buf.Reset()
+		enc.Encode(buf, ts, LevelInfo, benchEncoderAttrs, "test message 1234")
+	}
+}
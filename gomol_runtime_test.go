@@ -56,8 +56,8 @@ func (s *GomolSuite) TestLogWithRuntimeInfo(t sweet.T) {
 	setFakeCallerInfo("fakefile.go", 1234)
 
 	b := NewBase()
-	b.config.FilenameAttr = "filename"
-	b.config.LineNumberAttr = "line"
+	b.config().FilenameAttr = "filename"
+	b.config().LineNumberAttr = "line"
 
 	l := newDefaultMemLogger()
 
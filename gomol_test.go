@@ -1,6 +1,8 @@
 package gomol
 
 import (
+	"flag"
+	"os"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -9,21 +11,53 @@ import (
 	junit "github.com/aphistic/sweet-junit"
 )
 
+// platformSuites holds suites that are only built on some platforms
+var platformSuites = []interface{}{}
+
 func TestMain(m *testing.M) {
 	RegisterFailHandler(sweet.GomegaFail)
 
+	// sweet only knows how to run suites so leave benchmarks to the
+	// standard test runner
+	flag.Parse()
+	if bench := flag.Lookup("test.bench"); bench != nil && bench.Value.String() != "" {
+		os.Exit(m.Run())
+	}
+
 	sweet.Run(m, func(s *sweet.S) {
 		s.RegisterPlugin(junit.NewPlugin())
 
 		s.AddSuite(&AttrsSuite{})
 		s.AddSuite(&BaseSuite{})
+		s.AddSuite(&DedupSuite{})
 		s.AddSuite(&DefaultSuite{})
+		s.AddSuite(&EncoderSuite{})
 		s.AddSuite(&FallbackLoggerSuite{})
+		s.AddSuite(&FlightRecorderSuite{})
+		s.AddSuite(&FluentLoggerSuite{})
+		s.AddSuite(&ForwardLoggerSuite{})
+		s.AddSuite(&GELFLoggerSuite{})
 		s.AddSuite(&GomolSuite{})
+		s.AddSuite(&HTTPLoggerSuite{})
 		s.AddSuite(&IssueSuite{})
 		s.AddSuite(&LogAdapterSuite{})
 		s.AddSuite(&LogLevelSuite{})
 		s.AddSuite(&MemLoggerSuite{})
+		s.AddSuite(&NetLoggerSuite{})
+		s.AddSuite(&OTelLoggerSuite{})
+		s.AddSuite(&RateLimitedLoggerSuite{})
+		s.AddSuite(&RecoverSuite{})
+		s.AddSuite(&ReloadSuite{})
+		s.AddSuite(&RouterSuite{})
+		s.AddSuite(&SamplerSuite{})
+		s.AddSuite(&SpoolSuite{})
+		s.AddSuite(&StdLoggerSuite{})
+		s.AddSuite(&SyslogLoggerSuite{})
+		s.AddSuite(&WriterLoggerSuite{})
+
+		for _, suite := range platformSuites {
+			s.AddSuite(suite)
+		}
 	})
 }
 
@@ -34,13 +68,14 @@ func (s *GomolSuite) SetUpTest(t sweet.T) {
 	gomolFiles = map[string]fileRecord{}
 
 	curTestExiter = &testExiter{}
-	setExiter(curTestExiter)
 
 	testBase = NewBase()
+	testBase.SetExiter(curTestExiter)
 	testBase.AddLogger(newDefaultMemLogger())
 	testBase.InitLoggers()
 
 	curDefault = NewBase()
+	curDefault.SetExiter(curTestExiter)
 	curDefault.AddLogger(newDefaultMemLogger())
 	curDefault.InitLoggers()
 }
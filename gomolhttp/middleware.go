@@ -0,0 +1,318 @@
+/*
+Package gomolhttp provides net/http middleware that logs requests using gomol.
+The middleware works with anything that uses the standard
+func(http.Handler) http.Handler signature, such as chi.
+*/
+package gomolhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aphistic/gomol"
+	"github.com/efritz/glock"
+)
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	requestIDContextKey
+)
+
+// MiddlewareConfig is the configuration for Middleware
+type MiddlewareConfig struct {
+	// RequestIDHeader is the header a request ID is read from and written to
+	// the response in.  If a request doesn't have one, or its ID is longer
+	// than MaxRequestIDLength or has characters other than letters, digits,
+	// '-', '_', '.' and ':', a new ID is generated.
+	RequestIDHeader string
+
+	// MaxRequestIDLength is the longest request ID read from a request
+	MaxRequestIDLength int
+
+	// RequestIDAttr is the name of the attribute the request ID is added to
+	// each message logged for the request as.  If it is empty the attribute
+	// isn't added.
+	RequestIDAttr string
+
+	// GenerateRequestID creates a new request ID.  If it is nil a random
+	// 128 bit hex ID is generated.
+	GenerateRequestID func() string
+
+	// ExcludePaths are request paths, such as health checks, that aren't
+	// logged.  Requests to them still get a request ID and a LogAdapter.
+	ExcludePaths []string
+
+	// StatusLevels are the levels requests are logged at for each class of
+	// status code, where 2 is the class of 2xx codes.  Statuses in a class
+	// missing from the map are logged at LevelInfo, and a class set to
+	// LevelNone isn't logged.
+	StatusLevels map[int]gomol.LogLevel
+
+	// Message is the message logged for each request
+	Message string
+
+	// MethodAttr is the name of the attribute for the request method
+	MethodAttr string
+
+	// PathAttr is the name of the attribute for the request path
+	PathAttr string
+
+	// StatusAttr is the name of the attribute for the response status code
+	StatusAttr string
+
+	// BytesAttr is the name of the attribute for the number of bytes
+	// written in the response body
+	BytesAttr string
+
+	// DurationAttr is the name of the attribute for how long the request
+	// took, in milliseconds
+	DurationAttr string
+
+	// RemoteAddrAttr is the name of the attribute for the request's remote
+	// address
+	RemoteAddrAttr string
+
+	// UserAgentAttr is the name of the attribute for the request's user
+	// agent
+	UserAgentAttr string
+}
+
+// NewMiddlewareConfig creates a new MiddlewareConfig that logs 4xx responses
+// as warnings, 5xx responses as errors and everything else as info
+func NewMiddlewareConfig() *MiddlewareConfig {
+	return &MiddlewareConfig{
+		RequestIDHeader:    "X-Request-Id",
+		MaxRequestIDLength: 128,
+		RequestIDAttr:      "request_id",
+		GenerateRequestID:  nil,
+		ExcludePaths:       nil,
+		StatusLevels: map[int]gomol.LogLevel{
+			4: gomol.LevelWarning,
+			5: gomol.LevelError,
+		},
+		Message:        "request finished",
+		MethodAttr:     "method",
+		PathAttr:       "path",
+		StatusAttr:     "status",
+		BytesAttr:      "bytes",
+		DurationAttr:   "duration_ms",
+		RemoteAddrAttr: "remote_addr",
+		UserAgentAttr:  "user_agent",
+	}
+}
+
+type middleware struct {
+	config  *MiddlewareConfig
+	logger  gomol.WrappableLogger
+	clock   glock.Clock
+	exclude map[string]struct{}
+}
+
+/*
+Middleware creates net/http middleware that logs each request to logger once it
+has finished, with the request's method, path, status, bytes written, duration,
+remote address and user agent as attributes.  Requests whose handler panics are
+logged with a 500 status before the panic continues.  Each request is given a request
+ID, from the request's RequestIDHeader if it has one, and a LogAdapter with the
+request ID as an attribute that handlers can get using FromContext.  If config
+is nil the values from NewMiddlewareConfig are used.
+*/
+func Middleware(logger gomol.WrappableLogger, config *MiddlewareConfig) func(http.Handler) http.Handler {
+	return newMiddleware(logger, config).handler
+}
+
+func newMiddleware(logger gomol.WrappableLogger, config *MiddlewareConfig) *middleware {
+	if config == nil {
+		config = NewMiddlewareConfig()
+	}
+
+	exclude := make(map[string]struct{}, len(config.ExcludePaths))
+	for _, path := range config.ExcludePaths {
+		exclude[path] = struct{}{}
+	}
+
+	return &middleware{
+		config:  config,
+		logger:  logger,
+		clock:   glock.NewRealClock(),
+		exclude: exclude,
+	}
+}
+
+func (m *middleware) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := m.clock.Now()
+
+		requestID := r.Header.Get(m.config.RequestIDHeader)
+		if !m.validRequestID(requestID) {
+			requestID = m.generateRequestID()
+		}
+		if len(m.config.RequestIDHeader) > 0 {
+			w.Header().Set(m.config.RequestIDHeader, requestID)
+		}
+
+		attrs := gomol.NewAttrs()
+		if len(m.config.RequestIDAttr) > 0 {
+			attrs.SetAttr(m.config.RequestIDAttr, requestID)
+		}
+		la := gomol.NewLogAdapterFor(m.logger, attrs)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		ctx = context.WithValue(ctx, loggerContextKey, la)
+
+		rw := &responseWriter{ResponseWriter: w}
+		panicked := true
+		defer func() {
+			status := rw.status
+			if panicked {
+				status = http.StatusInternalServerError
+			}
+			m.logRequest(la, r, rw, status, start)
+		}()
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		panicked = false
+	})
+}
+
+// logRequest logs a finished request unless its path is excluded or its
+// status is logged at LevelNone
+func (m *middleware) logRequest(la *gomol.LogAdapter, r *http.Request, rw *responseWriter, status int, start time.Time) {
+	if _, ok := m.exclude[r.URL.Path]; ok {
+		return
+	}
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	level := m.level(status)
+	if level == gomol.LevelNone {
+		return
+	}
+
+	duration := m.clock.Now().Sub(start)
+	reqAttrs := gomol.NewAttrs()
+	setAttr(reqAttrs, m.config.MethodAttr, r.Method)
+	setAttr(reqAttrs, m.config.PathAttr, r.URL.Path)
+	setAttr(reqAttrs, m.config.StatusAttr, status)
+	setAttr(reqAttrs, m.config.BytesAttr, rw.bytes)
+	setAttr(reqAttrs, m.config.DurationAttr, float64(duration)/float64(time.Millisecond))
+	setAttr(reqAttrs, m.config.RemoteAddrAttr, r.RemoteAddr)
+	setAttr(reqAttrs, m.config.UserAgentAttr, r.UserAgent())
+
+	la.Log(level, reqAttrs, m.config.Message)
+}
+
+func (m *middleware) level(status int) gomol.LogLevel {
+	if level, ok := m.config.StatusLevels[status/100]; ok {
+		return level
+	}
+	return gomol.LevelInfo
+}
+
+// validRequestID returns whether a request ID read from a request is short
+// enough and only has characters that are safe to log and send back
+func (m *middleware) validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > m.config.MaxRequestIDLength {
+		return false
+	}
+
+	for idx := 0; idx < len(id); idx++ {
+		c := id[idx]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (m *middleware) generateRequestID() string {
+	if m.config.GenerateRequestID != nil {
+		return m.config.GenerateRequestID()
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func setAttr(attrs *gomol.Attrs, name string, value interface{}) {
+	if len(name) > 0 {
+		attrs.SetAttr(name, value)
+	}
+}
+
+// FromContext returns the request's LogAdapter added by Middleware.  If ctx
+// didn't come from a request handled by Middleware nil is returned.
+func FromContext(ctx context.Context) *gomol.LogAdapter {
+	la, _ := ctx.Value(loggerContextKey).(*gomol.LogAdapter)
+	return la
+}
+
+// RequestIDFromContext returns the request ID added by Middleware.  If ctx
+// didn't come from a request handled by Middleware an empty string is
+// returned.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// responseWriter records the status and number of bytes written to a response
+type responseWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets handlers stream responses if the wrapped writer supports it
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, such as for websockets, if
+// the wrapped writer supports it
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
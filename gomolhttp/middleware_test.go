@@ -0,0 +1,286 @@
+package gomolhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/aphistic/gomol"
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type MiddlewareSuite struct{}
+
+type testMessage struct {
+	Level gomol.LogLevel
+	Msg   string
+	Attrs map[string]interface{}
+}
+
+// testLogger keeps the messages logged to it in memory
+type testLogger struct {
+	lock        sync.Mutex
+	initialized bool
+	messages    []*testMessage
+}
+
+func (l *testLogger) SetBase(base *gomol.Base) {}
+
+func (l *testLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.initialized = true
+	return nil
+}
+
+func (l *testLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.initialized = false
+	return nil
+}
+
+func (l *testLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.initialized
+}
+
+func (l *testLogger) Logm(ts time.Time, level gomol.LogLevel, m map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.messages = append(l.messages, &testMessage{Level: level, Msg: msg, Attrs: m})
+	return nil
+}
+
+func (l *testLogger) Messages() []*testMessage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.messages
+}
+
+func newTestMiddleware(config *MiddlewareConfig, h http.Handler) (http.Handler, *gomol.Base, *testLogger, *glock.MockClock) {
+	tl := &testLogger{}
+	b := gomol.NewBase()
+	b.AddLogger(tl)
+	b.InitLoggers()
+
+	m := newMiddleware(b, config)
+	clock := glock.NewMockClock()
+	m.clock = clock
+	return m.handler(h), b, tl, clock
+}
+
+func (s *MiddlewareSuite) TestLogRequest(t sweet.T) {
+	var clock *glock.MockClock
+	h, b, tl, clock := newTestMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(1500 * time.Microsecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("POST", "/things?id=1", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	b.ShutdownLoggers()
+
+	Expect(rec.Code).To(Equal(http.StatusCreated))
+	requestID := rec.Header().Get("X-Request-Id")
+	Expect(requestID).To(HaveLen(32))
+
+	msgs := tl.Messages()
+	Expect(msgs).To(HaveLen(1))
+	Expect(msgs[0].Level).To(Equal(gomol.LevelInfo))
+	Expect(msgs[0].Msg).To(Equal("request finished"))
+	Expect(msgs[0].Attrs).To(Equal(map[string]interface{}{
+		"request_id":  requestID,
+		"method":      "POST",
+		"path":        "/things",
+		"status":      http.StatusCreated,
+		"bytes":       int64(5),
+		"duration_ms": 1.5,
+		"remote_addr": "10.0.0.1:1234",
+		"user_agent":  "test-agent",
+	}))
+}
+
+func (s *MiddlewareSuite) TestRequestContext(t sweet.T) {
+	var ctx context.Context
+	h, b, tl, _ := newTestMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+		FromContext(ctx).Info("handling")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "abc123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	b.ShutdownLoggers()
+
+	Expect(rec.Header().Get("X-Request-Id")).To(Equal("abc123"))
+	Expect(RequestIDFromContext(ctx)).To(Equal("abc123"))
+
+	msgs := tl.Messages()
+	Expect(msgs).To(HaveLen(2))
+	Expect(msgs[0].Msg).To(Equal("handling"))
+	Expect(msgs[0].Attrs).To(Equal(map[string]interface{}{"request_id": "abc123"}))
+	Expect(msgs[1].Attrs["status"]).To(Equal(http.StatusOK))
+	Expect(msgs[1].Attrs["bytes"]).To(Equal(int64(0)))
+
+	Expect(FromContext(context.Background())).To(BeNil())
+	Expect(RequestIDFromContext(context.Background())).To(Equal(""))
+}
+
+func (s *MiddlewareSuite) TestInvalidRequestID(t sweet.T) {
+	cfg := NewMiddlewareConfig()
+	cfg.MaxRequestIDLength = 8
+	h, b, _, _ := newTestMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, id := range []string{"abc-1.2_3:4", "abc 123", "abc\n123", "abc\"123"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-Id", id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		Expect(rec.Header().Get("X-Request-Id")).To(HaveLen(32))
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "A-1.b_2:")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	Expect(rec.Header().Get("X-Request-Id")).To(Equal("A-1.b_2:"))
+
+	b.ShutdownLoggers()
+}
+
+func (s *MiddlewareSuite) TestPanic(t sweet.T) {
+	h, b, tl, _ := newTestMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("handler failed")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	Expect(func() { h.ServeHTTP(rec, req) }).To(Panic())
+	b.ShutdownLoggers()
+
+	msgs := tl.Messages()
+	Expect(msgs).To(HaveLen(1))
+	Expect(msgs[0].Level).To(Equal(gomol.LevelError))
+	Expect(msgs[0].Attrs["status"]).To(Equal(http.StatusInternalServerError))
+	Expect(msgs[0].Attrs["bytes"]).To(Equal(int64(7)))
+}
+
+func (s *MiddlewareSuite) TestStatusLevels(t sweet.T) {
+	cfg := NewMiddlewareConfig()
+	cfg.StatusLevels[3] = gomol.LevelNone
+
+	var status int
+	h, b, tl, _ := newTestMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	for _, status = range []int{200, 302, 404, 503} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	b.ShutdownLoggers()
+
+	var levels []gomol.LogLevel
+	var statuses []interface{}
+	for _, msg := range tl.Messages() {
+		levels = append(levels, msg.Level)
+		statuses = append(statuses, msg.Attrs["status"])
+	}
+	Expect(levels).To(Equal([]gomol.LogLevel{gomol.LevelInfo, gomol.LevelWarning, gomol.LevelError}))
+	Expect(statuses).To(Equal([]interface{}{200, 404, 503}))
+}
+
+func (s *MiddlewareSuite) TestExcludePaths(t sweet.T) {
+	cfg := NewMiddlewareConfig()
+	cfg.ExcludePaths = []string{"/healthz"}
+	cfg.GenerateRequestID = func() string {
+		return "generated"
+	}
+
+	var la *gomol.LogAdapter
+	h, b, tl, _ := newTestMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		la = FromContext(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	Expect(la).ToNot(BeNil())
+	Expect(rec.Header().Get("X-Request-Id")).To(Equal("generated"))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+	b.ShutdownLoggers()
+
+	msgs := tl.Messages()
+	Expect(msgs).To(HaveLen(1))
+	Expect(msgs[0].Attrs["path"]).To(Equal("/other"))
+	Expect(msgs[0].Attrs["request_id"]).To(Equal("generated"))
+}
+
+func (s *MiddlewareSuite) TestDisabledAttrs(t sweet.T) {
+	cfg := NewMiddlewareConfig()
+	cfg.RequestIDHeader = ""
+	cfg.RequestIDAttr = ""
+	cfg.DurationAttr = ""
+	cfg.RemoteAddrAttr = ""
+	cfg.UserAgentAttr = ""
+
+	h, b, tl, _ := newTestMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	b.ShutdownLoggers()
+
+	Expect(rec.Header()).ToNot(HaveKey("X-Request-Id"))
+	Expect(tl.Messages()).To(HaveLen(1))
+	Expect(tl.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"method": "GET",
+		"path":   "/",
+		"status": http.StatusOK,
+		"bytes":  int64(2),
+	}))
+}
+
+func (s *MiddlewareSuite) TestFlusher(t sweet.T) {
+	var flushed bool
+	h, b, _, _ := newTestMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if ok {
+			flusher.Flush()
+			flushed = true
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	b.ShutdownLoggers()
+
+	Expect(flushed).To(BeTrue())
+	Expect(rec.Flushed).To(BeTrue())
+}
+
+func (s *MiddlewareSuite) TestMiddleware(t sweet.T) {
+	tl := &testLogger{}
+	b := gomol.NewBase()
+	b.AddLogger(tl)
+	b.InitLoggers()
+
+	h := Middleware(b, nil)(http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	b.ShutdownLoggers()
+
+	Expect(tl.Messages()).To(HaveLen(1))
+	Expect(tl.Messages()[0].Level).To(Equal(gomol.LevelWarning))
+}
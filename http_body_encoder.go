@@ -0,0 +1,338 @@
+package gomol
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LinesBodyEncoder is an HTTPBodyEncoder that writes each message on its own
+// line using an Encoder
+type LinesBodyEncoder struct {
+	enc Encoder
+}
+
+var _ HTTPBodyEncoder = &LinesBodyEncoder{}
+
+// NewLinesBodyEncoder creates a LinesBodyEncoder that encodes each message
+// with enc
+func NewLinesBodyEncoder(enc Encoder) *LinesBodyEncoder {
+	return &LinesBodyEncoder{
+		enc: enc,
+	}
+}
+
+// ContentType returns the content type of the Encoder's output if it's a
+// ContentTypeEncoder, or plain text if it isn't
+func (e *LinesBodyEncoder) ContentType() string {
+	if enc, ok := e.enc.(ContentTypeEncoder); ok {
+		return enc.ContentType()
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// EncodeBody writes each entry to buf with the Encoder
+func (e *LinesBodyEncoder) EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error {
+	for _, entry := range entries {
+		err := e.enc.Encode(buf, entry.Timestamp, entry.Level, entry.Attrs, entry.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ElasticsearchBodyEncoder is an HTTPBodyEncoder for the Elasticsearch bulk API.
+Each message is added to the index as a document with its timestamp in the
+"@timestamp" field, its level in the "level" field, its message in the
+"message" field and its attributes as the remaining fields.
+*/
+type ElasticsearchBodyEncoder struct {
+	action []byte
+	enc    *JSONEncoder
+}
+
+var _ HTTPBodyEncoder = &ElasticsearchBodyEncoder{}
+
+// NewElasticsearchBodyEncoder creates an ElasticsearchBodyEncoder that adds
+// documents to index, which may also be a data stream
+func NewElasticsearchBodyEncoder(index string) *ElasticsearchBodyEncoder {
+	action := &bytes.Buffer{}
+	action.WriteString(`{"create":{"_index":`)
+	writeJSONString(action, index)
+	action.WriteString("}}\n")
+
+	return &ElasticsearchBodyEncoder{
+		action: action.Bytes(),
+		enc: NewJSONEncoder(&JSONEncoderConfig{
+			TimeKey:    "@timestamp",
+			LevelKey:   "level",
+			MessageKey: "message",
+			TimeFormat: time.RFC3339Nano,
+		}),
+	}
+}
+
+// ContentType returns the newline delimited JSON content type
+func (e *ElasticsearchBodyEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// EncodeBody writes a create action and document for each entry to buf
+func (e *ElasticsearchBodyEncoder) EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error {
+	for _, entry := range entries {
+		buf.Write(e.action)
+		e.enc.Encode(buf, entry.Timestamp, entry.Level, entry.Attrs, entry.Message)
+	}
+	return nil
+}
+
+/*
+LokiBodyEncoder is an HTTPBodyEncoder for the Grafana Loki push API.  Messages
+are grouped into streams by their level and the values of the attributes used
+as labels, and each message's line is written in logfmt with its message and
+its other attributes.
+*/
+type LokiBodyEncoder struct {
+	labels []string
+	enc    *LogfmtEncoder
+}
+
+var _ HTTPBodyEncoder = &LokiBodyEncoder{}
+
+// NewLokiBodyEncoder creates a LokiBodyEncoder that uses the attributes named
+// in labels as stream labels along with the message's level
+func NewLokiBodyEncoder(labels []string) *LokiBodyEncoder {
+	return &LokiBodyEncoder{
+		labels: labels,
+		enc: NewLogfmtEncoder(&LogfmtEncoderConfig{
+			MessageKey: "msg",
+			KeyOrder:   []string{},
+		}),
+	}
+}
+
+// ContentType returns the JSON content type
+func (e *LokiBodyEncoder) ContentType() string {
+	return "application/json"
+}
+
+type lokiStream struct {
+	labels map[string]string
+	values [][2]string
+}
+
+// EncodeBody writes a push request with the entries grouped into streams to buf
+func (e *LokiBodyEncoder) EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error {
+	streams := make([]*lokiStream, 0)
+	streamsByKey := make(map[string]*lokiStream)
+
+	line := &bytes.Buffer{}
+	for _, entry := range entries {
+		labels := map[string]string{"level": entry.Level.String()}
+		lineAttrs := make(map[string]interface{}, len(entry.Attrs))
+		for key, val := range entry.Attrs {
+			lineAttrs[key] = val
+		}
+		for _, label := range e.labels {
+			if val, ok := entry.Attrs[label]; ok {
+				labels[label] = attrString(val, time.RFC3339Nano)
+				delete(lineAttrs, label)
+			}
+		}
+
+		key := lokiStreamKey(labels)
+		stream, ok := streamsByKey[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			streamsByKey[key] = stream
+			streams = append(streams, stream)
+		}
+
+		line.Reset()
+		e.enc.encode(line, entry.Timestamp, entry.Level, lineAttrs, entry.Message)
+		stream.values = append(stream.values, [2]string{
+			strconv.FormatInt(entry.Timestamp.UnixNano(), 10),
+			line.String(),
+		})
+	}
+
+	buf.WriteString(`{"streams":[`)
+	for idx, stream := range streams {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteString(`{"stream":{`)
+		for labelIdx, label := range sortedLabelKeys(stream.labels) {
+			if labelIdx > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, label)
+			buf.WriteByte(':')
+			writeJSONString(buf, stream.labels[label])
+		}
+		buf.WriteString(`},"values":[`)
+		for valIdx, val := range stream.values {
+			if valIdx > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('[')
+			writeJSONString(buf, val[0])
+			buf.WriteByte(',')
+			writeJSONString(buf, val[1])
+			buf.WriteByte(']')
+		}
+		buf.WriteString("]}")
+	}
+	buf.WriteString("]}")
+
+	return nil
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lokiStreamKey returns a string that uniquely identifies a set of labels
+func lokiStreamKey(labels map[string]string) string {
+	key := &strings.Builder{}
+	for _, label := range sortedLabelKeys(labels) {
+		key.WriteString(strconv.Quote(label))
+		key.WriteByte('=')
+		key.WriteString(strconv.Quote(labels[label]))
+		key.WriteByte(',')
+	}
+	return key.String()
+}
+
+/*
+SplunkHECBodyEncoder is an HTTPBodyEncoder for the Splunk HTTP Event Collector.
+Each message is sent as an event with its message in the "message" field, its
+level in the "severity" field and its attributes as the remaining fields.  The
+Authorization header the collector expects should be added to the
+HTTPLoggerConfig's Headers as "Splunk <token>".
+*/
+type SplunkHECBodyEncoder struct {
+	meta []byte
+	enc  *JSONEncoder
+}
+
+var _ HTTPBodyEncoder = &SplunkHECBodyEncoder{}
+
+// NewSplunkHECBodyEncoder creates a SplunkHECBodyEncoder that sends events
+// with the given source, sourcetype and index.  Any of them may be empty to
+// use the collector's defaults.
+func NewSplunkHECBodyEncoder(source string, sourceType string, index string) *SplunkHECBodyEncoder {
+	meta := &bytes.Buffer{}
+	for _, field := range [][2]string{{"source", source}, {"sourcetype", sourceType}, {"index", index}} {
+		if len(field[1]) == 0 {
+			continue
+		}
+		meta.WriteByte(',')
+		writeJSONString(meta, field[0])
+		meta.WriteByte(':')
+		writeJSONString(meta, field[1])
+	}
+
+	return &SplunkHECBodyEncoder{
+		meta: meta.Bytes(),
+		enc: NewJSONEncoder(&JSONEncoderConfig{
+			LevelKey:   "severity",
+			MessageKey: "message",
+			TimeFormat: time.RFC3339Nano,
+		}),
+	}
+}
+
+// ContentType returns the JSON content type
+func (e *SplunkHECBodyEncoder) ContentType() string {
+	return "application/json"
+}
+
+// EncodeBody writes an event for each entry to buf
+func (e *SplunkHECBodyEncoder) EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error {
+	var scratch [32]byte
+	for _, entry := range entries {
+		millis := entry.Timestamp.UnixNano() / int64(time.Millisecond)
+		buf.WriteString(`{"time":`)
+		buf.Write(strconv.AppendFloat(scratch[:0], float64(millis)/1000, 'f', 3, 64))
+		buf.Write(e.meta)
+		buf.WriteString(`,"event":`)
+		e.enc.Encode(buf, entry.Timestamp, entry.Level, entry.Attrs, entry.Message)
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteString("}\n")
+	}
+	return nil
+}
+
+/*
+DatadogBodyEncoder is an HTTPBodyEncoder for the Datadog logs intake API.
+Messages are sent as a JSON array with each message's level in the "status"
+field and its attributes as the remaining fields.  The API key should be added
+to the HTTPLoggerConfig's Headers as "DD-API-KEY".
+*/
+type DatadogBodyEncoder struct {
+	service string
+	source  string
+	enc     *JSONEncoder
+}
+
+var _ HTTPBodyEncoder = &DatadogBodyEncoder{}
+
+// NewDatadogBodyEncoder creates a DatadogBodyEncoder that tags messages with
+// the given service and source.  Either may be empty to leave it out.
+func NewDatadogBodyEncoder(service string, source string) *DatadogBodyEncoder {
+	return &DatadogBodyEncoder{
+		service: service,
+		source:  source,
+		enc: NewJSONEncoder(&JSONEncoderConfig{
+			TimeKey:    "timestamp",
+			LevelKey:   "status",
+			MessageKey: "message",
+			TimeFormat: JSONTimeEpochMillis,
+		}),
+	}
+}
+
+// ContentType returns the JSON content type
+func (e *DatadogBodyEncoder) ContentType() string {
+	return "application/json"
+}
+
+// EncodeBody writes the entries to buf as a JSON array
+func (e *DatadogBodyEncoder) EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error {
+	buf.WriteByte('[')
+	for idx, entry := range entries {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+
+		attrs := entry.Attrs
+		if len(e.service) > 0 || len(e.source) > 0 {
+			attrs = make(map[string]interface{}, len(entry.Attrs)+2)
+			if len(e.service) > 0 {
+				attrs["service"] = e.service
+			}
+			if len(e.source) > 0 {
+				attrs["ddsource"] = e.source
+			}
+			for key, val := range entry.Attrs {
+				attrs[key] = val
+			}
+		}
+
+		e.enc.Encode(buf, entry.Timestamp, entry.Level, attrs, entry.Message)
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte(']')
+	return nil
+}
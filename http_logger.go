@@ -0,0 +1,440 @@
+package gomol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// HTTPEntry is a message waiting to be sent by an HTTPLogger.  Its Attrs are
+// the Base's attributes merged with the message's attributes.
+type HTTPEntry struct {
+	Timestamp time.Time
+	Level     LogLevel
+	Attrs     map[string]interface{}
+	Message   string
+}
+
+// HTTPBodyEncoder converts a batch of messages into the body of a request sent
+// by an HTTPLogger
+type HTTPBodyEncoder interface {
+	// ContentType returns the value of the Content-Type header requests are
+	// sent with
+	ContentType() string
+
+	// EncodeBody writes the request body for entries to buf
+	EncodeBody(buf *bytes.Buffer, entries []*HTTPEntry) error
+}
+
+// HTTPLoggerConfig is the configuration for an HTTPLogger
+type HTTPLoggerConfig struct {
+	// URL is where batches of messages are sent
+	URL string
+
+	// Method is the HTTP method requests are sent with
+	Method string
+
+	// BodyEncoder converts each batch of messages into a request body
+	BodyEncoder HTTPBodyEncoder
+
+	// Headers are extra headers added to each request, such as for
+	// authentication
+	Headers map[string]string
+
+	// Username and Password are sent with each request using HTTP basic
+	// authentication if Username isn't empty
+	Username string
+	Password string
+
+	// Gzip compresses request bodies with gzip
+	Gzip bool
+
+	// HTTPClient is the client requests are sent with.  If it is nil a client
+	// with a timeout of Timeout is used.
+	HTTPClient *http.Client
+
+	// Timeout is how long to wait for each request
+	Timeout time.Duration
+
+	// MaxRetries is how many times a request that failed with a network
+	// error or a retryable status is retried before its batch is given up on
+	MaxRetries int
+
+	RetryDelay
+
+	// BufferSize is the most batches kept waiting to be sent.  Once it's
+	// reached the oldest waiting batch is dropped.
+	BufferSize int
+
+	// DeadLetterFile is the path of a file the bodies of batches that
+	// couldn't be sent are appended to.  If it is empty they're dropped.
+	DeadLetterFile string
+}
+
+// NewHTTPLoggerConfig creates a new HTTPLoggerConfig that sends JSON lines to
+// url
+func NewHTTPLoggerConfig(url string) *HTTPLoggerConfig {
+	return &HTTPLoggerConfig{
+		URL:         url,
+		Method:      "POST",
+		BodyEncoder: NewLinesBodyEncoder(NewJSONEncoder(nil)),
+		Headers:     map[string]string{},
+		Gzip:        false,
+		Timeout:     10 * time.Second,
+		MaxRetries:  5,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 500 * time.Millisecond,
+			MaxRetryDelay: 30 * time.Second,
+		},
+		BufferSize:     100,
+		DeadLetterFile: "",
+	}
+}
+
+/*
+HTTPLogger is a Logger that sends batches of messages to an HTTP endpoint, such
+as the push API of a log aggregation service.  The body of each request is
+created by the config's HTTPBodyEncoder so the same logger can be used with any
+backend that accepts logs over HTTP.
+
+HTTPLogger is a BatchLogger so each batch of messages from the queue is sent
+in a single request, and the size of the batches and how long the queue waits
+to fill them are set with the Base's Config.MaxBatchSize and
+Config.MaxBatchWait.  Batches are sent in the background so a slow or
+unreachable server doesn't hold up the queue, and up to BufferSize batches wait
+to be sent.  Requests that fail with a network error or a retryable status are
+retried, waiting longer between each attempt.  Once a batch has been given up
+on it's written to the dead letter file, the error is reported to the Base's
+error channel and the logger reports itself as unhealthy until a request
+succeeds again.
+*/
+type HTTPLogger struct {
+	config *HTTPLoggerConfig
+	clock  glock.Clock
+	client *http.Client
+
+	lock          sync.Mutex
+	base          *Base
+	isInitialized bool
+	healthy       bool
+	sender        *sender
+}
+
+var _ Logger = &HTTPLogger{}
+var _ BatchLogger = &HTTPLogger{}
+var _ HealthCheckLogger = &HTTPLogger{}
+var _ ShutdownLoggerContext = &HTTPLogger{}
+
+// NewHTTPLogger creates a new HTTPLogger
+func NewHTTPLogger(config *HTTPLoggerConfig) (*HTTPLogger, error) {
+	if config == nil || len(config.URL) == 0 {
+		return nil, fmt.Errorf("a URL is required")
+	}
+	cfg := *config
+	if cfg.BodyEncoder == nil {
+		cfg.BodyEncoder = NewLinesBodyEncoder(NewJSONEncoder(nil))
+	}
+	if len(cfg.Method) == 0 {
+		cfg.Method = "POST"
+	}
+	if cfg.BufferSize <= 0 {
+		return nil, fmt.Errorf("the buffer size must be positive")
+	}
+	if err := cfg.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &HTTPLogger{
+		config: &cfg,
+		clock:  glock.NewRealClock(),
+		client: client,
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *HTTPLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger prepares the logger to send messages
+func (l *HTTPLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	l.sender = newSender(l.config.BufferSize)
+	l.healthy = true
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *HTTPLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger waits for the batches waiting to be sent and stops the logger
+func (l *HTTPLogger) ShutdownLogger() error {
+	return l.ShutdownLoggerContext(context.Background())
+}
+
+// ShutdownLoggerContext waits for the batches waiting to be sent and stops the
+// logger.  If ctx is done before they're sent they're no longer retried and
+// are written to the dead letter file.
+func (l *HTTPLogger) ShutdownLoggerContext(ctx context.Context) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+	sender := l.sender
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	sender.shutdown(ctx)
+
+	return nil
+}
+
+// Healthy returns whether the last batch of messages was sent
+func (l *HTTPLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.healthy
+}
+
+// Logm adds a single message to the batches waiting to be sent
+func (l *HTTPLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	baseAttrs := l.baseAttrs()
+	l.lock.Unlock()
+
+	return l.queue([]*HTTPEntry{newHTTPEntry(baseAttrs, timestamp, level, attrs, msg)})
+}
+
+// LogBatch adds a batch of messages to be sent in a single request to the
+// batches waiting to be sent
+func (l *HTTPLogger) LogBatch(msgs []*Message) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	baseAttrs := l.baseAttrs()
+	l.lock.Unlock()
+
+	batch := make([]*HTTPEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		batch = append(batch, newHTTPEntry(baseAttrs, msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg))
+	}
+	return l.queue(batch)
+}
+
+// queue encodes batch and adds it to the batches waiting to be sent
+func (l *HTTPLogger) queue(batch []*HTTPEntry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body := &bytes.Buffer{}
+	err := l.config.BodyEncoder.EncodeBody(body, batch)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Encoding the batch again would fail the same way so the error is
+	// reported instead of returned for the queue to retry
+	if err != nil {
+		l.report(err)
+		return nil
+	}
+
+	// The logger may have been shut down while the batch was being encoded
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	if l.sender.add(func(stop <-chan struct{}) { l.send(body.Bytes(), stop) }) {
+		l.report(ErrSendBufferFull)
+	}
+	return nil
+}
+
+// report reports err to the Base's error channel, if the logger has a Base.
+// It must be called with the lock held.
+func (l *HTTPLogger) report(err error) {
+	if l.base != nil {
+		l.base.report(err)
+	}
+}
+
+// baseAttrs returns the Base's attributes.  It must be called with the lock
+// held.
+func (l *HTTPLogger) baseAttrs() map[string]interface{} {
+	if l.base == nil {
+		return nil
+	}
+	return l.base.BaseAttrs.Attrs()
+}
+
+func newHTTPEntry(baseAttrs map[string]interface{}, timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) *HTTPEntry {
+	mergedAttrs := make(map[string]interface{}, len(baseAttrs)+len(attrs))
+	for key, val := range baseAttrs {
+		mergedAttrs[key] = val
+	}
+	for key, val := range attrs {
+		mergedAttrs[key] = val
+	}
+
+	return &HTTPEntry{
+		Timestamp: timestamp,
+		Level:     level,
+		Attrs:     mergedAttrs,
+		Message:   msg,
+	}
+}
+
+// send sends the encoded body of a batch, retrying until it succeeds or stop
+// is closed.  If the batch can't be sent it's written to the dead letter file
+// and the error is reported.
+func (l *HTTPLogger) send(body []byte, stop <-chan struct{}) {
+	data := body
+	if l.config.Gzip {
+		gzBuf := &bytes.Buffer{}
+		gz := gzip.NewWriter(gzBuf)
+		gz.Write(data)
+		gz.Close()
+		data = gzBuf.Bytes()
+	}
+
+	err := postWithRetry(l.client, l.clock, l.config.MaxRetries, l.config.RetryDelay, func() (*http.Request, error) {
+		req, err := http.NewRequest(l.config.Method, l.config.URL, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", l.config.BodyEncoder.ContentType())
+		if l.config.Gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if len(l.config.Username) > 0 {
+			req.SetBasicAuth(l.config.Username, l.config.Password)
+		}
+		for key, val := range l.config.Headers {
+			req.Header.Set(key, val)
+		}
+		return req, nil
+	}, httpRetryable, stop)
+
+	if err != nil {
+		if dlErr := l.writeDeadLetter(body); dlErr != nil {
+			err = fmt.Errorf("%s (writing to the dead letter file failed: %s)", err, dlErr)
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.healthy = err == nil
+	if err != nil {
+		l.report(err)
+	}
+}
+
+func (l *HTTPLogger) writeDeadLetter(body []byte) error {
+	if len(l.config.DeadLetterFile) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(l.config.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body, '\n')
+	}
+	_, err = file.Write(body)
+	return err
+}
+
+// postWithRetry sends the request created by newRequest until it succeeds,
+// fails with a status retryable says shouldn't be retried, has been retried
+// maxRetries times or stop is closed.  The server can ask for a longer delay
+// before the next attempt with a Retry-After header.
+func postWithRetry(client *http.Client, clock glock.Clock, maxRetries int, delay RetryDelay, newRequest func() (*http.Request, error), retryable func(status int) bool, stop <-chan struct{}) error {
+	return retry(clock, delay.backoff(), maxRetries, stop, func() (time.Duration, error) {
+		return doHTTPRequest(client, newRequest, retryable)
+	})
+}
+
+// httpRetryable returns whether a request that failed with status should be
+// retried, which it should for a timeout, too many requests or a server error
+func httpRetryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// doHTTPRequest sends a single request.  Requests that failed with a network
+// error or a status retryable returns true for are retryable, and if the
+// server asked for the request to be retried later the requested delay is
+// returned with the error.
+func doHTTPRequest(client *http.Client, newRequest func() (*http.Request, error), retryable func(status int) bool) (time.Duration, error) {
+	req, err := newRequest()
+	if err != nil {
+		return 0, &permanentError{err: err}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	err = fmt.Errorf("request to %s failed: %s", req.URL, resp.Status)
+	if !retryable(resp.StatusCode) {
+		return 0, &permanentError{err: err}
+	}
+	return retryAfter(resp), err
+}
+
+// retryAfter returns how long the server asked to wait before sending the
+// request again in the Retry-After header of resp
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,363 @@
+package gomol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type HTTPLoggerSuite struct{}
+
+// httpTestServer records the bodies and headers of the requests it receives
+// and responds with the queued statuses
+type httpTestServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	statuses []int
+}
+
+func newHTTPTestServer(statuses ...int) *httpTestServer {
+	s := &httpTestServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(bytes.NewReader(data))
+			if err == nil {
+				data, _ = ioutil.ReadAll(gz)
+			}
+		}
+
+		s.lock.Lock()
+		s.bodies = append(s.bodies, string(data))
+		s.headers = append(s.headers, r.Header)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status = s.statuses[0]
+			s.statuses = s.statuses[1:]
+		}
+		s.lock.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *httpTestServer) Bodies() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+func (s *httpTestServer) Header(idx int) http.Header {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.headers[idx]
+}
+
+func newTestHTTPLogger(url string) (*HTTPLogger, *glock.MockClock) {
+	cfg := NewHTTPLoggerConfig(url)
+	cfg.BodyEncoder = NewLinesBodyEncoder(NewLogfmtEncoder(nil))
+	cfg.MaxRetries = 2
+	cfg.MinRetryDelay = time.Second
+	cfg.MaxRetryDelay = 10 * time.Second
+
+	l, err := NewHTTPLogger(cfg)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	l.clock = clock
+	return l, clock
+}
+
+func encodeBody(enc HTTPBodyEncoder, entries ...*HTTPEntry) string {
+	buf := &bytes.Buffer{}
+	Expect(enc.EncodeBody(buf, entries)).To(BeNil())
+	return buf.String()
+}
+
+func (s *HTTPLoggerSuite) TestNewHTTPLoggerNoURL(t sweet.T) {
+	l, err := NewHTTPLogger(nil)
+	Expect(l).To(BeNil())
+	Expect(err).ToNot(BeNil())
+
+	l, err = NewHTTPLogger(NewHTTPLoggerConfig(""))
+	Expect(l).To(BeNil())
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *HTTPLoggerSuite) TestLogmNotInitialized(t sweet.T) {
+	l, _ := newTestHTTPLogger("http://127.0.0.1:1/")
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+	Expect(l.LogBatch([]*Message{
+		newMessage(testTime, nil, LevelInfo, NewAttrs(), "message"),
+	})).To(Equal(ErrNotInitialized))
+}
+
+func (s *HTTPLoggerSuite) TestLogBatch(t sweet.T) {
+	server := newHTTPTestServer()
+	defer server.Close()
+
+	l, _ := newTestHTTPLogger(server.URL)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.LogBatch([]*Message{
+		newMessage(testTime, nil, LevelInfo, NewAttrs(), "first"),
+		newMessage(testTime, nil, LevelInfo, NewAttrs().SetAttr("attr", 1), "second"),
+		newMessage(testTime, nil, LevelError, NewAttrs(), "third"),
+	})).To(BeNil())
+	Eventually(server.Bodies).Should(Equal([]string{
+		"time=2017-03-04T05:06:07.89Z level=info msg=first\n" +
+			"time=2017-03-04T05:06:07.89Z level=info msg=second attr=1\n" +
+			"time=2017-03-04T05:06:07.89Z level=error msg=third\n",
+	}))
+	Expect(server.Header(0).Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+}
+
+func (s *HTTPLoggerSuite) TestQueueBatches(t sweet.T) {
+	server := newHTTPTestServer()
+	defer server.Close()
+
+	l, _ := newTestHTTPLogger(server.URL)
+
+	b := NewBase()
+	cfg := NewConfig()
+	cfg.MaxBatchSize = 2
+	b.SetConfig(cfg)
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+
+	b.LogWithTime(LevelWarning, testTime, NewAttrs().SetAttr("msg_attr", "msg"), "first")
+	b.LogWithTime(LevelInfo, testTime, nil, "second")
+	b.LogWithTime(LevelInfo, testTime, nil, "third")
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Expect(server.Bodies()).To(Equal([]string{
+		"time=2017-03-04T05:06:07.89Z level=warn msg=first base_attr=base msg_attr=msg\n" +
+			"time=2017-03-04T05:06:07.89Z level=info msg=second base_attr=base\n",
+		"time=2017-03-04T05:06:07.89Z level=info msg=third base_attr=base\n",
+	}))
+	Expect(l.IsInitialized()).To(BeFalse())
+}
+
+func (s *HTTPLoggerSuite) TestContentType(t sweet.T) {
+	server := newHTTPTestServer()
+	defer server.Close()
+
+	l, _ := newTestHTTPLogger(server.URL)
+	l.config.BodyEncoder = NewLinesBodyEncoder(NewJSONEncoder(nil))
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(server.Bodies).Should(HaveLen(1))
+	Expect(server.Header(0).Get("Content-Type")).To(Equal("application/x-ndjson"))
+}
+
+func (s *HTTPLoggerSuite) TestGzipAndAuth(t sweet.T) {
+	server := newHTTPTestServer()
+	defer server.Close()
+
+	l, _ := newTestHTTPLogger(server.URL)
+	l.config.Gzip = true
+	l.config.Username = "user"
+	l.config.Password = "pass"
+	l.config.Headers = map[string]string{"X-Api-Key": "key"}
+	Expect(l.InitLogger()).To(BeNil())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Expect(l.ShutdownLogger()).To(BeNil())
+
+	Expect(server.Bodies()).To(Equal([]string{"time=2017-03-04T05:06:07.89Z level=info msg=message\n"}))
+	header := server.Header(0)
+	Expect(header.Get("Content-Encoding")).To(Equal("gzip"))
+	Expect(header.Get("X-Api-Key")).To(Equal("key"))
+	Expect(header.Get("Authorization")).To(Equal("Basic dXNlcjpwYXNz"))
+}
+
+func (s *HTTPLoggerSuite) TestRetry(t sweet.T) {
+	server := newHTTPTestServer(http.StatusServiceUnavailable, http.StatusInternalServerError)
+	defer server.Close()
+
+	l, clock := newTestHTTPLogger(server.URL)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// The batch is retried in the background so Logm doesn't wait for it
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		Eventually(clock.BlockedOnAfter).Should(Equal(1))
+		Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{delay}))
+		clock.Advance(delay)
+	}
+
+	Eventually(server.Bodies).Should(HaveLen(3))
+	Expect(l.Healthy()).To(BeTrue())
+}
+
+func (s *HTTPLoggerSuite) TestBufferFull(t sweet.T) {
+	server := newHTTPTestServer(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	l, clock := newTestHTTPLogger(server.URL)
+	l.config.BufferSize = 1
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "first")).To(BeNil())
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+
+	// While the first batch is being retried the second waits to be sent
+	// and is dropped to make room for the third
+	Expect(l.Logm(testTime, LevelInfo, nil, "second")).To(BeNil())
+	Expect(l.Logm(testTime, LevelInfo, nil, "third")).To(BeNil())
+	Eventually(errs).Should(Receive(Equal(ErrSendBufferFull)))
+
+	clock.Advance(time.Second)
+	Eventually(server.Bodies).Should(Equal([]string{
+		"time=2017-03-04T05:06:07.89Z level=info msg=first\n",
+		"time=2017-03-04T05:06:07.89Z level=info msg=first\n",
+		"time=2017-03-04T05:06:07.89Z level=info msg=third\n",
+	}))
+}
+
+func (s *HTTPLoggerSuite) TestDeadLetter(t sweet.T) {
+	server := newHTTPTestServer(http.StatusBadRequest)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gomol-http")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	l, _ := newTestHTTPLogger(server.URL)
+	l.config.DeadLetterFile = filepath.Join(dir, "dead.log")
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(errs).Should(Receive(HaveOccurred()))
+	Expect(l.Healthy()).To(BeFalse())
+
+	data, err := ioutil.ReadFile(l.config.DeadLetterFile)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=message\n"))
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(l.Healthy).Should(BeTrue())
+}
+
+func (s *HTTPLoggerSuite) TestShutdownContextDone(t sweet.T) {
+	server := newHTTPTestServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gomol-http")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	l, clock := newTestHTTPLogger(server.URL)
+	l.config.DeadLetterFile = filepath.Join(dir, "dead.log")
+	Expect(l.InitLogger()).To(BeNil())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "first")).To(BeNil())
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	Expect(l.Logm(testTime, LevelInfo, nil, "second")).To(BeNil())
+
+	// The batch being retried and the one waiting to be sent are given up
+	// on without sending them again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Expect(l.ShutdownLoggerContext(ctx)).To(BeNil())
+	Expect(server.Bodies()).To(HaveLen(1))
+
+	data, err := ioutil.ReadFile(l.config.DeadLetterFile)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(
+		"time=2017-03-04T05:06:07.89Z level=info msg=first\n" +
+			"time=2017-03-04T05:06:07.89Z level=info msg=second\n",
+	))
+}
+
+func (s *HTTPLoggerSuite) TestElasticsearchBodyEncoder(t sweet.T) {
+	body := encodeBody(NewElasticsearchBodyEncoder("logs"),
+		&HTTPEntry{Timestamp: testTime, Level: LevelInfo, Attrs: map[string]interface{}{"attr": 1}, Message: "first"},
+		&HTTPEntry{Timestamp: testTime, Level: LevelError, Message: "second"},
+	)
+	Expect(body).To(Equal(
+		`{"create":{"_index":"logs"}}` + "\n" +
+			`{"@timestamp":"2017-03-04T05:06:07.89Z","level":"info","message":"first","attr":1}` + "\n" +
+			`{"create":{"_index":"logs"}}` + "\n" +
+			`{"@timestamp":"2017-03-04T05:06:07.89Z","level":"error","message":"second"}` + "\n",
+	))
+}
+
+func (s *HTTPLoggerSuite) TestLokiBodyEncoder(t sweet.T) {
+	body := encodeBody(NewLokiBodyEncoder([]string{"app"}),
+		&HTTPEntry{Timestamp: testTime, Level: LevelInfo, Attrs: map[string]interface{}{"app": "web", "attr": 1}, Message: "first"},
+		&HTTPEntry{Timestamp: testTime, Level: LevelError, Attrs: map[string]interface{}{"app": "web"}, Message: "second"},
+		&HTTPEntry{Timestamp: testTime.Add(time.Second), Level: LevelInfo, Attrs: map[string]interface{}{"app": "web"}, Message: "third"},
+	)
+
+	push := map[string]interface{}{}
+	Expect(json.Unmarshal([]byte(body), &push)).To(BeNil())
+	Expect(push).To(Equal(map[string]interface{}{
+		"streams": []interface{}{
+			map[string]interface{}{
+				"stream": map[string]interface{}{"app": "web", "level": "info"},
+				"values": []interface{}{
+					[]interface{}{"1488603967890000000", "msg=first attr=1"},
+					[]interface{}{"1488603968890000000", "msg=third"},
+				},
+			},
+			map[string]interface{}{
+				"stream": map[string]interface{}{"app": "web", "level": "error"},
+				"values": []interface{}{
+					[]interface{}{"1488603967890000000", "msg=second"},
+				},
+			},
+		},
+	}))
+}
+
+func (s *HTTPLoggerSuite) TestSplunkHECBodyEncoder(t sweet.T) {
+	body := encodeBody(NewSplunkHECBodyEncoder("app", "", "main"),
+		&HTTPEntry{Timestamp: testTime, Level: LevelWarning, Attrs: map[string]interface{}{"attr": "val"}, Message: "message"},
+	)
+	Expect(body).To(Equal(
+		`{"time":1488603967.890,"source":"app","index":"main","event":{"severity":"warn","message":"message","attr":"val"}}` + "\n",
+	))
+}
+
+func (s *HTTPLoggerSuite) TestDatadogBodyEncoder(t sweet.T) {
+	body := encodeBody(NewDatadogBodyEncoder("web", "go"),
+		&HTTPEntry{Timestamp: testTime, Level: LevelInfo, Attrs: map[string]interface{}{"service": "api"}, Message: "first"},
+		&HTTPEntry{Timestamp: testTime, Level: LevelDebug, Message: "second"},
+	)
+	Expect(body).To(Equal(
+		`[{"timestamp":1488603967890,"status":"info","message":"first","ddsource":"go","service":"api"},` +
+			`{"timestamp":1488603967890,"status":"debug","message":"second","ddsource":"go","service":"web"}]`,
+	))
+}
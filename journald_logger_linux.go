@@ -0,0 +1,291 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// JournaldLoggerConfig is the configuration for a JournaldLogger
+type JournaldLoggerConfig struct {
+	// SocketPath is the path of the journal's native protocol socket
+	SocketPath string
+
+	// SyslogIdentifier is sent as the SYSLOG_IDENTIFIER field of each
+	// message.  If it's empty the field isn't sent.
+	SyslogIdentifier string
+}
+
+// NewJournaldLoggerConfig creates a new JournaldLoggerConfig with default settings
+func NewJournaldLoggerConfig() *JournaldLoggerConfig {
+	return &JournaldLoggerConfig{
+		SocketPath:       "/run/systemd/journal/socket",
+		SyslogIdentifier: filepath.Base(os.Args[0]),
+	}
+}
+
+/*
+JournaldLogger is a Logger that sends messages to the systemd journal using its
+native protocol.  Each of a message's attributes is sent as a journal field with
+the attribute's name upper cased and any characters the journal doesn't allow
+replaced with underscores.  The message's level is sent as PRIORITY and, if the
+Base's Config has FilenameAttr or LineNumberAttr set, those attributes are sent
+as CODE_FILE and CODE_LINE.
+
+Messages that are too large to send in a single datagram are written to a
+sealed memfd which is passed to the journal instead.
+*/
+type JournaldLogger struct {
+	config *JournaldLoggerConfig
+
+	lock          sync.Mutex
+	base          *Base
+	conn          *net.UnixConn
+	isInitialized bool
+}
+
+var _ Logger = &JournaldLogger{}
+
+// NewJournaldLogger creates a new JournaldLogger.  If config is nil the values
+// from NewJournaldLoggerConfig are used.
+func NewJournaldLogger(config *JournaldLoggerConfig) (*JournaldLogger, error) {
+	if config == nil {
+		config = NewJournaldLoggerConfig()
+	}
+
+	return &JournaldLogger{
+		config: config,
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *JournaldLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger opens the connection to the journal
+func (l *JournaldLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: l.config.SocketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	l.conn = conn
+	l.isInitialized = true
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *JournaldLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger closes the connection to the journal
+func (l *JournaldLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.isInitialized {
+		return nil
+	}
+
+	err := l.conn.Close()
+	l.conn = nil
+	l.isInitialized = false
+	return err
+}
+
+// Logm sends a message to the journal
+func (l *JournaldLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	var fileAttr, lineAttr string
+	mergedAttrs := make(map[string]interface{}, len(attrs))
+	if l.base != nil {
+		cfg := l.base.config()
+		fileAttr = cfg.FilenameAttr
+		lineAttr = cfg.LineNumberAttr
+		mergedAttrs = l.base.BaseAttrs.Attrs()
+	}
+	for key, val := range attrs {
+		mergedAttrs[key] = val
+	}
+
+	data := l.encode(level, mergedAttrs, fileAttr, lineAttr, msg)
+
+	_, err := l.conn.Write(data)
+	if isMessageTooLarge(err) {
+		err = l.writeMemfd(data)
+	}
+	return err
+}
+
+func (l *JournaldLogger) encode(level LogLevel, attrs map[string]interface{}, fileAttr string, lineAttr string, msg string) []byte {
+	buf := &bytes.Buffer{}
+
+	priority := int(level)
+	if priority < 0 || priority > 7 {
+		priority = int(LevelDebug)
+	}
+
+	writeJournalField(buf, "MESSAGE", msg)
+	writeJournalField(buf, "PRIORITY", fmt.Sprint(priority))
+	if len(l.config.SyslogIdentifier) > 0 {
+		writeJournalField(buf, "SYSLOG_IDENTIFIER", l.config.SyslogIdentifier)
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var name string
+		switch {
+		case len(fileAttr) > 0 && key == fileAttr:
+			name = "CODE_FILE"
+		case len(lineAttr) > 0 && key == lineAttr:
+			name = "CODE_LINE"
+		default:
+			name = journalFieldName(key)
+		}
+		if len(name) == 0 {
+			continue
+		}
+
+		writeJournalField(buf, name, fmt.Sprint(attrs[key]))
+	}
+
+	return buf.Bytes()
+}
+
+// writeMemfd passes data to the journal in a sealed memfd for messages that
+// are too large to fit in a datagram
+func (l *JournaldLogger) writeMemfd(data []byte) error {
+	fd, err := unix.MemfdCreate("gomol-journal", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "gomol-journal")
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// The journal won't accept a memfd that could still be changed
+	_, err = unix.FcntlInt(file.Fd(), unix.F_ADD_SEALS,
+		unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL)
+	if err != nil {
+		return err
+	}
+
+	rawConn, err := l.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(file.Fd()))
+	var sendErr error
+	err = rawConn.Write(func(connFd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(connFd), nil, rights, nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func isMessageTooLarge(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sysErr.Err == syscall.EMSGSIZE || sysErr.Err == syscall.ENOBUFS
+}
+
+// writeJournalField writes a field in the journal's native format.  Values
+// with newlines in them are written with their length instead of being
+// terminated by a newline.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalReservedFields are the fields the logger sets itself.  Attributes
+// with these names are prefixed so they aren't mixed up with them.
+var journalReservedFields = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+}
+
+// journalFieldName converts an attribute name to a valid journal field name.
+// Field names can only contain upper case letters, numbers and underscores,
+// can't start with an underscore or a number and can be at most 64 characters.
+// Names starting with a number or matching a field the logger sets itself are
+// prefixed with "F_".
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for idx, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[idx] = '_'
+		}
+	}
+
+	// Fields starting with an underscore are trusted fields that only the
+	// journal can set
+	name = bytes.TrimLeft(name, "_")
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' || journalReservedFields[string(name)] {
+		name = append([]byte("F_"), name...)
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return string(name)
+}
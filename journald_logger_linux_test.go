@@ -0,0 +1,182 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	platformSuites = append(platformSuites, &JournaldLoggerSuite{})
+}
+
+type JournaldLoggerSuite struct {
+	dir      string
+	listener *net.UnixConn
+}
+
+func (s *JournaldLoggerSuite) SetUpTest(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol")
+	Expect(err).To(BeNil())
+	s.dir = dir
+
+	s.listener, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: s.socketPath(), Net: "unixgram"})
+	Expect(err).To(BeNil())
+}
+
+func (s *JournaldLoggerSuite) TearDownTest(t sweet.T) {
+	s.listener.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *JournaldLoggerSuite) socketPath() string {
+	return filepath.Join(s.dir, "journal.sock")
+}
+
+func (s *JournaldLoggerSuite) newLogger() *JournaldLogger {
+	cfg := NewJournaldLoggerConfig()
+	cfg.SocketPath = s.socketPath()
+	cfg.SyslogIdentifier = "testapp"
+
+	l, err := NewJournaldLogger(cfg)
+	Expect(err).To(BeNil())
+	return l
+}
+
+// receive reads the next entry sent to the journal socket, following any
+// memfd passed with it
+func (s *JournaldLoggerSuite) receive() ([]byte, bool) {
+	buf := make([]byte, 1<<20)
+	oob := make([]byte, 1024)
+
+	s.listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := s.listener.ReadMsgUnix(buf, oob)
+	Expect(err).To(BeNil())
+	if oobn == 0 {
+		return buf[:n], false
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	Expect(err).To(BeNil())
+	Expect(msgs).To(HaveLen(1))
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	Expect(err).To(BeNil())
+	Expect(fds).To(HaveLen(1))
+
+	file := os.NewFile(uintptr(fds[0]), "memfd")
+	defer file.Close()
+
+	info, err := file.Stat()
+	Expect(err).To(BeNil())
+	data := make([]byte, info.Size())
+	_, err = file.ReadAt(data, 0)
+	Expect(err).To(BeNil())
+	return data, true
+}
+
+// parseJournalFields parses an entry in the journal's native format
+func parseJournalFields(data []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		lineEnd := bytes.IndexByte(data, '\n')
+		Expect(lineEnd).ToNot(Equal(-1))
+		line := string(data[:lineEnd])
+		data = data[lineEnd+1:]
+
+		if idx := strings.IndexByte(line, '='); idx >= 0 {
+			fields[line[:idx]] = line[idx+1:]
+			continue
+		}
+
+		valueLen := binary.LittleEndian.Uint64(data[:8])
+		fields[line] = string(data[8 : 8+valueLen])
+		Expect(data[8+valueLen]).To(Equal(byte('\n')))
+		data = data[8+valueLen+1:]
+	}
+	return fields
+}
+
+func (s *JournaldLoggerSuite) TestFieldName(t sweet.T) {
+	Expect(journalFieldName("attr")).To(Equal("ATTR"))
+	Expect(journalFieldName("my-attr.name")).To(Equal("MY_ATTR_NAME"))
+	Expect(journalFieldName("__trusted")).To(Equal("TRUSTED"))
+	Expect(journalFieldName("1st")).To(Equal("F_1ST"))
+	Expect(journalFieldName("message")).To(Equal("F_MESSAGE"))
+	Expect(journalFieldName("Priority")).To(Equal("F_PRIORITY"))
+	Expect(journalFieldName("syslog-identifier")).To(Equal("F_SYSLOG_IDENTIFIER"))
+	Expect(journalFieldName("___")).To(Equal(""))
+	Expect(journalFieldName(strings.Repeat("a", 70))).To(Equal(strings.Repeat("A", 64)))
+}
+
+func (s *JournaldLoggerSuite) TestInitMissingSocket(t sweet.T) {
+	cfg := NewJournaldLoggerConfig()
+	cfg.SocketPath = filepath.Join(s.dir, "missing.sock")
+
+	l, err := NewJournaldLogger(cfg)
+	Expect(err).To(BeNil())
+	Expect(l.InitLogger()).ToNot(BeNil())
+	Expect(l.IsInitialized()).To(BeFalse())
+}
+
+func (s *JournaldLoggerSuite) TestLogNotInitialized(t sweet.T) {
+	l := s.newLogger()
+	Expect(l.Logm(time.Now(), LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+}
+
+func (s *JournaldLoggerSuite) TestLogFields(t sweet.T) {
+	l := s.newLogger()
+
+	b := NewBase()
+	cfg := NewConfig()
+	cfg.FilenameAttr = "file"
+	cfg.LineNumberAttr = "line"
+	b.SetConfig(cfg)
+	b.SetAttr("base-attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+	defer b.ShutdownLoggers()
+
+	setFakeCallerInfo("fakefile.go", 1234)
+	defer setFakeCallerInfo("", 0)
+
+	b.Warnm(NewAttrs().SetAttr("multi", "line 1\nline 2").SetAttr("message", "attr"), "the message")
+	b.Flush()
+
+	data, memfd := s.receive()
+	Expect(memfd).To(BeFalse())
+	Expect(parseJournalFields(data)).To(Equal(map[string]string{
+		"MESSAGE":           "the message",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "testapp",
+		"CODE_FILE":         "fakefile.go",
+		"CODE_LINE":         "1234",
+		"BASE_ATTR":         "base",
+		"MULTI":             "line 1\nline 2",
+		"F_MESSAGE":         "attr",
+	}))
+}
+
+func (s *JournaldLoggerSuite) TestLogLargeMemfd(t sweet.T) {
+	l := s.newLogger()
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	msg := strings.Repeat("a", 512*1024)
+	Expect(l.Logm(time.Now(), LevelError, nil, msg)).To(BeNil())
+
+	data, memfd := s.receive()
+	Expect(memfd).To(BeTrue())
+
+	fields := parseJournalFields(data)
+	Expect(fields["MESSAGE"]).To(Equal(msg))
+	Expect(fields["PRIORITY"]).To(Equal("3"))
+}
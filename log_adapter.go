@@ -10,6 +10,8 @@ type LogAdapter struct {
 	base     WrappableLogger
 	logLevel *LogLevel
 	attrs    *Attrs
+	sampler  *Sampler
+	recorder *flightRecorder
 }
 
 /*
@@ -45,6 +47,21 @@ func NewLogAdapterFor(base WrappableLogger, attrs *Attrs) *LogAdapter {
 	}
 }
 
+// wrappedBase returns the Base logger logs to, unwrapping any LogAdapters
+// around it, or nil if it doesn't log to a Base
+func wrappedBase(logger WrappableLogger) *Base {
+	for {
+		la, ok := logger.(*LogAdapter)
+		if !ok {
+			break
+		}
+		logger = la.base
+	}
+
+	b, _ := logger.(*Base)
+	return b
+}
+
 // SetLogLevel sets the level the current LogAdapter will log at.  The level is still filtered
 // at the parent level, though, so if a LogAdapter is set to log at Debug while the parent is set
 // to log at Info, the Debug message will not be logged because it will be filtered by the parent
@@ -53,6 +70,104 @@ func (la *LogAdapter) SetLogLevel(level LogLevel) {
 	la.logLevel = &level
 }
 
+// SetSampler sets the Sampler used to limit how many of the same message are
+// logged through this LogAdapter.  Messages are still sampled by the parent
+// logger's Sampler if it has one.  Passing nil stops sampling.
+func (la *LogAdapter) SetSampler(sampler *Sampler) {
+	la.sampler = sampler
+}
+
+/*
+SetFlightRecorder turns on flight recorder mode for this LogAdapter.  Messages
+logged through it that won't be logged because of its level or its parent's
+level are kept, up to config.Size of them, instead of being dropped.  When a
+message at config.TriggerLevel or more severe is logged through it the kept
+messages are logged first, so the context leading up to an error is logged
+along with it.  Creating a LogAdapter for each request with flight recorder
+mode on keeps each request's messages separate.  Passing nil turns flight
+recorder mode off and drops any kept messages.
+*/
+func (la *LogAdapter) SetFlightRecorder(config *FlightRecorderConfig) {
+	if config == nil {
+		la.recorder = nil
+		return
+	}
+	la.recorder = newFlightRecorder(config)
+}
+
+// FlushFlightRecorder logs any messages kept by the flight recorder right away
+func (la *LogAdapter) FlushFlightRecorder() error {
+	if la.recorder == nil {
+		return nil
+	}
+
+	var lastErr error
+	for _, record := range la.recorder.take() {
+		var err error
+		if parent, ok := la.base.(unleveledLogger); ok {
+			err = parent.logBuffered(record.level, record.ts, record.attrs, record.msg)
+		} else {
+			err = la.base.LogWithTime(record.level, record.ts, record.attrs, record.msg)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// record keeps a message in the flight recorder if it won't be logged, or
+// logs the kept messages if level triggers it.  It returns true if the message
+// was kept.
+func (la *LogAdapter) record(level LogLevel, ts time.Time, attrs *Attrs, msg string, a []interface{}) bool {
+	if !la.shouldLog(level) {
+		mergedAttrs := la.attrs.clone()
+		mergedAttrs.MergeAttrs(attrs)
+		la.recorder.add(level, ts, mergedAttrs, msg, a)
+		return true
+	}
+
+	if level <= la.recorder.config.TriggerLevel {
+		la.FlushFlightRecorder()
+	}
+	return false
+}
+
+func (la *LogAdapter) shouldLog(level LogLevel) bool {
+	if la.logLevel != nil && level > *la.logLevel {
+		return false
+	}
+	if parent, ok := la.base.(unleveledLogger); ok {
+		return parent.shouldLog(level)
+	}
+	return true
+}
+
+func (la *LogAdapter) now() time.Time {
+	if parent, ok := la.base.(unleveledLogger); ok {
+		return parent.now()
+	}
+	return time.Now()
+}
+
+func (la *LogAdapter) logBuffered(level LogLevel, ts time.Time, attrs *Attrs, msg string) error {
+	mergedAttrs := la.attrs.clone()
+	mergedAttrs.MergeAttrs(attrs)
+	if parent, ok := la.base.(unleveledLogger); ok {
+		return parent.logBuffered(level, ts, mergedAttrs, msg)
+	}
+	return la.base.LogWithTime(level, ts, mergedAttrs, msg)
+}
+
+func (la *LogAdapter) logForwarded(level LogLevel, ts time.Time, attrs *Attrs, msg string) error {
+	mergedAttrs := la.attrs.clone()
+	mergedAttrs.MergeAttrs(attrs)
+	if parent, ok := la.base.(unleveledLogger); ok {
+		return parent.logForwarded(level, ts, mergedAttrs, msg)
+	}
+	return la.base.LogWithTime(level, ts, mergedAttrs, msg)
+}
+
 // SetAttr sets the attribute key to value for this LogAdapter only
 func (la *LogAdapter) SetAttr(key string, value interface{}) {
 	la.attrs.SetAttr(key, value)
@@ -78,10 +193,22 @@ func (la *LogAdapter) ClearAttrs() {
 // to the Base associated with this LogAdapter. It is similar to Log except
 // the timestamp will be set to the value of ts.
 func (la *LogAdapter) LogWithTime(level LogLevel, ts time.Time, attrs *Attrs, msg string, a ...interface{}) error {
-	if la.logLevel != nil && level > *la.logLevel {
+	if la.recorder != nil {
+		if la.record(level, ts, attrs, msg, a) {
+			return nil
+		}
+	} else if la.logLevel != nil && level > *la.logLevel {
 		return nil
 	}
 
+	if la.sampler != nil {
+		var ok bool
+		attrs, ok = sampleAttrs(la.sampler, level, attrs, msg)
+		if !ok {
+			return nil
+		}
+	}
+
 	mergedAttrs := la.attrs.clone()
 	mergedAttrs.MergeAttrs(attrs)
 	return la.base.LogWithTime(level, ts, mergedAttrs, msg, a...)
@@ -90,10 +217,22 @@ func (la *LogAdapter) LogWithTime(level LogLevel, ts time.Time, attrs *Attrs, ms
 // Log will log a message at the provided level to all loggers added
 // to the Base associated with this LogAdapter
 func (la *LogAdapter) Log(level LogLevel, attrs *Attrs, msg string, a ...interface{}) error {
-	if la.logLevel != nil && level > *la.logLevel {
+	if la.recorder != nil {
+		if la.record(level, la.now(), attrs, msg, a) {
+			return nil
+		}
+	} else if la.logLevel != nil && level > *la.logLevel {
 		return nil
 	}
 
+	if la.sampler != nil {
+		var ok bool
+		attrs, ok = sampleAttrs(la.sampler, level, attrs, msg)
+		if !ok {
+			return nil
+		}
+	}
+
 	mergedAttrs := la.attrs.clone()
 	mergedAttrs.MergeAttrs(attrs)
 	return la.base.Log(level, mergedAttrs, msg, a...)
@@ -269,24 +408,24 @@ func (la *LogAdapter) Fatalm(m *Attrs, msg string, a ...interface{}) error {
 }
 
 // Die will log a message using Fatal, call ShutdownLoggers and then exit the application with the provided exit code.
+// If the LogAdapter wraps a Base, the Base's OnExit hooks are run and its Exiter is used.
 func (la *LogAdapter) Die(exitCode int, msg string) {
 	la.Log(LevelFatal, nil, msg)
-	la.base.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	exitFrom(la.base, exitCode)
 }
 
 // Dief will log a message using Fatalf, call ShutdownLoggers and then exit the application with the provided exit code.
+// If the LogAdapter wraps a Base, the Base's OnExit hooks are run and its Exiter is used.
 func (la *LogAdapter) Dief(exitCode int, msg string, a ...interface{}) {
 	la.Log(LevelFatal, nil, msg, a...)
-	la.base.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	exitFrom(la.base, exitCode)
 }
 
 // Diem will log a message using Fatalm, call ShutdownLoggers and then exit the application with the provided exit code.
+// If the LogAdapter wraps a Base, the Base's OnExit hooks are run and its Exiter is used.
 func (la *LogAdapter) Diem(exitCode int, m *Attrs, msg string, a ...interface{}) {
 	la.Log(LevelFatal, m, msg, a...)
-	la.base.ShutdownLoggers()
-	curExiter.Exit(exitCode)
+	exitFrom(la.base, exitCode)
 }
 
 // ShutdownLoggers will call the wrapped logger's ShutdownLoggers method.
@@ -609,6 +609,8 @@ func (s *LogAdapterSuite) TestLogAdapterFatal(t sweet.T) {
 func (s *LogAdapterSuite) TestLogAdapterDie(t sweet.T) {
 	clock := glock.NewMockClock()
 	b := NewBase(withClock(clock))
+	curTestExiter = &testExiter{}
+	b.SetExiter(curTestExiter)
 	ml := newDefaultMemLogger()
 	b.AddLogger(ml)
 	b.InitLoggers()
@@ -639,6 +641,8 @@ func (s *LogAdapterSuite) TestLogAdapterDie(t sweet.T) {
 func (s *LogAdapterSuite) TestLogAdapterDief(t sweet.T) {
 	clock := glock.NewMockClock()
 	b := NewBase(withClock(clock))
+	curTestExiter = &testExiter{}
+	b.SetExiter(curTestExiter)
 	ml := newDefaultMemLogger()
 	b.AddLogger(ml)
 	b.InitLoggers()
@@ -669,6 +673,8 @@ func (s *LogAdapterSuite) TestLogAdapterDief(t sweet.T) {
 func (s *LogAdapterSuite) TestLogAdapterDiem(t sweet.T) {
 	clock := glock.NewMockClock()
 	b := NewBase(withClock(clock))
+	curTestExiter = &testExiter{}
+	b.SetExiter(curTestExiter)
 	ml := newDefaultMemLogger()
 	b.AddLogger(ml)
 	b.InitLoggers()
@@ -1,6 +1,9 @@
 package gomol
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Logger is an interface libraries can implement to create their own loggers to be
 // used with gomol.
@@ -23,6 +26,19 @@ type HealthCheckLogger interface {
 	Healthy() bool
 }
 
+// BatchLogger is an interface a Logger can implement to receive messages in
+// batches rather than one at a time, which can be much faster for loggers that
+// write to files or the network.  If a Logger implements BatchLogger, LogBatch
+// will be called instead of Logm with up to Config.MaxBatchSize messages at a
+// time.  A message's Attrs only contain the attributes added to that message,
+// the Base's attributes are available from the Base passed to SetBase.  An
+// error returned from LogBatch is sent to the Base's error channel.
+type BatchLogger interface {
+	Logger
+
+	LogBatch(msgs []*Message) error
+}
+
 // HookPreQueue is an interface a Logger can implement to be able to inspect
 // and modify a Message before it is added to the queue
 type HookPreQueue interface {
@@ -30,3 +46,12 @@ type HookPreQueue interface {
 
 	PreQueue(msg *Message) error
 }
+
+// ShutdownLoggerContext is an interface a Logger can implement to stop shutting
+// down once a context is done.  If a Logger implements ShutdownLoggerContext,
+// Base.ShutdownLoggersContext will call it instead of ShutdownLogger.
+type ShutdownLoggerContext interface {
+	Logger
+
+	ShutdownLoggerContext(ctx context.Context) error
+}
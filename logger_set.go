@@ -0,0 +1,79 @@
+package gomol
+
+/*
+loggerSet is an immutable snapshot of the loggers added to a Base.  Adding or
+removing a logger creates a new loggerSet that replaces the old one, so the
+queue worker can keep using the set it loaded for a message while loggers are
+being added or removed in other goroutines.
+*/
+type loggerSet struct {
+	loggers        []Logger
+	hookPreQueue   []HookPreQueue
+	batchLoggers   []BatchLogger
+	fallbackLogger Logger
+}
+
+func newLoggerSet(loggers []Logger, fallbackLogger Logger) *loggerSet {
+	set := &loggerSet{
+		loggers:        make([]Logger, 0, len(loggers)),
+		hookPreQueue:   make([]HookPreQueue, 0),
+		batchLoggers:   make([]BatchLogger, 0),
+		fallbackLogger: fallbackLogger,
+	}
+
+	for _, logger := range loggers {
+		set.loggers = append(set.loggers, logger)
+		if hook, ok := logger.(HookPreQueue); ok {
+			set.hookPreQueue = append(set.hookPreQueue, hook)
+		}
+		if batchLogger, ok := logger.(BatchLogger); ok {
+			set.batchLoggers = append(set.batchLoggers, batchLogger)
+		}
+	}
+
+	return set
+}
+
+// withLogger returns a copy of the set with logger added to it
+func (s *loggerSet) withLogger(logger Logger) *loggerSet {
+	loggers := make([]Logger, 0, len(s.loggers)+1)
+	loggers = append(loggers, s.loggers...)
+	loggers = append(loggers, logger)
+	return newLoggerSet(loggers, s.fallbackLogger)
+}
+
+// withoutLogger returns a copy of the set with logger removed from it
+func (s *loggerSet) withoutLogger(logger Logger) *loggerSet {
+	loggers := make([]Logger, 0, len(s.loggers))
+	for _, setLogger := range s.loggers {
+		if setLogger != logger {
+			loggers = append(loggers, setLogger)
+		}
+	}
+	return newLoggerSet(loggers, s.fallbackLogger)
+}
+
+// withLoggers returns a copy of the set with its loggers replaced by loggers
+func (s *loggerSet) withLoggers(loggers []Logger) *loggerSet {
+	return newLoggerSet(loggers, s.fallbackLogger)
+}
+
+// withFallbackLogger returns a copy of the set with its fallback logger replaced
+// by logger
+func (s *loggerSet) withFallbackLogger(logger Logger) *loggerSet {
+	return &loggerSet{
+		loggers:        s.loggers,
+		hookPreQueue:   s.hookPreQueue,
+		batchLoggers:   s.batchLoggers,
+		fallbackLogger: logger,
+	}
+}
+
+func (s *loggerSet) contains(logger Logger) bool {
+	for _, setLogger := range s.loggers {
+		if setLogger == logger {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package gomol
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GomolSuite) TestLoggerSetWithLogger(t sweet.T) {
+	ml1 := newDefaultMemLogger()
+	ml2 := newDefaultMemLogger()
+
+	set := newLoggerSet([]Logger{ml1}, nil)
+	newSet := set.withLogger(ml2)
+
+	Expect(set.loggers).To(Equal([]Logger{ml1}))
+	Expect(newSet.loggers).To(Equal([]Logger{ml1, ml2}))
+}
+
+func (s *GomolSuite) TestLoggerSetWithoutLogger(t sweet.T) {
+	ml1 := newDefaultMemLogger()
+	ml2 := newDefaultMemLogger()
+	hl := &hookLogger{memLogger: newDefaultMemLogger()}
+
+	set := newLoggerSet([]Logger{ml1, hl, ml2}, nil)
+	Expect(set.hookPreQueue).To(HaveLen(1))
+
+	newSet := set.withoutLogger(hl)
+	Expect(set.loggers).To(Equal([]Logger{ml1, hl, ml2}))
+	Expect(set.hookPreQueue).To(HaveLen(1))
+	Expect(newSet.loggers).To(Equal([]Logger{ml1, ml2}))
+	Expect(newSet.hookPreQueue).To(HaveLen(0))
+
+	Expect(set.contains(hl)).To(BeTrue())
+	Expect(newSet.contains(hl)).To(BeFalse())
+}
+
+func (s *GomolSuite) TestLoggerSetKeepsFallback(t sweet.T) {
+	ml := newDefaultMemLogger()
+	fb := newDefaultMemLogger()
+
+	set := newLoggerSet(nil, nil).withFallbackLogger(fb)
+	Expect(set.fallbackLogger).To(Equal(fb))
+	Expect(set.withLogger(ml).fallbackLogger).To(Equal(fb))
+	Expect(set.withoutLogger(ml).fallbackLogger).To(Equal(fb))
+	Expect(set.withLoggers(nil).fallbackLogger).To(Equal(fb))
+	Expect(set.withFallbackLogger(nil).fallbackLogger).To(BeNil())
+}
@@ -18,12 +18,13 @@ func newMemLoggerConfig() *memLoggerConfig {
 }
 
 type memLogger struct {
-	base   *Base
 	config *memLoggerConfig
 
 	messageLock sync.Mutex
 	messages    []*memMessage
 
+	stateLock     sync.RWMutex
+	base          *Base
 	healthy       bool
 	isInitialized bool
 	isShutdown    bool
@@ -69,6 +70,8 @@ func newMemMessage() *memMessage {
 }
 
 func (l *memLogger) SetBase(base *Base) {
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
 	l.base = base
 }
 
@@ -76,27 +79,37 @@ func (l *memLogger) InitLogger() error {
 	if l.config.FailInit {
 		return errors.New("Init failed")
 	}
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
 	l.isInitialized = true
 	l.isShutdown = false
 	return nil
 }
 func (l *memLogger) IsInitialized() bool {
+	l.stateLock.RLock()
+	defer l.stateLock.RUnlock()
 	return l.isInitialized
 }
 func (l *memLogger) ShutdownLogger() error {
 	if l.config.FailShutdown {
 		return errors.New("Shutdown failed")
 	}
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
 	l.isInitialized = false
 	l.isShutdown = true
 	return nil
 }
 
 func (l *memLogger) Healthy() bool {
+	l.stateLock.RLock()
+	defer l.stateLock.RUnlock()
 	return l.healthy
 }
 
 func (l *memLogger) SetHealthy(healthy bool) {
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
 	l.healthy = healthy
 }
 
@@ -106,8 +119,12 @@ func (l *memLogger) Logm(timestamp time.Time, level LogLevel, m map[string]inter
 	nm.Level = level
 	nm.Message = msg
 
-	if l.base != nil {
-		for k, v := range l.base.BaseAttrs.Attrs() {
+	l.stateLock.RLock()
+	base := l.base
+	l.stateLock.RUnlock()
+
+	if base != nil {
+		for k, v := range base.BaseAttrs.Attrs() {
 			nm.Attrs[k] = v
 
 			buf := bytes.NewBufferString("")
@@ -13,6 +13,25 @@ func newDefaultMemLogger() *memLogger {
 	return l
 }
 
+// newTestBase creates an initialized Base with configs applied that logs to a
+// new memLogger
+func newTestBase(configs ...baseConfigFunc) (*Base, *memLogger) {
+	b := NewBase(configs...)
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+	return b, ml
+}
+
+// memMessageTexts returns the text of each message logged to ml
+func memMessageTexts(ml *memLogger) []string {
+	var texts []string
+	for _, msg := range ml.Messages() {
+		texts = append(texts, msg.Message)
+	}
+	return texts
+}
+
 type MemLoggerSuite struct{}
 
 func (s *MemLoggerSuite) TestMemInitLogger(t sweet.T) {
@@ -103,6 +103,33 @@ type Message struct {
 	Timestamp time.Time
 	Attrs     *Attrs
 	Msg       string
+
+	// spool is the spool the message was written to, if any, so it can
+	// be acked once it's delivered.
+	spool   *spool
+	spoolID uint64
+
+	// routing is the set of loggers the Base's Router sent the message to,
+	// or nil if it's sent to all of them.
+	routing *routing
+
+	// loggers is the Base's set of loggers when the message was queued so
+	// it's written to the loggers it was logged to, even if loggers are
+	// added or removed before the queue worker gets to it.
+	loggers *loggerSet
+}
+
+// routedTo returns true if the message should be written to l
+func (m *Message) routedTo(l Logger) bool {
+	return m.routing == nil || m.routing.includes(l)
+}
+
+// loggerSet returns the set of loggers the message was logged to
+func (m *Message) loggerSet() *loggerSet {
+	if m.loggers == nil {
+		return m.base.getLoggerSet()
+	}
+	return m.loggers
 }
 
 func newMessage(timestamp time.Time,
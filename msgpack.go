@@ -0,0 +1,431 @@
+package gomol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// The MessagePack support here is just enough to encode log messages and
+// decode the small responses sent back by servers such as Fluentd.
+
+// msgpackExt is an extension value decoded by readMsgpack
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+func writeMsgpackNil(buf *bytes.Buffer) {
+	buf.WriteByte(0xc0)
+}
+
+func writeMsgpackBool(buf *bytes.Buffer, val bool) {
+	if val {
+		buf.WriteByte(0xc3)
+	} else {
+		buf.WriteByte(0xc2)
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, val int64) {
+	switch {
+	case val >= 0:
+		writeMsgpackUint(buf, uint64(val))
+	case val >= -32:
+		buf.WriteByte(byte(val))
+	case val >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(val))
+	case val >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		writeMsgpackBE(buf, uint64(val), 2)
+	case val >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		writeMsgpackBE(buf, uint64(val), 4)
+	default:
+		buf.WriteByte(0xd3)
+		writeMsgpackBE(buf, uint64(val), 8)
+	}
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, val uint64) {
+	switch {
+	case val <= 0x7f:
+		buf.WriteByte(byte(val))
+	case val <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(val))
+	case val <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		writeMsgpackBE(buf, val, 2)
+	case val <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		writeMsgpackBE(buf, val, 4)
+	default:
+		buf.WriteByte(0xcf)
+		writeMsgpackBE(buf, val, 8)
+	}
+}
+
+func writeMsgpackFloat(buf *bytes.Buffer, val float64) {
+	buf.WriteByte(0xcb)
+	writeMsgpackBE(buf, math.Float64bits(val), 8)
+}
+
+func writeMsgpackString(buf *bytes.Buffer, val string) {
+	size := len(val)
+	switch {
+	case size <= 31:
+		buf.WriteByte(0xa0 | byte(size))
+	case size <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(size))
+	case size <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		writeMsgpackBE(buf, uint64(size), 2)
+	default:
+		buf.WriteByte(0xdb)
+		writeMsgpackBE(buf, uint64(size), 4)
+	}
+	buf.WriteString(val)
+}
+
+func writeMsgpackBin(buf *bytes.Buffer, val []byte) {
+	size := len(val)
+	switch {
+	case size <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(size))
+	case size <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		writeMsgpackBE(buf, uint64(size), 2)
+	default:
+		buf.WriteByte(0xc6)
+		writeMsgpackBE(buf, uint64(size), 4)
+	}
+	buf.Write(val)
+}
+
+func writeMsgpackArrayHeader(buf *bytes.Buffer, size int) {
+	switch {
+	case size <= 15:
+		buf.WriteByte(0x90 | byte(size))
+	case size <= math.MaxUint16:
+		buf.WriteByte(0xdc)
+		writeMsgpackBE(buf, uint64(size), 2)
+	default:
+		buf.WriteByte(0xdd)
+		writeMsgpackBE(buf, uint64(size), 4)
+	}
+}
+
+func writeMsgpackMapHeader(buf *bytes.Buffer, size int) {
+	switch {
+	case size <= 15:
+		buf.WriteByte(0x80 | byte(size))
+	case size <= math.MaxUint16:
+		buf.WriteByte(0xde)
+		writeMsgpackBE(buf, uint64(size), 2)
+	default:
+		buf.WriteByte(0xdf)
+		writeMsgpackBE(buf, uint64(size), 4)
+	}
+}
+
+// writeMsgpackEventTime writes t as a Fluentd EventTime, an extension of type
+// 0 holding the seconds and nanoseconds as big endian 32 bit integers
+func writeMsgpackEventTime(buf *bytes.Buffer, t time.Time) {
+	buf.WriteByte(0xd7)
+	buf.WriteByte(0x00)
+	writeMsgpackBE(buf, uint64(t.Unix()), 4)
+	writeMsgpackBE(buf, uint64(t.Nanosecond()), 4)
+}
+
+func writeMsgpackBE(buf *bytes.Buffer, val uint64, size int) {
+	for shift := uint(size-1) * 8; ; shift -= 8 {
+		buf.WriteByte(byte(val >> shift))
+		if shift == 0 {
+			return
+		}
+	}
+}
+
+// writeMsgpackValue writes an attribute value.  Values that don't have a
+// MessagePack representation are written as strings.
+func writeMsgpackValue(buf *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+		writeMsgpackNil(buf)
+	case string:
+		writeMsgpackString(buf, v)
+	case bool:
+		writeMsgpackBool(buf, v)
+	case int:
+		writeMsgpackInt(buf, int64(v))
+	case int8:
+		writeMsgpackInt(buf, int64(v))
+	case int16:
+		writeMsgpackInt(buf, int64(v))
+	case int32:
+		writeMsgpackInt(buf, int64(v))
+	case int64:
+		writeMsgpackInt(buf, v)
+	case uint:
+		writeMsgpackUint(buf, uint64(v))
+	case uint8:
+		writeMsgpackUint(buf, uint64(v))
+	case uint16:
+		writeMsgpackUint(buf, uint64(v))
+	case uint32:
+		writeMsgpackUint(buf, uint64(v))
+	case uint64:
+		writeMsgpackUint(buf, v)
+	case float32:
+		writeMsgpackFloat(buf, float64(v))
+	case float64:
+		writeMsgpackFloat(buf, v)
+	case []byte:
+		writeMsgpackBin(buf, v)
+	case []interface{}:
+		writeMsgpackArrayHeader(buf, len(v))
+		for _, item := range v {
+			writeMsgpackValue(buf, item)
+		}
+	case []string:
+		writeMsgpackArrayHeader(buf, len(v))
+		for _, item := range v {
+			writeMsgpackString(buf, item)
+		}
+	case map[string]interface{}:
+		writeMsgpackMapHeader(buf, len(v))
+		for _, key := range sortedAttrKeys(v) {
+			writeMsgpackString(buf, key)
+			writeMsgpackValue(buf, v[key])
+		}
+	default:
+		writeMsgpackString(buf, attrString(v, time.RFC3339Nano))
+	}
+}
+
+// errMsgpackTooLarge is returned by readMsgpack when a value needs more bytes
+// than its limit allows
+var errMsgpackTooLarge = errors.New("msgpack: the value is larger than the limit")
+
+// readMsgpack reads a single value from r.  Integers are returned as int64
+// unless they're too large to fit in one, maps as map[string]interface{} and
+// extensions as msgpackExt.  If the value needs more than limit bytes reading
+// fails with errMsgpackTooLarge, so a malformed length can't make it allocate
+// more than that.
+func readMsgpack(r *bufio.Reader, limit int) (interface{}, error) {
+	d := &msgpackDecoder{r: r, remaining: limit}
+	return d.read()
+}
+
+// msgpackDecoder reads a value, counting down how many more bytes it's allowed
+// to read
+type msgpackDecoder struct {
+	r         *bufio.Reader
+	remaining int
+}
+
+// take accounts for the next size bytes of the value
+func (d *msgpackDecoder) take(size int) error {
+	if size < 0 || size > d.remaining {
+		return errMsgpackTooLarge
+	}
+	d.remaining -= size
+	return nil
+}
+
+func (d *msgpackDecoder) read() (interface{}, error) {
+	if err := d.take(1); err != nil {
+		return nil, err
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.readMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.readArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		data, err := d.readBytes(int(c & 0x1f))
+		return string(data), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		size, err := d.readBE(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(msgpackLen(size))
+	case 0xc7, 0xc8, 0xc9:
+		size, err := d.readBE(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.readExt(msgpackLen(size))
+	case 0xca:
+		bits, err := d.readBE(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readBE(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		val, err := d.readBE(1 << (c - 0xcc))
+		if err != nil || val > math.MaxInt64 {
+			return val, err
+		}
+		return int64(val), nil
+	case 0xd0:
+		val, err := d.readBE(1)
+		return int64(int8(val)), err
+	case 0xd1:
+		val, err := d.readBE(2)
+		return int64(int16(val)), err
+	case 0xd2:
+		val, err := d.readBE(4)
+		return int64(int32(val)), err
+	case 0xd3:
+		val, err := d.readBE(8)
+		return int64(val), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.readExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		size, err := d.readBE(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readBytes(msgpackLen(size))
+		return string(data), err
+	case 0xdc, 0xdd:
+		size, err := d.readBE(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.readArray(msgpackLen(size))
+	case 0xde, 0xdf:
+		size, err := d.readBE(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.readMap(msgpackLen(size))
+	}
+
+	return nil, fmt.Errorf("msgpack: unknown type 0x%02x", c)
+}
+
+// msgpackLen converts a length read from a value to an int.  Lengths too large
+// for an int become -1, which is always over the limit.
+func msgpackLen(size uint64) int {
+	if size > math.MaxInt32 {
+		return -1
+	}
+	return int(size)
+}
+
+func (d *msgpackDecoder) readBE(size int) (uint64, error) {
+	if err := d.take(size); err != nil {
+		return 0, err
+	}
+	var data [8]byte
+	_, err := io.ReadFull(d.r, data[8-size:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data[:]), nil
+}
+
+func (d *msgpackDecoder) readBytes(size int) ([]byte, error) {
+	if err := d.take(size); err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(d.r, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (d *msgpackDecoder) readExt(size int) (interface{}, error) {
+	if err := d.take(1); err != nil {
+		return nil, err
+	}
+	extType, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.readBytes(size)
+	if err != nil {
+		return nil, err
+	}
+	return msgpackExt{Type: int8(extType), Data: data}, nil
+}
+
+// readArray reads size values.  Each value is at least a byte so size is
+// checked against the limit before anything is allocated for them.
+func (d *msgpackDecoder) readArray(size int) ([]interface{}, error) {
+	if size < 0 || size > d.remaining {
+		return nil, errMsgpackTooLarge
+	}
+
+	vals := make([]interface{}, 0, size)
+	for idx := 0; idx < size; idx++ {
+		val, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// readMap reads size keys and values.  Each key and value is at least a byte
+// so size is checked against the limit before anything is allocated for them.
+func (d *msgpackDecoder) readMap(size int) (map[string]interface{}, error) {
+	if size < 0 || size > d.remaining/2 {
+		return nil, errMsgpackTooLarge
+	}
+
+	vals := make(map[string]interface{}, size)
+	for idx := 0; idx < size; idx++ {
+		key, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := key.(type) {
+		case string:
+			vals[k] = val
+		case []byte:
+			vals[string(k)] = val
+		default:
+			vals[fmt.Sprint(k)] = val
+		}
+	}
+	return vals, nil
+}
@@ -0,0 +1,321 @@
+package gomol
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// NetFraming is how messages are separated from each other when they're
+// written to a stream connection such as TCP.  Messages written to datagram
+// connections such as UDP are sent one per datagram and never framed.
+type NetFraming int
+
+const (
+	// NetFramingNewline ends each message with a newline
+	NetFramingNewline NetFraming = iota
+	// NetFramingLengthPrefix prefixes each message with its length as a
+	// 4 byte big endian unsigned integer
+	NetFramingLengthPrefix
+)
+
+// ErrNetBufferFull is reported to the Base's error channel when a message is
+// logged while a NetLogger is disconnected and its buffer is full.  The message
+// is buffered and the oldest buffered message is dropped to make room for it.
+var ErrNetBufferFull = errors.New("the buffer is full, the oldest message was dropped")
+
+// NetLoggerConfig is the configuration for a NetLogger
+type NetLoggerConfig struct {
+	// Network is the type of connection and can be one of "tcp", "udp",
+	// "unix", "unixgram" or "tcp+tls"
+	Network string
+
+	// Address is the address to connect to, or the path to the socket for
+	// "unix" and "unixgram" connections
+	Address string
+
+	// TLSConfig is the TLS configuration used for "tcp+tls" connections
+	TLSConfig *tls.Config
+
+	// Encoder converts each message into the bytes written to the connection
+	Encoder Encoder
+
+	// Framing is how messages are separated on stream connections
+	Framing NetFraming
+
+	// BufferSize is the most messages kept while the logger is disconnected.
+	// They're written once the logger reconnects.
+	BufferSize int
+
+	// AllowDisconnectedInit lets InitLogger succeed when the first attempt to
+	// connect fails.  The logger will keep trying to connect in the
+	// background.
+	AllowDisconnectedInit bool
+
+	// DialTimeout is how long to wait when connecting
+	DialTimeout time.Duration
+
+	// WriteTimeout is how long to wait for a message to be written before
+	// the connection is considered broken
+	WriteTimeout time.Duration
+
+	// RetryDelay is how long to wait between attempts to reconnect after
+	// the connection is lost
+	RetryDelay
+}
+
+// NewNetLoggerConfig creates a new NetLoggerConfig that writes JSON lines to
+// address over network
+func NewNetLoggerConfig(network string, address string) *NetLoggerConfig {
+	return &NetLoggerConfig{
+		Network:               network,
+		Address:               address,
+		Encoder:               NewJSONEncoder(nil),
+		Framing:               NetFramingNewline,
+		BufferSize:            1000,
+		AllowDisconnectedInit: false,
+		DialTimeout:           5 * time.Second,
+		WriteTimeout:          5 * time.Second,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 100 * time.Millisecond,
+			MaxRetryDelay: 30 * time.Second,
+		},
+	}
+}
+
+/*
+NetLogger is a Logger that writes encoded messages to a network connection, such
+as a log forwarding sidecar listening on a local socket.  If the connection is
+lost the logger reports itself as unhealthy and reconnects in the background,
+waiting longer between each failed attempt.  Messages logged while it's
+disconnected are kept in a buffer of up to BufferSize messages and written
+once it has reconnected.  Messages still buffered when the logger is shut down
+are dropped.  Since a message that couldn't be written is buffered instead,
+Logm doesn't return an error for it.  The error is reported to the Base's error
+channel instead.
+*/
+type NetLogger struct {
+	config *NetLoggerConfig
+	clock  glock.Clock
+
+	lock          sync.Mutex
+	base          *Base
+	conn          *reconnectingConn
+	encBuf        bytes.Buffer
+	buffer        [][]byte
+	isInitialized bool
+}
+
+var _ Logger = &NetLogger{}
+var _ HealthCheckLogger = &NetLogger{}
+
+// NewNetLogger creates a new NetLogger
+func NewNetLogger(config *NetLoggerConfig) (*NetLogger, error) {
+	if config == nil {
+		return nil, fmt.Errorf("a config is required")
+	}
+
+	switch config.Network {
+	case "tcp", "tcp4", "tcp6", "tcp+tls", "udp", "udp4", "udp6", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unknown network: %s", config.Network)
+	}
+	if config.Framing != NetFramingNewline && config.Framing != NetFramingLengthPrefix {
+		return nil, fmt.Errorf("unknown framing: %d", config.Framing)
+	}
+	if err := config.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+	cfg := *config
+	if cfg.Encoder == nil {
+		cfg.Encoder = NewJSONEncoder(nil)
+	}
+
+	return &NetLogger{
+		config: &cfg,
+		clock:  glock.NewRealClock(),
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *NetLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger connects to the configured address
+func (l *NetLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	conn := newReconnectingConn(&l.lock, l.clock, l.config.RetryDelay, l.dial)
+	conn.connected = l.writeBuffered
+	err := conn.open(l.config.AllowDisconnectedInit)
+	if err != nil {
+		return err
+	}
+
+	l.conn = conn
+	l.buffer = nil
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *NetLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger closes the connection
+func (l *NetLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+
+	conn := l.conn
+	err := conn.close()
+	l.buffer = nil
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	conn.wait()
+
+	return err
+}
+
+// Healthy returns whether the logger is connected
+func (l *NetLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.conn.conn != nil
+}
+
+// Logm encodes the message and writes it to the connection, or adds it to the
+// buffer if the logger is disconnected
+func (l *NetLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	mergedAttrs := attrs
+	if l.base != nil {
+		mergedAttrs = l.base.BaseAttrs.Attrs()
+		for key, val := range attrs {
+			mergedAttrs[key] = val
+		}
+	}
+
+	l.encBuf.Reset()
+	err := l.config.Encoder.Encode(&l.encBuf, timestamp, level, mergedAttrs, msg)
+	if err != nil {
+		return err
+	}
+	data := bytes.TrimSuffix(l.encBuf.Bytes(), []byte{'\n'})
+
+	if l.conn.conn == nil {
+		l.bufferMessage(data)
+		return nil
+	}
+
+	err = l.write(data)
+	if err != nil {
+		l.conn.broken()
+		l.report(err)
+		l.bufferMessage(data)
+	}
+
+	return nil
+}
+
+// report reports err to the Base's error channel, if the logger has a Base.
+// It must be called with the lock held.
+func (l *NetLogger) report(err error) {
+	if l.base != nil {
+		l.base.report(err)
+	}
+}
+
+// write frames data and writes it to the connection.  It must be called with
+// the lock held.
+func (l *NetLogger) write(data []byte) error {
+	if l.conn.stream {
+		data = l.frame(data)
+	}
+
+	if l.config.WriteTimeout > 0 {
+		l.conn.conn.SetWriteDeadline(time.Now().Add(l.config.WriteTimeout))
+	}
+	_, err := l.conn.conn.Write(data)
+	return err
+}
+
+// bufferMessage keeps a copy of data to write once the logger reconnects,
+// dropping the oldest buffered message if the buffer is full.  It must be
+// called with the lock held.
+func (l *NetLogger) bufferMessage(data []byte) {
+	if l.config.BufferSize <= 0 {
+		l.report(ErrNetBufferFull)
+		return
+	}
+
+	if len(l.buffer) >= l.config.BufferSize {
+		l.buffer[0] = nil
+		l.buffer = l.buffer[1:]
+		l.report(ErrNetBufferFull)
+	}
+	l.buffer = append(l.buffer, append([]byte(nil), data...))
+}
+
+// writeBuffered writes the messages buffered while the logger was
+// disconnected.  If the connection breaks again the messages that weren't
+// written are kept.  It must be called with the lock held.
+func (l *NetLogger) writeBuffered() {
+	for len(l.buffer) > 0 {
+		err := l.write(l.buffer[0])
+		if err != nil {
+			l.conn.broken()
+			return
+		}
+		l.buffer[0] = nil
+		l.buffer = l.buffer[1:]
+	}
+	l.buffer = nil
+}
+
+func (l *NetLogger) frame(data []byte) []byte {
+	if l.config.Framing == NetFramingLengthPrefix {
+		framed := make([]byte, 4, len(data)+4)
+		binary.BigEndian.PutUint32(framed, uint32(len(data)))
+		return append(framed, data...)
+	}
+
+	framed := make([]byte, 0, len(data)+1)
+	framed = append(framed, data...)
+	return append(framed, '\n')
+}
+
+// dial connects to the configured address and returns the connection and
+// whether it's a stream connection that messages need to be framed on
+func (l *NetLogger) dial() (net.Conn, bool, error) {
+	return dialNetwork(l.config.Network, l.config.Address, l.config.TLSConfig, l.config.DialTimeout)
+}
@@ -0,0 +1,292 @@
+package gomol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type NetLoggerSuite struct{}
+
+func newTestNetLogger(network string, address string) (*NetLogger, *glock.MockClock) {
+	cfg := NewNetLoggerConfig(network, address)
+	cfg.Encoder = NewLogfmtEncoder(nil)
+	cfg.BufferSize = 3
+
+	l, err := NewNetLogger(cfg)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	l.clock = clock
+	return l, clock
+}
+
+func (s *NetLoggerSuite) TestNewNetLoggerBadConfig(t sweet.T) {
+	l, err := NewNetLogger(nil)
+	Expect(l).To(BeNil())
+	Expect(err).ToNot(BeNil())
+
+	l, err = NewNetLogger(NewNetLoggerConfig("ip", "127.0.0.1"))
+	Expect(l).To(BeNil())
+	Expect(err).ToNot(BeNil())
+
+	cfg := NewNetLoggerConfig("tcp", "127.0.0.1:1")
+	cfg.Framing = NetFraming(100)
+	l, err = NewNetLogger(cfg)
+	Expect(l).To(BeNil())
+	Expect(err).ToNot(BeNil())
+
+	cfg = NewNetLoggerConfig("tcp", "127.0.0.1:1")
+	cfg.MinRetryDelay = 0
+	l, err = NewNetLogger(cfg)
+	Expect(l).To(BeNil())
+	Expect(err).To(Equal(ErrInvalidRetryDelay))
+}
+
+func (s *NetLoggerSuite) TestFrame(t sweet.T) {
+	l, _ := newTestNetLogger("tcp", "")
+	Expect(string(l.frame([]byte("hello")))).To(Equal("hello\n"))
+
+	l.config.Framing = NetFramingLengthPrefix
+	Expect(l.frame([]byte("hello"))).To(Equal([]byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}))
+}
+
+func (s *NetLoggerSuite) TestTCPNewline(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, _ := newTestNetLogger("tcp", ln.Addr().String())
+
+	b := NewBase()
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+	defer b.ShutdownLoggers()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Healthy()).To(BeTrue())
+	b.LogWithTime(LevelInfo, testTime, NewAttrs().SetAttr("msg_attr", 1), "message 1")
+	b.LogWithTime(LevelInfo, testTime, nil, "message 2")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=\"message 1\" base_attr=base msg_attr=1\n"))
+	line, err = r.ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=\"message 2\" base_attr=base\n"))
+}
+
+func (s *NetLoggerSuite) TestUnixLengthPrefix(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.sock")
+	ln, err := net.Listen("unix", path)
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, _ := newTestNetLogger("unix", path)
+	l.config.Framing = NetFramingLengthPrefix
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Logm(testTime, LevelWarning, nil, "message")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	Expect(err).To(BeNil())
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(conn, data)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("time=2017-03-04T05:06:07.89Z level=warn msg=message"))
+}
+
+func (s *NetLoggerSuite) TestUDP(t sweet.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer pc.Close()
+
+	l, _ := newTestNetLogger("udp", pc.LocalAddr().String())
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "udp message")).To(BeNil())
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(string(buf[:n])).To(Equal(`time=2017-03-04T05:06:07.89Z level=info msg="udp message"`))
+}
+
+func (s *NetLoggerSuite) TestTLS(t sweet.T) {
+	serverCfg, clientCfg := newTestTLSConfigs()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil && conn.(*tls.Conn).Handshake() == nil {
+			accepted <- conn
+		}
+	}()
+
+	l, _ := newTestNetLogger("tcp+tls", ln.Addr().String())
+	l.config.TLSConfig = clientCfg
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "tls message")).To(BeNil())
+
+	var conn net.Conn
+	Eventually(accepted).Should(Receive(&conn))
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=\"tls message\"\n"))
+}
+
+func (s *NetLoggerSuite) TestInitConnectFailure(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l, _ := newTestNetLogger("tcp", addr)
+	Expect(l.InitLogger()).ToNot(BeNil())
+	Expect(l.IsInitialized()).To(BeFalse())
+	Expect(l.Healthy()).To(BeFalse())
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+}
+
+func (s *NetLoggerSuite) TestAllowDisconnectedInit(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l, clock := newTestNetLogger("tcp", addr)
+	l.config.AllowDisconnectedInit = true
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.IsInitialized()).To(BeTrue())
+	Expect(l.Healthy()).To(BeFalse())
+	Expect(l.Logm(testTime, LevelInfo, nil, "buffered")).To(BeNil())
+
+	ln, err = net.Listen("tcp", addr)
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	clock.Advance(100 * time.Millisecond)
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+	Eventually(l.Healthy).Should(BeTrue())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=buffered\n"))
+}
+
+func (s *NetLoggerSuite) TestReconnectWritesBuffered(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l, clock := newTestNetLogger("tcp", ln.Addr().String())
+	errs := make(chan error, 100)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	conn.Close()
+
+	// Writes to a socket closed by the other side don't fail right away
+	// so keep writing until the logger notices.
+	Eventually(func() bool {
+		l.Logm(testTime, LevelInfo, nil, "lost message")
+		return l.Healthy()
+	}).Should(BeFalse())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "buffered 1")).To(BeNil())
+	Expect(l.Logm(testTime, LevelInfo, nil, "buffered 2")).To(BeNil())
+	Expect(l.Logm(testTime, LevelInfo, nil, "buffered 3")).To(BeNil())
+	Eventually(errs).Should(Receive(Equal(ErrNetBufferFull)))
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{100 * time.Millisecond}))
+	clock.Advance(100 * time.Millisecond)
+
+	conn, err = ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Eventually(l.Healthy).Should(BeTrue())
+	Expect(l.Logm(testTime, LevelInfo, nil, "new message")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, msg := range []string{"buffered 1", "buffered 2", "buffered 3", "new message"} {
+		line, err := r.ReadString('\n')
+		Expect(err).To(BeNil())
+		Expect(line).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=\"" + msg + "\"\n"))
+	}
+}
+
+func (s *NetLoggerSuite) TestFallbackWhileDisconnected(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l, _ := newTestNetLogger("tcp", addr)
+	l.config.AllowDisconnectedInit = true
+
+	fl := newDefaultMemLogger()
+	fl.SetHealthy(true)
+
+	b := NewBase()
+	b.AddLogger(l)
+	Expect(b.SetFallbackLogger(fl)).To(BeNil())
+	Expect(b.InitLoggers()).To(BeNil())
+
+	b.Info("fallback message")
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Expect(fl.Messages()).To(HaveLen(1))
+	Expect(fl.Messages()[0].Message).To(Equal("fallback message"))
+}
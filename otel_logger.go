@@ -0,0 +1,648 @@
+package gomol
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// The attribute names OTelLoggerConfig reads trace context from by default
+const (
+	OTelTraceIDAttr    = "trace_id"
+	OTelSpanIDAttr     = "span_id"
+	OTelTraceFlagsAttr = "trace_flags"
+)
+
+// ErrOTelNoOutput is returned by NewOTelLogger when the config has neither an
+// Endpoint nor a FilePath to export messages to
+var ErrOTelNoOutput = errors.New("an OTLP endpoint or file path is required")
+
+// OTelLoggerConfig is the configuration for an OTelLogger
+type OTelLoggerConfig struct {
+	// Endpoint is the URL of the OTLP/HTTP logs endpoint messages are sent
+	// to, such as http://localhost:4318/v1/logs.  If it is empty messages
+	// aren't sent over HTTP.
+	Endpoint string
+
+	// Headers are extra headers added to each request, such as for
+	// authentication
+	Headers map[string]string
+
+	// HTTPClient is the client requests are sent with.  If it is nil a client
+	// with a timeout of Timeout is used.
+	HTTPClient *http.Client
+
+	// Timeout is how long to wait for each request to the endpoint
+	Timeout time.Duration
+
+	// MaxRetries is how many times a request that failed with a network
+	// error or a retryable status is retried before its messages are dropped
+	MaxRetries int
+
+	RetryDelay
+
+	// BufferSize is the most batches kept waiting to be exported.  Once it's
+	// reached the oldest waiting batch is dropped.
+	BufferSize int
+
+	// FilePath is the path of a file messages are appended to as lines of
+	// OTLP JSON.  If it is empty messages aren't written to a file.
+	FilePath string
+
+	// ResourceAttrs are attributes describing the resource that produced the
+	// messages.  The Base's attributes are added to them.
+	ResourceAttrs map[string]interface{}
+
+	// ScopeName is the name of the instrumentation scope messages are
+	// reported under
+	ScopeName string
+
+	// ScopeVersion is the version of the instrumentation scope
+	ScopeVersion string
+
+	// TraceIDAttr is the name of the attribute holding a message's trace ID
+	// as 32 hex characters
+	TraceIDAttr string
+
+	// SpanIDAttr is the name of the attribute holding a message's span ID as
+	// 16 hex characters
+	SpanIDAttr string
+
+	// TraceFlagsAttr is the name of the attribute holding a message's W3C
+	// trace flags
+	TraceFlagsAttr string
+}
+
+// NewOTelLoggerConfig creates a new OTelLoggerConfig that sends messages to an
+// OpenTelemetry collector running on the local host
+func NewOTelLoggerConfig() *OTelLoggerConfig {
+	return &OTelLoggerConfig{
+		Endpoint:   "http://localhost:4318/v1/logs",
+		Headers:    map[string]string{},
+		Timeout:    10 * time.Second,
+		MaxRetries: 5,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 500 * time.Millisecond,
+			MaxRetryDelay: 30 * time.Second,
+		},
+		BufferSize: 100,
+		FilePath:   "",
+		ResourceAttrs: map[string]interface{}{
+			"service.name": filepath.Base(os.Args[0]),
+		},
+		ScopeName:      "github.com/aphistic/gomol",
+		ScopeVersion:   "",
+		TraceIDAttr:    OTelTraceIDAttr,
+		SpanIDAttr:     OTelSpanIDAttr,
+		TraceFlagsAttr: OTelTraceFlagsAttr,
+	}
+}
+
+/*
+OTelLogger is a Logger that exports messages using the OpenTelemetry logs data
+model.  Each message becomes a LogRecord with its level mapped to a severity,
+its attributes as the record's attributes and the Base's attributes as the
+resource's attributes.  Trace and span IDs are read from the attributes named
+in the config and aren't included with the record's other attributes.
+
+Messages are exported as OTLP/JSON, either sent to an OTLP/HTTP endpoint, written
+to a file as one request per line, or both.  OTelLogger is a BatchLogger so each
+batch of messages from the queue is exported with a single request.  Batches are
+exported in the background so a slow or unreachable endpoint doesn't hold up the
+queue, and up to BufferSize batches wait to be exported.  Requests that fail
+with a network error or a retryable status are retried, waiting longer between
+each attempt.  Once a batch has been dropped the error is reported to the Base's
+error channel and the logger reports itself as unhealthy until a request
+succeeds again.
+*/
+type OTelLogger struct {
+	config *OTelLoggerConfig
+	clock  glock.Clock
+	client *http.Client
+
+	lock          sync.Mutex
+	base          *Base
+	isInitialized bool
+	healthy       bool
+	sender        *sender
+
+	// file is only written to by the sender, and is opened before it
+	// starts and closed after it has stopped
+	file *os.File
+}
+
+var _ Logger = &OTelLogger{}
+var _ HealthCheckLogger = &OTelLogger{}
+var _ BatchLogger = &OTelLogger{}
+var _ ShutdownLoggerContext = &OTelLogger{}
+
+// NewOTelLogger creates a new OTelLogger.  If config is nil the values from
+// NewOTelLoggerConfig are used.
+func NewOTelLogger(config *OTelLoggerConfig) (*OTelLogger, error) {
+	if config == nil {
+		config = NewOTelLoggerConfig()
+	}
+	if len(config.Endpoint) == 0 && len(config.FilePath) == 0 {
+		return nil, ErrOTelNoOutput
+	}
+	if config.BufferSize <= 0 {
+		return nil, fmt.Errorf("the buffer size must be positive")
+	}
+	if err := config.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &OTelLogger{
+		config: config,
+		clock:  glock.NewRealClock(),
+		client: client,
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *OTelLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger opens the output file if one is configured
+func (l *OTelLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	if len(l.config.FilePath) > 0 {
+		file, err := os.OpenFile(l.config.FilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		l.file = file
+	}
+
+	l.sender = newSender(l.config.BufferSize)
+	l.healthy = true
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *OTelLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger waits for the batches waiting to be exported and closes the
+// output file
+func (l *OTelLogger) ShutdownLogger() error {
+	return l.ShutdownLoggerContext(context.Background())
+}
+
+// ShutdownLoggerContext waits for the batches waiting to be exported and closes
+// the output file.  If ctx is done before they're exported they're no longer
+// retried and are only written to the file.
+func (l *OTelLogger) ShutdownLoggerContext(ctx context.Context) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+	sender := l.sender
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	sender.shutdown(ctx)
+
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+		l.file = nil
+	}
+	return err
+}
+
+// Healthy returns whether the last batch of messages was exported
+func (l *OTelLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.healthy
+}
+
+// Logm adds a single message to the batches waiting to be exported
+func (l *OTelLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	observed := l.clock.Now()
+	l.lock.Unlock()
+
+	return l.queue([]*otlpLogRecord{l.newLogRecord(timestamp, observed, level, attrs, msg)})
+}
+
+// LogBatch adds a batch of messages to be exported with a single request to the
+// batches waiting to be exported
+func (l *OTelLogger) LogBatch(msgs []*Message) error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return ErrNotInitialized
+	}
+	observed := l.clock.Now()
+	l.lock.Unlock()
+
+	records := make([]*otlpLogRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		records = append(records, l.newLogRecord(msg.Timestamp, observed, msg.Level, msg.Attrs.Attrs(), msg.Msg))
+	}
+	return l.queue(records)
+}
+
+// queue encodes records and adds them to the batches waiting to be exported
+func (l *OTelLogger) queue(records []*otlpLogRecord) error {
+	data, err := json.Marshal(l.newRequest(records))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Encoding the batch again would fail the same way so the error is
+	// reported instead of returned for the queue to retry
+	if err != nil {
+		l.report(err)
+		return nil
+	}
+
+	// The logger may have been shut down while the batch was being encoded
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	if l.sender.add(func(stop <-chan struct{}) { l.export(data, stop) }) {
+		l.report(ErrSendBufferFull)
+	}
+	return nil
+}
+
+// report reports err to the Base's error channel, if the logger has a Base.
+// It must be called with the lock held.
+func (l *OTelLogger) report(err error) {
+	if l.base != nil {
+		l.base.report(err)
+	}
+}
+
+// export writes an encoded batch to the file and sends it to the endpoint,
+// retrying until it succeeds, fails with a status that shouldn't be retried,
+// runs out of retries or stop is closed.  Any error is reported.
+func (l *OTelLogger) export(data []byte, stop <-chan struct{}) {
+	var fileErr error
+	if l.file != nil {
+		_, fileErr = l.file.Write(append(data, '\n'))
+	}
+
+	var sendErr error
+	if len(l.config.Endpoint) > 0 {
+		sendErr = postWithRetry(l.client, l.clock, l.config.MaxRetries, l.config.RetryDelay, func() (*http.Request, error) {
+			req, err := http.NewRequest("POST", l.config.Endpoint, bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			for key, val := range l.config.Headers {
+				req.Header.Set(key, val)
+			}
+			return req, nil
+		}, otelRetryable, stop)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.config.Endpoint) > 0 {
+		l.healthy = sendErr == nil
+	}
+	if sendErr != nil {
+		l.report(sendErr)
+	}
+	if fileErr != nil {
+		l.report(fileErr)
+	}
+}
+
+// otelRetryable returns whether an export that failed with status should be
+// retried, which the OTLP/HTTP spec only allows for these statuses
+func otelRetryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *OTelLogger) newRequest(records []*otlpLogRecord) *otlpExportRequest {
+	resourceAttrs := make(map[string]interface{}, len(l.config.ResourceAttrs))
+	for key, val := range l.config.ResourceAttrs {
+		resourceAttrs[key] = val
+	}
+
+	l.lock.Lock()
+	base := l.base
+	l.lock.Unlock()
+	if base != nil {
+		for key, val := range base.BaseAttrs.Attrs() {
+			resourceAttrs[key] = val
+		}
+	}
+
+	return &otlpExportRequest{
+		ResourceLogs: []*otlpResourceLogs{{
+			Resource: otlpResource{Attributes: otlpKeyValues(resourceAttrs, nil)},
+			ScopeLogs: []*otlpScopeLogs{{
+				Scope: otlpScope{
+					Name:    l.config.ScopeName,
+					Version: l.config.ScopeVersion,
+				},
+				LogRecords: records,
+			}},
+		}},
+	}
+}
+
+func (l *OTelLogger) newLogRecord(timestamp time.Time, observed time.Time, level LogLevel, attrs map[string]interface{}, msg string) *otlpLogRecord {
+	severity, severityText := otelSeverity(level)
+	record := &otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(timestamp.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(observed.UnixNano(), 10),
+		SeverityNumber:       severity,
+		SeverityText:         severityText,
+		Body:                 otlpStringValue(msg),
+	}
+
+	skip := map[string]bool{}
+	if id, ok := otelHexID(attrs, l.config.TraceIDAttr, 16); ok {
+		record.TraceID = id
+		skip[l.config.TraceIDAttr] = true
+	}
+	if id, ok := otelHexID(attrs, l.config.SpanIDAttr, 8); ok {
+		record.SpanID = id
+		skip[l.config.SpanIDAttr] = true
+	}
+	if val, ok := attrs[l.config.TraceFlagsAttr]; ok && len(l.config.TraceFlagsAttr) > 0 {
+		if flags, err := strconv.ParseUint(attrString(val, time.RFC3339Nano), 0, 8); err == nil {
+			record.Flags = uint32(flags)
+			skip[l.config.TraceFlagsAttr] = true
+		}
+	}
+
+	record.Attributes = otlpKeyValues(attrs, skip)
+	return record
+}
+
+// otelSeverity returns the OpenTelemetry severity number and text for level
+func otelSeverity(level LogLevel) (int, string) {
+	switch level {
+	case LevelDebug:
+		return 5, "DEBUG"
+	case LevelInfo:
+		return 9, "INFO"
+	case LevelWarning:
+		return 13, "WARN"
+	case LevelError:
+		return 17, "ERROR"
+	case LevelFatal:
+		return 21, "FATAL"
+	default:
+		return 0, ""
+	}
+}
+
+// otelHexID returns the ID in the attribute named key if it's a valid, non-zero
+// ID of size bytes written as hex
+func otelHexID(attrs map[string]interface{}, key string, size int) (string, bool) {
+	val, ok := attrs[key]
+	if !ok || len(key) == 0 {
+		return "", false
+	}
+
+	id, err := hex.DecodeString(attrString(val, time.RFC3339Nano))
+	if err != nil || len(id) != size {
+		return "", false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return hex.EncodeToString(id), true
+		}
+	}
+	return "", false
+}
+
+type otelTraceContextKey struct{}
+
+type otelTraceContext struct {
+	traceID string
+	spanID  string
+}
+
+// ContextWithTrace returns a copy of ctx that carries a trace and span ID so
+// they can be added to messages logged while handling it with
+// TraceAttrsFromContext
+func ContextWithTrace(ctx context.Context, traceID string, spanID string) context.Context {
+	return context.WithValue(ctx, otelTraceContextKey{}, otelTraceContext{
+		traceID: traceID,
+		spanID:  spanID,
+	})
+}
+
+// TraceAttrsFromContext returns Attrs with the trace and span IDs added to ctx
+// by ContextWithTrace, named OTelTraceIDAttr and OTelSpanIDAttr.  If ctx
+// doesn't carry a trace the returned Attrs are empty.
+func TraceAttrsFromContext(ctx context.Context) *Attrs {
+	attrs := NewAttrs()
+	if trace, ok := ctx.Value(otelTraceContextKey{}).(otelTraceContext); ok {
+		if len(trace.traceID) > 0 {
+			attrs.SetAttr(OTelTraceIDAttr, trace.traceID)
+		}
+		if len(trace.spanID) > 0 {
+			attrs.SetAttr(OTelSpanIDAttr, trace.spanID)
+		}
+	}
+	return attrs
+}
+
+// The OTLP/JSON encoding of an ExportLogsServiceRequest
+type otlpExportRequest struct {
+	ResourceLogs []*otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource     `json:"resource"`
+	ScopeLogs []*otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope        `json:"scope"`
+	LogRecords []*otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber,omitempty"`
+	SeverityText         string          `json:"severityText,omitempty"`
+	Body                 *otlpAnyValue   `json:"body"`
+	Attributes           []*otlpKeyValue `json:"attributes"`
+	Flags                uint32          `json:"flags,omitempty"`
+	TraceID              string          `json:"traceId,omitempty"`
+	SpanID               string          `json:"spanId,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string        `json:"key"`
+	Value *otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string           `json:"stringValue,omitempty"`
+	BoolValue   *bool             `json:"boolValue,omitempty"`
+	IntValue    string            `json:"intValue,omitempty"`
+	DoubleValue *float64          `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte            `json:"bytesValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []*otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValueList struct {
+	Values []*otlpKeyValue `json:"values"`
+}
+
+func otlpStringValue(val string) *otlpAnyValue {
+	return &otlpAnyValue{StringValue: &val}
+}
+
+// otlpKeyValues converts attrs to a list of OTLP attributes sorted by key,
+// leaving out any keys in skip
+func otlpKeyValues(attrs map[string]interface{}, skip map[string]bool) []*otlpKeyValue {
+	kvs := make([]*otlpKeyValue, 0, len(attrs))
+	for _, key := range sortedAttrKeys(attrs) {
+		if skip[key] {
+			continue
+		}
+		kvs = append(kvs, &otlpKeyValue{
+			Key:   key,
+			Value: otlpValue(attrs[key]),
+		})
+	}
+	return kvs
+}
+
+// otlpValue converts an attribute value to an OTLP AnyValue
+func otlpValue(val interface{}) *otlpAnyValue {
+	var intVal int64
+	switch v := val.(type) {
+	case nil:
+		return &otlpAnyValue{}
+	case string:
+		return otlpStringValue(v)
+	case bool:
+		return &otlpAnyValue{BoolValue: &v}
+	case int:
+		intVal = int64(v)
+	case int8:
+		intVal = int64(v)
+	case int16:
+		intVal = int64(v)
+	case int32:
+		intVal = int64(v)
+	case int64:
+		intVal = v
+	case uint:
+		return otlpUintValue(uint64(v))
+	case uint8:
+		intVal = int64(v)
+	case uint16:
+		intVal = int64(v)
+	case uint32:
+		intVal = int64(v)
+	case uint64:
+		return otlpUintValue(v)
+	case float32:
+		return otlpDoubleValue(float64(v))
+	case float64:
+		return otlpDoubleValue(v)
+	case []byte:
+		return &otlpAnyValue{BytesValue: v}
+	case []interface{}:
+		values := make([]*otlpAnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, otlpValue(item))
+		}
+		return &otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case []string:
+		values := make([]*otlpAnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, otlpStringValue(item))
+		}
+		return &otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case map[string]interface{}:
+		return &otlpAnyValue{KvlistValue: &otlpKeyValueList{Values: otlpKeyValues(v, nil)}}
+	default:
+		return otlpStringValue(attrString(v, time.RFC3339Nano))
+	}
+
+	return &otlpAnyValue{IntValue: strconv.FormatInt(intVal, 10)}
+}
+
+// otlpUintValue converts val to an int value, or a string if it's too large
+// to fit in an int64
+func otlpUintValue(val uint64) *otlpAnyValue {
+	if val > math.MaxInt64 {
+		return otlpStringValue(strconv.FormatUint(val, 10))
+	}
+	return &otlpAnyValue{IntValue: strconv.FormatInt(int64(val), 10)}
+}
+
+// otlpDoubleValue converts val to a double value, or a string if it's NaN or
+// infinite since they can't be written in JSON
+func otlpDoubleValue(val float64) *otlpAnyValue {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return otlpStringValue(strconv.FormatFloat(val, 'g', -1, 64))
+	}
+	return &otlpAnyValue{DoubleValue: &val}
+}
@@ -0,0 +1,386 @@
+package gomol
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type OTelLoggerSuite struct{}
+
+// otelCollector is a stand-in for an OTLP/HTTP collector that records the
+// requests it receives and responds with the queued statuses
+type otelCollector struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	requests []map[string]interface{}
+	headers  []http.Header
+	statuses []int
+}
+
+func newOTelCollector(statuses ...int) *otelCollector {
+	c := &otelCollector{statuses: statuses}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		req := map[string]interface{}{}
+		json.Unmarshal(data, &req)
+
+		c.lock.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header)
+		status := http.StatusOK
+		if len(c.statuses) > 0 {
+			status = c.statuses[0]
+			c.statuses = c.statuses[1:]
+		}
+		c.lock.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	return c
+}
+
+func (c *otelCollector) Requests() []map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]map[string]interface{}{}, c.requests...)
+}
+
+func newTestOTelLogger(endpoint string) (*OTelLogger, *glock.MockClock) {
+	cfg := NewOTelLoggerConfig()
+	cfg.Endpoint = endpoint
+	cfg.MaxRetries = 2
+	cfg.MinRetryDelay = time.Second
+	cfg.MaxRetryDelay = 10 * time.Second
+	cfg.ResourceAttrs = map[string]interface{}{"service.name": "test"}
+
+	l, err := NewOTelLogger(cfg)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	clock.SetCurrent(testTime.Add(time.Second))
+	l.clock = clock
+	return l, clock
+}
+
+// otelRecords returns the log records from a decoded export request
+func otelRecords(req map[string]interface{}) []interface{} {
+	resourceLogs := req["resourceLogs"].([]interface{})[0].(map[string]interface{})
+	scopeLogs := resourceLogs["scopeLogs"].([]interface{})[0].(map[string]interface{})
+	return scopeLogs["logRecords"].([]interface{})
+}
+
+func (s *OTelLoggerSuite) TestNewOTelLoggerNoOutput(t sweet.T) {
+	cfg := NewOTelLoggerConfig()
+	cfg.Endpoint = ""
+	l, err := NewOTelLogger(cfg)
+	Expect(l).To(BeNil())
+	Expect(err).To(Equal(ErrOTelNoOutput))
+}
+
+func (s *OTelLoggerSuite) TestLogmNotInitialized(t sweet.T) {
+	l, _ := newTestOTelLogger("http://127.0.0.1:1/v1/logs")
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+}
+
+func (s *OTelLoggerSuite) TestSeverity(t sweet.T) {
+	tests := []struct {
+		level  LogLevel
+		number int
+		text   string
+	}{
+		{LevelDebug, 5, "DEBUG"},
+		{LevelInfo, 9, "INFO"},
+		{LevelWarning, 13, "WARN"},
+		{LevelError, 17, "ERROR"},
+		{LevelFatal, 21, "FATAL"},
+		{LogLevel(100), 0, ""},
+	}
+	for _, test := range tests {
+		number, text := otelSeverity(test.level)
+		Expect(number).To(Equal(test.number))
+		Expect(text).To(Equal(test.text))
+	}
+}
+
+func (s *OTelLoggerSuite) TestExport(t sweet.T) {
+	collector := newOTelCollector()
+	defer collector.Close()
+
+	l, _ := newTestOTelLogger(collector.URL + "/v1/logs")
+	l.config.Headers = map[string]string{"Authorization": "Bearer token"}
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelWarning, map[string]interface{}{
+		"str":         "val",
+		"int":         1234,
+		"float":       1.5,
+		"bool":        true,
+		"list":        []interface{}{"a", 1},
+		"trace_id":    "0af7651916cd43dd8448eb211c80319c",
+		"span_id":     "b7ad6b7169203331",
+		"trace_flags": 1,
+	}, "the message")).To(BeNil())
+
+	Eventually(collector.Requests).Should(HaveLen(1))
+	Expect(l.Healthy()).To(BeTrue())
+	Expect(collector.headers[0].Get("Content-Type")).To(Equal("application/json"))
+	Expect(collector.headers[0].Get("Authorization")).To(Equal("Bearer token"))
+
+	records := otelRecords(collector.Requests()[0])
+	Expect(records).To(HaveLen(1))
+	Expect(records[0]).To(Equal(map[string]interface{}{
+		"timeUnixNano":         "1488603967890000000",
+		"observedTimeUnixNano": "1488603968890000000",
+		"severityNumber":       float64(13),
+		"severityText":         "WARN",
+		"body":                 map[string]interface{}{"stringValue": "the message"},
+		"traceId":              "0af7651916cd43dd8448eb211c80319c",
+		"spanId":               "b7ad6b7169203331",
+		"flags":                float64(1),
+		"attributes": []interface{}{
+			map[string]interface{}{"key": "bool", "value": map[string]interface{}{"boolValue": true}},
+			map[string]interface{}{"key": "float", "value": map[string]interface{}{"doubleValue": 1.5}},
+			map[string]interface{}{"key": "int", "value": map[string]interface{}{"intValue": "1234"}},
+			map[string]interface{}{"key": "list", "value": map[string]interface{}{"arrayValue": map[string]interface{}{
+				"values": []interface{}{
+					map[string]interface{}{"stringValue": "a"},
+					map[string]interface{}{"intValue": "1"},
+				},
+			}}},
+			map[string]interface{}{"key": "str", "value": map[string]interface{}{"stringValue": "val"}},
+		},
+	}))
+}
+
+func (s *OTelLoggerSuite) TestInvalidTraceIDs(t sweet.T) {
+	l, _ := newTestOTelLogger("http://127.0.0.1:1/v1/logs")
+
+	record := l.newLogRecord(testTime, testTime, LevelInfo, map[string]interface{}{
+		"trace_id": "not hex",
+		"span_id":  "0000000000000000",
+	}, "message")
+	Expect(record.TraceID).To(Equal(""))
+	Expect(record.SpanID).To(Equal(""))
+	Expect(record.Attributes).To(HaveLen(2))
+}
+
+func (s *OTelLoggerSuite) TestTraceAttrsFromContext(t sweet.T) {
+	Expect(TraceAttrsFromContext(context.Background()).Attrs()).To(BeEmpty())
+
+	ctx := ContextWithTrace(context.Background(), "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331")
+	Expect(TraceAttrsFromContext(ctx).Attrs()).To(Equal(map[string]interface{}{
+		OTelTraceIDAttr: "0af7651916cd43dd8448eb211c80319c",
+		OTelSpanIDAttr:  "b7ad6b7169203331",
+	}))
+}
+
+func (s *OTelLoggerSuite) TestBatchWithResourceAttrs(t sweet.T) {
+	collector := newOTelCollector()
+	defer collector.Close()
+
+	l, _ := newTestOTelLogger(collector.URL + "/v1/logs")
+
+	b := NewBase()
+	b.SetAttr("host.name", "myhost")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+
+	for idx := 0; idx < 10; idx++ {
+		b.Infom(NewAttrs().SetAttr("idx", idx), "message")
+	}
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	total := 0
+	for _, req := range collector.Requests() {
+		resource := req["resourceLogs"].([]interface{})[0].(map[string]interface{})["resource"]
+		Expect(resource).To(Equal(map[string]interface{}{
+			"attributes": []interface{}{
+				map[string]interface{}{"key": "host.name", "value": map[string]interface{}{"stringValue": "myhost"}},
+				map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "test"}},
+			},
+		}))
+
+		for _, record := range otelRecords(req) {
+			attrs := record.(map[string]interface{})["attributes"].([]interface{})
+			Expect(attrs).To(HaveLen(1))
+			total++
+		}
+	}
+	Expect(total).To(Equal(10))
+	Expect(len(collector.Requests())).To(BeNumerically("<", 10))
+}
+
+func (s *OTelLoggerSuite) TestRetry(t sweet.T) {
+	collector := newOTelCollector(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer collector.Close()
+
+	l, clock := newTestOTelLogger(collector.URL + "/v1/logs")
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// The batch is retried in the background so Logm doesn't wait for it
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		Eventually(clock.BlockedOnAfter).Should(Equal(1))
+		Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{delay}))
+		clock.Advance(delay)
+	}
+
+	Eventually(collector.Requests).Should(HaveLen(3))
+	Eventually(l.Healthy).Should(BeTrue())
+}
+
+func (s *OTelLoggerSuite) TestRetriesExhausted(t sweet.T) {
+	collector := newOTelCollector(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer collector.Close()
+
+	l, clock := newTestOTelLogger(collector.URL + "/v1/logs")
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	for idx := 0; idx < 2; idx++ {
+		Eventually(clock.BlockedOnAfter).Should(Equal(1))
+		clock.Advance(10 * time.Second)
+	}
+
+	Eventually(errs).Should(Receive(HaveOccurred()))
+	Expect(collector.Requests()).To(HaveLen(3))
+	Expect(l.Healthy()).To(BeFalse())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(collector.Requests).Should(HaveLen(4))
+	Eventually(l.Healthy).Should(BeTrue())
+}
+
+func (s *OTelLoggerSuite) TestPermanentError(t sweet.T) {
+	collector := newOTelCollector(http.StatusInternalServerError)
+	defer collector.Close()
+
+	l, _ := newTestOTelLogger(collector.URL + "/v1/logs")
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	// OTLP only allows a few statuses to be retried, which doesn't include
+	// every server error
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+
+	var err error
+	Eventually(errs).Should(Receive(&err))
+	Expect(err.Error()).To(ContainSubstring("500"))
+	Expect(collector.Requests()).To(HaveLen(1))
+	Expect(l.Healthy()).To(BeFalse())
+}
+
+func (s *OTelLoggerSuite) TestBufferFull(t sweet.T) {
+	collector := newOTelCollector(http.StatusServiceUnavailable)
+	defer collector.Close()
+
+	l, clock := newTestOTelLogger(collector.URL + "/v1/logs")
+	l.config.BufferSize = 1
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "first")).To(BeNil())
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+
+	// While the first batch is being retried the second waits to be
+	// exported and is dropped to make room for the third
+	Expect(l.Logm(testTime, LevelInfo, nil, "second")).To(BeNil())
+	Expect(l.Logm(testTime, LevelInfo, nil, "third")).To(BeNil())
+	Eventually(errs).Should(Receive(Equal(ErrSendBufferFull)))
+
+	clock.Advance(time.Second)
+	Eventually(collector.Requests).Should(HaveLen(3))
+
+	bodies := []interface{}{}
+	for _, req := range collector.Requests() {
+		bodies = append(bodies, otelRecords(req)[0].(map[string]interface{})["body"])
+	}
+	Expect(bodies).To(Equal([]interface{}{
+		map[string]interface{}{"stringValue": "first"},
+		map[string]interface{}{"stringValue": "first"},
+		map[string]interface{}{"stringValue": "third"},
+	}))
+}
+
+func (s *OTelLoggerSuite) TestShutdownStopsRetrying(t sweet.T) {
+	collector := newOTelCollector(http.StatusServiceUnavailable)
+	defer collector.Close()
+
+	l, clock := newTestOTelLogger(collector.URL + "/v1/logs")
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	l.SetBase(b)
+	Expect(l.InitLogger()).To(BeNil())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Expect(l.ShutdownLoggerContext(ctx)).To(BeNil())
+	Eventually(errs).Should(Receive(HaveOccurred()))
+	Expect(collector.Requests()).To(HaveLen(1))
+}
+
+func (s *OTelLoggerSuite) TestFile(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol-otel")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	cfg := NewOTelLoggerConfig()
+	cfg.Endpoint = ""
+	cfg.FilePath = filepath.Join(dir, "logs.jsonl")
+	l, err := NewOTelLogger(cfg)
+	Expect(err).To(BeNil())
+	Expect(l.InitLogger()).To(BeNil())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "first")).To(BeNil())
+	Expect(l.LogBatch([]*Message{
+		newMessage(testTime, nil, LevelError, nil, "second"),
+		newMessage(testTime, nil, LevelError, nil, "third"),
+	})).To(BeNil())
+	Expect(l.ShutdownLogger()).To(BeNil())
+
+	data, err := ioutil.ReadFile(cfg.FilePath)
+	Expect(err).To(BeNil())
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	Expect(lines).To(HaveLen(2))
+
+	counts := []int{}
+	for _, line := range lines {
+		req := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(line), &req)).To(BeNil())
+		counts = append(counts, len(otelRecords(req)))
+	}
+	Expect(counts).To(Equal([]int{1, 2}))
+}
@@ -1,23 +1,86 @@
 package gomol
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type queue struct {
+	// These must be at the beginning of the struct due to
+	// how 64 bit atomic values are handled on 32 bit systems
+	// in Go.
+	//
+	// queued is the number of messages that have been added to the
+	// queue and done is the number of them that have been written or
+	// dropped.  A flush waits for done to reach the value queued had
+	// when it started, so messages logged while it's waiting don't
+	// keep it from returning.
+	queued uint64
+	done   uint64
+
 	base      *Base
+	spool     *spool
 	running   bool
 	finished  chan struct{}
 	queueChan chan *Message
+
+	// flushWake is signaled by a flush so the worker passes a partial
+	// batch to the BatchLoggers instead of waiting for it to fill up.
+	flushWake chan struct{}
+	flushing  int32
+
+	waiterLock sync.Mutex
+	waiters    []*flushWaiter
+
+	// batchLen is the number of messages the worker is holding for
+	// BatchLoggers, including a batch that is currently being written.
+	batchLen int32
+
+	// writingSet holds the loggers the worker is currently passing a
+	// message or batch to and writingIdx the index of the logger in it
+	// plus one, or zero if it isn't passing one to a logger, so a flush
+	// that gives up can report it.  These are updated for every logger
+	// a message is written to so they're kept as a pointer and an
+	// integer, which can be stored without allocating.
+	writingSet   atomic.Value
+	writingIdx   int32
+	writingBatch int32
+
+	// retries holds the messages each logger returned an error for so
+	// they can be passed to it again before its next message, and
+	// retrySet is the set of loggers retries was last checked against so
+	// loggers that have been removed can be forgotten.  These are only
+	// used by the worker.
+	retries  map[Logger][]*Message
+	retrySet *loggerSet
+}
+
+// flushWaiter is a flush waiting for done to reach target
+type flushWaiter struct {
+	target uint64
+	ready  chan struct{}
 }
 
 func newQueue(base *Base, maxQueueSize uint) *queue {
-	return &queue{
+	q := &queue{
 		base:      base,
 		running:   false,
 		finished:  make(chan struct{}),
 		queueChan: make(chan *Message, maxQueueSize),
+		flushWake: make(chan struct{}, 1),
+		retries:   make(map[Logger][]*Message),
+	}
+
+	// The worker acks messages in the Base's spool, so it keeps the
+	// spool open until it stops even if the Base has shut down by then.
+	if base.spool != nil && base.spool.retain() {
+		q.spool = base.spool
 	}
+
+	return q
 }
 
 func (queue *queue) startWorker() error {
@@ -32,82 +95,458 @@ func (queue *queue) startWorker() error {
 }
 
 func (queue *queue) stopWorker() error {
+	return queue.stopWorkerContext(context.Background())
+}
+
+// stopWorkerContext stops accepting new messages and waits for the worker to
+// write everything left in the queue or for ctx to be done, whichever
+// happens first.
+func (queue *queue) stopWorkerContext(ctx context.Context) error {
 	if !queue.running {
 		return errors.New("workers are not running")
 	}
 
 	queue.running = false
 	close(queue.queueChan)
-	queue.flush()
 
-	return nil
+	// Prefer reporting success if the worker has already stopped
+	// even if ctx happens to be done as well.
+	select {
+	case <-queue.finished:
+		return nil
+	default:
+	}
+
+	select {
+	case <-queue.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (queue *queue) work() {
 	defer close(queue.finished)
+	defer func() {
+		if queue.spool != nil {
+			queue.spool.release()
+		}
+	}()
+
+	// batch holds messages waiting to be passed to BatchLoggers, and
+	// batchTimeout fires once the oldest of them has waited long enough.
+	var batch []*Message
+	var batchTimeout <-chan time.Time
 
 	for {
 		// First, try to consume _all_ messages which are
-		// currently on the channel. If we hit the default
-		// block here it's because there's no message ready
-		// to process.
+		// currently on the channel so they can be batched
+		// together. If we hit the default block here it's
+		// because there's no message ready to process.
 
 		select {
 		case msg, ok := <-queue.queueChan:
 			if !ok {
+				queue.writeBatch(batch)
 				return
 			}
 
-			queue.write(msg)
+			batch, batchTimeout = queue.process(msg, batch, batchTimeout)
+			continue
+		case <-batchTimeout:
+			batch, batchTimeout = queue.writeBatch(batch), nil
 			continue
 		default:
 		}
 
-		// In that case, we're going to either try to process
-		// another message, or if someone is waiting in another
-		// goroutine for us to finish the queue (a flush sync),
-		// then we'll throw a value on that channel to inform
-		// them that we had a bit of downtime.
+		// A flush can't finish while a batch is still waiting, so
+		// write it now rather than when it fills up or times out.
+		// If messages never stop arriving a flush still finishes
+		// once the batch fills up or Config.MaxBatchWait passes.
+		if len(batch) > 0 && atomic.LoadInt32(&queue.flushing) > 0 {
+			batch, batchTimeout = queue.writeBatch(batch), nil
+		}
 
 		select {
 		case msg, ok := <-queue.queueChan:
 			if !ok {
+				queue.writeBatch(batch)
 				return
 			}
 
-			queue.write(msg)
-		case queue.finished <- struct{}{}:
+			batch, batchTimeout = queue.process(msg, batch, batchTimeout)
+		case <-batchTimeout:
+			batch, batchTimeout = queue.writeBatch(batch), nil
+		case <-queue.flushWake:
 		}
 	}
 }
 
-func (queue *queue) write(msg *Message) {
+// process writes msg to the loggers that take one message at a time and adds
+// it to batch if there are any BatchLoggers to pass it to later.  It returns
+// the updated batch and the channel that fires when the batch needs to be
+// written.
+func (queue *queue) process(msg *Message, batch []*Message, batchTimeout <-chan time.Time) ([]*Message, <-chan time.Time) {
+	if !queue.write(msg) {
+		ackMessage(msg)
+		queue.markDone(1)
+		return batch, batchTimeout
+	}
+
+	cfg := msg.base.config()
+	if len(batch) == 0 {
+		batchTimeout = queue.base.clock.After(cfg.MaxBatchWait)
+	}
+	batch = append(batch, msg)
+	atomic.AddInt32(&queue.batchLen, 1)
+
+	if uint(len(batch)) >= cfg.MaxBatchSize {
+		return queue.writeBatch(batch), nil
+	}
+	return batch, batchTimeout
+}
+
+// write passes msg to each logger it's routed to that isn't a BatchLogger and
+// to the fallback logger if needed.  It returns true if msg should also be
+// batched for the BatchLoggers.
+func (queue *queue) write(msg *Message) bool {
 	if msg == nil {
-		return
+		return false
+	}
+
+	// Use the loggers the message was logged to so loggers being added
+	// or removed since then don't change where it's written.
+	set := msg.loggerSet()
+	if set != queue.retrySet {
+		queue.forgetRetries(set)
 	}
 
-	unhealthy := len(msg.base.loggers) == 0
-	for _, l := range msg.base.loggers {
+	if router := msg.base.router(); router != nil {
+		msg.routing = router.route(msg)
+	}
+
+	unhealthy := len(set.loggers) == 0
+	batched := false
+	for idx, l := range set.loggers {
+		if !msg.routedTo(l) {
+			continue
+		}
 		if hcLogger, ok := l.(HealthCheckLogger); ok {
 			if !hcLogger.Healthy() {
 				unhealthy = true
 			}
 		}
-		l.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+		if _, ok := l.(BatchLogger); ok {
+			batched = true
+			continue
+		}
+		queue.setWriting(set, idx+1, false)
+		queue.logm(l, msg)
 	}
-	if unhealthy && msg.base.fallbackLogger != nil {
+	if unhealthy && set.fallbackLogger != nil {
 		logFallback := true
-		if hcLogger, ok := msg.base.fallbackLogger.(HealthCheckLogger); ok {
+		if hcLogger, ok := set.fallbackLogger.(HealthCheckLogger); ok {
 			logFallback = hcLogger.Healthy()
 		}
 		if logFallback {
-			msg.base.fallbackLogger.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+			queue.setWriting(set, len(set.loggers)+1, false)
+			queue.logm(set.fallbackLogger, msg)
+		}
+	}
+	atomic.StoreInt32(&queue.writingIdx, 0)
+
+	return batched
+}
+
+// writeBatch passes batch to each BatchLogger its messages were logged to and
+// returns an empty batch to start collecting into.
+func (queue *queue) writeBatch(batch []*Message) []*Message {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	loggers := batchLoggersOf(batch)
+	writingSet := &loggerSet{loggers: make([]Logger, 0, len(loggers))}
+	for _, l := range loggers {
+		writingSet.loggers = append(writingSet.loggers, l)
+	}
+	for idx, l := range loggers {
+		routed := routedBatch(batch, l)
+		if len(routed) == 0 {
+			continue
+		}
+		queue.setWriting(writingSet, idx+1, true)
+		if queue.retryFailed(l) != nil {
+			queue.addRetries(l, routed)
+			continue
+		}
+		if err := l.LogBatch(routed); err != nil {
+			queue.base.report(err)
+			queue.addRetries(l, routed)
+		}
+	}
+	atomic.StoreInt32(&queue.writingIdx, 0)
+	atomic.StoreInt32(&queue.batchLen, 0)
+
+	// Messages a logger failed to write are retried from memory so
+	// they're acked even if one did.
+	for _, msg := range batch {
+		ackMessage(msg)
+	}
+	queue.markDone(len(batch))
+
+	// Loggers may hold on to the batch they were given so don't reuse it.
+	return nil
+}
+
+// logm passes msg to l after any messages l failed to write before.  If l
+// returns an error, or the earlier messages still can't be written, msg is kept
+// to be passed to it again.
+func (queue *queue) logm(l Logger, msg *Message) {
+	if queue.retryFailed(l) != nil {
+		queue.addRetries(l, []*Message{msg})
+		return
+	}
+	if err := l.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg); err != nil {
+		queue.addRetries(l, []*Message{msg})
+	}
+}
+
+// retryFailed passes the messages l failed to write before to it again, in
+// the order they were logged.  It stops at the first error and returns it,
+// keeping the messages that still haven't been written.
+func (queue *queue) retryFailed(l Logger) error {
+	failed := queue.retries[l]
+	if len(failed) == 0 {
+		return nil
+	}
+
+	bl, isBatch := l.(BatchLogger)
+	maxBatch := int(queue.base.config().MaxBatchSize)
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	for len(failed) > 0 {
+		var err error
+		written := 1
+		if isBatch {
+			if written = len(failed); written > maxBatch {
+				written = maxBatch
+			}
+			err = bl.LogBatch(failed[:written])
+		} else {
+			msg := failed[0]
+			err = l.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+		}
+		if err != nil {
+			queue.retries[l] = failed
+			return err
+		}
+		failed = failed[written:]
+	}
+
+	delete(queue.retries, l)
+	return nil
+}
+
+// addRetries keeps msgs to be passed to l again, dropping the oldest messages
+// kept for l if it has more than Config.MaxRetrySize of them
+func (queue *queue) addRetries(l Logger, msgs []*Message) {
+	max := int(queue.base.config().MaxRetrySize)
+
+	// Copy so a batch shared with other loggers isn't changed
+	failed := append(queue.retries[l][:len(queue.retries[l]):len(queue.retries[l])], msgs...)
+	if len(failed) > max {
+		failed = failed[len(failed)-max:]
+		queue.base.report(ErrMessageDropped)
+	}
+	if len(failed) == 0 {
+		delete(queue.retries, l)
+		return
+	}
+	queue.retries[l] = failed
+}
+
+// forgetRetries drops the messages kept for loggers that aren't in set
+func (queue *queue) forgetRetries(set *loggerSet) {
+	queue.retrySet = set
+	for l := range queue.retries {
+		if l != set.fallbackLogger && !set.contains(l) {
+			delete(queue.retries, l)
+		}
+	}
+}
+
+// batchLoggersOf returns every BatchLogger the messages in batch were logged
+// to.  Loggers added or removed while the batch was collected mean messages
+// in the same batch can have different sets of loggers.
+func batchLoggersOf(batch []*Message) []BatchLogger {
+	set := batch[0].loggerSet()
+	loggers := set.batchLoggers
+	for _, msg := range batch[1:] {
+		msgSet := msg.loggerSet()
+		if msgSet == set {
+			continue
+		}
+		set = msgSet
+
+		for _, l := range msgSet.batchLoggers {
+			if !containsBatchLogger(loggers, l) {
+				// Copy before appending so the set's slice
+				// isn't changed.
+				loggers = append(loggers[:len(loggers):len(loggers)], l)
+			}
 		}
 	}
+	return loggers
+}
+
+func containsBatchLogger(loggers []BatchLogger, l BatchLogger) bool {
+	for _, batchLogger := range loggers {
+		if batchLogger == l {
+			return true
+		}
+	}
+	return false
+}
+
+// routedBatch returns the messages in batch that were logged to l and are
+// routed to it.  The batch itself is returned if all of them are.
+func routedBatch(batch []*Message, l BatchLogger) []*Message {
+	for idx, msg := range batch {
+		if batchedTo(msg, l) {
+			continue
+		}
+
+		routed := make([]*Message, idx, len(batch)-1)
+		copy(routed, batch[:idx])
+		for _, msg := range batch[idx+1:] {
+			if batchedTo(msg, l) {
+				routed = append(routed, msg)
+			}
+		}
+		return routed
+	}
+	return batch
+}
+
+func batchedTo(msg *Message, l BatchLogger) bool {
+	return msg.routedTo(l) && containsBatchLogger(msg.loggerSet().batchLoggers, l)
+}
+
+// setWriting records that the worker is passing a message or batch to the
+// logger at idx-1 in set, or to set's fallback logger if idx-1 is past the end
+// of its loggers
+func (queue *queue) setWriting(set *loggerSet, idx int, batch bool) {
+	// The index is cleared first so writingTo never pairs it with the
+	// wrong set.
+	atomic.StoreInt32(&queue.writingIdx, 0)
+	queue.writingSet.Store(set)
+	if batch {
+		atomic.StoreInt32(&queue.writingBatch, 1)
+	} else {
+		atomic.StoreInt32(&queue.writingBatch, 0)
+	}
+	atomic.StoreInt32(&queue.writingIdx, int32(idx))
+}
+
+// writingTo returns the logger the worker is currently writing a message
+// to, or nil if it isn't writing a message, and whether it is writing a
+// batch to the logger.
+func (queue *queue) writingTo() (Logger, bool) {
+	idx := int(atomic.LoadInt32(&queue.writingIdx))
+	if idx == 0 {
+		return nil, false
+	}
+	set, ok := queue.writingSet.Load().(*loggerSet)
+	if !ok {
+		return nil, false
+	}
+	batch := atomic.LoadInt32(&queue.writingBatch) == 1
+
+	if idx > len(set.loggers) {
+		return set.fallbackLogger, batch
+	}
+	return set.loggers[idx-1], batch
+}
+
+// markDone records that count more messages have been written or dropped and
+// wakes up any flushes that were waiting for them.
+func (queue *queue) markDone(count int) {
+	done := atomic.AddUint64(&queue.done, uint64(count))
+	if atomic.LoadInt32(&queue.flushing) == 0 {
+		return
+	}
+
+	queue.waiterLock.Lock()
+	defer queue.waiterLock.Unlock()
+
+	waiting := queue.waiters[:0]
+	for _, waiter := range queue.waiters {
+		if done >= waiter.target {
+			close(waiter.ready)
+		} else {
+			waiting = append(waiting, waiter)
+		}
+	}
+	queue.waiters = waiting
 }
 
 func (queue *queue) flush() {
-	<-queue.finished
+	queue.flushContext(context.Background())
+}
+
+// flushContext waits until the worker has written all the messages that were
+// queued when it was called or ctx is done, whichever happens first.
+func (queue *queue) flushContext(ctx context.Context) error {
+	atomic.AddInt32(&queue.flushing, 1)
+	defer atomic.AddInt32(&queue.flushing, -1)
+
+	// The waiter is added with the lock held and done checked after
+	// flushing is set so markDone can't miss it.
+	waiter := &flushWaiter{
+		target: atomic.LoadUint64(&queue.queued),
+		ready:  make(chan struct{}),
+	}
+	queue.waiterLock.Lock()
+	if atomic.LoadUint64(&queue.done) >= waiter.target {
+		queue.waiterLock.Unlock()
+		return nil
+	}
+	queue.waiters = append(queue.waiters, waiter)
+	queue.waiterLock.Unlock()
+
+	select {
+	case queue.flushWake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-queue.finished:
+		// Once the worker has stopped nothing else will be written
+		queue.removeWaiter(waiter)
+		if atomic.LoadUint64(&queue.done) >= waiter.target {
+			return nil
+		}
+		return errors.New("the queue worker stopped before the flush finished")
+	case <-ctx.Done():
+		queue.removeWaiter(waiter)
+		return ctx.Err()
+	}
+}
+
+func (queue *queue) removeWaiter(waiter *flushWaiter) {
+	queue.waiterLock.Lock()
+	defer queue.waiterLock.Unlock()
+
+	for idx, w := range queue.waiters {
+		if w == waiter {
+			queue.waiters = append(queue.waiters[:idx], queue.waiters[idx+1:]...)
+			return
+		}
+	}
 }
 
 func (queue *queue) queueMessage(msg *Message) error {
@@ -115,6 +554,8 @@ func (queue *queue) queueMessage(msg *Message) error {
 		return errors.New("the logging system is not running - has InitLoggers() been executed?")
 	}
 
+	atomic.AddUint64(&queue.queued, 1)
+
 loop:
 	for {
 		// Attempt to queue the message immediately to
@@ -133,7 +574,10 @@ loop:
 		// eating from the front until we finally make it in.
 
 		select {
-		case <-queue.queueChan:
+		case dropped := <-queue.queueChan:
+			// The message was dropped on purpose so don't replay it
+			ackMessage(dropped)
+			queue.markDone(1)
 			queue.base.report(ErrMessageDropped)
 		default:
 		}
@@ -142,6 +586,18 @@ loop:
 	return nil
 }
 
+// enqueue adds msg to the queue, waiting for room instead of dropping the
+// oldest message if the queue is full
+func (queue *queue) enqueue(msg *Message) {
+	atomic.AddUint64(&queue.queued, 1)
+	queue.queueChan <- msg
+}
+
 func (queue *queue) pressure() int {
 	return len(queue.queueChan)
 }
+
+// batched returns the number of messages being held for BatchLoggers
+func (queue *queue) batched() int {
+	return int(atomic.LoadInt32(&queue.batchLen))
+}
@@ -1,9 +1,12 @@
 package gomol
 
 import (
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
 	. "github.com/onsi/gomega"
 )
 
@@ -73,3 +76,270 @@ func (s *GomolSuite) TestQueueFlushMessages(t sweet.T) {
 	q.stopWorker()
 	Expect(q.pressure()).To(Equal(0))
 }
+
+type batchMemLogger struct {
+	*memLogger
+
+	batchLock sync.Mutex
+	batches   []int
+}
+
+func newBatchMemLogger() *batchMemLogger {
+	return &batchMemLogger{
+		memLogger: newDefaultMemLogger(),
+		batches:   make([]int, 0),
+	}
+}
+
+func (l *batchMemLogger) LogBatch(msgs []*Message) error {
+	l.batchLock.Lock()
+	l.batches = append(l.batches, len(msgs))
+	l.batchLock.Unlock()
+
+	for _, msg := range msgs {
+		l.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+	}
+	return nil
+}
+
+func (l *batchMemLogger) Batches() []int {
+	l.batchLock.Lock()
+	defer l.batchLock.Unlock()
+	return append([]int{}, l.batches...)
+}
+
+func (s *GomolSuite) TestQueueBatchMaxSize(t sweet.T) {
+	ml := newDefaultMemLogger()
+	bl := newBatchMemLogger()
+
+	b := NewBase(withClock(glock.NewMockClock()))
+	cfg := NewConfig()
+	cfg.MaxBatchSize = 10
+	b.SetConfig(cfg)
+	b.AddLogger(ml)
+	b.AddLogger(bl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	for i := 0; i < 25; i++ {
+		b.Infof("test %d", i)
+	}
+	Eventually(bl.Batches).Should(Equal([]int{10, 10}))
+
+	// The last 5 messages don't fill a batch but are delivered on Flush
+	b.Flush()
+	Expect(bl.Batches()).To(Equal([]int{10, 10, 5}))
+	Expect(bl.Messages()).To(HaveLen(25))
+	Expect(bl.Messages()[24].Message).To(Equal("test 24"))
+	Expect(ml.Messages()).To(HaveLen(25))
+}
+
+func (s *GomolSuite) TestQueueBatchMaxWait(t sweet.T) {
+	clock := glock.NewMockClock()
+	bl := newBatchMemLogger()
+
+	b := NewBase(withClock(clock))
+	cfg := NewConfig()
+	cfg.MaxBatchWait = time.Second
+	b.SetConfig(cfg)
+	b.AddLogger(bl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	b.Info("test 3")
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{time.Second}))
+	Consistently(bl.Batches).Should(HaveLen(0))
+
+	clock.Advance(time.Second)
+	Eventually(bl.Batches).Should(Equal([]int{3}))
+	Expect(bl.Messages()).To(HaveLen(3))
+}
+
+func (s *GomolSuite) TestQueueBatchShutdown(t sweet.T) {
+	bl := newBatchMemLogger()
+
+	b := NewBase(withClock(glock.NewMockClock()))
+	b.AddLogger(bl)
+	b.InitLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	b.ShutdownLoggers()
+
+	Expect(bl.Batches()).To(Equal([]int{2}))
+	Expect(bl.Messages()).To(HaveLen(2))
+}
+
+func (s *GomolSuite) TestQueueBatchRemovedLogger(t sweet.T) {
+	bl := newBatchMemLogger()
+
+	b := NewBase(withClock(glock.NewMockClock()))
+	b.AddLogger(bl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	Expect(b.RemoveLogger(bl)).To(BeNil())
+
+	// The batch was waiting on the clock but it's written before the
+	// logger is shut down.
+	Expect(bl.Batches()).To(Equal([]int{2}))
+	Expect(bl.Messages()).To(HaveLen(2))
+	Expect(bl.IsInitialized()).To(BeFalse())
+}
+
+func (s *GomolSuite) TestQueueBatchAddedLogger(t sweet.T) {
+	bl1 := newBatchMemLogger()
+	bl2 := newBatchMemLogger()
+
+	b := NewBase(withClock(glock.NewMockClock()))
+	b.AddLogger(bl1)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	Expect(b.AddLogger(bl2)).To(BeNil())
+	b.Info("test 3")
+	b.Flush()
+
+	Expect(memMessageTexts(bl1.memLogger)).To(Equal([]string{"test 1", "test 2", "test 3"}))
+	Expect(memMessageTexts(bl2.memLogger)).To(Equal([]string{"test 3"}))
+}
+
+type errorBatchLogger struct {
+	*batchMemLogger
+}
+
+func (l *errorBatchLogger) LogBatch(msgs []*Message) error {
+	l.batchMemLogger.LogBatch(msgs)
+	return errors.New("batch failed")
+}
+
+func (s *GomolSuite) TestQueueBatchErrorReported(t sweet.T) {
+	el := &errorBatchLogger{batchMemLogger: newBatchMemLogger()}
+	errChan := make(chan error, 1)
+
+	b := NewBase()
+	b.SetErrorChan(errChan)
+	b.AddLogger(el)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test")
+	b.Flush()
+
+	Expect(errChan).To(Receive(MatchError("batch failed")))
+}
+
+// flakyLogger returns an error instead of logging a message while it's set
+// to fail
+type flakyLogger struct {
+	*memLogger
+
+	failLock sync.Mutex
+	fail     bool
+}
+
+func (l *flakyLogger) setFail(fail bool) {
+	l.failLock.Lock()
+	defer l.failLock.Unlock()
+	l.fail = fail
+}
+
+func (l *flakyLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.failLock.Lock()
+	fail := l.fail
+	l.failLock.Unlock()
+
+	if fail {
+		return errors.New("log failed")
+	}
+	return l.memLogger.Logm(timestamp, level, attrs, msg)
+}
+
+func (s *GomolSuite) TestQueueRetryFailed(t sweet.T) {
+	fl := &flakyLogger{memLogger: newDefaultMemLogger(), fail: true}
+	b, ml := newTestBase()
+	b.AddLogger(fl)
+	defer b.ShutdownLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	b.Flush()
+	Expect(fl.Messages()).To(BeEmpty())
+
+	fl.setFail(false)
+	b.Info("test 3")
+	b.Flush()
+
+	Expect(memMessageTexts(fl.memLogger)).To(Equal([]string{"test 1", "test 2", "test 3"}))
+	Expect(memMessageTexts(ml)).To(Equal([]string{"test 1", "test 2", "test 3"}))
+}
+
+func (s *GomolSuite) TestQueueRetryMaxSize(t sweet.T) {
+	cfg := NewConfig()
+	cfg.MaxRetrySize = 2
+	errChan := make(chan error, 10)
+
+	fl := &flakyLogger{memLogger: newDefaultMemLogger(), fail: true}
+	b := NewBase()
+	b.SetConfig(cfg)
+	b.SetErrorChan(errChan)
+	b.AddLogger(fl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test 1")
+	b.Info("test 2")
+	b.Info("test 3")
+	b.Flush()
+	Expect(errChan).To(Receive(Equal(ErrMessageDropped)))
+
+	fl.setFail(false)
+	b.Info("test 4")
+	b.Flush()
+
+	Expect(memMessageTexts(fl.memLogger)).To(Equal([]string{"test 2", "test 3", "test 4"}))
+}
+
+func (s *GomolSuite) TestQueueRetryFailedBatch(t sweet.T) {
+	el := &errorBatchLogger{batchMemLogger: newBatchMemLogger()}
+
+	b := NewBase()
+	b.AddLogger(el)
+	b.InitLoggers()
+
+	b.Info("test 1")
+	b.Flush()
+	b.Info("test 2")
+	b.Flush()
+	b.ShutdownLoggers()
+
+	// The failed batch is passed to it again before the next one
+	Expect(memMessageTexts(el.memLogger)).To(Equal([]string{"test 1", "test 1"}))
+}
+
+func (s *GomolSuite) TestQueueBatchUnhealthyFallback(t sweet.T) {
+	bl := newBatchMemLogger()
+	bl.SetHealthy(false)
+	fl := newDefaultMemLogger()
+	fl.SetHealthy(true)
+
+	b := NewBase()
+	b.AddLogger(bl)
+	b.SetFallbackLogger(fl)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("test")
+	b.Flush()
+
+	Expect(bl.Messages()).To(HaveLen(1))
+	Expect(fl.Messages()).To(HaveLen(1))
+}
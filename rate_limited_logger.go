@@ -0,0 +1,440 @@
+package gomol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// RateLimitPolicy is what a RateLimitedLogger does with messages that are over
+// its limits
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop drops messages that are over the limits
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitFallback logs messages that are over the limits to the
+	// Base's fallback logger instead.  If the Base doesn't have a fallback
+	// logger they're dropped.
+	RateLimitFallback
+	// RateLimitSummary drops messages that are over the limits and logs a
+	// summary of how many were dropped before the next message that is
+	// within the limits
+	RateLimitSummary
+)
+
+// ErrRateLimited is reported to the Base's error channel when a
+// RateLimitedLogger drops a message because it's over the limits
+var ErrRateLimited = errors.New("message dropped by the rate limiter")
+
+// RateLimitedLoggerConfig is the configuration for a RateLimitedLogger
+type RateLimitedLoggerConfig struct {
+	// MessagesPerSecond is how many messages can be logged each second.  If
+	// it is 0 the number of messages isn't limited.
+	MessagesPerSecond float64
+
+	// MessageBurst is how many messages can be logged at once after none
+	// have been logged for a while
+	MessageBurst int
+
+	// BytesPerSecond is how many bytes of messages can be logged each
+	// second.  A message's size is estimated as the length of the message
+	// plus the lengths of its attribute names and values.  If it is 0 the
+	// number of bytes isn't limited.
+	BytesPerSecond float64
+
+	// ByteBurst is how many bytes of messages can be logged at once after
+	// none have been logged for a while
+	ByteBurst int
+
+	// KeyAttr is the name of an attribute, such as a tenant, that messages
+	// are limited by separately.  Messages without the attribute share a
+	// limit.  If it is empty all messages share a limit.
+	KeyAttr string
+
+	// Policy is what's done with messages that are over the limits
+	Policy RateLimitPolicy
+
+	// SummaryFormat is the format string of the summary logged with
+	// RateLimitSummary, given the number of messages that were dropped
+	SummaryFormat string
+
+	// DroppedAttr is the name of the attribute the number of dropped
+	// messages is added to the summary as
+	DroppedAttr string
+}
+
+// NewRateLimitedLoggerConfig creates a new RateLimitedLoggerConfig that
+// limits messages to 100 each second and drops any over the limit
+func NewRateLimitedLoggerConfig() *RateLimitedLoggerConfig {
+	return &RateLimitedLoggerConfig{
+		MessagesPerSecond: 100,
+		MessageBurst:      100,
+		BytesPerSecond:    0,
+		ByteBurst:         0,
+		KeyAttr:           "",
+		Policy:            RateLimitDrop,
+		SummaryFormat:     "%d messages were dropped by the rate limiter",
+		DroppedAttr:       "rate_limited",
+	}
+}
+
+/*
+RateLimitedLogger wraps another Logger and limits how many messages and bytes
+are logged to it each second using token buckets.  This keeps outputs with a
+budget, such as a paid logging service, from going over it when something logs
+far more than usual.  Every message that's dropped is reported to the Base's
+error channel as ErrRateLimited.
+
+With RateLimitSummary a summary of the dropped messages is logged, at the level
+of the most severe one, before the next message with the same key that's
+within the limits or when the logger is shut down.
+
+Dropping a message is deliberate so Logm doesn't return an error for it.  If
+the wrapped Logger is a BatchLogger, NewRateLimitedLogger returns a BatchLogger
+as well and the messages within the limits are passed to it in batches.
+*/
+type RateLimitedLogger struct {
+	logger Logger
+	config *RateLimitedLoggerConfig
+	clock  glock.Clock
+
+	lock    sync.Mutex
+	base    *Base
+	buckets map[string]*rateLimitBucket
+}
+
+type rateLimitBucket struct {
+	messages float64
+	bytes    float64
+	last     time.Time
+
+	dropped      uint64
+	droppedLevel LogLevel
+}
+
+// rateLimitedBatchLogger is a RateLimitedLogger wrapping a BatchLogger
+type rateLimitedBatchLogger struct {
+	*RateLimitedLogger
+}
+
+var _ Logger = &RateLimitedLogger{}
+var _ HealthCheckLogger = &RateLimitedLogger{}
+var _ BatchLogger = &rateLimitedBatchLogger{}
+var _ HealthCheckLogger = &rateLimitedBatchLogger{}
+
+// NewRateLimitedLogger creates a new RateLimitedLogger wrapping logger.  If
+// logger is a BatchLogger the returned logger is one as well.  If config is
+// nil the values from NewRateLimitedLoggerConfig are used.
+func NewRateLimitedLogger(logger Logger, config *RateLimitedLoggerConfig) (HealthCheckLogger, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("a logger is required")
+	}
+	if config == nil {
+		config = NewRateLimitedLoggerConfig()
+	}
+
+	if config.MessagesPerSecond < 0 || config.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("rate limits can't be negative")
+	}
+	if config.MessagesPerSecond > 0 && config.MessageBurst < 1 {
+		return nil, fmt.Errorf("the message burst must be at least 1")
+	}
+	if config.BytesPerSecond > 0 && config.ByteBurst < 1 {
+		return nil, fmt.Errorf("the byte burst must be at least 1")
+	}
+	switch config.Policy {
+	case RateLimitDrop, RateLimitFallback, RateLimitSummary:
+	default:
+		return nil, fmt.Errorf("unknown rate limit policy: %d", config.Policy)
+	}
+
+	l := &RateLimitedLogger{
+		logger:  logger,
+		config:  config,
+		clock:   glock.NewRealClock(),
+		buckets: make(map[string]*rateLimitBucket),
+	}
+	if _, ok := logger.(BatchLogger); ok {
+		return &rateLimitedBatchLogger{l}, nil
+	}
+	return l, nil
+}
+
+// SetBase will set the gomol.Base this logger and the wrapped logger are
+// associated with
+func (l *RateLimitedLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	l.base = base
+	l.lock.Unlock()
+
+	l.logger.SetBase(base)
+}
+
+// InitLogger initializes the wrapped logger
+func (l *RateLimitedLogger) InitLogger() error {
+	return l.logger.InitLogger()
+}
+
+// IsInitialized returns whether the wrapped logger has been initialized
+func (l *RateLimitedLogger) IsInitialized() bool {
+	return l.logger.IsInitialized()
+}
+
+// ShutdownLogger logs the summaries of any dropped messages with
+// RateLimitSummary and shuts down the wrapped logger
+func (l *RateLimitedLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	var summaries []*Message
+	for key, bucket := range l.buckets {
+		if summary := l.summary(key, bucket); summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+	l.lock.Unlock()
+
+	l.write(summaries)
+	return l.logger.ShutdownLogger()
+}
+
+// Healthy returns whether the wrapped logger is healthy.  Messages being over
+// the limits doesn't make the logger unhealthy.
+func (l *RateLimitedLogger) Healthy() bool {
+	if hcLogger, ok := l.logger.(HealthCheckLogger); ok {
+		return hcLogger.Healthy()
+	}
+	return true
+}
+
+// Logm passes the message to the wrapped logger if it's within the limits
+func (l *RateLimitedLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	return l.write(l.limit([]*Message{{
+		Timestamp: timestamp,
+		Level:     level,
+		Attrs:     NewAttrsFromMap(attrs),
+		Msg:       msg,
+	}}))
+}
+
+// LogBatch passes the messages in msgs that are within the limits to the
+// wrapped logger
+func (l *rateLimitedBatchLogger) LogBatch(msgs []*Message) error {
+	return l.write(l.limit(msgs))
+}
+
+// write passes msgs to the wrapped logger
+func (l *RateLimitedLogger) write(msgs []*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if batchLogger, ok := l.logger.(BatchLogger); ok {
+		return batchLogger.LogBatch(msgs)
+	}
+
+	var lastErr error
+	for _, msg := range msgs {
+		err := l.logger.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// limit returns the messages in msgs that are within the limits, preceded by
+// any summaries that should be logged with them, and handles the rest
+// according to the policy
+func (l *RateLimitedLogger) limit(msgs []*Message) []*Message {
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	base := l.base
+	var baseAttrs *Attrs
+	if base != nil && len(l.config.KeyAttr) > 0 {
+		baseAttrs = base.BaseAttrs
+	}
+
+	allowed := make([]*Message, 0, len(msgs))
+	var over []*Message
+	for _, msg := range msgs {
+		key := l.key(baseAttrs, msg)
+		bucket := l.bucket(key, now)
+
+		if !bucket.take(l.config, messageSize(msg)) {
+			over = append(over, msg)
+			if l.config.Policy == RateLimitSummary {
+				if bucket.dropped == 0 || msg.Level < bucket.droppedLevel {
+					bucket.droppedLevel = msg.Level
+				}
+				bucket.dropped++
+			}
+			continue
+		}
+
+		if summary := l.summary(key, bucket); summary != nil {
+			allowed = append(allowed, summary)
+		}
+		allowed = append(allowed, msg)
+	}
+	l.lock.Unlock()
+
+	if len(over) > 0 {
+		l.handleOver(base, over)
+	}
+
+	return allowed
+}
+
+// handleOver logs messages that are over the limits to the fallback logger
+// with RateLimitFallback, or reports them as dropped
+func (l *RateLimitedLogger) handleOver(base *Base, msgs []*Message) {
+	if base == nil {
+		return
+	}
+
+	if l.config.Policy == RateLimitFallback {
+		if fallback := base.fallbackLogger(); fallback != nil {
+			for _, msg := range msgs {
+				fallback.Logm(msg.Timestamp, msg.Level, msg.Attrs.Attrs(), msg.Msg)
+			}
+			return
+		}
+	}
+
+	for range msgs {
+		base.report(ErrRateLimited)
+	}
+}
+
+// key returns the key of the bucket msg is limited by.  It must be called with
+// the lock held.
+func (l *RateLimitedLogger) key(baseAttrs *Attrs, msg *Message) string {
+	if len(l.config.KeyAttr) == 0 {
+		return ""
+	}
+
+	val := msg.Attrs.GetAttr(l.config.KeyAttr)
+	if val == nil && baseAttrs != nil {
+		val = baseAttrs.GetAttr(l.config.KeyAttr)
+	}
+	return attrString(val, time.RFC3339Nano)
+}
+
+// bucket returns the bucket for key, refilled up to now.  Buckets that have
+// refilled completely are forgotten from time to time so keys that aren't
+// seen again don't use up memory.  It must be called with the lock held.
+func (l *RateLimitedLogger) bucket(key string, now time.Time) *rateLimitBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 1024 {
+			l.sweep(now)
+		}
+
+		bucket = &rateLimitBucket{
+			messages: float64(l.config.MessageBurst),
+			bytes:    float64(l.config.ByteBurst),
+			last:     now,
+		}
+		l.buckets[key] = bucket
+		return bucket
+	}
+
+	bucket.refill(l.config, now)
+	return bucket
+}
+
+// sweep forgets buckets that have refilled completely and have no summary
+// waiting.  It must be called with the lock held.
+func (l *RateLimitedLogger) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		bucket.refill(l.config, now)
+		if bucket.dropped == 0 && bucket.full(l.config) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// summary returns the summary of the messages dropped from bucket, or nil if
+// none were dropped.  It must be called with the lock held.
+func (l *RateLimitedLogger) summary(key string, bucket *rateLimitBucket) *Message {
+	if bucket.dropped == 0 {
+		return nil
+	}
+
+	attrs := NewAttrs()
+	if len(l.config.KeyAttr) > 0 && len(key) > 0 {
+		attrs.SetAttr(l.config.KeyAttr, key)
+	}
+	if len(l.config.DroppedAttr) > 0 {
+		attrs.SetAttr(l.config.DroppedAttr, bucket.dropped)
+	}
+
+	summary := &Message{
+		base:      l.base,
+		Level:     bucket.droppedLevel,
+		Timestamp: l.clock.Now(),
+		Attrs:     attrs,
+		Msg:       fmt.Sprintf(l.config.SummaryFormat, bucket.dropped),
+	}
+	bucket.dropped = 0
+	return summary
+}
+
+func (b *rateLimitBucket) refill(config *RateLimitedLoggerConfig, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+
+	b.messages += elapsed * config.MessagesPerSecond
+	if b.messages > float64(config.MessageBurst) {
+		b.messages = float64(config.MessageBurst)
+	}
+	b.bytes += elapsed * config.BytesPerSecond
+	if b.bytes > float64(config.ByteBurst) {
+		b.bytes = float64(config.ByteBurst)
+	}
+}
+
+func (b *rateLimitBucket) full(config *RateLimitedLoggerConfig) bool {
+	return b.messages >= float64(config.MessageBurst) && b.bytes >= float64(config.ByteBurst)
+}
+
+// take removes a message of size bytes from the bucket if there's room for it.
+// A message larger than ByteBurst can be taken once the bucket is full so it
+// isn't dropped forever.
+func (b *rateLimitBucket) take(config *RateLimitedLoggerConfig, size int) bool {
+	if config.MessagesPerSecond > 0 && b.messages < 1 {
+		return false
+	}
+
+	cost := float64(size)
+	if cost > float64(config.ByteBurst) {
+		cost = float64(config.ByteBurst)
+	}
+	if config.BytesPerSecond > 0 && b.bytes < cost {
+		return false
+	}
+
+	if config.MessagesPerSecond > 0 {
+		b.messages--
+	}
+	if config.BytesPerSecond > 0 {
+		b.bytes -= float64(size)
+	}
+	return true
+}
+
+// messageSize estimates the size of msg once it's logged
+func messageSize(msg *Message) int {
+	size := len(msg.Msg)
+	for key, val := range msg.Attrs.Attrs() {
+		size += len(key) + len(attrString(val, time.RFC3339Nano))
+	}
+	return size
+}
@@ -0,0 +1,233 @@
+package gomol
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type RateLimitedLoggerSuite struct{}
+
+func newTestRateLimitedLogger(logger Logger, config *RateLimitedLoggerConfig) (HealthCheckLogger, *glock.MockClock) {
+	l, err := NewRateLimitedLogger(logger, config)
+	Expect(err).To(BeNil())
+	clock := glock.NewMockClock()
+	switch rl := l.(type) {
+	case *RateLimitedLogger:
+		rl.clock = clock
+	case *rateLimitedBatchLogger:
+		rl.clock = clock
+	}
+	return l, clock
+}
+
+func newTestRateLimitedLoggerConfig(perSecond float64, burst int) *RateLimitedLoggerConfig {
+	cfg := NewRateLimitedLoggerConfig()
+	cfg.MessagesPerSecond = perSecond
+	cfg.MessageBurst = burst
+	return cfg
+}
+
+func (s *RateLimitedLoggerSuite) TestNewRateLimitedLoggerBadConfig(t sweet.T) {
+	_, err := NewRateLimitedLogger(nil, nil)
+	Expect(err).ToNot(BeNil())
+
+	_, err = NewRateLimitedLogger(newDefaultMemLogger(), newTestRateLimitedLoggerConfig(-1, 1))
+	Expect(err).ToNot(BeNil())
+
+	_, err = NewRateLimitedLogger(newDefaultMemLogger(), newTestRateLimitedLoggerConfig(1, 0))
+	Expect(err).ToNot(BeNil())
+
+	cfg := NewRateLimitedLoggerConfig()
+	cfg.BytesPerSecond = 100
+	_, err = NewRateLimitedLogger(newDefaultMemLogger(), cfg)
+	Expect(err).ToNot(BeNil())
+
+	cfg = NewRateLimitedLoggerConfig()
+	cfg.Policy = RateLimitPolicy(100)
+	_, err = NewRateLimitedLogger(newDefaultMemLogger(), cfg)
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *RateLimitedLoggerSuite) TestMessageLimit(t sweet.T) {
+	ml := newDefaultMemLogger()
+	l, clock := newTestRateLimitedLogger(ml, newTestRateLimitedLoggerConfig(2, 3))
+	Expect(l.InitLogger()).To(BeNil())
+	Expect(ml.IsInitialized()).To(BeTrue())
+
+	for idx := 0; idx < 3; idx++ {
+		Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+	}
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+
+	clock.Advance(500 * time.Millisecond)
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+
+	// The bucket never holds more than the burst
+	clock.Advance(time.Hour)
+	for idx := 0; idx < 3; idx++ {
+		Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+	}
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "message")).To(BeNil())
+
+	Expect(ml.Messages()).To(HaveLen(7))
+
+	// Being over the limits doesn't change the wrapped logger's health
+	Expect(l.Healthy()).To(BeFalse())
+	ml.SetHealthy(true)
+	Expect(l.Healthy()).To(BeTrue())
+}
+
+func (s *RateLimitedLoggerSuite) TestByteLimit(t sweet.T) {
+	cfg := NewRateLimitedLoggerConfig()
+	cfg.MessagesPerSecond = 0
+	cfg.BytesPerSecond = 10
+	cfg.ByteBurst = 20
+
+	ml := newDefaultMemLogger()
+	l, clock := newTestRateLimitedLogger(ml, cfg)
+	l.InitLogger()
+
+	Expect(l.Logm(clock.Now(), LevelInfo, map[string]interface{}{"k": "v"}, "12345678")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "1234567890")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "1")).To(BeNil())
+
+	clock.Advance(100 * time.Millisecond)
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "1")).To(BeNil())
+
+	// A message larger than the burst is logged once the bucket is full
+	clock.Advance(time.Hour)
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, strings.Repeat("x", 50))).To(BeNil())
+	clock.Advance(2 * time.Second)
+	Expect(l.Logm(clock.Now(), LevelInfo, nil, "1")).To(BeNil())
+
+	Expect(ml.Messages()).To(HaveLen(4))
+}
+
+func (s *RateLimitedLoggerSuite) TestKeyAttr(t sweet.T) {
+	cfg := newTestRateLimitedLoggerConfig(1, 1)
+	cfg.KeyAttr = "tenant"
+
+	ml := newDefaultMemLogger()
+	l, _ := newTestRateLimitedLogger(ml, cfg)
+
+	b := NewBase()
+	b.SetAttr("tenant", "default")
+	b.AddLogger(l)
+	b.InitLoggers()
+
+	b.Infom(NewAttrs().SetAttr("tenant", "a"), "a1")
+	b.Infom(NewAttrs().SetAttr("tenant", "a"), "a2")
+	b.Infom(NewAttrs().SetAttr("tenant", "b"), "b1")
+	b.Info("default1")
+	b.Info("default2")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"a1", "b1", "default1"}))
+}
+
+func (s *RateLimitedLoggerSuite) TestReportDrops(t sweet.T) {
+	ml := newDefaultMemLogger()
+	l, _ := newTestRateLimitedLogger(ml, newTestRateLimitedLoggerConfig(1, 1))
+
+	errs := make(chan error, 10)
+	b := NewBase()
+	b.SetErrorChan(errs)
+	b.AddLogger(l)
+	b.InitLoggers()
+
+	b.Info("first")
+	b.Info("second")
+	b.Info("third")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first"}))
+	Expect(errs).To(Receive(Equal(ErrRateLimited)))
+	Expect(errs).To(Receive(Equal(ErrRateLimited)))
+	Expect(errs).ToNot(Receive(Equal(ErrRateLimited)))
+}
+
+func (s *RateLimitedLoggerSuite) TestFallbackPolicy(t sweet.T) {
+	cfg := newTestRateLimitedLoggerConfig(1, 1)
+	cfg.Policy = RateLimitFallback
+
+	ml := newDefaultMemLogger()
+	l, _ := newTestRateLimitedLogger(ml, cfg)
+
+	fl := newDefaultMemLogger()
+	fl.SetHealthy(true)
+
+	b := NewBase()
+	b.AddLogger(l)
+	Expect(b.SetFallbackLogger(fl)).To(BeNil())
+	b.InitLoggers()
+
+	ml.SetHealthy(true)
+	b.Info("first")
+	b.Info("second")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first"}))
+	Expect(memMessageTexts(fl)).To(Equal([]string{"second"}))
+}
+
+func (s *RateLimitedLoggerSuite) TestSummaryPolicy(t sweet.T) {
+	cfg := newTestRateLimitedLoggerConfig(1, 1)
+	cfg.KeyAttr = "tenant"
+	cfg.Policy = RateLimitSummary
+
+	ml := newDefaultMemLogger()
+	l, clock := newTestRateLimitedLogger(ml, cfg)
+	l.InitLogger()
+
+	tenant := map[string]interface{}{"tenant": "a"}
+	Expect(l.Logm(clock.Now(), LevelInfo, tenant, "first")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelInfo, tenant, "dropped")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelError, tenant, "dropped")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelDebug, tenant, "dropped")).To(BeNil())
+
+	clock.Advance(time.Second)
+	Expect(l.Logm(clock.Now(), LevelInfo, tenant, "second")).To(BeNil())
+	Expect(l.Logm(clock.Now(), LevelWarning, tenant, "dropped")).To(BeNil())
+	Expect(l.ShutdownLogger()).To(BeNil())
+
+	msgs := ml.Messages()
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"first",
+		"3 messages were dropped by the rate limiter",
+		"second",
+		"1 messages were dropped by the rate limiter",
+	}))
+	Expect(msgs[1].Level).To(Equal(LevelError))
+	Expect(msgs[1].Attrs).To(Equal(map[string]interface{}{
+		"tenant":       "a",
+		"rate_limited": uint64(3),
+	}))
+	Expect(msgs[3].Level).To(Equal(LevelWarning))
+}
+
+func (s *RateLimitedLoggerSuite) TestNotBatchLogger(t sweet.T) {
+	l, _ := newTestRateLimitedLogger(newDefaultMemLogger(), nil)
+	_, ok := l.(BatchLogger)
+	Expect(ok).To(BeFalse())
+}
+
+func (s *RateLimitedLoggerSuite) TestBatchLogger(t sweet.T) {
+	bl := newBatchMemLogger()
+	l, _ := newTestRateLimitedLogger(bl, newTestRateLimitedLoggerConfig(1, 2))
+	l.InitLogger()
+
+	Expect(l).To(BeAssignableToTypeOf(&rateLimitedBatchLogger{}))
+	Expect(l.(BatchLogger).LogBatch([]*Message{
+		newMessage(testTime, nil, LevelInfo, nil, "first"),
+		newMessage(testTime, nil, LevelInfo, nil, "second"),
+		newMessage(testTime, nil, LevelInfo, nil, "third"),
+	})).To(BeNil())
+
+	Expect(bl.Batches()).To(Equal([]int{2}))
+	Expect(memMessageTexts(bl.memLogger)).To(Equal([]string{"first", "second"}))
+}
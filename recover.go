@@ -0,0 +1,146 @@
+package gomol
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+)
+
+// PanicAction is what RecoverAndLog does after logging a panic
+type PanicAction int
+
+const (
+	// PanicRepanic panics again with the same value after logging it
+	PanicRepanic PanicAction = iota
+	// PanicExit exits the application the same way Base.Die does
+	PanicExit
+	// PanicSwallow stops the panic after logging it
+	PanicSwallow
+)
+
+// RecoverConfig is the configuration for RecoverAndLog and Go
+type RecoverConfig struct {
+	// Level is the level the panic is logged at
+	Level LogLevel
+
+	// Action is what happens after the panic is logged
+	Action PanicAction
+
+	// ExitCode is the code the application exits with when Action is
+	// PanicExit
+	ExitCode int
+
+	// Attrs are added to the message logged for the panic, such as
+	// attributes describing what the goroutine was doing
+	Attrs *Attrs
+
+	// Message is the format string of the message logged, given the value
+	// the panic was called with
+	Message string
+
+	// PanicAttr is the name of the attribute the panic value is added as.
+	// If it is empty the attribute isn't added.
+	PanicAttr string
+
+	// StackAttr is the name of the attribute the stack trace is added as.
+	// If it is empty the attribute isn't added.
+	StackAttr string
+
+	// GoroutineAttr is the name of the attribute the ID of the goroutine
+	// that panicked is added as.  If it is empty the attribute isn't added.
+	GoroutineAttr string
+}
+
+// NewRecoverConfig creates a new RecoverConfig that logs a panic at Fatal and
+// then panics again
+func NewRecoverConfig() *RecoverConfig {
+	return &RecoverConfig{
+		Level:         LevelFatal,
+		Action:        PanicRepanic,
+		ExitCode:      1,
+		Attrs:         nil,
+		Message:       "recovered from panic: %v",
+		PanicAttr:     "panic",
+		StackAttr:     "stack",
+		GoroutineAttr: "goroutine",
+	}
+}
+
+/*
+RecoverAndLog recovers from a panic, logs it to logger along with its stack
+trace, and flushes the logger's Base so the message isn't lost.  What happens
+after that depends on config.Action.  If config is nil the values from
+NewRecoverConfig are used.  RecoverAndLog must be deferred directly for it to
+recover from anything:
+
+	defer gomol.RecoverAndLog(base, nil)
+*/
+func RecoverAndLog(logger WrappableLogger, config *RecoverConfig) {
+	val := recover()
+	if val == nil {
+		return
+	}
+
+	if config == nil {
+		config = NewRecoverConfig()
+	}
+
+	stack := debug.Stack()
+
+	attrs := NewAttrs()
+	if config.Attrs != nil {
+		attrs.MergeAttrs(config.Attrs)
+	}
+	if len(config.PanicAttr) > 0 {
+		attrs.SetAttr(config.PanicAttr, fmt.Sprint(val))
+	}
+	if len(config.StackAttr) > 0 {
+		attrs.SetAttr(config.StackAttr, string(stack))
+	}
+	if len(config.GoroutineAttr) > 0 {
+		if id, ok := goroutineID(stack); ok {
+			attrs.SetAttr(config.GoroutineAttr, id)
+		}
+	}
+
+	logger.Log(config.Level, attrs, config.Message, val)
+
+	switch config.Action {
+	case PanicExit:
+		exitFrom(logger, config.ExitCode)
+	case PanicSwallow:
+		if b := wrappedBase(logger); b != nil {
+			b.Flush()
+		}
+	default:
+		if b := wrappedBase(logger); b != nil {
+			b.Flush()
+		}
+		panic(val)
+	}
+}
+
+// Go runs f in a new goroutine, using RecoverAndLog to log it if it panics
+func Go(logger WrappableLogger, config *RecoverConfig, f func()) {
+	go func() {
+		defer RecoverAndLog(logger, config)
+		f()
+	}()
+}
+
+// goroutineID finds the ID of the goroutine in the first line of a stack
+// trace from debug.Stack, which looks like "goroutine 18 [running]:"
+func goroutineID(stack []byte) (uint64, bool) {
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	idx := bytes.IndexByte(stack, ' ')
+	if idx < 0 {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(string(stack[:idx]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,117 @@
+package gomol
+
+import (
+	"strings"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type RecoverSuite struct{}
+
+func (s *RecoverSuite) TestNoPanic(t sweet.T) {
+	b, ml := newTestBase()
+
+	func() {
+		defer RecoverAndLog(b, nil)
+	}()
+	b.ShutdownLoggers()
+
+	Expect(ml.Messages()).To(BeEmpty())
+}
+
+func (s *RecoverSuite) TestRepanic(t sweet.T) {
+	b, ml := newTestBase()
+	defer b.ShutdownLoggers()
+
+	var repanicked interface{}
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+		defer RecoverAndLog(b, nil)
+		panic("oh no")
+	}()
+
+	Expect(repanicked).To(Equal("oh no"))
+
+	// The message is logged before the panic continues
+	Expect(memMessageTexts(ml)).To(Equal([]string{"recovered from panic: oh no"}))
+	msg := ml.Messages()[0]
+	Expect(msg.Level).To(Equal(LevelFatal))
+	Expect(msg.Attrs["panic"]).To(Equal("oh no"))
+	Expect(msg.Attrs["stack"]).To(ContainSubstring("recover_test.go"))
+	Expect(msg.Attrs["goroutine"]).To(BeNumerically(">", 0))
+}
+
+func (s *RecoverSuite) TestSwallow(t sweet.T) {
+	b, ml := newTestBase()
+	defer b.ShutdownLoggers()
+
+	cfg := NewRecoverConfig()
+	cfg.Action = PanicSwallow
+	cfg.Level = LevelError
+	cfg.Message = "worker failed: %v"
+	cfg.Attrs = NewAttrs().SetAttr("worker", 3)
+	cfg.StackAttr = ""
+	cfg.GoroutineAttr = ""
+
+	la := b.NewLogAdapter(NewAttrs().SetAttr("adapter", true))
+	func() {
+		defer RecoverAndLog(la, cfg)
+		panic(strings.NewReader("").UnreadByte())
+	}()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"worker failed: strings.Reader.UnreadByte: at beginning of string",
+	}))
+	Expect(ml.Messages()[0].Level).To(Equal(LevelError))
+	Expect(ml.Messages()[0].Attrs).To(Equal(map[string]interface{}{
+		"adapter": true,
+		"worker":  3,
+		"panic":   "strings.Reader.UnreadByte: at beginning of string",
+	}))
+}
+
+func (s *RecoverSuite) TestExit(t sweet.T) {
+	exiter := &testExiter{}
+	b, ml := newTestBase()
+	b.SetExiter(exiter)
+
+	cfg := NewRecoverConfig()
+	cfg.Action = PanicExit
+	cfg.ExitCode = 42
+	func() {
+		defer RecoverAndLog(b, cfg)
+		panic(1234)
+	}()
+
+	Expect(exiter.exited).To(BeTrue())
+	Expect(exiter.code).To(Equal(42))
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(memMessageTexts(ml)).To(Equal([]string{"recovered from panic: 1234"}))
+}
+
+func (s *RecoverSuite) TestGo(t sweet.T) {
+	b, ml := newTestBase()
+	defer b.ShutdownLoggers()
+
+	cfg := NewRecoverConfig()
+	cfg.Action = PanicSwallow
+	Go(b, cfg, func() {
+		panic("in goroutine")
+	})
+
+	Eventually(func() []string {
+		return memMessageTexts(ml)
+	}).Should(Equal([]string{"recovered from panic: in goroutine"}))
+}
+
+func (s *RecoverSuite) TestGoroutineID(t sweet.T) {
+	id, ok := goroutineID([]byte("goroutine 18 [running]:\nmain.main()"))
+	Expect(ok).To(BeTrue())
+	Expect(id).To(Equal(uint64(18)))
+
+	_, ok = goroutineID([]byte("something else"))
+	Expect(ok).To(BeFalse())
+}
@@ -0,0 +1,429 @@
+package gomol
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// ErrNilBaseConfig is returned when a nil BaseConfig is passed to Reload
+var ErrNilBaseConfig = errors.New("base config cannot be nil")
+
+// BaseConfig is a declarative description of everything that makes up a Base:
+// its runtime Config, the level it logs at, its attributes and the set of
+// loggers messages are sent to.  A BaseConfig can be applied to a Base all at
+// once using Reload.
+type BaseConfig struct {
+	// Config is the runtime configuration for the Base.  If it is nil the
+	// values from NewConfig will be used.
+	Config *Config
+
+	// LogLevel is the level the Base will log messages at.
+	LogLevel LogLevel
+
+	// Attrs are the attributes added to all messages logged by the Base.  If
+	// it is nil the Base will have no attributes.
+	Attrs *Attrs
+
+	// Loggers is the complete set of loggers messages will be sent to.
+	Loggers []Logger
+}
+
+// NewBaseConfig creates a new BaseConfig with default settings
+func NewBaseConfig() *BaseConfig {
+	return &BaseConfig{
+		Config:   NewConfig(),
+		LogLevel: LevelDebug,
+		Attrs:    NewAttrs(),
+		Loggers:  make([]Logger, 0),
+	}
+}
+
+// NewBaseFromConfig creates a new Base and applies the given BaseConfig to it.
+// The loggers in the config are added to the Base but InitLoggers still needs
+// to be called before messages can be logged.
+func NewBaseFromConfig(cfg *BaseConfig, configs ...baseConfigFunc) (*Base, error) {
+	b := NewBase(configs...)
+	err := b.Reload(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+/*
+Reload swaps the Base's Config, log level, attributes and loggers for the
+values in cfg.  Loggers in cfg that aren't already part of the Base are added
+and, if the Base is initialized, initialized before anything else changes.  If
+one of them fails to initialize the new loggers are shut down again and the
+Base is left as it was.  Each message is written to the loggers the Base had
+when it was logged, and loggers that aren't part of cfg are shut down only
+after they've received every message logged before the swap.  If any of them
+fail to shut down the rest are still shut down and a *ShutdownError is
+returned.
+
+The Config, level and loggers are swapped all at once, so each message is
+logged with either the old values or the new ones.  The attributes are
+replaced along with them, but like changes made with SetAttr they're added to
+messages when the loggers write them.
+
+Unlike SetConfig, a change to MaxQueueSize takes effect immediately when the
+Base is initialized.  New messages go to the new queue right away and are
+written once everything in the old queue has been written.
+*/
+func (b *Base) Reload(cfg *BaseConfig) error {
+	return b.ReloadContext(context.Background(), cfg)
+}
+
+/*
+ReloadContext is the same as Reload but stops waiting for queued messages to be
+written once ctx is done.  If ctx is done before the messages logged with the
+old values have been written the new loggers are shut down again, the Base is
+left as it was and an *IncompleteError is returned.  If ctx is done after the
+values have been swapped the loggers that were removed are left running, since
+the queue may still be writing to them, and they're returned in the
+*IncompleteError.
+*/
+func (b *Base) ReloadContext(ctx context.Context, cfg *BaseConfig) error {
+	if cfg == nil {
+		return ErrNilBaseConfig
+	}
+
+	b.mutLock.Lock()
+	defer b.mutLock.Unlock()
+
+	config := cfg.Config
+	if config == nil {
+		config = NewConfig()
+	}
+	attrs := cfg.Attrs
+	if attrs == nil {
+		attrs = NewAttrs()
+	}
+
+	added, removed := diffLoggers(b.loggers(), cfg.Loggers)
+
+	// Start up the new loggers first so if any of them fail the Base
+	// is left the way it was.
+	started := make([]Logger, 0, len(added))
+	for _, logger := range added {
+		var err error
+		if b.IsInitialized() && !logger.IsInitialized() {
+			err = logger.InitLogger()
+			if err == nil {
+				started = append(started, logger)
+			}
+		} else if !b.IsInitialized() && logger.IsInitialized() {
+			err = logger.ShutdownLogger()
+		}
+
+		if err != nil {
+			for _, startedLogger := range started {
+				startedLogger.ShutdownLogger()
+			}
+			return err
+		}
+	}
+
+	// Make sure everything logged with the old settings has made it to the
+	// old loggers before anything is swapped out from under them.
+	err := b.FlushContext(ctx)
+	if err != nil {
+		for _, startedLogger := range started {
+			startedLogger.ShutdownLogger()
+		}
+		return err
+	}
+
+	for _, logger := range added {
+		logger.SetBase(b)
+	}
+
+	b.BaseAttrs.replaceAttrs(attrs)
+	b.updateState(func(state *baseState) {
+		state.config = config
+		state.level = cfg.LogLevel
+		state.loggers = state.loggers.withLoggers(cfg.Loggers)
+	})
+	atomic.AddUint64(&loggerSetGeneration, 1)
+
+	oldQueue, err := b.resizeQueue(ctx, config.MaxQueueSize)
+	if err != nil {
+		return newIncompleteError(oldQueue, removed, err)
+	}
+
+	// A message may have been picked up by the queue worker before the swap,
+	// so wait for it to finish before shutting down the removed loggers.
+	err = b.FlushContext(ctx)
+	if err != nil {
+		return newIncompleteError(b.queue, removed, ctx.Err())
+	}
+
+	var shutdownErr *ShutdownError
+	for _, logger := range removed {
+		err := shutdownLoggerContext(ctx, logger)
+		if err != nil {
+			if shutdownErr == nil {
+				shutdownErr = &ShutdownError{}
+			}
+			shutdownErr.Loggers = append(shutdownErr.Loggers, logger)
+			shutdownErr.Errors = append(shutdownErr.Errors, err)
+		}
+	}
+	if shutdownErr != nil {
+		return shutdownErr
+	}
+
+	return nil
+}
+
+// resizeQueue replaces the queue with one that holds maxQueueSize messages.
+// New messages go to the new queue straight away, but its worker is only
+// started once the old worker has written everything left in the old queue so
+// messages are still written in order.  Logging isn't held up while that
+// happens.  If ctx is done first the new worker is started anyway and the old
+// queue is returned with the error.
+func (b *Base) resizeQueue(ctx context.Context, maxQueueSize uint) (*queue, error) {
+	b.queueLock.Lock()
+	oldQueue := b.queue
+	if oldQueue == nil || uint(cap(oldQueue.queueChan)) == maxQueueSize {
+		b.queueLock.Unlock()
+		return nil, nil
+	}
+	newQueue := newQueue(b, maxQueueSize)
+	newQueue.running = true
+	b.queue = newQueue
+	b.queueLock.Unlock()
+
+	err := oldQueue.stopWorkerContext(ctx)
+	if err == nil {
+		// The old worker has stopped so the messages it was going to
+		// retry can be handed over to the new one.
+		newQueue.retries = oldQueue.retries
+		newQueue.retrySet = oldQueue.retrySet
+	}
+	go newQueue.work()
+
+	return oldQueue, err
+}
+
+// diffLoggers returns the loggers in next that aren't in cur and the loggers
+// in cur that aren't in next.
+func diffLoggers(cur []Logger, next []Logger) ([]Logger, []Logger) {
+	curSet := make(map[Logger]bool, len(cur))
+	for _, logger := range cur {
+		curSet[logger] = true
+	}
+	nextSet := make(map[Logger]bool, len(next))
+	for _, logger := range next {
+		nextSet[logger] = true
+	}
+
+	added := make([]Logger, 0)
+	for _, logger := range next {
+		if !curSet[logger] {
+			added = append(added, logger)
+		}
+	}
+
+	removed := make([]Logger, 0)
+	for _, logger := range cur {
+		if !nextSet[logger] {
+			removed = append(removed, logger)
+		}
+	}
+
+	return added, removed
+}
+
+// ReloadLoader builds the BaseConfig a ReloadWatcher applies to its Base.  It
+// is called each time a reload is triggered.
+type ReloadLoader func() (*BaseConfig, error)
+
+// ReloadWatcherConfig is the configuration for a ReloadWatcher
+type ReloadWatcherConfig struct {
+	// Signals is the list of signals that will trigger a reload.  If it is
+	// empty the watcher will not listen for signals.
+	Signals []os.Signal
+
+	// Filename is the path of a configuration file that will trigger a reload
+	// when it changes.  If it is empty no file will be watched.
+	Filename string
+
+	// PollInterval is how often Filename is checked for changes.
+	PollInterval time.Duration
+
+	// Timeout is how long a reload waits for queued messages to be written
+	// before giving up.  If it is zero there is no limit.
+	Timeout time.Duration
+}
+
+// NewReloadWatcherConfig creates a new ReloadWatcherConfig that reloads on SIGHUP
+func NewReloadWatcherConfig() *ReloadWatcherConfig {
+	return &ReloadWatcherConfig{
+		Signals:      []os.Signal{syscall.SIGHUP},
+		Filename:     "",
+		PollInterval: 5 * time.Second,
+		Timeout:      30 * time.Second,
+	}
+}
+
+/*
+ReloadWatcher calls Reload on a Base with the result of a ReloadLoader whenever
+one of the configured signals is received or the configured file changes.  Any
+errors while loading or applying the new configuration are sent to the Base's
+error channel and the Base keeps its current configuration.
+*/
+type ReloadWatcher struct {
+	base   *Base
+	loader ReloadLoader
+	config *ReloadWatcherConfig
+
+	runLock  sync.Mutex
+	running  bool
+	signals  chan os.Signal
+	stop     chan struct{}
+	finished chan struct{}
+
+	fileMod  time.Time
+	fileSize int64
+}
+
+// NewReloadWatcher creates a ReloadWatcher that applies configs created by loader to base.
+// If config is nil the values from NewReloadWatcherConfig are used.
+func NewReloadWatcher(base *Base, loader ReloadLoader, config *ReloadWatcherConfig) *ReloadWatcher {
+	if config == nil {
+		config = NewReloadWatcherConfig()
+	}
+
+	return &ReloadWatcher{
+		base:   base,
+		loader: loader,
+		config: config,
+	}
+}
+
+// Start begins watching for signals and file changes
+func (w *ReloadWatcher) Start() error {
+	w.runLock.Lock()
+	defer w.runLock.Unlock()
+
+	if w.running {
+		return errors.New("reload watcher is already running")
+	}
+
+	if len(w.config.Filename) > 0 {
+		info, err := os.Stat(w.config.Filename)
+		if err != nil {
+			return err
+		}
+		w.fileMod = info.ModTime()
+		w.fileSize = info.Size()
+	}
+
+	w.signals = make(chan os.Signal, 1)
+	if len(w.config.Signals) > 0 {
+		signal.Notify(w.signals, w.config.Signals...)
+	}
+
+	var ticker glock.Ticker
+	if len(w.config.Filename) > 0 {
+		ticker = w.base.clock.NewTicker(w.config.PollInterval)
+	}
+
+	w.stop = make(chan struct{})
+	w.finished = make(chan struct{})
+	w.running = true
+
+	go w.watch(ticker)
+
+	return nil
+}
+
+// Stop stops watching for signals and file changes
+func (w *ReloadWatcher) Stop() error {
+	w.runLock.Lock()
+	defer w.runLock.Unlock()
+
+	if !w.running {
+		return errors.New("reload watcher is not running")
+	}
+
+	signal.Stop(w.signals)
+	close(w.stop)
+	<-w.finished
+	w.running = false
+
+	return nil
+}
+
+// Reload loads a new configuration and applies it to the Base immediately
+func (w *ReloadWatcher) Reload() error {
+	cfg, err := w.loader()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if w.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.config.Timeout)
+		defer cancel()
+	}
+
+	return w.base.ReloadContext(ctx, cfg)
+}
+
+func (w *ReloadWatcher) watch(ticker glock.Ticker) {
+	defer close(w.finished)
+
+	var tick <-chan time.Time
+	if ticker != nil {
+		defer ticker.Stop()
+		tick = ticker.Chan()
+	}
+
+	for {
+		select {
+		case <-w.signals:
+			w.reload()
+		case <-tick:
+			if w.fileChanged() {
+				w.reload()
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *ReloadWatcher) reload() {
+	err := w.Reload()
+	if err != nil {
+		w.base.report(err)
+	}
+}
+
+func (w *ReloadWatcher) fileChanged() bool {
+	info, err := os.Stat(w.config.Filename)
+	if err != nil {
+		// The file may be in the middle of being replaced so wait for the
+		// next check rather than reloading a half-written config.
+		return false
+	}
+
+	if info.ModTime().Equal(w.fileMod) && info.Size() == w.fileSize {
+		return false
+	}
+
+	w.fileMod = info.ModTime()
+	w.fileSize = info.Size()
+	return true
+}
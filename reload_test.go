@@ -0,0 +1,387 @@
+package gomol
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type ReloadSuite struct{}
+
+func (s *ReloadSuite) TestNewBaseConfig(t sweet.T) {
+	cfg := NewBaseConfig()
+	Expect(cfg.Config).To(Equal(NewConfig()))
+	Expect(cfg.LogLevel).To(Equal(LevelDebug))
+	Expect(cfg.Attrs.Attrs()).To(HaveLen(0))
+	Expect(cfg.Loggers).To(HaveLen(0))
+}
+
+func (s *ReloadSuite) TestNewBaseFromConfig(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	cfg := NewBaseConfig()
+	cfg.LogLevel = LevelInfo
+	cfg.Attrs.SetAttr("attr1", 1234)
+	cfg.Loggers = append(cfg.Loggers, ml)
+
+	b, err := NewBaseFromConfig(cfg)
+	Expect(err).To(BeNil())
+	Expect(b.IsInitialized()).To(BeFalse())
+	Expect(b.logLevel()).To(Equal(LevelInfo))
+	Expect(b.GetAttr("attr1")).To(Equal(1234))
+	Expect(b.loggers()).To(Equal([]Logger{ml}))
+	Expect(ml.base).To(Equal(b))
+	Expect(ml.IsInitialized()).To(BeFalse())
+}
+
+func (s *ReloadSuite) TestReloadNil(t sweet.T) {
+	b := NewBase()
+	Expect(b.Reload(nil)).To(Equal(ErrNilBaseConfig))
+}
+
+func (s *ReloadSuite) TestReloadSwapsState(t sweet.T) {
+	kept := newDefaultMemLogger()
+	removed := newDefaultMemLogger()
+	added := newDefaultMemLogger()
+
+	b := NewBase()
+	b.SetAttr("old", 1)
+	b.AddLogger(kept)
+	b.AddLogger(removed)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	cfg := NewBaseConfig()
+	cfg.Config.SequenceAttr = "seq"
+	cfg.LogLevel = LevelWarning
+	cfg.Attrs.SetAttr("new", 2)
+	cfg.Loggers = []Logger{kept, added}
+
+	err := b.Reload(cfg)
+	Expect(err).To(BeNil())
+
+	Expect(b.config()).To(Equal(cfg.Config))
+	Expect(b.logLevel()).To(Equal(LevelWarning))
+	Expect(b.GetAttr("old")).To(BeNil())
+	Expect(b.GetAttr("new")).To(Equal(2))
+	Expect(b.loggers()).To(Equal([]Logger{kept, added}))
+
+	Expect(kept.IsInitialized()).To(BeTrue())
+	Expect(added.IsInitialized()).To(BeTrue())
+	Expect(added.base).To(Equal(b))
+	Expect(removed.IsInitialized()).To(BeFalse())
+	Expect(removed.isShutdown).To(BeTrue())
+}
+
+func (s *ReloadSuite) TestReloadDeliversQueuedMessages(t sweet.T) {
+	kept := newDefaultMemLogger()
+	removed := newDefaultMemLogger()
+	added := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(kept)
+	b.AddLogger(removed)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	for i := 0; i < 100; i++ {
+		b.Infof("before %d", i)
+	}
+
+	cfg := NewBaseConfig()
+	cfg.Loggers = []Logger{kept, added}
+	err := b.Reload(cfg)
+	Expect(err).To(BeNil())
+
+	b.Info("after")
+	b.Flush()
+
+	Expect(removed.Messages()).To(HaveLen(100))
+	Expect(kept.Messages()).To(HaveLen(101))
+	Expect(added.Messages()).To(HaveLen(1))
+	Expect(added.Messages()[0].Message).To(Equal("after"))
+}
+
+func (s *ReloadSuite) TestMessagesUseLoggersWhenLogged(t sweet.T) {
+	blocker := make(chan struct{})
+	kept := newDefaultMemLogger()
+	added := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(&BlockingLogger{ch: blocker})
+	b.AddLogger(kept)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	// The worker is stuck on the first message so the second one is
+	// still queued when the logger is added.
+	b.Info("first")
+	b.Info("second")
+	Expect(b.AddLogger(added)).To(BeNil())
+	b.Info("third")
+
+	close(blocker)
+	b.Flush()
+
+	Expect(memMessageTexts(kept)).To(Equal([]string{"first", "second", "third"}))
+	Expect(memMessageTexts(added)).To(Equal([]string{"third"}))
+}
+
+func (s *ReloadSuite) TestReloadInitFailKeepsState(t sweet.T) {
+	cur := newDefaultMemLogger()
+	good := newDefaultMemLogger()
+	bad := newDefaultMemLogger()
+	bad.config.FailInit = true
+
+	b := NewBase()
+	b.SetLogLevel(LevelInfo)
+	b.AddLogger(cur)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	cfg := NewBaseConfig()
+	cfg.LogLevel = LevelError
+	cfg.Loggers = []Logger{good, bad}
+
+	err := b.Reload(cfg)
+	Expect(err).ToNot(BeNil())
+	Expect(err.Error()).To(Equal("Init failed"))
+
+	Expect(b.logLevel()).To(Equal(LevelInfo))
+	Expect(b.loggers()).To(Equal([]Logger{cur}))
+	Expect(cur.IsInitialized()).To(BeTrue())
+	Expect(good.IsInitialized()).To(BeFalse())
+	Expect(bad.IsInitialized()).To(BeFalse())
+}
+
+func (s *ReloadSuite) TestReloadNotInitialized(t sweet.T) {
+	ml := newDefaultMemLogger()
+	ml.InitLogger()
+
+	b := NewBase()
+
+	cfg := NewBaseConfig()
+	cfg.Loggers = []Logger{ml}
+
+	err := b.Reload(cfg)
+	Expect(err).To(BeNil())
+	Expect(ml.IsInitialized()).To(BeFalse())
+	Expect(b.queue).To(BeNil())
+}
+
+func (s *ReloadSuite) TestReloadResizesQueue(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(ml)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+	Expect(cap(b.queue.queueChan)).To(Equal(10000))
+
+	for i := 0; i < 50; i++ {
+		b.Infof("before %d", i)
+	}
+
+	cfg := NewBaseConfig()
+	cfg.Config.MaxQueueSize = 100
+	cfg.Loggers = []Logger{ml}
+
+	err := b.Reload(cfg)
+	Expect(err).To(BeNil())
+	Expect(cap(b.queue.queueChan)).To(Equal(100))
+
+	b.Info("after")
+	b.Flush()
+
+	Expect(ml.Messages()).To(HaveLen(51))
+	Expect(ml.Messages()[50].Message).To(Equal("after"))
+}
+
+func (s *ReloadSuite) TestReloadShutsDownAllRemoved(t sweet.T) {
+	failFirst := newDefaultMemLogger()
+	failFirst.config.FailShutdown = true
+	removed := newDefaultMemLogger()
+	failLast := newDefaultMemLogger()
+	failLast.config.FailShutdown = true
+
+	b := NewBase()
+	b.AddLogger(failFirst)
+	b.AddLogger(removed)
+	b.AddLogger(failLast)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	err := b.Reload(NewBaseConfig())
+	Expect(err).To(BeAssignableToTypeOf(&ShutdownError{}))
+	shutdownErr := err.(*ShutdownError)
+	Expect(shutdownErr.Loggers).To(Equal([]Logger{failFirst, failLast}))
+	Expect(shutdownErr.Errors).To(HaveLen(2))
+	Expect(removed.isShutdown).To(BeTrue())
+	Expect(b.loggers()).To(BeEmpty())
+}
+
+func (s *ReloadSuite) TestReloadContextDone(t sweet.T) {
+	blocker := make(chan struct{})
+	blocking := &BlockingLogger{ch: blocker}
+	added := newDefaultMemLogger()
+
+	b := NewBase()
+	b.SetLogLevel(LevelInfo)
+	b.AddLogger(blocking)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+	defer close(blocker)
+
+	// The worker is stuck on this message so it can't be flushed
+	b.Info("stuck")
+
+	cfg := NewBaseConfig()
+	cfg.LogLevel = LevelError
+	cfg.Loggers = []Logger{blocking, added}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := b.ReloadContext(ctx, cfg)
+	Expect(err).To(BeAssignableToTypeOf(&IncompleteError{}))
+
+	Expect(b.logLevel()).To(Equal(LevelInfo))
+	Expect(b.loggers()).To(Equal([]Logger{blocking}))
+	Expect(added.IsInitialized()).To(BeFalse())
+}
+
+func (s *ReloadSuite) TestResizeQueueDoesNotBlockLogging(t sweet.T) {
+	blocker := make(chan struct{})
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.AddLogger(&BlockingLogger{ch: blocker})
+	b.AddLogger(ml)
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	b.Info("first")
+
+	resized := make(chan error)
+	go func() {
+		_, err := b.resizeQueue(context.Background(), 100)
+		resized <- err
+	}()
+
+	// The old worker is stuck on the first message but new messages still
+	// go to the new queue
+	Eventually(func() int {
+		b.queueLock.RLock()
+		defer b.queueLock.RUnlock()
+		return cap(b.queue.queueChan)
+	}).Should(Equal(100))
+	Expect(b.Info("second")).To(BeNil())
+	Consistently(resized).ShouldNot(Receive())
+
+	close(blocker)
+	Eventually(resized).Should(Receive(BeNil()))
+	b.Flush()
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first", "second"}))
+}
+
+func (s *ReloadSuite) TestWatcherReload(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	b := NewBase()
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	w := NewReloadWatcher(b, func() (*BaseConfig, error) {
+		cfg := NewBaseConfig()
+		cfg.Loggers = []Logger{ml}
+		return cfg, nil
+	}, nil)
+
+	err := w.Reload()
+	Expect(err).To(BeNil())
+	Expect(b.loggers()).To(Equal([]Logger{ml}))
+}
+
+func (s *ReloadSuite) TestWatcherReloadLoaderError(t sweet.T) {
+	b := NewBase()
+	b.SetLogLevel(LevelInfo)
+
+	w := NewReloadWatcher(b, func() (*BaseConfig, error) {
+		return nil, errors.New("bad config")
+	}, nil)
+
+	err := w.Reload()
+	Expect(err).To(MatchError("bad config"))
+	Expect(b.logLevel()).To(Equal(LevelInfo))
+}
+
+func (s *ReloadSuite) TestWatcherStartTwice(t sweet.T) {
+	cfg := NewReloadWatcherConfig()
+	cfg.Signals = nil
+
+	w := NewReloadWatcher(NewBase(), nil, cfg)
+	Expect(w.Start()).To(BeNil())
+	Expect(w.Start()).To(MatchError("reload watcher is already running"))
+	Expect(w.Stop()).To(BeNil())
+	Expect(w.Stop()).To(MatchError("reload watcher is not running"))
+}
+
+func (s *ReloadSuite) TestWatcherStartMissingFile(t sweet.T) {
+	cfg := NewReloadWatcherConfig()
+	cfg.Filename = filepath.Join(os.TempDir(), "gomol-does-not-exist.conf")
+
+	w := NewReloadWatcher(NewBase(), nil, cfg)
+	Expect(w.Start()).ToNot(BeNil())
+}
+
+func (s *ReloadSuite) TestWatcherFileChange(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gomol.conf")
+	Expect(ioutil.WriteFile(filename, []byte("info"), 0644)).To(BeNil())
+
+	clock := glock.NewMockClock()
+	b := NewBase(withClock(clock))
+	b.InitLoggers()
+	defer b.ShutdownLoggers()
+
+	loads := make(chan LogLevel, 2)
+	cfg := NewReloadWatcherConfig()
+	cfg.Signals = nil
+	cfg.Filename = filename
+	cfg.PollInterval = time.Second
+
+	w := NewReloadWatcher(b, func() (*BaseConfig, error) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		level, err := ToLogLevel(string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := NewBaseConfig()
+		cfg.LogLevel = level
+		loads <- level
+		return cfg, nil
+	}, cfg)
+	Expect(w.Start()).To(BeNil())
+	defer w.Stop()
+
+	// Nothing has changed yet so nothing should be reloaded
+	clock.Advance(time.Second)
+	Consistently(loads).ShouldNot(Receive())
+
+	Expect(ioutil.WriteFile(filename, []byte("error"), 0644)).To(BeNil())
+	clock.Advance(time.Second)
+	Eventually(loads).Should(Receive(Equal(LevelError)))
+}
@@ -0,0 +1,359 @@
+package gomol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// backoff is how long to wait between attempts to do something that keeps
+// failing.  The delay starts at min and doubles after each attempt up to max.
+type backoff struct {
+	delay time.Duration
+	max   time.Duration
+}
+
+func newBackoff(min time.Duration, max time.Duration) *backoff {
+	return &backoff{
+		delay: min,
+		max:   max,
+	}
+}
+
+// next returns how long to wait before the next attempt
+func (b *backoff) next() time.Duration {
+	delay := b.delay
+	b.delay *= 2
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	return delay
+}
+
+// errStopped is returned by retry when stop was closed before the first
+// attempt
+var errStopped = errors.New("the logger was shut down before the messages were sent")
+
+// permanentError is an error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// retry calls attempt until it succeeds, returns a *permanentError, has been
+// retried maxRetries times or stop is closed, waiting between attempts as
+// long as b says.  attempt can ask for a longer wait, such as when a server
+// sends a Retry-After header, but never longer than b's max.  If stop is
+// already closed attempt isn't called at all.
+func retry(clock glock.Clock, b *backoff, maxRetries int, stop <-chan struct{}, attempt func() (time.Duration, error)) error {
+	select {
+	case <-stop:
+		return errStopped
+	default:
+	}
+
+	for tries := 0; ; tries++ {
+		minWait, err := attempt()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*permanentError); ok || tries >= maxRetries {
+			return err
+		}
+
+		wait := b.next()
+		if minWait > wait {
+			wait = minWait
+		}
+		if wait > b.max {
+			wait = b.max
+		}
+
+		select {
+		case <-stop:
+			return err
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// dialNetwork connects to address and returns the connection and whether it's
+// a stream connection that messages need to be framed on.  network can be any
+// network known to net.Dial or "tcp+tls".
+func dialNetwork(network string, address string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, bool, error) {
+	var conn net.Conn
+	var err error
+	if network == "tcp+tls" {
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout(network, address, timeout)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch network {
+	case "tcp+tls", "tcp", "tcp4", "tcp6", "unix":
+		return conn, true, nil
+	default:
+		return conn, false, nil
+	}
+}
+
+// reconnectingConn is a connection that's dialed again in the background
+// when it breaks, waiting longer between each failed attempt.  It's protected
+// by the lock of the logger that owns it, which must be held when calling any
+// of its methods other than wait.
+type reconnectingConn struct {
+	lock  *sync.Mutex
+	clock glock.Clock
+	delay RetryDelay
+	dial  func() (net.Conn, bool, error)
+
+	// connected is called with the lock held after the connection has been
+	// dialed again.  It may be nil.
+	connected func()
+
+	conn         net.Conn
+	stream       bool
+	reconnecting bool
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// newReconnectingConn creates a reconnectingConn that connects with dial.
+// It isn't connected until open is called.
+func newReconnectingConn(lock *sync.Mutex, clock glock.Clock, delay RetryDelay, dial func() (net.Conn, bool, error)) *reconnectingConn {
+	return &reconnectingConn{
+		lock:  lock,
+		clock: clock,
+		delay: delay,
+		dial:  dial,
+		stop:  make(chan struct{}),
+	}
+}
+
+// open dials the connection.  If that fails and allowDisconnected is set the
+// connection is dialed again in the background instead of returning the
+// error.
+func (c *reconnectingConn) open(allowDisconnected bool) error {
+	conn, stream, err := c.dial()
+	if err != nil {
+		if !allowDisconnected {
+			return err
+		}
+		c.startReconnect()
+		return nil
+	}
+
+	c.conn = conn
+	c.stream = stream
+	return nil
+}
+
+// close stops reconnecting and closes the connection.  Call wait once the
+// lock has been released to wait for a reconnect in progress to stop.
+func (c *reconnectingConn) close() error {
+	close(c.stop)
+
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+// wait waits for a reconnect in progress to stop after close has been called.
+// It must be called without the lock held.
+func (c *reconnectingConn) wait() {
+	c.wg.Wait()
+}
+
+// broken closes the connection after it failed and starts dialing it again
+// in the background
+func (c *reconnectingConn) broken() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.startReconnect()
+}
+
+func (c *reconnectingConn) startReconnect() {
+	if c.reconnecting {
+		return
+	}
+	c.reconnecting = true
+
+	c.wg.Add(1)
+	go c.reconnect()
+}
+
+func (c *reconnectingConn) reconnect() {
+	defer c.wg.Done()
+
+	b := c.delay.backoff()
+	for {
+		select {
+		case <-c.stop:
+			c.lock.Lock()
+			c.reconnecting = false
+			c.lock.Unlock()
+			return
+		case <-c.clock.After(b.next()):
+		}
+
+		conn, stream, err := c.dial()
+		if err != nil {
+			continue
+		}
+
+		c.lock.Lock()
+		c.reconnecting = false
+		select {
+		case <-c.stop:
+			c.lock.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		c.conn = conn
+		c.stream = stream
+		if c.connected != nil {
+			c.connected()
+		}
+		c.lock.Unlock()
+		return
+	}
+}
+
+// ErrInvalidRetryDelay is returned when creating a logger with a MinRetryDelay
+// that isn't positive or a MaxRetryDelay shorter than MinRetryDelay
+var ErrInvalidRetryDelay = errors.New("the min retry delay must be positive and no longer than the max retry delay")
+
+// RetryDelay is how long a logger waits between attempts to send messages
+// that couldn't be sent or to reconnect after losing its connection.  The
+// delay starts at MinRetryDelay and doubles after each failed attempt up to
+// MaxRetryDelay.
+type RetryDelay struct {
+	// MinRetryDelay is how long to wait before the first retry
+	MinRetryDelay time.Duration
+
+	// MaxRetryDelay is the longest to wait between retries
+	MaxRetryDelay time.Duration
+}
+
+// validate makes sure the delay can't be zero, which would retry without
+// ever waiting
+func (d RetryDelay) validate() error {
+	if d.MinRetryDelay <= 0 || d.MaxRetryDelay < d.MinRetryDelay {
+		return ErrInvalidRetryDelay
+	}
+	return nil
+}
+
+func (d RetryDelay) backoff() *backoff {
+	return newBackoff(d.MinRetryDelay, d.MaxRetryDelay)
+}
+
+// ErrSendBufferFull is reported to the Base's error channel when a logger that
+// sends messages in the background already has BufferSize sends waiting.  The
+// oldest waiting send is dropped to make room for the new one.
+var ErrSendBufferFull = errors.New("the send buffer is full, the oldest messages were dropped")
+
+// sender runs sends one at a time on a background goroutine, in the order
+// they were added, so a logger retrying a slow or unreachable server doesn't
+// hold up the Base's queue.  Up to size sends wait to be run.
+type sender struct {
+	size int
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending []func(stop <-chan struct{})
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newSender creates a sender and starts running sends
+func newSender(size int) *sender {
+	s := &sender{
+		size: size,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.lock)
+
+	go s.run()
+	return s
+}
+
+// add adds send to the sends waiting to be run and returns whether the oldest
+// waiting send was dropped to make room for it.  send is called with a channel
+// that's closed once it should stop retrying because the sender is being shut
+// down.  It must not be called after shutdown.
+func (s *sender) add(send func(stop <-chan struct{})) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dropped := false
+	if len(s.pending) >= s.size {
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		dropped = true
+	}
+	s.pending = append(s.pending, send)
+	s.cond.Signal()
+
+	return dropped
+}
+
+func (s *sender) run() {
+	defer close(s.done)
+
+	for {
+		s.lock.Lock()
+		for len(s.pending) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.pending) == 0 {
+			s.lock.Unlock()
+			return
+		}
+		send := s.pending[0]
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.lock.Unlock()
+
+		send(s.stop)
+	}
+}
+
+// shutdown waits for the waiting sends to be run.  If ctx is done first the
+// stop channel is closed so the send being run stops retrying and the sends
+// still waiting are given up on.
+func (s *sender) shutdown(ctx context.Context) {
+	s.lock.Lock()
+	s.closed = true
+	s.cond.Signal()
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	case <-ctx.Done():
+	}
+
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,286 @@
+package gomol
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RouteMode is how a Router picks the routes a message is sent to
+type RouteMode int
+
+const (
+	// RouteFirstMatch sends a message to the loggers of the first route
+	// it matches
+	RouteFirstMatch RouteMode = iota
+	// RouteFanOut sends a message to the loggers of every route it matches
+	RouteFanOut
+)
+
+// Route is a rule in a RouterConfig.  A message matches a Route if it matches
+// all of the conditions that are set.
+type Route struct {
+	// Loggers are the loggers messages matching the route are sent to.  They
+	// also need to be added to the Base using AddLogger.  A route without
+	// any loggers drops the messages it matches unless Default is true.
+	Loggers []Logger
+
+	// MinLevel is the least severe level the route matches.  If it is zero,
+	// which isn't a level, it's LevelDebug.
+	MinLevel LogLevel
+
+	// MaxLevel is the most severe level the route matches.  If it is zero,
+	// which isn't a level, it's LevelFatal.
+	MaxLevel LogLevel
+
+	// Attrs are attributes the message must have with equal values.  Values
+	// are compared using their string representations, so true and "true"
+	// are equal.
+	Attrs map[string]interface{}
+
+	// AttrPatterns are regular expressions the string representations of
+	// the message's attributes must match
+	AttrPatterns map[string]string
+
+	// MessagePattern is a regular expression the message must match.  If it
+	// is empty any message matches.
+	MessagePattern string
+
+	// Default sends messages matching the route to the default loggers as
+	// well as the route's loggers
+	Default bool
+}
+
+// NewRoute creates a new Route that matches every message and sends it to
+// loggers
+func NewRoute(loggers ...Logger) *Route {
+	return &Route{
+		Loggers:  loggers,
+		MinLevel: LevelDebug,
+		MaxLevel: LevelFatal,
+	}
+}
+
+// RouterConfig is the configuration for a Router
+type RouterConfig struct {
+	// Mode is whether a message is sent to the first route it matches or to
+	// every route it matches
+	Mode RouteMode
+
+	// Routes are checked in order for each message
+	Routes []*Route
+}
+
+// NewRouterConfig creates a new RouterConfig in RouteFirstMatch mode without
+// any routes
+func NewRouterConfig() *RouterConfig {
+	return &RouterConfig{
+		Mode:   RouteFirstMatch,
+		Routes: make([]*Route, 0),
+	}
+}
+
+/*
+Router sends messages to some of a Base's loggers instead of all of them,
+based on the routes it was configured with.  Messages matching a route are sent
+to that route's loggers, and messages that don't match any route are sent to
+the default loggers: the loggers added to the Base that aren't part of any
+route.
+
+For example, with a route sending messages with audit=true to an audit logger
+and a route sending messages with component=billing to a billing logger with
+Default set, audit messages are only logged to the audit logger, billing
+messages are logged to the billing logger and the default loggers, and
+everything else is logged to the default loggers.
+
+The fallback logger is used if any of the loggers a message is sent to are
+unhealthy.  A Router is set on a Base with SetRouter.
+*/
+type Router struct {
+	mode   RouteMode
+	routes []*compiledRoute
+
+	// routed holds every logger that's part of a route
+	routed map[Logger]struct{}
+}
+
+type compiledRoute struct {
+	loggers      []Logger
+	minLevel     LogLevel
+	maxLevel     LogLevel
+	attrs        map[string]string
+	attrPatterns map[string]*regexp.Regexp
+	msgPattern   *regexp.Regexp
+	toDefault    bool
+}
+
+// routing is the set of loggers a Router sent a message to
+type routing struct {
+	router    *Router
+	loggers   map[Logger]struct{}
+	toDefault bool
+}
+
+// NewRouter creates a new Router.  If config is nil the values from
+// NewRouterConfig are used, which sends every message to every logger.
+func NewRouter(config *RouterConfig) (*Router, error) {
+	if config == nil {
+		config = NewRouterConfig()
+	}
+
+	if config.Mode != RouteFirstMatch && config.Mode != RouteFanOut {
+		return nil, fmt.Errorf("unknown route mode %d", config.Mode)
+	}
+
+	r := &Router{
+		mode:   config.Mode,
+		routes: make([]*compiledRoute, 0, len(config.Routes)),
+		routed: make(map[Logger]struct{}),
+	}
+	for idx, route := range config.Routes {
+		compiled, err := compileRoute(route)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", idx, err)
+		}
+		r.routes = append(r.routes, compiled)
+		for _, l := range compiled.loggers {
+			r.routed[l] = struct{}{}
+		}
+	}
+
+	return r, nil
+}
+
+func compileRoute(route *Route) (*compiledRoute, error) {
+	if route == nil {
+		return nil, errors.New("route cannot be nil")
+	}
+
+	// A Route created without NewRoute would otherwise match nothing
+	minLevel, maxLevel := route.MinLevel, route.MaxLevel
+	if minLevel == 0 {
+		minLevel = LevelDebug
+	}
+	if maxLevel == 0 {
+		maxLevel = LevelFatal
+	}
+	if minLevel < maxLevel {
+		return nil, fmt.Errorf("min level %v is more severe than max level %v", minLevel, maxLevel)
+	}
+
+	compiled := &compiledRoute{
+		loggers:      make([]Logger, 0, len(route.Loggers)),
+		minLevel:     minLevel,
+		maxLevel:     maxLevel,
+		attrs:        make(map[string]string, len(route.Attrs)),
+		attrPatterns: make(map[string]*regexp.Regexp, len(route.AttrPatterns)),
+		toDefault:    route.Default,
+	}
+	for _, l := range route.Loggers {
+		if l == nil {
+			return nil, errors.New("logger cannot be nil")
+		}
+		compiled.loggers = append(compiled.loggers, l)
+	}
+	for key, val := range route.Attrs {
+		compiled.attrs[key] = attrString(val, time.RFC3339Nano)
+	}
+	for key, pattern := range route.AttrPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("attr %s: %v", key, err)
+		}
+		compiled.attrPatterns[key] = re
+	}
+	if len(route.MessagePattern) > 0 {
+		re, err := regexp.Compile(route.MessagePattern)
+		if err != nil {
+			return nil, fmt.Errorf("message: %v", err)
+		}
+		compiled.msgPattern = re
+	}
+
+	return compiled, nil
+}
+
+// route finds the loggers msg should be sent to
+func (r *Router) route(msg *Message) *routing {
+	res := &routing{
+		router:  r,
+		loggers: make(map[Logger]struct{}),
+	}
+
+	matched := false
+	for _, route := range r.routes {
+		if !route.matches(msg) {
+			continue
+		}
+
+		matched = true
+		for _, l := range route.loggers {
+			res.loggers[l] = struct{}{}
+		}
+		if route.toDefault {
+			res.toDefault = true
+		}
+		if r.mode == RouteFirstMatch {
+			break
+		}
+	}
+	if !matched {
+		res.toDefault = true
+	}
+
+	return res
+}
+
+func (route *compiledRoute) matches(msg *Message) bool {
+	if msg.Level > route.minLevel || msg.Level < route.maxLevel {
+		return false
+	}
+
+	for key, val := range route.attrs {
+		attr, ok := routeAttr(msg, key)
+		if !ok || attrString(attr, time.RFC3339Nano) != val {
+			return false
+		}
+	}
+	for key, re := range route.attrPatterns {
+		attr, ok := routeAttr(msg, key)
+		if !ok || !re.MatchString(attrString(attr, time.RFC3339Nano)) {
+			return false
+		}
+	}
+	if route.msgPattern != nil && !route.msgPattern.MatchString(msg.Msg) {
+		return false
+	}
+
+	return true
+}
+
+// routeAttr returns the value of the attribute key for msg, using the Base's
+// attribute if the message doesn't have its own
+func routeAttr(msg *Message, key string) (interface{}, bool) {
+	if val := msg.Attrs.GetAttr(key); val != nil {
+		return val, true
+	}
+	if msg.base != nil {
+		if val := msg.base.BaseAttrs.GetAttr(key); val != nil {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// includes returns true if the message was sent to l
+func (r *routing) includes(l Logger) bool {
+	if _, ok := r.loggers[l]; ok {
+		return true
+	}
+	if r.toDefault {
+		_, routed := r.router.routed[l]
+		return !routed
+	}
+	return false
+}
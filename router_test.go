@@ -0,0 +1,260 @@
+package gomol
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type RouterSuite struct{}
+
+// withTestRouter sets a Router created from config on a Base
+func withTestRouter(config *RouterConfig) baseConfigFunc {
+	router, err := NewRouter(config)
+	Expect(err).To(BeNil())
+	return func(b *Base) {
+		b.SetRouter(router)
+	}
+}
+
+func (s *RouterSuite) TestNewRouterBadConfig(t sweet.T) {
+	_, err := NewRouter(&RouterConfig{Mode: RouteMode(100)})
+	Expect(err).ToNot(BeNil())
+
+	cfg := NewRouterConfig()
+	cfg.Routes = append(cfg.Routes, nil)
+	_, err = NewRouter(cfg)
+	Expect(err).ToNot(BeNil())
+
+	cfg = NewRouterConfig()
+	cfg.Routes = append(cfg.Routes, NewRoute(nil))
+	_, err = NewRouter(cfg)
+	Expect(err).ToNot(BeNil())
+
+	route := NewRoute()
+	route.MinLevel = LevelError
+	route.MaxLevel = LevelInfo
+	cfg = NewRouterConfig()
+	cfg.Routes = append(cfg.Routes, route)
+	_, err = NewRouter(cfg)
+	Expect(err).ToNot(BeNil())
+
+	route = NewRoute()
+	route.MessagePattern = "("
+	cfg = NewRouterConfig()
+	cfg.Routes = append(cfg.Routes, route)
+	_, err = NewRouter(cfg)
+	Expect(err).ToNot(BeNil())
+
+	route = NewRoute()
+	route.AttrPatterns = map[string]string{"attr": "["}
+	cfg = NewRouterConfig()
+	cfg.Routes = append(cfg.Routes, route)
+	_, err = NewRouter(cfg)
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *RouterSuite) TestNoRoutes(t sweet.T) {
+	b, ml1 := newTestBase(withTestRouter(nil))
+	ml2 := newDefaultMemLogger()
+	b.AddLogger(ml2)
+
+	b.Info("message")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml1)).To(Equal([]string{"message"}))
+	Expect(memMessageTexts(ml2)).To(Equal([]string{"message"}))
+}
+
+func (s *RouterSuite) TestFirstMatch(t sweet.T) {
+	audit := newDefaultMemLogger()
+	billing := newDefaultMemLogger()
+
+	auditRoute := NewRoute(audit)
+	auditRoute.Attrs = map[string]interface{}{"audit": true}
+	billingRoute := NewRoute(billing)
+	billingRoute.Attrs = map[string]interface{}{"component": "billing"}
+	billingRoute.Default = true
+
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{auditRoute, billingRoute}
+	b, def := newTestBase(withTestRouter(cfg))
+	b.AddLogger(audit)
+	b.AddLogger(billing)
+
+	b.Infom(NewAttrs().SetAttr("audit", true), "audited")
+	b.Infom(NewAttrs().SetAttr("audit", "true").SetAttr("component", "billing"), "audited billing")
+	b.Infom(NewAttrs().SetAttr("component", "billing"), "billing")
+	b.Infom(NewAttrs().SetAttr("audit", false), "other")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(audit)).To(Equal([]string{"audited", "audited billing"}))
+	Expect(memMessageTexts(billing)).To(Equal([]string{"billing"}))
+	Expect(memMessageTexts(def)).To(Equal([]string{"billing", "other"}))
+}
+
+func (s *RouterSuite) TestFanOut(t sweet.T) {
+	audit := newDefaultMemLogger()
+	billing := newDefaultMemLogger()
+
+	auditRoute := NewRoute(audit)
+	auditRoute.Attrs = map[string]interface{}{"audit": true}
+	billingRoute := NewRoute(billing)
+	billingRoute.Attrs = map[string]interface{}{"component": "billing"}
+
+	cfg := NewRouterConfig()
+	cfg.Mode = RouteFanOut
+	cfg.Routes = []*Route{auditRoute, billingRoute}
+	b, def := newTestBase(withTestRouter(cfg))
+	b.AddLogger(audit)
+	b.AddLogger(billing)
+
+	b.Infom(NewAttrs().SetAttr("audit", true).SetAttr("component", "billing"), "audited billing")
+	b.Info("other")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(audit)).To(Equal([]string{"audited billing"}))
+	Expect(memMessageTexts(billing)).To(Equal([]string{"audited billing"}))
+	Expect(memMessageTexts(def)).To(Equal([]string{"other"}))
+}
+
+func (s *RouterSuite) TestLevels(t sweet.T) {
+	errs := newDefaultMemLogger()
+
+	route := NewRoute(errs)
+	route.MinLevel = LevelWarning
+	route.MaxLevel = LevelError
+	route.Default = true
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, def := newTestBase(withTestRouter(cfg))
+	b.AddLogger(errs)
+
+	b.Debug("debug")
+	b.Warn("warning")
+	b.Error("error")
+	b.Fatal("fatal")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(errs)).To(Equal([]string{"warning", "error"}))
+	Expect(memMessageTexts(def)).To(Equal([]string{"debug", "warning", "error", "fatal"}))
+}
+
+func (s *RouterSuite) TestPatterns(t sweet.T) {
+	ml := newDefaultMemLogger()
+
+	route := NewRoute(ml)
+	route.AttrPatterns = map[string]string{"status": "^5[0-9]{2}$"}
+	route.MessagePattern = "^request"
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, _ := newTestBase(withTestRouter(cfg))
+	b.AddLogger(ml)
+
+	b.Infom(NewAttrs().SetAttr("status", 503), "request failed")
+	b.Infom(NewAttrs().SetAttr("status", 200), "request finished")
+	b.Infom(NewAttrs().SetAttr("status", 500), "other failure")
+	b.Info("request without status")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"request failed"}))
+}
+
+func (s *RouterSuite) TestBaseAttrs(t sweet.T) {
+	billing := newDefaultMemLogger()
+
+	route := NewRoute(billing)
+	route.Attrs = map[string]interface{}{"component": "billing"}
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, def := newTestBase(withTestRouter(cfg))
+	b.AddLogger(billing)
+	b.SetAttr("component", "billing")
+
+	b.Info("billing")
+	b.Infom(NewAttrs().SetAttr("component", "web"), "web")
+	b.NewLogAdapter(NewAttrs().SetAttr("component", "api")).Info("api")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(billing)).To(Equal([]string{"billing"}))
+	Expect(memMessageTexts(def)).To(Equal([]string{"web", "api"}))
+}
+
+func (s *RouterSuite) TestDropRoute(t sweet.T) {
+	route := NewRoute()
+	route.MessagePattern = "noisy"
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, ml := newTestBase(withTestRouter(cfg))
+
+	b.Info("noisy message")
+	b.Info("message")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"message"}))
+}
+
+func (s *RouterSuite) TestBatchLoggers(t sweet.T) {
+	audit := newBatchMemLogger()
+	def := newBatchMemLogger()
+
+	route := NewRoute(audit)
+	route.Attrs = map[string]interface{}{"audit": true}
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, _ := newTestBase(withTestRouter(cfg))
+	b.AddLogger(audit)
+	b.AddLogger(def)
+
+	b.Infom(NewAttrs().SetAttr("audit", true), "audited")
+	b.Info("first")
+	b.Info("second")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(audit.memLogger)).To(Equal([]string{"audited"}))
+	Expect(memMessageTexts(def.memLogger)).To(Equal([]string{"first", "second"}))
+}
+
+func (s *RouterSuite) TestFallbackLogger(t sweet.T) {
+	audit := newDefaultMemLogger()
+	fl := newDefaultMemLogger()
+	fl.SetHealthy(true)
+
+	route := NewRoute(audit)
+	route.Attrs = map[string]interface{}{"audit": true}
+	cfg := NewRouterConfig()
+	cfg.Routes = []*Route{route}
+	b, def := newTestBase(withTestRouter(cfg))
+	def.SetHealthy(true)
+	b.AddLogger(audit)
+	Expect(b.SetFallbackLogger(fl)).To(BeNil())
+
+	b.Infom(NewAttrs().SetAttr("audit", true), "audited")
+	b.Info("other")
+	b.ShutdownLoggers()
+
+	// Only the audit logger is unhealthy so only its messages fall back
+	Expect(memMessageTexts(fl)).To(Equal([]string{"audited"}))
+}
+
+func (s *RouterSuite) TestUnsetLevels(t sweet.T) {
+	all := newDefaultMemLogger()
+	errs := newDefaultMemLogger()
+
+	cfg := NewRouterConfig()
+	cfg.Mode = RouteFanOut
+	cfg.Routes = []*Route{
+		{Loggers: []Logger{all}},
+		{Loggers: []Logger{errs}, MaxLevel: LevelError},
+	}
+	b, _ := newTestBase(withTestRouter(cfg))
+	b.AddLogger(all)
+	b.AddLogger(errs)
+
+	b.Debug("debug")
+	b.Warn("warning")
+	b.Fatal("fatal")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(all)).To(Equal([]string{"debug", "warning", "fatal"}))
+	Expect(memMessageTexts(errs)).To(Equal([]string{"debug", "warning"}))
+}
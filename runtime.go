@@ -3,6 +3,7 @@ package gomol
 import (
 	"path"
 	"runtime"
+	"sync"
 )
 
 type fileRecord struct {
@@ -11,6 +12,7 @@ type fileRecord struct {
 }
 
 var gomolFiles = make(map[string]fileRecord)
+var gomolFilesLock sync.RWMutex
 
 var fakeCaller = false
 var fakeCallerFile = ""
@@ -57,41 +59,41 @@ func getCallerInfo() (string, int) {
 	return file, line
 }
 func isGomolCaller(file string) (bool, string) {
-	if val, ok := gomolFiles[file]; ok {
+	gomolFilesLock.RLock()
+	val, ok := gomolFiles[file]
+	gomolFilesLock.RUnlock()
+	if ok {
 		return val.gomolFile, val.filename
 	}
 
+	isGomol, filename := checkGomolCaller(file)
+
+	gomolFilesLock.Lock()
+	gomolFiles[file] = fileRecord{
+		filename:  filename,
+		gomolFile: isGomol,
+	}
+	gomolFilesLock.Unlock()
+
+	return isGomol, filename
+}
+
+func checkGomolCaller(file string) (bool, string) {
 	dir := path.Dir(file)
 	filename := path.Base(file)
 
 	if len(dir) < 5 {
-		gomolFiles[file] = fileRecord{
-			filename:  filename,
-			gomolFile: false,
-		}
 		return false, filename
 	}
 
 	if dir[len(dir)-5:] == "gomol" {
 		if len(filename) < 8 {
-			gomolFiles[file] = fileRecord{
-				filename:  filename,
-				gomolFile: true,
-			}
 			return true, filename
 		}
 		if filename[len(filename)-8:] == "_test.go" {
-			gomolFiles[file] = fileRecord{
-				filename:  filename,
-				gomolFile: false,
-			}
 			return false, filename
 		}
 	}
 
-	gomolFiles[file] = fileRecord{
-		filename:  filename,
-		gomolFile: false,
-	}
 	return false, filename
 }
@@ -0,0 +1,211 @@
+package gomol
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// SamplerConfig is the configuration for a Sampler
+type SamplerConfig struct {
+	// Interval is how long the First messages with the same level and format
+	// string are always logged for
+	Interval time.Duration
+
+	// First is how many messages with the same level and format string are
+	// logged in each interval before sampling starts
+	First uint64
+
+	// Thereafter logs one in every Thereafter messages once First messages
+	// have been logged in the interval.  If it is 0 no more messages are
+	// logged until the next interval.
+	Thereafter uint64
+
+	// Levels are the levels that are sampled.  Messages at other levels are
+	// always logged.  If it is empty all levels are sampled.
+	Levels []LogLevel
+
+	// DroppedAttr is the name of the attribute added to the next message
+	// logged after messages with the same level and format string were
+	// dropped, set to how many were dropped.  If it is empty the attribute
+	// isn't added.
+	DroppedAttr string
+
+	// DroppedExpiry is how long the number of dropped messages is kept for
+	// DroppedAttr when no message with the same level and format string is
+	// logged again.  After that the number is forgotten, although the
+	// messages are still counted in Stats.
+	DroppedExpiry time.Duration
+}
+
+// NewSamplerConfig creates a new SamplerConfig that logs the first 100
+// messages with the same level and format string each second, then every
+// 100th message
+func NewSamplerConfig() *SamplerConfig {
+	return &SamplerConfig{
+		Interval:      time.Second,
+		First:         100,
+		Thereafter:    100,
+		Levels:        nil,
+		DroppedAttr:   "sampled_dropped",
+		DroppedExpiry: time.Minute,
+	}
+}
+
+// SamplerStats are the counts of messages seen by a Sampler
+type SamplerStats struct {
+	// Logged is how many messages the sampler let through
+	Logged uint64
+
+	// Dropped is how many messages the sampler dropped
+	Dropped uint64
+}
+
+/*
+Sampler limits how many of the same message are logged so a hot loop can't
+flood the loggers.  Messages are grouped by their level and their unformatted
+format string, so messages that only differ by their format arguments count as
+the same message.  The first First messages in each group are logged each
+Interval, then one in every Thereafter.
+
+A Sampler is set on a Base or LogAdapter with SetSampler and decides whether to
+drop a message before it's formatted, so dropped messages cost very little.
+*/
+type Sampler struct {
+	config *SamplerConfig
+	clock  glock.Clock
+	levels map[LogLevel]bool
+
+	logged  uint64
+	dropped uint64
+
+	lock      sync.Mutex
+	counters  map[samplerKey]*samplerCounter
+	lastSweep time.Time
+}
+
+type samplerKey struct {
+	level  LogLevel
+	format string
+}
+
+type samplerCounter struct {
+	start   time.Time
+	last    time.Time
+	count   uint64
+	dropped uint64
+}
+
+// NewSampler creates a new Sampler.  If config is nil the values from
+// NewSamplerConfig are used.
+func NewSampler(config *SamplerConfig) *Sampler {
+	if config == nil {
+		config = NewSamplerConfig()
+	}
+
+	var levels map[LogLevel]bool
+	if len(config.Levels) > 0 {
+		levels = make(map[LogLevel]bool, len(config.Levels))
+		for _, level := range config.Levels {
+			levels[level] = true
+		}
+	}
+
+	return &Sampler{
+		config:   config,
+		clock:    glock.NewRealClock(),
+		levels:   levels,
+		counters: make(map[samplerKey]*samplerCounter),
+	}
+}
+
+// Stats returns how many messages the sampler has logged and dropped
+func (s *Sampler) Stats() *SamplerStats {
+	return &SamplerStats{
+		Logged:  atomic.LoadUint64(&s.logged),
+		Dropped: atomic.LoadUint64(&s.dropped),
+	}
+}
+
+// sample returns whether a message with level and format should be logged,
+// and if so how many messages like it were dropped since the last one logged
+func (s *Sampler) sample(level LogLevel, format string) (bool, uint64) {
+	if s.levels != nil && !s.levels[level] {
+		atomic.AddUint64(&s.logged, 1)
+		return true, 0
+	}
+
+	now := s.clock.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sweep(now)
+
+	key := samplerKey{level: level, format: format}
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &samplerCounter{start: now}
+		s.counters[key] = counter
+	} else if now.Sub(counter.start) >= s.config.Interval {
+		counter.start = now
+		counter.count = 0
+	}
+
+	counter.last = now
+	counter.count++
+	if counter.count > s.config.First {
+		over := counter.count - s.config.First
+		if s.config.Thereafter == 0 || over%s.config.Thereafter != 0 {
+			counter.dropped++
+			atomic.AddUint64(&s.dropped, 1)
+			return false, 0
+		}
+	}
+
+	dropped := counter.dropped
+	counter.dropped = 0
+	atomic.AddUint64(&s.logged, 1)
+	return true, dropped
+}
+
+// sweep forgets the counters of messages that haven't been logged for an
+// interval, or for DroppedExpiry if messages were dropped, so messages that
+// are never repeated don't use up memory.  It must be called with the lock
+// held.
+func (s *Sampler) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.config.Interval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, counter := range s.counters {
+		if counter.dropped == 0 && now.Sub(counter.start) >= s.config.Interval {
+			delete(s.counters, key)
+		} else if counter.dropped > 0 && now.Sub(counter.last) >= s.config.DroppedExpiry {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// sampleAttrs samples a message logged with level, m and msg.  If it should be
+// logged the attrs to log it with are returned, with DroppedAttr added to a
+// copy of m if messages like it were dropped.
+func sampleAttrs(sampler *Sampler, level LogLevel, m *Attrs, msg string) (*Attrs, bool) {
+	ok, dropped := sampler.sample(level, msg)
+	if !ok {
+		return nil, false
+	}
+
+	if dropped > 0 && len(sampler.config.DroppedAttr) > 0 {
+		if m == nil {
+			m = NewAttrs()
+		} else {
+			m = m.clone()
+		}
+		m.SetAttr(sampler.config.DroppedAttr, dropped)
+	}
+	return m, true
+}
@@ -0,0 +1,170 @@
+package gomol
+
+import (
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type SamplerSuite struct{}
+
+func newTestSampler(first uint64, thereafter uint64) (*Sampler, *glock.MockClock) {
+	cfg := NewSamplerConfig()
+	cfg.First = first
+	cfg.Thereafter = thereafter
+
+	sampler := NewSampler(cfg)
+	clock := glock.NewMockClock()
+	sampler.clock = clock
+	return sampler, clock
+}
+
+// countingStringer counts how many times it's formatted
+type countingStringer struct {
+	count int
+}
+
+func (c *countingStringer) String() string {
+	c.count++
+	return "counted"
+}
+
+func (s *SamplerSuite) TestSample(t sweet.T) {
+	sampler, _ := newTestSampler(2, 3)
+
+	var logged []bool
+	var dropped []uint64
+	for idx := 0; idx < 9; idx++ {
+		ok, d := sampler.sample(LevelInfo, "message %d")
+		logged = append(logged, ok)
+		dropped = append(dropped, d)
+	}
+
+	Expect(logged).To(Equal([]bool{true, true, false, false, true, false, false, true, false}))
+	Expect(dropped).To(Equal([]uint64{0, 0, 0, 0, 2, 0, 0, 2, 0}))
+	Expect(sampler.Stats()).To(Equal(&SamplerStats{Logged: 4, Dropped: 5}))
+}
+
+func (s *SamplerSuite) TestSampleKeys(t sweet.T) {
+	sampler, _ := newTestSampler(1, 0)
+
+	ok, _ := sampler.sample(LevelInfo, "first")
+	Expect(ok).To(BeTrue())
+	ok, _ = sampler.sample(LevelInfo, "first")
+	Expect(ok).To(BeFalse())
+
+	ok, _ = sampler.sample(LevelWarning, "first")
+	Expect(ok).To(BeTrue())
+	ok, _ = sampler.sample(LevelInfo, "second")
+	Expect(ok).To(BeTrue())
+}
+
+func (s *SamplerSuite) TestSampleInterval(t sweet.T) {
+	sampler, clock := newTestSampler(1, 0)
+
+	ok, _ := sampler.sample(LevelInfo, "message")
+	Expect(ok).To(BeTrue())
+	ok, _ = sampler.sample(LevelInfo, "message")
+	Expect(ok).To(BeFalse())
+	ok, _ = sampler.sample(LevelInfo, "message")
+	Expect(ok).To(BeFalse())
+
+	clock.Advance(time.Second)
+	ok, dropped := sampler.sample(LevelInfo, "message")
+	Expect(ok).To(BeTrue())
+	Expect(dropped).To(Equal(uint64(2)))
+	ok, _ = sampler.sample(LevelInfo, "message")
+	Expect(ok).To(BeFalse())
+}
+
+func (s *SamplerSuite) TestSampleLevels(t sweet.T) {
+	sampler := NewSampler(&SamplerConfig{
+		Interval: time.Second,
+		First:    1,
+		Levels:   []LogLevel{LevelDebug},
+	})
+
+	for idx := 0; idx < 3; idx++ {
+		ok, _ := sampler.sample(LevelError, "message")
+		Expect(ok).To(BeTrue())
+	}
+	ok, _ := sampler.sample(LevelDebug, "message")
+	Expect(ok).To(BeTrue())
+	ok, _ = sampler.sample(LevelDebug, "message")
+	Expect(ok).To(BeFalse())
+}
+
+func (s *SamplerSuite) TestSweep(t sweet.T) {
+	sampler, clock := newTestSampler(1, 0)
+
+	sampler.sample(LevelInfo, "once")
+	sampler.sample(LevelInfo, "dropped")
+	sampler.sample(LevelInfo, "dropped")
+	Expect(sampler.counters).To(HaveLen(2))
+
+	clock.Advance(time.Second)
+	sampler.sample(LevelInfo, "other")
+
+	// The dropped count is kept until the message is logged again
+	Expect(sampler.counters).To(HaveLen(2))
+	Expect(sampler.counters).To(HaveKey(samplerKey{level: LevelInfo, format: "dropped"}))
+
+	// ...or until it expires
+	clock.Advance(time.Minute)
+	sampler.sample(LevelInfo, "other")
+	Expect(sampler.counters).To(HaveLen(1))
+	Expect(sampler.counters).ToNot(HaveKey(samplerKey{level: LevelInfo, format: "dropped"}))
+}
+
+func (s *SamplerSuite) TestBaseSampler(t sweet.T) {
+	sampler, _ := newTestSampler(1, 2)
+
+	b := NewBase()
+	b.SetSampler(sampler)
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	stringer := &countingStringer{}
+	attrs := NewAttrs().SetAttr("attr", "val")
+	for idx := 0; idx < 5; idx++ {
+		b.Infom(attrs, "message %s", stringer)
+	}
+	b.ShutdownLoggers()
+
+	// Dropped messages are never formatted
+	Expect(stringer.count).To(Equal(3))
+	Expect(attrs.Attrs()).To(Equal(map[string]interface{}{"attr": "val"}))
+
+	msgs := ml.Messages()
+	Expect(msgs).To(HaveLen(3))
+	Expect(msgs[0].Attrs).ToNot(HaveKey("sampled_dropped"))
+	Expect(msgs[1].Attrs).To(HaveKeyWithValue("sampled_dropped", uint64(1)))
+	Expect(msgs[2].Attrs).To(HaveKeyWithValue("sampled_dropped", uint64(1)))
+	Expect(msgs[2].Attrs).To(HaveKeyWithValue("attr", "val"))
+
+	b.SetSampler(nil)
+	Expect(b.sampler()).To(BeNil())
+}
+
+func (s *SamplerSuite) TestLogAdapterSampler(t sweet.T) {
+	sampler, _ := newTestSampler(1, 0)
+
+	b := NewBase()
+	ml := newDefaultMemLogger()
+	b.AddLogger(ml)
+	b.InitLoggers()
+
+	la := b.NewLogAdapter(nil)
+	la.SetSampler(sampler)
+	la.Info("message")
+	la.Info("message")
+	b.Info("message")
+	la.LogWithTime(LevelInfo, time.Now(), nil, "message")
+	b.ShutdownLoggers()
+
+	Expect(ml.Messages()).To(HaveLen(2))
+	Expect(sampler.Stats()).To(Equal(&SamplerStats{Logged: 1, Dropped: 2}))
+}
@@ -0,0 +1,376 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	spoolRecordMessage byte = 'M'
+	spoolRecordAck     byte = 'A'
+
+	// A record is a one byte type, a four byte payload length and a four
+	// byte CRC-32 of the type, length and payload followed by the payload.
+	spoolHeaderSize = 9
+
+	// spoolMaxRecordSize is the largest payload that will be read back from
+	// a spool.  Anything larger is treated as a corrupt record.
+	spoolMaxRecordSize = 64 << 20
+
+	// spoolMaxWaste is how many bytes of delivered messages and acks the
+	// spool file can collect before it's rewritten with only the messages
+	// that haven't been delivered yet.
+	spoolMaxWaste = 1 << 20
+)
+
+var errSpoolClosed = errors.New("spool is closed")
+
+/*
+spool is a write-ahead log of messages that have been logged but not yet
+delivered to every logger.  Each message is appended to the spool file before
+Log returns and an ack is appended once the queue worker has passed it to every
+logger it was written to.  A logger that returned an error for it has it retried
+from memory instead, so the loggers that did write it don't get it again.  When
+the spool is opened again after a crash, any messages without an ack are
+replayed.  A record that was only partially written when the process
+died fails its checksum and it, along with anything after it, is discarded.
+*/
+type spool struct {
+	lock       sync.Mutex
+	path       string
+	syncWrites bool
+	file       *os.File
+	users      int
+
+	nextID       uint64
+	size         int64
+	pending      map[uint64][]byte
+	pendingBytes int64
+}
+
+// spoolEntry is how a message is stored in the spool.  Attributes are stored
+// as JSON so replayed messages will have JSON types for their values, such as
+// json.Number for numbers.
+type spoolEntry struct {
+	Level     LogLevel               `json:"level"`
+	Timestamp time.Time              `json:"timestamp"`
+	Attrs     map[string]interface{} `json:"attrs,omitempty"`
+	Msg       string                 `json:"msg"`
+}
+
+type spoolRecord struct {
+	recordType byte
+	id         uint64
+	payload    []byte
+	raw        []byte
+}
+
+// openSpool opens the spool file at path, creating it if it doesn't exist, and
+// returns the messages in it that were never delivered.  The file is
+// rewritten to only hold those messages.
+func openSpool(path string, syncWrites bool) (*spool, []*spoolRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	s := &spool{
+		path:       path,
+		syncWrites: syncWrites,
+		users:      1,
+		pending:    make(map[uint64][]byte),
+	}
+
+	records := decodeSpoolRecords(data)
+	msgs := make([]*spoolRecord, 0)
+	for _, record := range records {
+		switch record.recordType {
+		case spoolRecordMessage:
+			s.pending[record.id] = record.raw
+			s.pendingBytes += int64(len(record.raw))
+			msgs = append(msgs, record)
+			if record.id >= s.nextID {
+				s.nextID = record.id + 1
+			}
+		case spoolRecordAck:
+			if raw, ok := s.pending[record.id]; ok {
+				delete(s.pending, record.id)
+				s.pendingBytes -= int64(len(raw))
+			}
+		}
+	}
+
+	replay := make([]*spoolRecord, 0, len(s.pending))
+	for _, record := range msgs {
+		if _, ok := s.pending[record.id]; ok {
+			replay = append(replay, record)
+		}
+	}
+
+	err = s.rewrite()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, replay, nil
+}
+
+// decodeSpoolRecords reads records from data until it runs out of data or
+// finds a record that is incomplete or corrupt.
+func decodeSpoolRecords(data []byte) []*spoolRecord {
+	records := make([]*spoolRecord, 0)
+	for len(data) >= spoolHeaderSize {
+		recordType := data[0]
+		payloadLen := binary.BigEndian.Uint32(data[1:5])
+		checksum := binary.BigEndian.Uint32(data[5:9])
+		if payloadLen < 8 || payloadLen > spoolMaxRecordSize || len(data)-spoolHeaderSize < int(payloadLen) {
+			break
+		}
+
+		raw := data[:spoolHeaderSize+int(payloadLen)]
+		if spoolChecksum(raw) != checksum {
+			break
+		}
+		if recordType != spoolRecordMessage && recordType != spoolRecordAck {
+			break
+		}
+
+		payload := raw[spoolHeaderSize:]
+		records = append(records, &spoolRecord{
+			recordType: recordType,
+			id:         binary.BigEndian.Uint64(payload[:8]),
+			payload:    payload[8:],
+			raw:        raw,
+		})
+		data = data[len(raw):]
+	}
+	return records
+}
+
+func encodeSpoolRecord(recordType byte, id uint64, body []byte) []byte {
+	raw := make([]byte, spoolHeaderSize+8+len(body))
+	raw[0] = recordType
+	binary.BigEndian.PutUint32(raw[1:5], uint32(8+len(body)))
+	binary.BigEndian.PutUint64(raw[spoolHeaderSize:spoolHeaderSize+8], id)
+	copy(raw[spoolHeaderSize+8:], body)
+	binary.BigEndian.PutUint32(raw[5:9], spoolChecksum(raw))
+	return raw
+}
+
+// spoolChecksum calculates the checksum of a record, skipping over the bytes
+// the checksum itself is stored in.
+func spoolChecksum(raw []byte) uint32 {
+	crc := crc32.ChecksumIEEE(raw[:5])
+	return crc32.Update(crc, crc32.IEEETable, raw[spoolHeaderSize:])
+}
+
+// entry decodes the message stored in a message record
+func (r *spoolRecord) entry() (*spoolEntry, error) {
+	entry := &spoolEntry{}
+	dec := json.NewDecoder(bytes.NewReader(r.payload))
+	dec.UseNumber()
+	err := dec.Decode(entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// append writes msg to the spool and marks msg as spooled so it's acked once
+// it's delivered.
+func (s *spool) append(msg *Message) error {
+	body, err := json.Marshal(&spoolEntry{
+		Level:     msg.Level,
+		Timestamp: msg.Timestamp,
+		Attrs:     msg.Attrs.Attrs(),
+		Msg:       msg.Msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return errSpoolClosed
+	}
+
+	id := s.nextID
+	raw := encodeSpoolRecord(spoolRecordMessage, id, body)
+	err = s.write(raw)
+	if err != nil {
+		return err
+	}
+	if s.syncWrites {
+		err = s.file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.nextID++
+	s.pending[id] = raw
+	s.pendingBytes += int64(len(raw))
+
+	msg.spool = s
+	msg.spoolID = id
+
+	return nil
+}
+
+// ack marks the message with the given id as delivered.  Once every message
+// has been delivered the spool file is truncated, and if enough delivered
+// messages pile up in the file it's rewritten without them.
+func (s *spool) ack(id uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	raw, ok := s.pending[id]
+	if !ok {
+		return nil
+	}
+	if s.file == nil {
+		return errSpoolClosed
+	}
+
+	delete(s.pending, id)
+	s.pendingBytes -= int64(len(raw))
+
+	if len(s.pending) == 0 {
+		err := s.file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		s.size = 0
+		return nil
+	}
+
+	err := s.write(encodeSpoolRecord(spoolRecordAck, id, nil))
+	if err != nil {
+		return err
+	}
+
+	if s.size-s.pendingBytes > spoolMaxWaste {
+		return s.rewrite()
+	}
+	return nil
+}
+
+func (s *spool) write(raw []byte) error {
+	n, err := s.file.Write(raw)
+	s.size += int64(n)
+	return err
+}
+
+// rewrite replaces the spool file with one that only has the messages that
+// haven't been delivered yet.  It must be called with the lock held.
+func (s *spool) rewrite() error {
+	ids := make([]uint64, 0, len(s.pending))
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, id := range ids {
+		n, err := tmp.Write(s.pending[id])
+		size += int64(n)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.size = size
+
+	return nil
+}
+
+// retain adds a user of the spool so it isn't closed until that user
+// releases it.  It returns false if the spool has already been closed.
+func (s *spool) retain() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return false
+	}
+	s.users++
+	return true
+}
+
+// release removes a user of the spool and closes it once there are no users
+// left.  The spool starts with one user, the one that opened it.
+func (s *spool) release() error {
+	s.lock.Lock()
+	s.users--
+	users := s.users
+	s.lock.Unlock()
+
+	if users > 0 {
+		return nil
+	}
+	return s.close()
+}
+
+// close closes the spool file.  Any messages that haven't been delivered are
+// left in the file to be replayed the next time it's opened.
+func (s *spool) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return errSpoolClosed
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// ackMessage marks msg as delivered in the spool it was written to, if any.
+// If the ack can't be written the message will be replayed again the next
+// time the spool is opened, which is preferable to losing it.
+func ackMessage(msg *Message) {
+	if msg != nil && msg.spool != nil {
+		msg.spool.ack(msg.spoolID)
+	}
+}
@@ -0,0 +1,353 @@
+package gomol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type SpoolSuite struct {
+	dir string
+}
+
+func (s *SpoolSuite) SetUpTest(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol")
+	Expect(err).To(BeNil())
+	s.dir = dir
+}
+
+func (s *SpoolSuite) TearDownTest(t sweet.T) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *SpoolSuite) spoolFile() string {
+	return filepath.Join(s.dir, "gomol.spool")
+}
+
+func (s *SpoolSuite) newSpoolBase(loggers ...Logger) *Base {
+	b := NewBase()
+	cfg := NewConfig()
+	cfg.SpoolFile = s.spoolFile()
+	b.SetConfig(cfg)
+	for _, logger := range loggers {
+		b.AddLogger(logger)
+	}
+	return b
+}
+
+// writeSpool writes count messages to a spool and closes it without
+// acking any of them, as if the process crashed before they were logged.
+func (s *SpoolSuite) writeSpool(count int) []int64 {
+	sp, replay, err := openSpool(s.spoolFile(), false)
+	Expect(err).To(BeNil())
+	Expect(replay).To(HaveLen(0))
+
+	sizes := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		msg := newMessage(time.Now(), nil, LevelInfo, NewAttrs().SetAttr("idx", i), "message %d", i)
+		Expect(sp.append(msg)).To(BeNil())
+		sizes = append(sizes, sp.size)
+	}
+	Expect(sp.close()).To(BeNil())
+
+	return sizes
+}
+
+func (s *SpoolSuite) replayMessages() []*memMessage {
+	ml := newDefaultMemLogger()
+	b := s.newSpoolBase(ml)
+	Expect(b.InitLoggers()).To(BeNil())
+	b.Flush()
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	return ml.Messages()
+}
+
+func (s *SpoolSuite) TestDeliveredMessagesRemoved(t sweet.T) {
+	ml := newDefaultMemLogger()
+	b := s.newSpoolBase(ml)
+	Expect(b.InitLoggers()).To(BeNil())
+
+	for i := 0; i < 10; i++ {
+		Expect(b.Infof("message %d", i)).To(BeNil())
+	}
+	b.Flush()
+	Expect(ml.Messages()).To(HaveLen(10))
+
+	info, err := os.Stat(s.spoolFile())
+	Expect(err).To(BeNil())
+	Expect(info.Size()).To(Equal(int64(0)))
+
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
+
+func (s *SpoolSuite) TestReplayUndelivered(t sweet.T) {
+	blocker := make(chan struct{})
+	defer close(blocker)
+
+	b := s.newSpoolBase(&BlockingLogger{ch: blocker})
+	Expect(b.InitLoggers()).To(BeNil())
+
+	for i := 0; i < 5; i++ {
+		Expect(b.Infom(NewAttrs().SetAttr("idx", i), "message %d", i)).To(BeNil())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	Expect(b.ShutdownLoggersContext(ctx)).ToNot(BeNil())
+
+	msgs := s.replayMessages()
+	Expect(msgs).To(HaveLen(5))
+	for i, msg := range msgs {
+		Expect(msg.Level).To(Equal(LevelInfo))
+		Expect(msg.Message).To(Equal(fmt.Sprintf("message %d", i)))
+		Expect(msg.Attrs["idx"]).To(Equal(json.Number(fmt.Sprintf("%d", i))))
+	}
+
+	// Once they've been replayed they're gone
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
+
+func (s *SpoolSuite) TestReplayBeforeNewMessages(t sweet.T) {
+	s.writeSpool(2)
+
+	ml := newDefaultMemLogger()
+	b := s.newSpoolBase(ml)
+	Expect(b.InitLoggers()).To(BeNil())
+	defer b.ShutdownLoggers()
+
+	b.Info("new message")
+	b.Flush()
+
+	msgs := ml.Messages()
+	Expect(msgs).To(HaveLen(3))
+	Expect(msgs[0].Message).To(Equal("message 0"))
+	Expect(msgs[1].Message).To(Equal("message 1"))
+	Expect(msgs[2].Message).To(Equal("new message"))
+}
+
+type failingLogger struct {
+	*memLogger
+}
+
+func (l *failingLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	return errors.New("log failed")
+}
+
+func (s *SpoolSuite) TestFailedAcked(t sweet.T) {
+	ml := newDefaultMemLogger()
+	b := s.newSpoolBase(ml, &failingLogger{memLogger: newDefaultMemLogger()})
+	Expect(b.InitLoggers()).To(BeNil())
+
+	Expect(b.Info("message 0")).To(BeNil())
+	Expect(b.Info("message 1")).To(BeNil())
+	b.Flush()
+	Expect(ml.Messages()).To(HaveLen(2))
+
+	// The failed messages are retried from memory instead of staying in
+	// the spool
+	info, err := os.Stat(s.spoolFile())
+	Expect(err).To(BeNil())
+	Expect(info.Size()).To(Equal(int64(0)))
+
+	// so the logger that did get them doesn't get them again
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
+
+func (s *SpoolSuite) TestFailedBatchAcked(t sweet.T) {
+	bl := newBatchMemLogger()
+	b := s.newSpoolBase(bl, &errorBatchLogger{batchMemLogger: newBatchMemLogger()})
+	Expect(b.InitLoggers()).To(BeNil())
+
+	Expect(b.Info("message 0")).To(BeNil())
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Expect(bl.Messages()).To(HaveLen(1))
+
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
+
+func (s *SpoolSuite) TestUndecodableRecordDropped(t sweet.T) {
+	s.writeSpool(1)
+
+	f, err := os.OpenFile(s.spoolFile(), os.O_WRONLY|os.O_APPEND, 0600)
+	Expect(err).To(BeNil())
+	_, err = f.Write(encodeSpoolRecord(spoolRecordMessage, 1, []byte("not json")))
+	Expect(err).To(BeNil())
+	Expect(f.Close()).To(BeNil())
+
+	errChan := make(chan error, 2)
+	ml := newDefaultMemLogger()
+	b := s.newSpoolBase(ml)
+	b.SetErrorChan(errChan)
+	Expect(b.InitLoggers()).To(BeNil())
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"message 0"}))
+	Expect(errChan).To(Receive(HaveOccurred()))
+
+	// It's reported once and then removed from the spool
+	Expect(s.replayMessages()).To(HaveLen(0))
+	sp, replay, err := openSpool(s.spoolFile(), false)
+	Expect(err).To(BeNil())
+	Expect(replay).To(HaveLen(0))
+	Expect(sp.close()).To(BeNil())
+}
+
+func (s *SpoolSuite) TestReplayTruncatedPayload(t sweet.T) {
+	sizes := s.writeSpool(3)
+	Expect(os.Truncate(s.spoolFile(), sizes[2]-5)).To(BeNil())
+
+	msgs := s.replayMessages()
+	Expect(msgs).To(HaveLen(2))
+	Expect(msgs[0].Message).To(Equal("message 0"))
+	Expect(msgs[1].Message).To(Equal("message 1"))
+}
+
+func (s *SpoolSuite) TestReplayTruncatedHeader(t sweet.T) {
+	sizes := s.writeSpool(3)
+	Expect(os.Truncate(s.spoolFile(), sizes[1]+4)).To(BeNil())
+
+	msgs := s.replayMessages()
+	Expect(msgs).To(HaveLen(2))
+	Expect(msgs[1].Message).To(Equal("message 1"))
+}
+
+func (s *SpoolSuite) TestReplayCorruptRecord(t sweet.T) {
+	sizes := s.writeSpool(3)
+
+	data, err := ioutil.ReadFile(s.spoolFile())
+	Expect(err).To(BeNil())
+	data[sizes[0]+spoolHeaderSize+10] ^= 0xff
+	Expect(ioutil.WriteFile(s.spoolFile(), data, 0600)).To(BeNil())
+
+	// Nothing after the corrupt record can be trusted either
+	msgs := s.replayMessages()
+	Expect(msgs).To(HaveLen(1))
+	Expect(msgs[0].Message).To(Equal("message 0"))
+}
+
+func (s *SpoolSuite) TestTruncatedRecordDiscarded(t sweet.T) {
+	sizes := s.writeSpool(3)
+	Expect(os.Truncate(s.spoolFile(), sizes[2]-5)).To(BeNil())
+
+	sp, replay, err := openSpool(s.spoolFile(), false)
+	Expect(err).To(BeNil())
+	Expect(replay).To(HaveLen(2))
+
+	// The torn record is gone from the file so new records are
+	// appended after the good ones.
+	info, err := os.Stat(s.spoolFile())
+	Expect(err).To(BeNil())
+	Expect(info.Size()).To(Equal(sizes[1]))
+
+	Expect(sp.append(newMessage(time.Now(), nil, LevelInfo, nil, "message 3"))).To(BeNil())
+	Expect(sp.close()).To(BeNil())
+
+	msgs := s.replayMessages()
+	Expect(msgs).To(HaveLen(3))
+	Expect(msgs[2].Message).To(Equal("message 3"))
+}
+
+func (s *SpoolSuite) TestAckedNotReplayed(t sweet.T) {
+	sp, _, err := openSpool(s.spoolFile(), true)
+	Expect(err).To(BeNil())
+
+	msgs := make([]*Message, 0)
+	for i := 0; i < 3; i++ {
+		msg := newMessage(time.Now(), nil, LevelInfo, nil, "message %d", i)
+		Expect(sp.append(msg)).To(BeNil())
+		msgs = append(msgs, msg)
+	}
+	Expect(sp.ack(msgs[1].spoolID)).To(BeNil())
+	Expect(sp.close()).To(BeNil())
+
+	sp, replay, err := openSpool(s.spoolFile(), false)
+	Expect(err).To(BeNil())
+	Expect(replay).To(HaveLen(2))
+	Expect(replay[0].id).To(Equal(msgs[0].spoolID))
+	Expect(replay[1].id).To(Equal(msgs[2].spoolID))
+
+	// New messages don't reuse the ids of the replayed ones
+	msg := newMessage(time.Now(), nil, LevelInfo, nil, "message 3")
+	Expect(sp.append(msg)).To(BeNil())
+	Expect(msg.spoolID).To(Equal(uint64(3)))
+	Expect(sp.close()).To(BeNil())
+}
+
+func (s *SpoolSuite) TestAckClosedSpool(t sweet.T) {
+	sp, _, err := openSpool(s.spoolFile(), false)
+	Expect(err).To(BeNil())
+
+	msg := newMessage(time.Now(), nil, LevelInfo, nil, "message")
+	Expect(sp.append(msg)).To(BeNil())
+	Expect(sp.close()).To(BeNil())
+
+	Expect(sp.ack(msg.spoolID)).To(Equal(errSpoolClosed))
+	Expect(sp.append(msg)).To(Equal(errSpoolClosed))
+}
+
+func (s *SpoolSuite) spoolClosed(sp *spool) func() bool {
+	return func() bool {
+		sp.lock.Lock()
+		defer sp.lock.Unlock()
+		return sp.file == nil
+	}
+}
+
+func (s *SpoolSuite) TestShutdownTimeoutKeepsSpoolOpen(t sweet.T) {
+	blocker := make(chan struct{})
+	b := s.newSpoolBase(&BlockingLogger{ch: blocker})
+	Expect(b.InitLoggers()).To(BeNil())
+	sp := b.spool
+
+	for i := 0; i < 3; i++ {
+		Expect(b.Infof("message %d", i)).To(BeNil())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	Expect(b.ShutdownLoggersContext(ctx)).To(BeAssignableToTypeOf(&IncompleteError{}))
+
+	// The worker is still writing the messages so it can still ack them
+	Consistently(s.spoolClosed(sp)).Should(BeFalse())
+
+	close(blocker)
+	Eventually(s.spoolClosed(sp)).Should(BeTrue())
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
+
+func (s *SpoolSuite) TestInitWhileSpoolClosing(t sweet.T) {
+	blocker := make(chan struct{})
+	b := s.newSpoolBase(&BlockingLogger{ch: blocker})
+	Expect(b.InitLoggers()).To(BeNil())
+	sp := b.spool
+
+	for i := 0; i < 3; i++ {
+		Expect(b.Infof("message %d", i)).To(BeNil())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	Expect(b.ShutdownLoggersContext(ctx)).To(BeAssignableToTypeOf(&IncompleteError{}))
+
+	// The spool the old worker is using is picked up again rather than
+	// opened a second time, and its messages aren't replayed
+	Expect(b.InitLoggers()).To(BeNil())
+	Expect(b.spool).To(BeIdenticalTo(sp))
+	Expect(b.queue.queueChan).To(HaveLen(0))
+
+	close(blocker)
+	Expect(b.Infof("message 3")).To(BeNil())
+	Expect(b.ShutdownLoggers()).To(BeNil())
+	Eventually(s.spoolClosed(sp)).Should(BeTrue())
+	Expect(s.replayMessages()).To(HaveLen(0))
+}
@@ -0,0 +1,124 @@
+package gomol
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// stdFlagsPrefix matches the date, time and file a log.Logger adds to the
+// start of each line depending on its flags
+var stdFlagsPrefix = regexp.MustCompile(
+	`^(\d{4}/\d{2}/\d{2} )?(\d{2}:\d{2}:\d{2}(\.\d{6})? )?(\S+\.go:\d+: )?`,
+)
+
+// levelPrefix matches a level at the start of a line such as "[ERROR]",
+// "[warn]" or "ERROR:"
+var levelPrefix = regexp.MustCompile(`^(?:\[([A-Za-z]+)\]|([A-Za-z]+):)\s*`)
+
+/*
+LogWriter is an io.Writer that logs each line written to it as a message.  The
+date, time and file a log.Logger adds to the start of a line are removed, and
+if the line starts with a level such as "[ERROR]" or "WARN:" the message is
+logged at that level instead of the LogWriter's level.
+
+Any text written after the last newline is kept until the rest of the line is
+written or Close is called.  A LogWriter can be passed to log.SetOutput to log
+everything logged by the standard library's log package:
+
+	log.SetOutput(gomol.NewWriter(base, gomol.LevelInfo, nil))
+*/
+type LogWriter struct {
+	logger WrappableLogger
+	level  LogLevel
+	attrs  *Attrs
+
+	lock sync.Mutex
+	buf  []byte
+}
+
+// NewWriter creates a new LogWriter that logs lines to logger at level with
+// attrs added to each message
+func NewWriter(logger WrappableLogger, level LogLevel, attrs *Attrs) *LogWriter {
+	if attrs == nil {
+		attrs = NewAttrs()
+	}
+
+	return &LogWriter{
+		logger: logger,
+		level:  level,
+		attrs:  attrs,
+	}
+}
+
+// NewStdLogger creates a *log.Logger, such as for http.Server's ErrorLog, that
+// logs each line logged to it to logger at level with attrs added to each
+// message
+func NewStdLogger(logger WrappableLogger, level LogLevel, attrs *Attrs) *log.Logger {
+	return log.New(NewWriter(logger, level, attrs), "", 0)
+}
+
+// Write logs each complete line in p.  It always returns len(p) and a nil
+// error so the log package doesn't give up writing to it.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+	start := 0
+	for {
+		idx := bytes.IndexByte(w.buf[start:], '\n')
+		if idx < 0 {
+			break
+		}
+
+		w.logLine(string(w.buf[start : start+idx]))
+		start += idx + 1
+	}
+
+	// Move any partial line to the front so the underlying array is reused
+	// for the next line
+	w.buf = w.buf[:copy(w.buf, w.buf[start:])]
+
+	return len(p), nil
+}
+
+// Close logs any text written after the last newline
+func (w *LogWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logLine(string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
+
+// logLine logs a single line.  It must be called with the lock held.
+func (w *LogWriter) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	line = stdFlagsPrefix.ReplaceAllString(line, "")
+
+	level := w.level
+	if match := levelPrefix.FindStringSubmatch(line); match != nil {
+		name := match[1]
+		if len(name) == 0 {
+			name = match[2]
+		}
+		if parsed, err := ToLogLevel(name); err == nil && parsed != LevelNone {
+			level = parsed
+			line = line[len(match[0]):]
+		}
+	}
+
+	if len(strings.TrimSpace(line)) == 0 {
+		return
+	}
+
+	// Loggers may add to the attributes they're given, so each message
+	// needs its own copy.
+	w.logger.Log(level, w.attrs.clone(), line)
+}
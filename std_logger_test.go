@@ -0,0 +1,140 @@
+package gomol
+
+import (
+	"log"
+	"os"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type StdLoggerSuite struct{}
+
+func (s *StdLoggerSuite) TestStdLogger(t sweet.T) {
+	b, ml := newTestBase()
+
+	l := NewStdLogger(b, LevelWarning, NewAttrs().SetAttr("source", "http"))
+	l.Printf("http: TLS handshake error from %s", "127.0.0.1")
+	l.Print("[ERROR] something failed")
+	l.Print("100% done")
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"http: TLS handshake error from 127.0.0.1",
+		"something failed",
+		"100% done",
+	}))
+	msgs := ml.Messages()
+	Expect(msgs[0].Level).To(Equal(LevelWarning))
+	Expect(msgs[0].Attrs).To(Equal(map[string]interface{}{"source": "http"}))
+	Expect(msgs[1].Level).To(Equal(LevelError))
+}
+
+func (s *StdLoggerSuite) TestStripFlags(t sweet.T) {
+	b, ml := newTestBase()
+
+	w := NewWriter(b, LevelInfo, nil)
+	for _, flags := range []int{
+		log.LstdFlags,
+		log.Ldate | log.Ltime | log.Lmicroseconds,
+		log.LstdFlags | log.Lshortfile,
+		log.Llongfile,
+		0,
+	} {
+		log.New(w, "", flags).Print("message")
+	}
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"message",
+		"message",
+		"message",
+		"message",
+		"message",
+	}))
+}
+
+func (s *StdLoggerSuite) TestInferLevel(t sweet.T) {
+	b, ml := newTestBase()
+
+	w := NewWriter(b, LevelInfo, nil)
+	w.Write([]byte("[DEBUG] debug\n[warn]  warning\nERROR: error\n"))
+	w.Write([]byte("[fatal] fatal\n[none] none\n[unknown] unknown\nhttp: server\n"))
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{
+		"debug",
+		"warning",
+		"error",
+		"fatal",
+		"[none] none",
+		"[unknown] unknown",
+		"http: server",
+	}))
+
+	var levels []LogLevel
+	for _, msg := range ml.Messages() {
+		levels = append(levels, msg.Level)
+	}
+	Expect(levels).To(Equal([]LogLevel{
+		LevelDebug,
+		LevelWarning,
+		LevelError,
+		LevelFatal,
+		LevelInfo,
+		LevelInfo,
+		LevelInfo,
+	}))
+}
+
+func (s *StdLoggerSuite) TestSplitLines(t sweet.T) {
+	b, ml := newTestBase()
+
+	w := NewWriter(b.NewLogAdapter(nil), LevelInfo, nil)
+	n, err := w.Write([]byte("first\r\nsec"))
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(10))
+	w.Write([]byte("ond\n\n   \nthi"))
+	w.Write([]byte("rd"))
+	b.Flush()
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first", "second"}))
+
+	Expect(w.Close()).To(BeNil())
+	Expect(w.Close()).To(BeNil())
+	b.ShutdownLoggers()
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first", "second", "third"}))
+}
+
+func (s *StdLoggerSuite) TestReuseBuffer(t sweet.T) {
+	b, ml := newTestBase()
+
+	w := NewWriter(b.NewLogAdapter(nil), LevelInfo, nil)
+	w.Write([]byte("first\nsec"))
+	Expect(string(w.buf)).To(Equal("sec"))
+	start := &w.buf[0]
+
+	w.Write([]byte("ond\n"))
+	Expect(w.buf).To(BeEmpty())
+	Expect(&w.buf[:1][0]).To(Equal(start))
+
+	b.ShutdownLoggers()
+	Expect(memMessageTexts(ml)).To(Equal([]string{"first", "second"}))
+}
+
+func (s *StdLoggerSuite) TestSetOutput(t sweet.T) {
+	b, ml := newTestBase()
+
+	flags := log.Flags()
+	log.SetOutput(NewWriter(b, LevelInfo, nil))
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	log.Printf("[WARN] global %d", 1)
+	b.ShutdownLoggers()
+
+	Expect(memMessageTexts(ml)).To(Equal([]string{"global 1"}))
+	Expect(ml.Messages()[0].Level).To(Equal(LevelWarning))
+}
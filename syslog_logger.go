@@ -0,0 +1,451 @@
+package gomol
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// SyslogFormat is the format a SyslogLogger writes messages in
+type SyslogFormat int
+
+const (
+	// SyslogFormatRFC5424 writes messages in the format described in RFC 5424
+	// with the message's attributes in a structured data element.
+	SyslogFormatRFC5424 SyslogFormat = iota
+	// SyslogFormatRFC3164 writes messages in the legacy BSD syslog format
+	// described in RFC 3164.  This format has no room for attributes so they
+	// are not included.
+	SyslogFormatRFC3164
+)
+
+// SyslogFraming is how messages are separated from each other when they're
+// written to a stream connection such as TCP.  Messages written to datagram
+// connections such as UDP are never framed.
+type SyslogFraming int
+
+const (
+	// SyslogFramingOctetCounting prefixes each message with its length as
+	// described in RFC 6587.
+	SyslogFramingOctetCounting SyslogFraming = iota
+	// SyslogFramingNonTransparent ends each message with a newline as
+	// described in RFC 6587.
+	SyslogFramingNonTransparent
+)
+
+// SyslogFacility is the syslog facility messages are logged to
+type SyslogFacility int
+
+// The syslog facilities defined in RFC 5424
+const (
+	SyslogFacilityKern SyslogFacility = iota
+	SyslogFacilityUser
+	SyslogFacilityMail
+	SyslogFacilityDaemon
+	SyslogFacilityAuth
+	SyslogFacilitySyslog
+	SyslogFacilityLPR
+	SyslogFacilityNews
+	SyslogFacilityUUCP
+	SyslogFacilityCron
+	SyslogFacilityAuthPriv
+	SyslogFacilityFTP
+	SyslogFacilityNTP
+	SyslogFacilityAudit
+	SyslogFacilityAlert
+	SyslogFacilityClock
+	SyslogFacilityLocal0
+	SyslogFacilityLocal1
+	SyslogFacilityLocal2
+	SyslogFacilityLocal3
+	SyslogFacilityLocal4
+	SyslogFacilityLocal5
+	SyslogFacilityLocal6
+	SyslogFacilityLocal7
+)
+
+// ErrSyslogDisconnected is returned when a message is logged while a
+// SyslogLogger is reconnecting to the syslog server
+var ErrSyslogDisconnected = errors.New("not connected to the syslog server")
+
+// The paths the local syslog socket is usually found at
+var syslogLocalPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const syslogTimeRFC5424 = "2006-01-02T15:04:05.000000Z07:00"
+
+// SyslogLoggerConfig is the configuration for a SyslogLogger
+type SyslogLoggerConfig struct {
+	// Network is the type of connection to the syslog server and can be one
+	// of "unix", "udp", "tcp" or "tcp+tls".  A "unix" connection will try a
+	// datagram socket before a stream socket.
+	Network string
+
+	// Address is the address of the syslog server.  For a "unix" connection it
+	// is the path to the socket and if it's empty the usual locations of the
+	// local syslog socket, such as /dev/log, are tried.
+	Address string
+
+	// TLSConfig is the TLS configuration used for "tcp+tls" connections
+	TLSConfig *tls.Config
+
+	// Format is the format messages are written in
+	Format SyslogFormat
+
+	// Framing is how messages are separated on stream connections
+	Framing SyslogFraming
+
+	// Facility is the facility messages are logged to
+	Facility SyslogFacility
+
+	// Hostname is the name of the host included with each message
+	Hostname string
+
+	// AppName is the name of the application included with each message
+	AppName string
+
+	// StructuredDataID is the ID of the structured data element a message's
+	// attributes are added to when using SyslogFormatRFC5424.  It should be
+	// in the form name@<private enterprise number> using an enterprise
+	// number assigned to you by IANA.  If it's empty, which is the default,
+	// attributes aren't included in messages.
+	StructuredDataID string
+
+	// DialTimeout is how long to wait when connecting to the syslog server
+	DialTimeout time.Duration
+
+	// WriteTimeout is how long to wait for a message to be written before
+	// the connection is considered broken
+	WriteTimeout time.Duration
+
+	// RetryDelay is how long to wait between attempts to reconnect after
+	// the connection to the syslog server is lost
+	RetryDelay
+}
+
+// NewSyslogLoggerConfig creates a new SyslogLoggerConfig that logs to the
+// local syslog socket with default settings
+func NewSyslogLoggerConfig() *SyslogLoggerConfig {
+	hostname, _ := os.Hostname()
+
+	return &SyslogLoggerConfig{
+		Network:          "unix",
+		Address:          "",
+		Format:           SyslogFormatRFC5424,
+		Framing:          SyslogFramingOctetCounting,
+		Facility:         SyslogFacilityUser,
+		Hostname:         hostname,
+		AppName:          filepath.Base(os.Args[0]),
+		StructuredDataID: "",
+		DialTimeout:      5 * time.Second,
+		WriteTimeout:     5 * time.Second,
+		RetryDelay: RetryDelay{
+			MinRetryDelay: 100 * time.Millisecond,
+			MaxRetryDelay: 30 * time.Second,
+		},
+	}
+}
+
+/*
+SyslogLogger is a Logger that sends messages to a syslog server.  If the
+connection to the server is lost the logger reports itself as unhealthy and
+reconnects in the background, waiting longer between each failed attempt.
+*/
+type SyslogLogger struct {
+	config *SyslogLoggerConfig
+	clock  glock.Clock
+	pid    int
+
+	lock          sync.Mutex
+	base          *Base
+	conn          *reconnectingConn
+	isInitialized bool
+}
+
+var _ Logger = &SyslogLogger{}
+var _ HealthCheckLogger = &SyslogLogger{}
+
+// NewSyslogLogger creates a new SyslogLogger.  If config is nil the values
+// from NewSyslogLoggerConfig are used.
+func NewSyslogLogger(config *SyslogLoggerConfig) (*SyslogLogger, error) {
+	if config == nil {
+		config = NewSyslogLoggerConfig()
+	}
+
+	switch config.Network {
+	case "unix", "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tcp+tls":
+	default:
+		return nil, fmt.Errorf("unknown syslog network: %s", config.Network)
+	}
+	if config.Format != SyslogFormatRFC5424 && config.Format != SyslogFormatRFC3164 {
+		return nil, fmt.Errorf("unknown syslog format: %d", config.Format)
+	}
+	if err := config.RetryDelay.validate(); err != nil {
+		return nil, err
+	}
+
+	return &SyslogLogger{
+		config: config,
+		clock:  glock.NewRealClock(),
+		pid:    os.Getpid(),
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *SyslogLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger connects to the syslog server
+func (l *SyslogLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	conn := newReconnectingConn(&l.lock, l.clock, l.config.RetryDelay, l.dial)
+	err := conn.open(false)
+	if err != nil {
+		return err
+	}
+
+	l.conn = conn
+	l.isInitialized = true
+
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *SyslogLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger closes the connection to the syslog server
+func (l *SyslogLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+
+	conn := l.conn
+	err := conn.close()
+	l.isInitialized = false
+	l.lock.Unlock()
+
+	conn.wait()
+
+	return err
+}
+
+// Healthy returns whether the logger is connected to the syslog server
+func (l *SyslogLogger) Healthy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized && l.conn.conn != nil
+}
+
+// Logm sends a message to the syslog server
+func (l *SyslogLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+	if l.conn.conn == nil {
+		return ErrSyslogDisconnected
+	}
+
+	var mergedAttrs map[string]interface{}
+	if l.base != nil {
+		mergedAttrs = l.base.BaseAttrs.Attrs()
+	} else {
+		mergedAttrs = make(map[string]interface{}, len(attrs))
+	}
+	for key, val := range attrs {
+		mergedAttrs[key] = val
+	}
+
+	var data []byte
+	if l.config.Format == SyslogFormatRFC3164 {
+		data = l.formatRFC3164(timestamp, level, msg)
+	} else {
+		data = l.formatRFC5424(timestamp, level, mergedAttrs, msg)
+	}
+	if l.conn.stream {
+		data = l.frame(data)
+	}
+
+	if l.config.WriteTimeout > 0 {
+		l.conn.conn.SetWriteDeadline(time.Now().Add(l.config.WriteTimeout))
+	}
+	_, err := l.conn.conn.Write(data)
+	if err != nil {
+		l.conn.broken()
+		return err
+	}
+
+	return nil
+}
+
+// dial connects to the syslog server and returns the connection and whether
+// it's a stream connection that messages need to be framed on
+func (l *SyslogLogger) dial() (net.Conn, bool, error) {
+	if l.config.Network != "unix" {
+		return dialNetwork(l.config.Network, l.config.Address, l.config.TLSConfig, l.config.DialTimeout)
+	}
+
+	addresses := syslogLocalPaths
+	if len(l.config.Address) > 0 {
+		addresses = []string{l.config.Address}
+	}
+
+	var lastErr error
+	for _, address := range addresses {
+		conn, stream, err := dialNetwork("unixgram", address, nil, l.config.DialTimeout)
+		if err == nil {
+			return conn, stream, nil
+		}
+		conn, stream, err = dialNetwork("unix", address, nil, l.config.DialTimeout)
+		if err == nil {
+			return conn, stream, nil
+		}
+		lastErr = err
+	}
+	return nil, false, lastErr
+}
+
+func (l *SyslogLogger) priority(level LogLevel) int {
+	severity := int(level)
+	if severity < 0 || severity > 7 {
+		severity = int(LevelDebug)
+	}
+	return int(l.config.Facility)*8 + severity
+}
+
+func (l *SyslogLogger) formatRFC5424(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d - ",
+		l.priority(level),
+		timestamp.Format(syslogTimeRFC5424),
+		syslogHeaderField(l.config.Hostname, 255),
+		syslogHeaderField(l.config.AppName, 48),
+		l.pid,
+	)
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		if len(syslogParamName(key)) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 || len(l.config.StructuredDataID) == 0 {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[")
+		buf.WriteString(syslogParamName(l.config.StructuredDataID))
+		for _, key := range keys {
+			buf.WriteString(" ")
+			buf.WriteString(syslogParamName(key))
+			buf.WriteString(`="`)
+			syslogEscapeParamValue(buf, fmt.Sprint(attrs[key]))
+			buf.WriteString(`"`)
+		}
+		buf.WriteString("]")
+	}
+
+	if len(msg) > 0 {
+		buf.WriteString(" ")
+		buf.WriteString(msg)
+	}
+
+	return buf.Bytes()
+}
+
+func (l *SyslogLogger) formatRFC3164(timestamp time.Time, level LogLevel, msg string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>%s %s %s[%d]: %s",
+		l.priority(level),
+		timestamp.Format(time.Stamp),
+		syslogHeaderField(l.config.Hostname, 255),
+		syslogHeaderField(l.config.AppName, 32),
+		l.pid,
+		msg,
+	)
+	return buf.Bytes()
+}
+
+func (l *SyslogLogger) frame(data []byte) []byte {
+	if l.config.Framing == SyslogFramingNonTransparent {
+		return append(data, '\n')
+	}
+
+	framed := make([]byte, 0, len(data)+8)
+	framed = strconv.AppendInt(framed, int64(len(data)), 10)
+	framed = append(framed, ' ')
+	return append(framed, data...)
+}
+
+// syslogHeaderField makes value safe to use as a header field by replacing
+// anything that isn't printable ASCII and truncating it to maxLen.  An empty
+// value is replaced with the nil value, "-".
+func syslogHeaderField(value string, maxLen int) string {
+	if len(value) == 0 {
+		return "-"
+	}
+
+	field := []byte(value)
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	for idx, c := range field {
+		if c < 33 || c > 126 {
+			field[idx] = '_'
+		}
+	}
+	return string(field)
+}
+
+// syslogParamName makes name safe to use as a structured data name
+func syslogParamName(name string) string {
+	field := []byte(name)
+	if len(field) > 32 {
+		field = field[:32]
+	}
+	for idx, c := range field {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			field[idx] = '_'
+		}
+	}
+	return string(field)
+}
+
+// syslogEscapeParamValue writes value to buf with the characters that are
+// special in a structured data value escaped
+func syslogEscapeParamValue(buf *bytes.Buffer, value string) {
+	for _, c := range value {
+		switch c {
+		case '"', '\\', ']':
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(c)
+	}
+}
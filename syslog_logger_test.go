@@ -0,0 +1,343 @@
+package gomol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type SyslogLoggerSuite struct{}
+
+func newTestSyslogLogger(network string, address string) *SyslogLogger {
+	cfg := NewSyslogLoggerConfig()
+	cfg.Network = network
+	cfg.Address = address
+	cfg.Hostname = "testhost"
+	cfg.AppName = "testapp"
+	cfg.StructuredDataID = "gomol@32473"
+
+	l, err := NewSyslogLogger(cfg)
+	Expect(err).To(BeNil())
+	l.pid = 1234
+	return l
+}
+
+// readOctetCounted reads a single octet-counted frame from r
+func readOctetCounted(r *bufio.Reader) (string, error) {
+	lenStr, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	msgLen, err := strconv.Atoi(strings.TrimSpace(lenStr))
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, msgLen)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
+
+func (s *SyslogLoggerSuite) TestNewSyslogLoggerBadNetwork(t sweet.T) {
+	cfg := NewSyslogLoggerConfig()
+	cfg.Network = "carrier-pigeon"
+	_, err := NewSyslogLogger(cfg)
+	Expect(err).To(MatchError("unknown syslog network: carrier-pigeon"))
+}
+
+func (s *SyslogLoggerSuite) TestNewSyslogLoggerBadFormat(t sweet.T) {
+	cfg := NewSyslogLoggerConfig()
+	cfg.Format = SyslogFormat(10)
+	_, err := NewSyslogLogger(cfg)
+	Expect(err).To(MatchError("unknown syslog format: 10"))
+}
+
+func (s *SyslogLoggerSuite) TestNewSyslogLoggerBadRetryDelay(t sweet.T) {
+	cfg := NewSyslogLoggerConfig()
+	cfg.MinRetryDelay = 0
+	_, err := NewSyslogLogger(cfg)
+	Expect(err).To(Equal(ErrInvalidRetryDelay))
+
+	cfg = NewSyslogLoggerConfig()
+	cfg.MaxRetryDelay = cfg.MinRetryDelay / 2
+	_, err = NewSyslogLogger(cfg)
+	Expect(err).To(Equal(ErrInvalidRetryDelay))
+}
+
+func (s *SyslogLoggerSuite) TestFormatRFC5424(t sweet.T) {
+	l := newTestSyslogLogger("udp", "")
+
+	data := l.formatRFC5424(testTime, LevelError, map[string]interface{}{
+		"b_attr": 1234,
+		"a attr": `quote " slash \ bracket ]`,
+	}, "the message")
+	Expect(string(data)).To(Equal(
+		`<11>1 2017-03-04T05:06:07.890000Z testhost testapp 1234 - ` +
+			`[gomol@32473 a_attr="quote \" slash \\ bracket \]" b_attr="1234"] the message`,
+	))
+}
+
+func (s *SyslogLoggerSuite) TestFormatRFC5424NoAttrs(t sweet.T) {
+	l := newTestSyslogLogger("udp", "")
+	l.config.Facility = SyslogFacilityLocal0
+	l.config.Hostname = ""
+
+	data := l.formatRFC5424(testTime, LevelDebug, nil, "the message")
+	Expect(string(data)).To(Equal(`<135>1 2017-03-04T05:06:07.890000Z - testapp 1234 - - the message`))
+}
+
+func (s *SyslogLoggerSuite) TestFormatRFC5424NoStructuredDataID(t sweet.T) {
+	l := newTestSyslogLogger("udp", "")
+	l.config.StructuredDataID = ""
+
+	data := l.formatRFC5424(testTime, LevelError, map[string]interface{}{"attr": 1}, "the message")
+	Expect(string(data)).To(Equal(`<11>1 2017-03-04T05:06:07.890000Z testhost testapp 1234 - - the message`))
+}
+
+func (s *SyslogLoggerSuite) TestFormatRFC3164(t sweet.T) {
+	l := newTestSyslogLogger("udp", "")
+
+	data := l.formatRFC3164(testTime, LevelWarning, "the message")
+	Expect(string(data)).To(Equal(`<12>Mar  4 05:06:07 testhost testapp[1234]: the message`))
+}
+
+func (s *SyslogLoggerSuite) TestHeaderField(t sweet.T) {
+	Expect(syslogHeaderField("", 10)).To(Equal("-"))
+	Expect(syslogHeaderField("my host", 10)).To(Equal("my_host"))
+	Expect(syslogHeaderField("abcdefghijkl", 10)).To(Equal("abcdefghij"))
+}
+
+func (s *SyslogLoggerSuite) TestFrame(t sweet.T) {
+	l := newTestSyslogLogger("tcp", "")
+	Expect(string(l.frame([]byte("hello")))).To(Equal("5 hello"))
+
+	l.config.Framing = SyslogFramingNonTransparent
+	Expect(string(l.frame([]byte("hello")))).To(Equal("hello\n"))
+}
+
+func (s *SyslogLoggerSuite) TestUDP(t sweet.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer pc.Close()
+
+	l := newTestSyslogLogger("udp", pc.LocalAddr().String())
+
+	b := NewBase()
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+	defer b.ShutdownLoggers()
+
+	Expect(l.Healthy()).To(BeTrue())
+
+	b.LogWithTime(LevelInfo, testTime, NewAttrs().SetAttr("msg_attr", 1), "udp message")
+	b.Flush()
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(string(buf[:n])).To(Equal(
+		`<14>1 2017-03-04T05:06:07.890000Z testhost testapp 1234 - ` +
+			`[gomol@32473 base_attr="base" msg_attr="1"] udp message`,
+	))
+}
+
+func (s *SyslogLoggerSuite) TestUnixgram(t sweet.T) {
+	dir, err := ioutil.TempDir("", "gomol")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	l := newTestSyslogLogger("unix", path)
+	l.config.Format = SyslogFormatRFC3164
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelFatal, nil, "unix message")).To(BeNil())
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	Expect(err).To(BeNil())
+	Expect(string(buf[:n])).To(Equal(`<10>Mar  4 05:06:07 testhost testapp[1234]: unix message`))
+}
+
+func (s *SyslogLoggerSuite) TestTCPOctetCounting(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l := newTestSyslogLogger("tcp", ln.Addr().String())
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message 1")).To(BeNil())
+	Expect(l.Logm(testTime, LevelInfo, nil, "message\n2")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	msg, err := readOctetCounted(r)
+	Expect(err).To(BeNil())
+	Expect(msg).To(HaveSuffix(" - - message 1"))
+	msg, err = readOctetCounted(r)
+	Expect(err).To(BeNil())
+	Expect(msg).To(HaveSuffix(" - - message\n2"))
+}
+
+func (s *SyslogLoggerSuite) TestTCPNonTransparent(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	l := newTestSyslogLogger("tcp", ln.Addr().String())
+	l.config.Framing = SyslogFramingNonTransparent
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message 1")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(HaveSuffix(" - - message 1\n"))
+}
+
+func (s *SyslogLoggerSuite) TestTLS(t sweet.T) {
+	serverCfg, clientCfg := newTestTLSConfigs()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	// The server side of the handshake has to happen while the
+	// logger is connecting or it will never finish connecting.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil && conn.(*tls.Conn).Handshake() == nil {
+			accepted <- conn
+		}
+	}()
+
+	l := newTestSyslogLogger("tcp+tls", ln.Addr().String())
+	l.config.TLSConfig = clientCfg
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "tls message")).To(BeNil())
+
+	var conn net.Conn
+	Eventually(accepted).Should(Receive(&conn))
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := readOctetCounted(bufio.NewReader(conn))
+	Expect(err).To(BeNil())
+	Expect(msg).To(HaveSuffix(" - - tls message"))
+}
+
+func (s *SyslogLoggerSuite) TestInitConnectFailure(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := newTestSyslogLogger("tcp", addr)
+	Expect(l.InitLogger()).ToNot(BeNil())
+	Expect(l.IsInitialized()).To(BeFalse())
+	Expect(l.Healthy()).To(BeFalse())
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+}
+
+func (s *SyslogLoggerSuite) TestReconnect(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+
+	clock := glock.NewMockClock()
+	l := newTestSyslogLogger("tcp", ln.Addr().String())
+	l.clock = clock
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	conn.Close()
+
+	// Writes to a socket closed by the other side don't fail right away
+	// so keep writing until the logger notices.
+	Eventually(func() error {
+		return l.Logm(testTime, LevelInfo, nil, "lost message")
+	}).Should(Equal(ErrSyslogDisconnected))
+	Expect(l.Healthy()).To(BeFalse())
+
+	Eventually(clock.BlockedOnAfter).Should(Equal(1))
+	Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{100 * time.Millisecond}))
+	clock.Advance(100 * time.Millisecond)
+
+	conn, err = ln.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	Eventually(l.Healthy).Should(BeTrue())
+	Expect(l.Logm(testTime, LevelInfo, nil, "new message")).To(BeNil())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := readOctetCounted(bufio.NewReader(conn))
+	Expect(err).To(BeNil())
+	Expect(msg).To(HaveSuffix(" - - new message"))
+}
+
+func (s *SyslogLoggerSuite) TestReconnectBackoff(t sweet.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := ln.Addr().String()
+
+	clock := glock.NewMockClock()
+	l := newTestSyslogLogger("tcp", addr)
+	l.clock = clock
+	l.config.MaxRetryDelay = 300 * time.Millisecond
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	conn, err := ln.Accept()
+	Expect(err).To(BeNil())
+	conn.Close()
+	ln.Close()
+
+	Eventually(func() error {
+		return l.Logm(testTime, LevelInfo, nil, "lost message")
+	}).Should(Equal(ErrSyslogDisconnected))
+
+	for i, delay := range []time.Duration{100, 200, 300, 300} {
+		delay *= time.Millisecond
+		Eventually(clock.BlockedOnAfter).Should(Equal(1))
+		Expect(clock.GetAfterArgs()).To(Equal([]time.Duration{delay}), fmt.Sprintf("attempt %d", i))
+		clock.Advance(delay)
+	}
+	Expect(l.Healthy()).To(BeFalse())
+}
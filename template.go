@@ -59,6 +59,9 @@ logging messages.  Template includes a few useful template functions:
 		Upper cases a string
 	json
 		JSON marshals an object
+	logfmt
+		Formats a TemplateMsg as a line of logfmt, a map as logfmt key=value
+		pairs or anything else as a single logfmt value
 	color
 		Changes the color of any text after it to the log level's color
 	reset
@@ -70,11 +73,12 @@ type Template struct {
 
 func getFuncMap(level LogLevel, forceReset bool) template.FuncMap {
 	fMap := template.FuncMap{
-		"title": strings.Title,
-		"lcase": strings.ToLower,
-		"ucase": strings.ToUpper,
-		"json":  tplJSON,
-		"reset": tplColorReset,
+		"title":  strings.Title,
+		"lcase":  strings.ToLower,
+		"ucase":  strings.ToUpper,
+		"json":   tplJSON,
+		"logfmt": tplLogfmt,
+		"reset":  tplColorReset,
 	}
 
 	switch level {
@@ -268,6 +268,34 @@ func (s *GomolSuite) TestTplJSON(t sweet.T) {
 	Expect(out).To(Equal("{\"attr1\":\"val1\",\"attr2\":1234}"))
 }
 
+func (s *GomolSuite) TestTplLogfmt(t sweet.T) {
+	ts := time.Unix(1000000000, 100).UTC()
+
+	msg := newMessage(ts, nil, LevelError,
+		NewAttrs().
+			SetAttr("attr1", "val 1").
+			SetAttr("attr2", 1234),
+		"the message")
+
+	tpl, err := NewTemplate("{{ logfmt . }}")
+	Expect(err).To(BeNil())
+	out, err := tpl.executeInternalMsg(msg, false)
+	Expect(err).To(BeNil())
+	Expect(out).To(Equal(`time=2001-09-09T01:46:40.0000001Z level=error msg="the message" attr1="val 1" attr2=1234`))
+
+	tpl, err = NewTemplate("[{{ .LevelName }}] {{ .Message }} {{ logfmt .Attrs }}")
+	Expect(err).To(BeNil())
+	out, err = tpl.executeInternalMsg(msg, false)
+	Expect(err).To(BeNil())
+	Expect(out).To(Equal(`[error] the message attr1="val 1" attr2=1234`))
+
+	tpl, err = NewTemplate("msg={{ logfmt .Message }}")
+	Expect(err).To(BeNil())
+	out, err = tpl.executeInternalMsg(msg, false)
+	Expect(err).To(BeNil())
+	Expect(out).To(Equal(`msg="the message"`))
+}
+
 func (s *GomolSuite) TestTplAttrTemplate(t sweet.T) {
 	msg := newMessage(
 		time.Unix(10, 0),
@@ -0,0 +1,186 @@
+package gomol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/efritz/glock"
+)
+
+// WriterLoggerConfig is the configuration for a WriterLogger
+type WriterLoggerConfig struct {
+	// Encoder converts each message into the bytes written to the writer
+	Encoder Encoder
+
+	// BufferSize is the size of the buffer messages are written to before
+	// they're written to the writer.  If the buffer fills up it is written
+	// out right away.
+	BufferSize int
+
+	// FlushInterval is how often buffered messages are written to the
+	// writer.  If it is 0 each message is written as soon as it's logged.
+	FlushInterval time.Duration
+}
+
+// NewWriterLoggerConfig creates a new WriterLoggerConfig that writes JSON lines
+func NewWriterLoggerConfig() *WriterLoggerConfig {
+	return &WriterLoggerConfig{
+		Encoder:       NewJSONEncoder(nil),
+		BufferSize:    4096,
+		FlushInterval: time.Second,
+	}
+}
+
+/*
+WriterLogger is a Logger that encodes messages with an Encoder and writes them
+to an io.Writer.  Messages are buffered and written out every FlushInterval, when
+the buffer is full, when Flush is called or when the logger is shut down.  The
+writer is not closed when the logger is shut down.
+
+A WriterLogger is safe to use from multiple goroutines, and the writer will only
+ever be written to by one goroutine at a time.
+*/
+type WriterLogger struct {
+	config *WriterLoggerConfig
+	clock  glock.Clock
+
+	lock          sync.Mutex
+	base          *Base
+	writer        *bufio.Writer
+	encBuf        bytes.Buffer
+	isInitialized bool
+	stop          chan struct{}
+	finished      chan struct{}
+}
+
+var _ Logger = &WriterLogger{}
+
+// NewWriterLogger creates a new WriterLogger that writes to w.  If config is
+// nil the values from NewWriterLoggerConfig are used.
+func NewWriterLogger(w io.Writer, config *WriterLoggerConfig) (*WriterLogger, error) {
+	if config == nil {
+		config = NewWriterLoggerConfig()
+	}
+	cfg := *config
+	if cfg.Encoder == nil {
+		cfg.Encoder = NewJSONEncoder(nil)
+	}
+
+	return &WriterLogger{
+		config: &cfg,
+		clock:  glock.NewRealClock(),
+		writer: bufio.NewWriterSize(w, config.BufferSize),
+	}, nil
+}
+
+// SetBase will set the gomol.Base this logger is associated with
+func (l *WriterLogger) SetBase(base *Base) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.base = base
+}
+
+// InitLogger starts writing out buffered messages every FlushInterval
+func (l *WriterLogger) InitLogger() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isInitialized {
+		return nil
+	}
+
+	if l.config.FlushInterval > 0 {
+		l.stop = make(chan struct{})
+		l.finished = make(chan struct{})
+		go l.flushLoop(l.clock.NewTicker(l.config.FlushInterval), l.stop, l.finished)
+	}
+
+	l.isInitialized = true
+	return nil
+}
+
+// IsInitialized returns whether the logger has been initialized or not
+func (l *WriterLogger) IsInitialized() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isInitialized
+}
+
+// ShutdownLogger writes out any buffered messages and stops the logger
+func (l *WriterLogger) ShutdownLogger() error {
+	l.lock.Lock()
+	if !l.isInitialized {
+		l.lock.Unlock()
+		return nil
+	}
+	stop, finished := l.stop, l.finished
+	l.stop, l.finished = nil, nil
+	l.isInitialized = false
+	err := l.writer.Flush()
+	l.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-finished
+	}
+
+	return err
+}
+
+// Flush writes any buffered messages to the writer
+func (l *WriterLogger) Flush() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.writer.Flush()
+}
+
+// Logm encodes the message and adds it to the buffer
+func (l *WriterLogger) Logm(timestamp time.Time, level LogLevel, attrs map[string]interface{}, msg string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.isInitialized {
+		return ErrNotInitialized
+	}
+
+	mergedAttrs := attrs
+	if l.base != nil {
+		mergedAttrs = l.base.BaseAttrs.Attrs()
+		for key, val := range attrs {
+			mergedAttrs[key] = val
+		}
+	}
+
+	l.encBuf.Reset()
+	err := l.config.Encoder.Encode(&l.encBuf, timestamp, level, mergedAttrs, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.writer.Write(l.encBuf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if l.config.FlushInterval <= 0 {
+		return l.writer.Flush()
+	}
+	return nil
+}
+
+func (l *WriterLogger) flushLoop(ticker glock.Ticker, stop chan struct{}, finished chan struct{}) {
+	defer close(finished)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.Chan():
+			l.Flush()
+		case <-stop:
+			return
+		}
+	}
+}
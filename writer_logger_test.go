@@ -0,0 +1,178 @@
+package gomol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aphistic/sweet"
+	"github.com/efritz/glock"
+	. "github.com/onsi/gomega"
+)
+
+type WriterLoggerSuite struct{}
+
+// lockedBuffer is a bytes.Buffer that can be read while it's being written to
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func newTestWriterLogger(w *lockedBuffer, flushInterval time.Duration) (*WriterLogger, *glock.MockClock) {
+	cfg := NewWriterLoggerConfig()
+	cfg.Encoder = NewLogfmtEncoder(nil)
+	cfg.FlushInterval = flushInterval
+
+	l, err := NewWriterLogger(w, cfg)
+	Expect(err).To(BeNil())
+
+	clock := glock.NewMockClock()
+	l.clock = clock
+	return l, clock
+}
+
+func (s *WriterLoggerSuite) TestNewWriterLoggerDefaults(t sweet.T) {
+	l, err := NewWriterLogger(&bytes.Buffer{}, nil)
+	Expect(err).To(BeNil())
+	Expect(l.config).To(Equal(NewWriterLoggerConfig()))
+}
+
+func (s *WriterLoggerSuite) TestNewWriterLoggerConfigNotChanged(t sweet.T) {
+	cfg := NewWriterLoggerConfig()
+	cfg.Encoder = nil
+
+	l, err := NewWriterLogger(&bytes.Buffer{}, cfg)
+	Expect(err).To(BeNil())
+	Expect(l.config.Encoder).ToNot(BeNil())
+	Expect(cfg.Encoder).To(BeNil())
+}
+
+func (s *WriterLoggerSuite) TestNotInitialized(t sweet.T) {
+	l, _ := newTestWriterLogger(&lockedBuffer{}, 0)
+	Expect(l.IsInitialized()).To(BeFalse())
+	Expect(l.Logm(time.Now(), LevelInfo, nil, "message")).To(Equal(ErrNotInitialized))
+}
+
+func (s *WriterLoggerSuite) TestWriteThrough(t sweet.T) {
+	buf := &lockedBuffer{}
+	l, _ := newTestWriterLogger(buf, 0)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, map[string]interface{}{"attr": 1}, "message")).To(BeNil())
+	Expect(buf.String()).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=message attr=1\n"))
+}
+
+func (s *WriterLoggerSuite) TestFlushInterval(t sweet.T) {
+	buf := &lockedBuffer{}
+	l, clock := newTestWriterLogger(buf, time.Second)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Expect(buf.String()).To(Equal(""))
+
+	clock.Advance(time.Second)
+	Eventually(buf.String).Should(Equal("time=2017-03-04T05:06:07.89Z level=info msg=message\n"))
+}
+
+func (s *WriterLoggerSuite) TestFlush(t sweet.T) {
+	buf := &lockedBuffer{}
+	l, _ := newTestWriterLogger(buf, time.Second)
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Expect(buf.String()).To(Equal(""))
+	Expect(l.Flush()).To(BeNil())
+	Expect(buf.String()).ToNot(Equal(""))
+}
+
+func (s *WriterLoggerSuite) TestFlushOnShutdown(t sweet.T) {
+	buf := &lockedBuffer{}
+	l, _ := newTestWriterLogger(buf, time.Minute)
+	Expect(l.InitLogger()).To(BeNil())
+
+	Expect(l.Logm(testTime, LevelInfo, nil, "message")).To(BeNil())
+	Expect(buf.String()).To(Equal(""))
+
+	Expect(l.ShutdownLogger()).To(BeNil())
+	Expect(l.IsInitialized()).To(BeFalse())
+	Expect(buf.String()).To(Equal("time=2017-03-04T05:06:07.89Z level=info msg=message\n"))
+}
+
+func (s *WriterLoggerSuite) TestBufferFull(t sweet.T) {
+	buf := &lockedBuffer{}
+	cfg := NewWriterLoggerConfig()
+	cfg.BufferSize = 64
+	cfg.FlushInterval = time.Minute
+
+	l, err := NewWriterLogger(buf, cfg)
+	Expect(err).To(BeNil())
+	l.clock = glock.NewMockClock()
+	Expect(l.InitLogger()).To(BeNil())
+	defer l.ShutdownLogger()
+
+	Expect(l.Logm(testTime, LevelInfo, nil, strings.Repeat("a", 100))).To(BeNil())
+	Expect(buf.String()).To(ContainSubstring(strings.Repeat("a", 100)))
+}
+
+func (s *WriterLoggerSuite) TestBaseAttrs(t sweet.T) {
+	buf := &lockedBuffer{}
+	l, _ := newTestWriterLogger(buf, 0)
+
+	b := NewBase()
+	b.SetAttr("base_attr", "base")
+	b.AddLogger(l)
+	Expect(b.InitLoggers()).To(BeNil())
+
+	b.LogWithTime(LevelWarning, testTime, NewAttrs().SetAttr("msg_attr", "msg"), "message")
+	Expect(b.ShutdownLoggers()).To(BeNil())
+
+	Expect(buf.String()).To(Equal("time=2017-03-04T05:06:07.89Z level=warn msg=message base_attr=base msg_attr=msg\n"))
+}
+
+func (s *WriterLoggerSuite) TestConcurrentLogging(t sweet.T) {
+	buf := &lockedBuffer{}
+	cfg := NewWriterLoggerConfig()
+	cfg.BufferSize = 256
+
+	l, err := NewWriterLogger(buf, cfg)
+	Expect(err).To(BeNil())
+	Expect(l.InitLogger()).To(BeNil())
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Logm(time.Now(), LevelInfo, map[string]interface{}{"worker": i, "idx": j}, "concurrent message")
+			}
+		}(i)
+	}
+	wg.Wait()
+	Expect(l.ShutdownLogger()).To(BeNil())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	Expect(lines).To(HaveLen(1000))
+	for _, line := range lines {
+		obj := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(line), &obj)).To(BeNil())
+		Expect(obj["message"]).To(Equal("concurrent message"))
+	}
+}